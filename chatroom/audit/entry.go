@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Event defines the kind of an audited event
+type Event string
+
+const (
+	// EventLogin is recorded when a user successfully authenticated
+	EventLogin Event = "login"
+
+	// EventLoginFailed is recorded when an authentication attempt failed
+	EventLoginFailed Event = "login-failed"
+
+	// EventSessionClosed is recorded when a session was closed
+	EventSessionClosed Event = "session-closed"
+
+	// EventKick is recorded when a user was kicked by a moderator
+	EventKick Event = "kick"
+
+	// EventBan is recorded when an account was banned by a moderator
+	EventBan Event = "ban"
+
+	// EventUnban is recorded when the ban of an account was lifted
+	// by a moderator
+	EventUnban Event = "unban"
+
+	// EventAdminAction is recorded when a moderator or an administrator
+	// changed the state of the server
+	EventAdminAction Event = "admin-action"
+)
+
+// Entry represents a single record of the audit log.
+// Each entry references the hash of its predecessor forming a hash chain
+// which makes any modification of previous entries evident
+type Entry struct {
+	Seq        uint64            `json:"seq"`
+	Time       time.Time         `json:"time"`
+	Event      Event             `json:"event"`
+	User       string            `json:"user,omitempty"`
+	RemoteAddr string            `json:"remoteAddr,omitempty"`
+	Details    map[string]string `json:"details,omitempty"`
+	PrevHash   string            `json:"prevHash"`
+	Hash       string            `json:"hash"`
+}
+
+// genesisHash is the previous hash of the very first entry
+const genesisHash = ""
+
+// ComputeHash computes the hash of the entry
+// over all of its fields except the hash itself
+func (entry Entry) ComputeHash() (string, error) {
+	entry.Hash = ""
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't marshal audit entry: %s", err)
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Log represents an append-only, hash-chained audit log file
+type Log struct {
+	file     *os.File
+	lastSeq  uint64
+	lastHash string
+	lock     sync.Mutex
+}
+
+// Open opens the audit log file at the given path for appending.
+// Creates the file if it doesn't exist yet.
+// Fails if the existing hash chain is broken
+func Open(filePath string) (*Log, error) {
+	alog := &Log{
+		lastHash: genesisHash,
+	}
+
+	// Continue the chain of the existing entries
+	existing, err := os.Open(filePath)
+	if err == nil {
+		last, err := Verify(existing)
+		existing.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Existing audit log is corrupted: %s", err)
+		}
+		if last != nil {
+			alog.lastSeq = last.Seq
+			alog.lastHash = last.Hash
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Couldn't open audit log: %s", err)
+	}
+
+	alog.file, err = os.OpenFile(
+		filePath,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0600,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't open audit log for writing: %s", err)
+	}
+
+	return alog, nil
+}
+
+// Record appends a new entry to the audit log
+// and flushes it to stable storage
+func (alog *Log) Record(
+	event Event,
+	user string,
+	remoteAddr string,
+	details map[string]string,
+) error {
+	alog.lock.Lock()
+	defer alog.lock.Unlock()
+
+	entry := Entry{
+		Seq:        alog.lastSeq + 1,
+		Time:       time.Now().UTC(),
+		Event:      event,
+		User:       user,
+		RemoteAddr: remoteAddr,
+		Details:    details,
+		PrevHash:   alog.lastHash,
+	}
+
+	hash, err := entry.ComputeHash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal audit entry: %s", err)
+	}
+	if _, err := alog.file.Write(append(encoded, '\n')); err != nil {
+		return fmt.Errorf("Couldn't write audit entry: %s", err)
+	}
+	if err := alog.file.Sync(); err != nil {
+		return fmt.Errorf("Couldn't sync audit log: %s", err)
+	}
+
+	alog.lastSeq = entry.Seq
+	alog.lastHash = entry.Hash
+
+	return nil
+}
+
+// Close closes the audit log file
+func (alog *Log) Close() error {
+	alog.lock.Lock()
+	defer alog.lock.Unlock()
+	return alog.file.Close()
+}
+
+// Verify reads all entries of an audit log and verifies the
+// hash chain. Returns the last entry or nil if the log is empty
+func Verify(file io.Reader) (*Entry, error) {
+	var last *Entry
+	prevHash := genesisHash
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: malformed entry: %s", line, err)
+		}
+
+		if entry.Seq != uint64(line) {
+			return nil, fmt.Errorf(
+				"line %d: unexpected sequence number %d",
+				line,
+				entry.Seq,
+			)
+		}
+		if entry.PrevHash != prevHash {
+			return nil, fmt.Errorf(
+				"line %d: previous hash mismatch, chain broken",
+				line,
+			)
+		}
+
+		hash, err := entry.ComputeHash()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if entry.Hash != hash {
+			return nil, fmt.Errorf(
+				"line %d: hash mismatch, entry was modified",
+				line,
+			)
+		}
+
+		prevHash = entry.Hash
+		last = &entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Couldn't read audit log: %s", err)
+	}
+
+	return last, nil
+}
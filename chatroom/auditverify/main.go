@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/qbeon/webwire-go-examples/chatroom/audit"
+)
+
+var argAuditFilePath = flag.String(
+	"audit",
+	"../server/audit.log",
+	"path to the audit log file to be verified",
+)
+
+func main() {
+	// Parse command line arguments
+	flag.Parse()
+
+	file, err := os.Open(*argAuditFilePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't open audit log: %s\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	last, err := audit.Verify(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Audit log verification FAILED: %s\n", err)
+		os.Exit(1)
+	}
+
+	if last == nil {
+		fmt.Println("Audit log is empty")
+		return
+	}
+	fmt.Printf(
+		"Audit log verified: %d entries, last recorded at %s\n",
+		last.Seq,
+		last.Time,
+	)
+}
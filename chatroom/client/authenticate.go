@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -15,14 +14,14 @@ func (clt *ChatroomClient) Authenticate(login, password string) {
 	encodedCreds, err := json.Marshal(shared.AuthenticationCredentials{
 		Name:     login,
 		Password: password,
+		Codec:    clt.codec.Name(),
 	})
 	if err != nil {
 		panic(fmt.Errorf("Couldn't marshal credentials: %s", err))
 	}
 
-	reply, reqErr := clt.connection.Request(
-		context.Background(),
-		[]byte("auth"),
+	reply, reqErr := clt.request(
+		shared.RequestAuth,
 		webwire.Payload{
 			Encoding: webwire.EncodingBinary,
 			Data:     encodedCreds,
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// Devices prints all active connections of the authenticated user
+func (clt *ChatroomClient) Devices() {
+	reply, err := clt.request(
+		shared.RequestDevices,
+		webwire.Payload{},
+	)
+	if err != nil {
+		logRequestError(shared.RequestDevices, err)
+		return
+	}
+	defer reply.Close()
+
+	var devices []shared.Device
+	if err := shared.Decode(reply, &devices); err != nil {
+		log.Printf("Failed parsing devices: %s", err)
+		return
+	}
+
+	for _, device := range devices {
+		current := ""
+		if device.Current {
+			current = " (this device)"
+		}
+		fmt.Printf(
+			"  #%d %s in %s, connected since %s%s\n",
+			device.ID,
+			device.RemoteAddr,
+			device.Room,
+			device.Connected.Local().Format(time.Stamp),
+			current,
+		)
+	}
+}
+
+// SignoutDevice signs out one of the devices of the authenticated user
+func (clt *ChatroomClient) SignoutDevice(id string) {
+	reply, err := clt.request(
+		shared.RequestSignoutDevice,
+		webwire.Payload{
+			Encoding: webwire.EncodingUtf8,
+			Data:     []byte(id),
+		},
+	)
+	if err != nil {
+		logRequestError(shared.RequestSignoutDevice, err)
+		return
+	}
+	reply.Close()
+	fmt.Printf("Signed out device #%s\n", id)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// errKeyChanged is returned if the public key of a user
+// differs from the key pinned on first use
+var errKeyChanged = errors.New("public key changed")
+
+// publishKey loads or generates the key pair of the signed in user
+// and publishes its public key to the server's key directory
+func (clt *ChatroomClient) publishKey(username string) {
+	identity, err := clt.keys.Load(username)
+	if err != nil {
+		log.Printf("WARNING: direct messages unavailable: %s", err)
+		return
+	}
+	reply, err := clt.request(
+		shared.RequestPublishKey,
+		webwire.Payload{
+			Encoding: webwire.EncodingBinary,
+			Data:     identity.PublicKey(),
+		},
+	)
+	if err != nil {
+		logRequestError(shared.RequestPublishKey, err)
+		return
+	}
+	reply.Close()
+	fmt.Printf(
+		"Your key fingerprint: %s\n",
+		fingerprint(identity.PublicKey()),
+	)
+}
+
+// fetchKey requests the public key of the given user from the server
+func (clt *ChatroomClient) fetchKey(username string) ([]byte, error) {
+	reply, err := clt.request(
+		shared.RequestPublicKey,
+		webwire.Payload{
+			Encoding: webwire.EncodingUtf8,
+			Data:     []byte(username),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer reply.Close()
+	return append([]byte(nil), reply.Payload()...), nil
+}
+
+// verifiedKey returns the pinned public key of the given user.
+// The key is fetched and pinned on first use. Returns errKeyChanged
+// and warns the user if the key in the directory differs from the pinned one
+func (clt *ChatroomClient) verifiedKey(username string) ([]byte, error) {
+	key, err := clt.fetchKey(username)
+	if err != nil {
+		return nil, err
+	}
+	pinned, isPinned := clt.keys.Pinned(username)
+	if !isPinned {
+		if err := clt.keys.Pin(username, key); err != nil {
+			return nil, err
+		}
+		fmt.Printf(
+			"Pinned the key of %s, fingerprint: %s\n",
+			username,
+			fingerprint(key),
+		)
+		return key, nil
+	}
+	if !bytes.Equal(pinned, key) {
+		fmt.Printf(
+			"WARNING: the key of %s changed!\n"+
+				"  Pinned fingerprint: %s\n"+
+				"  New fingerprint:    %s\n"+
+				"  Verify the new fingerprint with %s "+
+				"and accept it using :trust %s\n",
+			username,
+			fingerprint(pinned),
+			fingerprint(key),
+			username,
+			username,
+		)
+		return nil, errKeyChanged
+	}
+	return pinned, nil
+}
+
+// SendDirectMessage sends an end-to-end encrypted direct message
+// to another user. The argument consists of the recipient's name
+// followed by the message
+func (clt *ChatroomClient) SendDirectMessage(argument string) {
+	parts := strings.SplitN(argument, " ", 2)
+	if len(parts) < 2 || parts[0] == "" {
+		fmt.Println("Usage: :dm <user> <message>")
+		return
+	}
+
+	username, identity := clt.keys.Identity()
+	if identity == nil {
+		fmt.Println("Login required to send direct messages")
+		return
+	}
+
+	recipientKey, err := clt.verifiedKey(parts[0])
+	if err == errKeyChanged {
+		fmt.Println("Direct message not sent")
+		return
+	} else if err != nil {
+		logRequestError(shared.RequestPublicKey, err)
+		return
+	}
+
+	dm, err := sealDirectMessage(
+		identity,
+		username,
+		parts[0],
+		recipientKey,
+		[]byte(parts[1]),
+	)
+	if err != nil {
+		log.Printf("Couldn't encrypt direct message: %s", err)
+		return
+	}
+	encoded, err := dm.MarshalBinary()
+	if err != nil {
+		log.Printf("Couldn't encode direct message: %s", err)
+		return
+	}
+
+	reply, err := clt.request(
+		shared.RequestDirectMessage,
+		webwire.Payload{
+			Encoding: webwire.EncodingBinary,
+			Data:     encoded,
+		},
+	)
+	if err != nil {
+		logRequestError(shared.RequestDirectMessage, err)
+		return
+	}
+	reply.Close()
+}
+
+// Trust pins the current public key of the given user
+// replacing the previously pinned key
+func (clt *ChatroomClient) Trust(username string) {
+	if username == "" {
+		fmt.Println("Usage: :trust <user>")
+		return
+	}
+	key, err := clt.fetchKey(username)
+	if err != nil {
+		logRequestError(shared.RequestPublicKey, err)
+		return
+	}
+	if err := clt.keys.Pin(username, key); err != nil {
+		log.Printf("Couldn't pin key: %s", err)
+		return
+	}
+	fmt.Printf(
+		"Trusting the key of %s, fingerprint: %s\n",
+		username,
+		fingerprint(key),
+	)
+}
+
+// Fingerprint prints the fingerprint of the pinned key of the given user
+// or of the own key if no user is given
+func (clt *ChatroomClient) Fingerprint(username string) {
+	if username == "" {
+		_, identity := clt.keys.Identity()
+		if identity == nil {
+			fmt.Println("Login required to have a key")
+			return
+		}
+		fmt.Printf(
+			"Your key fingerprint: %s\n",
+			fingerprint(identity.PublicKey()),
+		)
+		return
+	}
+	if _, err := clt.verifiedKey(username); err != nil {
+		if err != errKeyChanged {
+			logRequestError(shared.RequestPublicKey, err)
+		}
+		return
+	}
+	pinned, _ := clt.keys.Pinned(username)
+	fmt.Printf("Key fingerprint of %s: %s\n", username, fingerprint(pinned))
+}
+
+// Who prints the names of all online users
+func (clt *ChatroomClient) Who() {
+	reply, err := clt.request(
+		shared.RequestWho,
+		webwire.Payload{},
+	)
+	if err != nil {
+		logRequestError(shared.RequestWho, err)
+		return
+	}
+	defer reply.Close()
+
+	var users []string
+	if err := shared.Decode(reply, &users); err != nil {
+		log.Printf("Failed parsing online users: %s", err)
+		return
+	}
+	fmt.Printf("Online: %s\n", strings.Join(users, ", "))
+}
+
+// onDirectMessage decrypts and prints a received direct message.
+// Decryption may require fetching the sender's key, which must not
+// happen in the signal handler, thus the message is handled asynchronously
+func (clt *ChatroomClient) onDirectMessage(msg webwire.Message) {
+	payload := append([]byte(nil), msg.Payload()...)
+	go clt.receiveDirectMessage(payload)
+}
+
+// receiveDirectMessage decrypts and prints an encoded direct message
+func (clt *ChatroomClient) receiveDirectMessage(encoded []byte) {
+	var dm shared.DirectMessage
+	if err := dm.UnmarshalBinary(encoded); err != nil {
+		log.Printf("Failed parsing direct message: %s", err)
+		return
+	}
+	_, identity := clt.keys.Identity()
+	if identity == nil {
+		return
+	}
+
+	senderKey, err := clt.verifiedKey(dm.From)
+	if err == errKeyChanged {
+		fmt.Printf("Rejected a direct message from %s\n", dm.From)
+		return
+	} else if err != nil {
+		log.Printf("Couldn't get the key of %s: %s", dm.From, err)
+		return
+	}
+
+	plaintext, err := openDirectMessage(identity, senderKey, &dm)
+	if err != nil {
+		log.Printf("Couldn't decrypt direct message from %s: %s", dm.From, err)
+		return
+	}
+	log.Printf("(direct) %s: %s\n", dm.From, plaintext)
+}
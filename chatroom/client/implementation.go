@@ -1,8 +1,6 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
 	"log"
 
 	webwire "github.com/qbeon/webwire-go"
@@ -10,33 +8,64 @@ import (
 )
 
 // OnSessionCreated implements the webwireClient.Implementation interface
-// it's invoked when a new session is assigned to the client
+// it's invoked when a new session is assigned to the client.
+// Publishes the user's public key for others to send direct messages
 func (clt *ChatroomClient) OnSessionCreated(newSession *webwire.Session) {
 	username := newSession.Info.Value("username").(string)
 	log.Printf("Authenticated as %s", username)
+	go clt.publishKey(username)
 }
 
 // OnSignal implements the webwireClient.Implementation interface.
 // it's invoked when the client receives a signal from the server
-// containing a chatroom message
+// containing either a chatroom message, a direct message, a room update,
+// the room joined on connection or a shutdown notice
 func (clt *ChatroomClient) OnSignal(msg webwire.Message) {
-	var chatMsg shared.ChatMessage
-
-	// Interpret the message as UTF8 encoded JSON
-	jsonString, err := msg.PayloadUtf8()
-	if err != nil {
-		log.Printf("Couldn't decode incoming message: %s\n", err)
+	switch string(msg.Name()) {
+	case shared.SignalDirectMessage:
+		clt.onDirectMessage(msg)
+		return
+	case shared.SignalRoom:
+		clt.onRoomUpdate(msg)
+		return
+	case shared.SignalJoined:
+		clt.onJoined(msg)
+		return
+	case shared.SignalShutdown:
+		clt.onShutdownNotice(msg)
+		return
 	}
 
-	if err := json.Unmarshal([]byte(jsonString), &chatMsg); err != nil {
-		panic(fmt.Errorf("Failed parsing chat message: %s", err))
+	// Decode the message using the codec matching the payload encoding
+	var chatMsg shared.ChatMessage
+	if err := shared.Decode(msg, &chatMsg); err != nil {
+		log.Printf("Failed parsing chat message: %s", err)
+		return
 	}
 
-	log.Printf("%s: %s\n", chatMsg.User, chatMsg.Msg)
+	sender := chatMsg.User
+	if chatMsg.Guest {
+		sender += " (guest)"
+	}
+	if chatMsg.Own {
+		log.Printf("[%s] %s (you): %s\n", chatMsg.Room, sender, chatMsg.Msg)
+		return
+	}
+	log.Printf("[%s] %s: %s\n", chatMsg.Room, sender, chatMsg.Msg)
 }
 
-// OnDisconnected implements the wwrclt.Implementation interface
-func (clt *ChatroomClient) OnDisconnected() {}
+// OnDisconnected implements the wwrclt.Implementation interface.
+// The server tells reconnected clients which room they were joined to.
+// Autoconnect is deactivated when the server closes the connection after
+// a shutdown notice, the client reconnects after the announced downtime
+func (clt *ChatroomClient) OnDisconnected() {
+	clt.setRoom("")
+	if clt.claimShutdown() {
+		clt.connection.Close()
+	}
+}
 
 // OnSessionClosed implements the wwrclt.Implementation interface
-func (clt *ChatroomClient) OnSessionClosed() {}
+func (clt *ChatroomClient) OnSessionClosed() {
+	clt.keys.Unload()
+}
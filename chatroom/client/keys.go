@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// knownKeysFile defines the name of the file
+// the pinned public keys of other users are stored in
+const knownKeysFile = "known_keys.json"
+
+// keySize defines the length of X25519 keys in bytes
+const keySize = 32
+
+// keyPair represents an X25519 key pair
+type keyPair struct {
+	private [keySize]byte
+	public  [keySize]byte
+}
+
+// newKeyPair derives the key pair of the given private key
+func newKeyPair(private []byte) (*keyPair, error) {
+	if len(private) != keySize {
+		return nil, fmt.Errorf("private keys must be %d bytes long", keySize)
+	}
+	pair := &keyPair{}
+	copy(pair.private[:], private)
+	curve25519.ScalarBaseMult(&pair.public, &pair.private)
+	return pair, nil
+}
+
+// generateKeyPair generates a new random key pair
+func generateKeyPair() (*keyPair, error) {
+	private := make([]byte, keySize)
+	if _, err := rand.Read(private); err != nil {
+		return nil, err
+	}
+	return newKeyPair(private)
+}
+
+// PublicKey returns the public key of the key pair
+func (pair *keyPair) PublicKey() []byte {
+	return pair.public[:]
+}
+
+// ECDH returns the Diffie-Hellman secret of the key pair
+// and the given public key. Low order public keys are rejected
+func (pair *keyPair) ECDH(publicKey []byte) ([]byte, error) {
+	if len(publicKey) != keySize {
+		return nil, fmt.Errorf("public keys must be %d bytes long", keySize)
+	}
+	var public, secret [keySize]byte
+	copy(public[:], publicKey)
+	curve25519.ScalarMult(&secret, &pair.private, &public)
+	if subtle.ConstantTimeCompare(secret[:], make([]byte, keySize)) == 1 {
+		return nil, errors.New("low order public key")
+	}
+	return secret[:], nil
+}
+
+// keyRing keeps the key pair of the signed in user and the public keys of
+// other users pinned on first use. Pinned keys are never replaced silently
+type keyRing struct {
+	dir      string
+	username string
+	identity *keyPair
+	known    map[string][]byte
+	lock     sync.Mutex
+}
+
+// newKeyRing loads the pinned public keys from the given directory
+func newKeyRing(dir string) (*keyRing, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Couldn't create key directory: %s", err)
+	}
+	ring := &keyRing{
+		dir:   dir,
+		known: make(map[string][]byte),
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(dir, knownKeysFile))
+	if os.IsNotExist(err) {
+		return ring, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Couldn't read known keys: %s", err)
+	}
+	if err := json.Unmarshal(contents, &ring.known); err != nil {
+		return nil, fmt.Errorf("Couldn't parse known keys: %s", err)
+	}
+	return ring, nil
+}
+
+// Load loads the private key of the given user
+// or generates a new one if there's none yet
+func (ring *keyRing) Load(username string) (*keyPair, error) {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+
+	filePath := filepath.Join(ring.dir, username+".key")
+	contents, err := ioutil.ReadFile(filePath)
+	var identity *keyPair
+	switch {
+	case err == nil:
+		identity, err = newKeyPair(contents)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid private key in %s: %s", filePath, err)
+		}
+	case os.IsNotExist(err):
+		identity, err = generateKeyPair()
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't generate key pair: %s", err)
+		}
+		if err := ioutil.WriteFile(
+			filePath,
+			identity.private[:],
+			0600,
+		); err != nil {
+			return nil, fmt.Errorf("Couldn't write private key: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("Couldn't read private key: %s", err)
+	}
+
+	ring.username = username
+	ring.identity = identity
+	return identity, nil
+}
+
+// Unload forgets the key pair of the signed out user
+func (ring *keyRing) Unload() {
+	ring.lock.Lock()
+	ring.username = ""
+	ring.identity = nil
+	ring.lock.Unlock()
+}
+
+// Identity returns the name and the private key of the signed in user.
+// The key is nil if no user is signed in
+func (ring *keyRing) Identity() (string, *keyPair) {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+	return ring.username, ring.identity
+}
+
+// Pinned returns the pinned public key of the given user
+func (ring *keyRing) Pinned(username string) ([]byte, bool) {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+	key, exists := ring.known[username]
+	return key, exists
+}
+
+// Pin pins the public key of the given user replacing any previous key
+func (ring *keyRing) Pin(username string, key []byte) error {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+
+	ring.known[username] = key
+	encoded, err := json.MarshalIndent(ring.known, "", "\t")
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal known keys: %s", err)
+	}
+	if err := ioutil.WriteFile(
+		filepath.Join(ring.dir, knownKeysFile),
+		encoded,
+		0600,
+	); err != nil {
+		return fmt.Errorf("Couldn't write known keys: %s", err)
+	}
+	return nil
+}
+
+// fingerprint returns a human readable fingerprint of the given public key
+// for users to compare through another channel
+func fingerprint(key []byte) string {
+	hash := sha256.Sum256(key)
+	encoded := hex.EncodeToString(hash[:16])
+	groups := make([]string, 0, len(encoded)/4)
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+// messageCipher derives the AEAD of a direct message from the Diffie-Hellman
+// secrets of both the ephemeral and the static key of the sender with
+// the recipient's key. The derived key is bound to the message header
+// and the static keys of both parties
+func messageCipher(
+	ephemeralSecret []byte,
+	staticSecret []byte,
+	dm *shared.DirectMessage,
+	senderKey []byte,
+	recipientKey []byte,
+) (cipher.AEAD, error) {
+	var info bytes.Buffer
+	info.WriteString("chatroom direct message")
+	info.Write(dm.Header())
+	info.Write(senderKey)
+	info.Write(recipientKey)
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(
+		sha256.New,
+		append(append([]byte(nil), ephemeralSecret...), staticSecret...),
+		nil,
+		info.Bytes(),
+	), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealDirectMessage encrypts a direct message
+// for the recipient identified by the given public key
+func sealDirectMessage(
+	identity *keyPair,
+	from string,
+	to string,
+	recipientKey []byte,
+	plaintext []byte,
+) (*shared.DirectMessage, error) {
+	ephemeral, err := generateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	ephemeralSecret, err := ephemeral.ECDH(recipientKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient key: %s", err)
+	}
+	staticSecret, err := identity.ECDH(recipientKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient key: %s", err)
+	}
+
+	dm := &shared.DirectMessage{
+		From: from,
+		To:   to,
+	}
+	copy(dm.EphemeralKey[:], ephemeral.PublicKey())
+	if _, err := rand.Read(dm.Nonce[:]); err != nil {
+		return nil, err
+	}
+
+	aead, err := messageCipher(
+		ephemeralSecret,
+		staticSecret,
+		dm,
+		identity.PublicKey(),
+		recipientKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+	dm.Ciphertext = aead.Seal(nil, dm.Nonce[:], plaintext, dm.Header())
+	return dm, nil
+}
+
+// openDirectMessage decrypts a direct message
+// sent by the user identified by the given public key
+func openDirectMessage(
+	identity *keyPair,
+	senderKey []byte,
+	dm *shared.DirectMessage,
+) ([]byte, error) {
+	ephemeralSecret, err := identity.ECDH(dm.EphemeralKey[:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %s", err)
+	}
+	staticSecret, err := identity.ECDH(senderKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender key: %s", err)
+	}
+
+	aead, err := messageCipher(
+		ephemeralSecret,
+		staticSecret,
+		dm,
+		senderKey,
+		identity.PublicKey(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, dm.Nonce[:], dm.Ciphertext, dm.Header())
+	if err != nil {
+		return nil, errors.New("message authentication failed")
+	}
+	return plaintext, nil
+}
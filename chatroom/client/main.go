@@ -11,6 +11,7 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -48,11 +49,38 @@ func loadWebwireCACertificate(
 // ChatroomClient implements the wwrclt.Implementation interface
 type ChatroomClient struct {
 	connection wwrclt.Client
+
+	// keys keeps the keys used for end-to-end encrypted direct messages
+	keys *keyRing
+
+	// codec is the codec selected during authentication
+	codec shared.Codec
+
+	// room is the name of the room the client is currently in
+	room string
+
+	// shutdownPending is set after a shutdown notice was received
+	// until the client reconnects after the announced downtime
+	shutdownPending bool
+	lock            sync.Mutex
 }
 
-// NewChatroomClient constructs and returns a new chatroom client instance
-func NewChatroomClient(serverAddr url.URL) (*ChatroomClient, error) {
-	newChatroomClient := &ChatroomClient{}
+// NewChatroomClient constructs and returns a new chatroom client instance.
+// The keys for direct messages are stored in the given directory.
+// The given codec is selected when signing in
+func NewChatroomClient(
+	serverAddr url.URL,
+	keyDir string,
+	codec shared.Codec,
+) (*ChatroomClient, error) {
+	keys, err := newKeyRing(keyDir)
+	if err != nil {
+		return nil, err
+	}
+	newChatroomClient := &ChatroomClient{
+		keys:  keys,
+		codec: codec,
+	}
 
 	// Initialize dialer
 	dialer := websocket.Dialer{
@@ -99,7 +127,7 @@ func NewChatroomClient(serverAddr url.URL) (*ChatroomClient, error) {
 			),
 
 			// Define the sub-protocol name to be able to connect to the server
-			SubProtocolName: []byte("chatroom-example-protocol"),
+			SubProtocolName: []byte(shared.SubProtocolName),
 		},
 		&wwrgorilla.ClientTransport{
 			ServerAddress: serverAddr,
@@ -118,6 +146,16 @@ func NewChatroomClient(serverAddr url.URL) (*ChatroomClient, error) {
 var serverAddr = flag.String("addr", "localhost:9090", "server address")
 var password = flag.String("pass", "", "password")
 var username = flag.String("name", "", "username")
+var keyDir = flag.String(
+	"keys",
+	"./keys",
+	"path to the directory storing the keys for direct messages",
+)
+var codecName = flag.String(
+	"codec",
+	"json",
+	"payload codec selected when signing in (json or msgpack)",
+)
 
 func main() {
 	// Parse command line arguments
@@ -130,8 +168,13 @@ func main() {
 		Path:   "/",
 	}
 
+	codec, supported := shared.CodecByName(*codecName)
+	if !supported {
+		log.Fatalf("Unsupported codec: %s", *codecName)
+	}
+
 	// Initialize client
-	chatroomClient, err := NewChatroomClient(serverAddr)
+	chatroomClient, err := NewChatroomClient(serverAddr, *keyDir, codec)
 	if err != nil {
 		panic(err)
 	}
@@ -143,6 +186,9 @@ func main() {
 	); err != nil {
 		log.Fatalf("Couldn't connect to the server: %s", err)
 	}
+	if err := chatroomClient.handshake(); err != nil {
+		log.Fatalf("Protocol negotiation failed: %s", err)
+	}
 	fmt.Println("Connected successfully!")
 
 	// Authenticate if credentials are already provided from the CLI
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// Kick closes the sessions and connections of the given user
+func (clt *ChatroomClient) Kick(user string) {
+	reply, err := clt.request(
+		shared.RequestKick,
+		webwire.Payload{
+			Encoding: webwire.EncodingUtf8,
+			Data:     []byte(user),
+		},
+	)
+	if err != nil {
+		logRequestError(shared.RequestKick, err)
+		return
+	}
+	reply.Close()
+	fmt.Printf("Kicked %s\n", user)
+}
+
+// Ban bans an account disconnecting all of its connections.
+// The argument consists of the username optionally followed
+// by the duration of the ban and the reason
+func (clt *ChatroomClient) Ban(argument string) {
+	fields := strings.Fields(argument)
+	if len(fields) < 1 {
+		fmt.Println("Usage: :ban <user> [duration] [reason]")
+		return
+	}
+	req := shared.BanRequest{User: fields[0]}
+	fields = fields[1:]
+	if len(fields) > 0 {
+		if duration, err := time.ParseDuration(fields[0]); err == nil {
+			if duration < time.Second {
+				fmt.Println("The ban must last at least a second")
+				return
+			}
+			req.Duration = uint64(duration / time.Second)
+			fields = fields[1:]
+		}
+	}
+	req.Reason = strings.Join(fields, " ")
+
+	encoded, err := clt.codec.Marshal(req)
+	if err != nil {
+		panic(fmt.Errorf("Couldn't marshal ban request: %s", err))
+	}
+	reply, err := clt.request(
+		shared.RequestBan,
+		webwire.Payload{
+			Encoding: clt.codec.Encoding(),
+			Data:     encoded,
+		},
+	)
+	if err != nil {
+		logRequestError(shared.RequestBan, err)
+		return
+	}
+	reply.Close()
+	fmt.Printf("Banned %s\n", req.User)
+}
+
+// Unban lifts the ban of the given account
+func (clt *ChatroomClient) Unban(user string) {
+	reply, err := clt.request(
+		shared.RequestUnban,
+		webwire.Payload{
+			Encoding: webwire.EncodingUtf8,
+			Data:     []byte(user),
+		},
+	)
+	if err != nil {
+		logRequestError(shared.RequestUnban, err)
+		return
+	}
+	reply.Close()
+	fmt.Printf("Unbanned %s\n", user)
+}
+
+// Bans prints all banned accounts
+func (clt *ChatroomClient) Bans() {
+	reply, err := clt.request(
+		shared.RequestBans,
+		webwire.Payload{},
+	)
+	if err != nil {
+		logRequestError(shared.RequestBans, err)
+		return
+	}
+	defer reply.Close()
+
+	var bans []shared.Ban
+	if err := shared.Decode(reply, &bans); err != nil {
+		log.Printf("Failed parsing bans: %s", err)
+		return
+	}
+
+	if len(bans) < 1 {
+		fmt.Println("No bans")
+		return
+	}
+	for _, ban := range bans {
+		until := "permanently"
+		if !ban.Until.IsZero() {
+			until = "until " + ban.Until.Local().Format(time.Stamp)
+		}
+		reason := ""
+		if ban.Reason != "" {
+			reason = ": " + ban.Reason
+		}
+		fmt.Printf("  %s banned by %s %s%s\n", ban.User, ban.By, until, reason)
+	}
+}
+
+// Guests prints the anonymous guests connected to the server
+func (clt *ChatroomClient) Guests() {
+	reply, err := clt.request(
+		shared.RequestGuests,
+		webwire.Payload{},
+	)
+	if err != nil {
+		logRequestError(shared.RequestGuests, err)
+		return
+	}
+	defer reply.Close()
+
+	var guests []shared.Guest
+	if err := shared.Decode(reply, &guests); err != nil {
+		log.Printf("Failed parsing guests: %s", err)
+		return
+	}
+
+	if len(guests) < 1 {
+		fmt.Println("No guests")
+		return
+	}
+	for _, guest := range guests {
+		mode := ""
+		if guest.ReadOnly {
+			mode = ", read-only"
+		}
+		fmt.Printf(
+			"  %s from %s in %s since %s%s\n",
+			guest.Name,
+			guest.RemoteAddr,
+			guest.Room,
+			guest.Connected.Local().Format(time.Stamp),
+			mode,
+		)
+	}
+}
+
+// Lockouts prints all currently locked out accounts and addresses
+func (clt *ChatroomClient) Lockouts() {
+	reply, err := clt.request(
+		shared.RequestLockouts,
+		webwire.Payload{},
+	)
+	if err != nil {
+		logRequestError(shared.RequestLockouts, err)
+		return
+	}
+	defer reply.Close()
+
+	var lockouts []shared.Lockout
+	if err := shared.Decode(reply, &lockouts); err != nil {
+		log.Printf("Failed parsing lockouts: %s", err)
+		return
+	}
+
+	if len(lockouts) < 1 {
+		fmt.Println("No lockouts")
+		return
+	}
+	for _, lockout := range lockouts {
+		fmt.Printf(
+			"  %s %s: %d failures, locked until %s\n",
+			lockout.Kind,
+			lockout.Key,
+			lockout.Failures,
+			lockout.LockedUntil.Local().Format(time.Stamp),
+		)
+	}
+}
+
+// ClearLockout lifts the lockout of the given account or address
+func (clt *ChatroomClient) ClearLockout(key string) {
+	reply, err := clt.request(
+		shared.RequestClearLockout,
+		webwire.Payload{
+			Encoding: webwire.EncodingUtf8,
+			Data:     []byte(key),
+		},
+	)
+	if err != nil {
+		logRequestError(shared.RequestClearLockout, err)
+		return
+	}
+	reply.Close()
+	fmt.Printf("Cleared lockout of %s\n", key)
+}
+
+// Metrics prints the number of signals the server couldn't deliver
+func (clt *ChatroomClient) Metrics() {
+	reply, err := clt.request(
+		shared.RequestMetrics,
+		webwire.Payload{},
+	)
+	if err != nil {
+		logRequestError(shared.RequestMetrics, err)
+		return
+	}
+	defer reply.Close()
+
+	var metrics shared.OutboxMetrics
+	if err := shared.Decode(reply, &metrics); err != nil {
+		log.Printf("Failed parsing metrics: %s", err)
+		return
+	}
+	fmt.Printf(
+		"  Dropped oldest: %d\n  Dropped newest: %d\n"+
+			"  Disconnected: %d\n  Failed: %d\n",
+		metrics.DroppedOldest,
+		metrics.DroppedNewest,
+		metrics.Disconnected,
+		metrics.Failed,
+	)
+}
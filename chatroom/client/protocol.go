@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// handshake negotiates the protocol version with the server.
+// The connection is closed if the server doesn't support the client's version
+func (clt *ChatroomClient) handshake() error {
+	encoded, err := json.Marshal(shared.HandshakeRequest{
+		Version: shared.ProtocolVersion,
+		Client:  "chatroom-example-client",
+	})
+	if err != nil {
+		panic(fmt.Errorf("Couldn't marshal handshake: %s", err))
+	}
+
+	reply, err := clt.connection.Request(
+		context.Background(),
+		[]byte(shared.RequestHandshake),
+		webwire.Payload{
+			Encoding: webwire.EncodingUtf8,
+			Data:     encoded,
+		},
+	)
+	if reqErr, isReqErr := err.(webwire.ErrRequest); isReqErr &&
+		reqErr.Code == "INCOMPATIBLE_PROTOCOL" {
+		log.Printf("Incompatible server: %s", reqErr.Message)
+		clt.connection.Close()
+		return err
+	} else if err != nil {
+		return err
+	}
+	defer reply.Close()
+
+	var handshake shared.HandshakeReply
+	if err := shared.Decode(reply, &handshake); err != nil {
+		return fmt.Errorf("Couldn't parse handshake: %s", err)
+	}
+	return nil
+}
+
+// request sends a request to the server. The protocol version is negotiated
+// first if the connection wasn't negotiated yet, which is the case
+// after the initial connection and every reconnection
+func (clt *ChatroomClient) request(
+	name string,
+	payload webwire.Payload,
+) (webwire.Reply, error) {
+	reply, err := clt.connection.Request(
+		context.Background(),
+		[]byte(name),
+		payload,
+	)
+	reqErr, isReqErr := err.(webwire.ErrRequest)
+	if !isReqErr || reqErr.Code != "HANDSHAKE_REQUIRED" {
+		return reply, err
+	}
+
+	if err := clt.handshake(); err != nil {
+		return nil, err
+	}
+	return clt.connection.Request(
+		context.Background(),
+		[]byte(name),
+		payload,
+	)
+}
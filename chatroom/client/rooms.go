@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// printRoom prints the metadata of a room
+func printRoom(metadata shared.RoomMetadata) {
+	fmt.Printf("Room: %s (%s)\n", metadata.Name, metadata.Access)
+	if metadata.Topic != "" {
+		fmt.Printf("  Topic: %s\n", metadata.Topic)
+	}
+	for _, pinned := range metadata.Pinned {
+		fmt.Printf("  Pinned #%d by %s: %s\n", pinned.ID, pinned.User, pinned.Msg)
+	}
+}
+
+// onRoomUpdate prints the updated metadata of the current room
+func (clt *ChatroomClient) onRoomUpdate(msg webwire.Message) {
+	var metadata shared.RoomMetadata
+	if err := shared.Decode(msg, &metadata); err != nil {
+		log.Printf("Failed parsing room update: %s", err)
+		return
+	}
+	fmt.Println("Room updated")
+	printRoom(metadata)
+}
+
+// onJoined sets the room the server joined the client to on connection
+func (clt *ChatroomClient) onJoined(msg webwire.Message) {
+	var metadata shared.RoomMetadata
+	if err := shared.Decode(msg, &metadata); err != nil {
+		log.Printf("Failed parsing joined room: %s", err)
+		return
+	}
+	clt.setRoom(metadata.Name)
+	printRoom(metadata)
+}
+
+// roomRequest sends a room related request to the server
+// and returns the metadata of the affected room from the reply
+func (clt *ChatroomClient) roomRequest(
+	name string,
+	payload webwire.Payload,
+) (shared.RoomMetadata, error) {
+	reply, err := clt.request(
+		name,
+		payload,
+	)
+	if err != nil {
+		return shared.RoomMetadata{}, err
+	}
+	defer reply.Close()
+
+	var metadata shared.RoomMetadata
+	if err := shared.Decode(reply, &metadata); err != nil {
+		return shared.RoomMetadata{}, fmt.Errorf(
+			"Failed parsing room metadata: %s",
+			err,
+		)
+	}
+	return metadata, nil
+}
+
+// roomCommand encodes the given command, sends it as a request
+// and prints the updated room metadata
+func (clt *ChatroomClient) roomCommand(name string, command interface{}) {
+	encoded, err := clt.codec.Marshal(command)
+	if err != nil {
+		panic(fmt.Errorf("Couldn't marshal %s request: %s", name, err))
+	}
+
+	metadata, err := clt.roomRequest(name, webwire.Payload{
+		Encoding: clt.codec.Encoding(),
+		Data:     encoded,
+	})
+	if err != nil {
+		logRequestError(name, err)
+		return
+	}
+	printRoom(metadata)
+}
+
+// logRequestError logs a failed request
+func logRequestError(name string, err error) {
+	switch err := err.(type) {
+	case webwire.ErrRequest:
+		log.Printf("Request %s failed: %s : %s", name, err.Code, err.Message)
+	case webwire.ErrServerShutdown:
+		log.Printf("Request %s failed, server is being shut down", name)
+	default:
+		log.Printf("Request %s failed: %s", name, err)
+	}
+}
+
+// Room returns the name of the room the client is currently in
+func (clt *ChatroomClient) Room() string {
+	clt.lock.Lock()
+	defer clt.lock.Unlock()
+	return clt.room
+}
+
+// setRoom changes the name of the room the client is currently in
+func (clt *ChatroomClient) setRoom(room string) {
+	clt.lock.Lock()
+	clt.room = room
+	clt.lock.Unlock()
+}
+
+// Join moves the client into the given room
+func (clt *ChatroomClient) Join(room string) {
+	metadata, err := clt.roomRequest(shared.RequestJoin, webwire.Payload{
+		Encoding: webwire.EncodingUtf8,
+		Data:     []byte(room),
+	})
+	if err != nil {
+		logRequestError(shared.RequestJoin, err)
+		return
+	}
+	clt.setRoom(metadata.Name)
+	printRoom(metadata)
+}
+
+// SetTopic changes the topic of the current room
+func (clt *ChatroomClient) SetTopic(topic string) {
+	clt.roomCommand(shared.RequestSetTopic, shared.SetTopicRequest{
+		Room:  clt.Room(),
+		Topic: topic,
+	})
+}
+
+// Pin pins a message to the current room
+func (clt *ChatroomClient) Pin(msg string) {
+	clt.roomCommand(shared.RequestPin, shared.PinRequest{
+		Room: clt.Room(),
+		Msg:  msg,
+	})
+}
+
+// Unpin removes a pinned message from the current room
+func (clt *ChatroomClient) Unpin(id string) {
+	pinID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		fmt.Printf("Invalid pinned message id: %s\n", id)
+		return
+	}
+	clt.roomCommand(shared.RequestUnpin, shared.UnpinRequest{
+		Room: clt.Room(),
+		ID:   pinID,
+	})
+}
+
+// Invite invites a user to the current room
+func (clt *ChatroomClient) Invite(user string) {
+	clt.roomCommand(shared.RequestInvite, shared.InviteRequest{
+		Room: clt.Room(),
+		User: user,
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// maxReconnectJitter defines the maximum random delay added before
+// reconnecting after a server shutdown to prevent all clients from
+// reconnecting at the same time
+const maxReconnectJitter = 10 * time.Second
+
+// minReconnectBackoff and maxReconnectBackoff define the bounds
+// of the exponentially growing delay between failed reconnection attempts
+const (
+	minReconnectBackoff = 1 * time.Second
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// shutdownGrace defines how long the client waits for the server to close
+// the connection after the announced shutdown time before closing it itself
+const shutdownGrace = 2 * time.Second
+
+// claimShutdown returns true if a shutdown notice was received and the
+// connection wasn't yet closed for it. Only the first caller closes
+// the connection, either the disconnection hook or the reconnection timer
+func (clt *ChatroomClient) claimShutdown() bool {
+	clt.lock.Lock()
+	defer clt.lock.Unlock()
+	if !clt.shutdownPending {
+		return false
+	}
+	clt.shutdownPending = false
+	return true
+}
+
+// onShutdownNotice displays the shutdown notice and schedules a reconnection
+// to the server after the announced downtime plus a random jitter.
+// Failed reconnection attempts are retried with a jittered backoff
+// until the server is back
+func (clt *ChatroomClient) onShutdownNotice(msg webwire.Message) {
+	var notice shared.ShutdownNotice
+	if err := shared.Decode(msg, &notice); err != nil {
+		log.Printf("Failed parsing shutdown notice: %s", err)
+		return
+	}
+
+	reconnectAt := notice.ShutdownAt
+	if notice.BackAt.IsZero() {
+		fmt.Printf(
+			"*** The server is shutting down for %s at %s ***\n",
+			notice.Reason,
+			notice.ShutdownAt.Local().Format(time.Stamp),
+		)
+	} else {
+		reconnectAt = notice.BackAt
+		fmt.Printf(
+			"*** The server is shutting down for %s at %s, "+
+				"expected to be back at %s ***\n",
+			notice.Reason,
+			notice.ShutdownAt.Local().Format(time.Stamp),
+			notice.BackAt.Local().Format(time.Stamp),
+		)
+	}
+
+	clt.lock.Lock()
+	if clt.shutdownPending {
+		// The reconnection is already scheduled
+		clt.lock.Unlock()
+		return
+	}
+	clt.shutdownPending = true
+	clt.lock.Unlock()
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitter := time.Duration(random.Int63n(int64(maxReconnectJitter)))
+
+	go func() {
+		// Prevent the client from immediately reconnecting to a server
+		// that's still shutting down. The server usually closes
+		// the connection first which deactivates autoconnect
+		time.Sleep(time.Until(notice.ShutdownAt) + shutdownGrace)
+		if clt.claimShutdown() {
+			clt.connection.Close()
+		}
+
+		delay := time.Until(reconnectAt) + jitter
+		fmt.Printf("Reconnecting in %s...\n", delay.Round(time.Second))
+		time.Sleep(delay)
+
+		backoff := minReconnectBackoff
+		for {
+			err := clt.connection.Connect(context.Background())
+			if err == nil {
+				break
+			}
+			retryIn := backoff/2 + time.Duration(random.Int63n(int64(backoff)))
+			log.Printf(
+				"Couldn't reconnect: %s, retrying in %s",
+				err,
+				retryIn.Round(time.Second),
+			)
+			time.Sleep(retryIn)
+			if backoff *= 2; backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+		}
+		fmt.Println("Reconnected successfully!")
+	}()
+}
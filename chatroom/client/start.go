@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
 )
 
 func promptCreds() (username, password string) {
@@ -32,6 +34,18 @@ func promptCreds() (username, password string) {
 	return username, password
 }
 
+// parseCommand splits a command input line such as ":join lobby"
+// into the command and its argument
+func parseCommand(input string) (command, argument string) {
+	if !strings.HasPrefix(input, ":") {
+		return input, ""
+	}
+	if index := strings.IndexByte(input, ' '); index > 0 {
+		return input[:index], strings.TrimSpace(input[index+1:])
+	}
+	return input, ""
+}
+
 // Start runs the main loop blocking the calling goroutine
 func (clt *ChatroomClient) Start() {
 	defer clt.connection.Close()
@@ -47,7 +61,8 @@ MAINLOOP:
 		if len(input) < 1 {
 			continue
 		}
-		switch input {
+		command, argument := parseCommand(input)
+		switch command {
 		case ":x":
 			fmt.Println("Closing connection...")
 			break MAINLOOP
@@ -71,13 +86,50 @@ MAINLOOP:
 			if err := clt.connection.Connect(context.Background()); err != nil {
 				fmt.Printf("Error while connecting: %s\n", err)
 			}
+		case ":join":
+			clt.Join(argument)
+		case ":topic":
+			clt.SetTopic(argument)
+		case ":pin":
+			clt.Pin(argument)
+		case ":unpin":
+			clt.Unpin(argument)
+		case ":invite":
+			clt.Invite(argument)
+		case ":kick":
+			clt.Kick(argument)
+		case ":ban":
+			clt.Ban(argument)
+		case ":unban":
+			clt.Unban(argument)
+		case ":bans":
+			clt.Bans()
+		case ":guests":
+			clt.Guests()
+		case ":lockouts":
+			clt.Lockouts()
+		case ":unlock":
+			clt.ClearLockout(argument)
+		case ":devices":
+			clt.Devices()
+		case ":signout":
+			clt.SignoutDevice(argument)
+		case ":who":
+			clt.Who()
+		case ":dm":
+			clt.SendDirectMessage(argument)
+		case ":fingerprint":
+			clt.Fingerprint(argument)
+		case ":trust":
+			clt.Trust(argument)
+		case ":metrics":
+			clt.Metrics()
 		default:
 			// Send the message and await server reply
 			// for the message to be considered posted
 			go func() {
-				reply, err := clt.connection.Request(
-					context.Background(),
-					[]byte("msg"),
+				reply, err := clt.request(
+					shared.RequestMessage,
 					webwire.Payload{
 						Encoding: webwire.EncodingBinary,
 						Data:     []byte(input),
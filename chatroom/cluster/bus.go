@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Event represents a message published by one node to all other nodes
+type Event struct {
+	// Node identifies the publishing node
+	Node string `json:"node"`
+
+	// Kind defines how the event data is to be interpreted
+	Kind string `json:"kind"`
+
+	// Data contains the JSON encoded event data
+	Data json.RawMessage `json:"data"`
+}
+
+// Bus defines the interface of an inter-node message bus
+// connecting the nodes of a chatroom cluster
+type Bus interface {
+	// NodeID returns the unique identifier of this node
+	NodeID() string
+
+	// Publish sends an event to all other nodes of the cluster.
+	// The node field of the event is set automatically
+	Publish(kind string, data interface{}) error
+
+	// OnEvent sets the handler invoked for each event published by another
+	// node. Events are handled sequentially in the order they were received
+	OnEvent(handler func(Event))
+
+	// Close disconnects the node from the bus
+	Close() error
+}
+
+// SessionStore defines the interface of a session store
+// shared by all nodes of a chatroom cluster
+type SessionStore interface {
+	// Put stores the session record identified by the given key
+	Put(key string, record []byte) error
+
+	// Get returns the session record identified by the given key.
+	// Returns false if there's no such record
+	Get(key string) (record []byte, found bool, err error)
+
+	// Delete removes the session record identified by the given key
+	Delete(key string) error
+}
+
+// newNodeID generates a random node identifier
+func newNodeID() string {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(fmt.Errorf("Couldn't generate node id: %s", err))
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// newEvent constructs a new event encoding the given data
+func newEvent(node, kind string, data interface{}) (Event, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, fmt.Errorf("Couldn't marshal %s event: %s", kind, err)
+	}
+	return Event{
+		Node: node,
+		Kind: kind,
+		Data: encoded,
+	}, nil
+}
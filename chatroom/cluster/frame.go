@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// frameType defines the type of a TCP bus frame
+type frameType string
+
+const (
+	// framePublish carries an event published by a node
+	framePublish frameType = "publish"
+
+	// framePut requests the hub to store a session record
+	framePut frameType = "put"
+
+	// frameGet requests a session record from the hub
+	frameGet frameType = "get"
+
+	// frameDelete requests the hub to remove a session record
+	frameDelete frameType = "delete"
+
+	// frameReply carries the reply of the hub to a session store request
+	frameReply frameType = "reply"
+)
+
+// frame represents a single newline-delimited JSON message
+// exchanged between a TCP hub and its nodes
+type frame struct {
+	Type  frameType `json:"type"`
+	ID    uint64    `json:"id,omitempty"`
+	Event *Event    `json:"event,omitempty"`
+	Key   string    `json:"key,omitempty"`
+	Data  []byte    `json:"data,omitempty"`
+	Found bool      `json:"found,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// frameWriter writes frames to a connection.
+// It's safe for concurrent use
+type frameWriter struct {
+	writer io.Writer
+	lock   sync.Mutex
+}
+
+// Write encodes and writes a single frame
+func (wrt *frameWriter) Write(frm frame) error {
+	encoded, err := json.Marshal(frm)
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal %s frame: %s", frm.Type, err)
+	}
+	wrt.lock.Lock()
+	defer wrt.lock.Unlock()
+	_, err = wrt.writer.Write(append(encoded, '\n'))
+	return err
+}
+
+// readFrames reads frames from the given reader passing each of them to the
+// given handler until the reader fails
+func readFrames(reader io.Reader, handler func(frame)) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var frm frame
+		if err := json.Unmarshal(scanner.Bytes(), &frm); err != nil {
+			return fmt.Errorf("Malformed frame: %s", err)
+		}
+		handler(frm)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
@@ -0,0 +1,128 @@
+package cluster
+
+import "sync"
+
+// LocalHub implements an in-process bus and session store connecting nodes
+// running in the same process. It's mainly intended for testing and for
+// running a single standalone node
+type LocalHub struct {
+	nodes    map[*localNode]bool
+	sessions map[string][]byte
+	lock     sync.RWMutex
+}
+
+// NewLocalHub constructs a new in-process hub
+func NewLocalHub() *LocalHub {
+	return &LocalHub{
+		nodes:    make(map[*localNode]bool),
+		sessions: make(map[string][]byte),
+	}
+}
+
+// Join connects a new node to the hub
+func (hub *LocalHub) Join() Bus {
+	node := &localNode{
+		id:  newNodeID(),
+		hub: hub,
+	}
+	hub.lock.Lock()
+	hub.nodes[node] = true
+	hub.lock.Unlock()
+	return node
+}
+
+// Sessions returns the session store shared by all nodes of the hub
+func (hub *LocalHub) Sessions() SessionStore {
+	return hub
+}
+
+// Put implements the SessionStore interface
+func (hub *LocalHub) Put(key string, record []byte) error {
+	hub.lock.Lock()
+	hub.sessions[key] = append([]byte(nil), record...)
+	hub.lock.Unlock()
+	return nil
+}
+
+// Get implements the SessionStore interface
+func (hub *LocalHub) Get(key string) ([]byte, bool, error) {
+	hub.lock.RLock()
+	defer hub.lock.RUnlock()
+	record, found := hub.sessions[key]
+	if !found {
+		return nil, false, nil
+	}
+	return append([]byte(nil), record...), true, nil
+}
+
+// Delete implements the SessionStore interface
+func (hub *LocalHub) Delete(key string) error {
+	hub.lock.Lock()
+	delete(hub.sessions, key)
+	hub.lock.Unlock()
+	return nil
+}
+
+// publish delivers the event to all nodes except the publishing one
+func (hub *LocalHub) publish(event Event, publisher *localNode) {
+	hub.lock.RLock()
+	receivers := make([]*localNode, 0, len(hub.nodes))
+	for node := range hub.nodes {
+		if node != publisher {
+			receivers = append(receivers, node)
+		}
+	}
+	hub.lock.RUnlock()
+
+	for _, node := range receivers {
+		node.deliver(event)
+	}
+}
+
+// localNode implements the Bus interface for nodes of a local hub
+type localNode struct {
+	id      string
+	hub     *LocalHub
+	handler func(Event)
+	lock    sync.Mutex
+}
+
+// NodeID implements the Bus interface
+func (node *localNode) NodeID() string {
+	return node.id
+}
+
+// Publish implements the Bus interface
+func (node *localNode) Publish(kind string, data interface{}) error {
+	event, err := newEvent(node.id, kind, data)
+	if err != nil {
+		return err
+	}
+	node.hub.publish(event, node)
+	return nil
+}
+
+// OnEvent implements the Bus interface
+func (node *localNode) OnEvent(handler func(Event)) {
+	node.lock.Lock()
+	node.handler = handler
+	node.lock.Unlock()
+}
+
+// deliver passes an event to the event handler.
+// Events are handled sequentially
+func (node *localNode) deliver(event Event) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	if node.handler != nil {
+		node.handler(event)
+	}
+}
+
+// Close implements the Bus interface
+func (node *localNode) Close() error {
+	node.hub.lock.Lock()
+	delete(node.hub.nodes, node)
+	node.hub.lock.Unlock()
+	return nil
+}
@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"log"
+	"net"
+	"sync"
+)
+
+// TCPHub relays events between nodes connected over TCP
+// and hosts the session store shared by them
+type TCPHub struct {
+	listener net.Listener
+	sessions *LocalHub
+	nodes    map[*frameWriter]bool
+	lock     sync.RWMutex
+}
+
+// ListenTCPHub starts listening for nodes on the given address
+func ListenTCPHub(addr string) (*TCPHub, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPHub{
+		listener: listener,
+		sessions: NewLocalHub(),
+		nodes:    make(map[*frameWriter]bool),
+	}, nil
+}
+
+// Addr returns the address the hub is listening on
+func (hub *TCPHub) Addr() net.Addr {
+	return hub.listener.Addr()
+}
+
+// Serve accepts and serves nodes blocking the calling goroutine
+// until the hub is closed
+func (hub *TCPHub) Serve() error {
+	for {
+		conn, err := hub.listener.Accept()
+		if err != nil {
+			return err
+		}
+		go hub.serveNode(conn)
+	}
+}
+
+// Close stops accepting new nodes
+func (hub *TCPHub) Close() error {
+	return hub.listener.Close()
+}
+
+// serveNode serves a single connected node until it disconnects
+func (hub *TCPHub) serveNode(conn net.Conn) {
+	defer conn.Close()
+
+	writer := &frameWriter{writer: conn}
+	hub.lock.Lock()
+	hub.nodes[writer] = true
+	hub.lock.Unlock()
+
+	err := readFrames(conn, func(frm frame) {
+		hub.handleFrame(writer, frm)
+	})
+
+	hub.lock.Lock()
+	delete(hub.nodes, writer)
+	hub.lock.Unlock()
+
+	log.Printf("Cluster node %s disconnected: %s", conn.RemoteAddr(), err)
+}
+
+// handleFrame handles a single frame received from a node
+func (hub *TCPHub) handleFrame(sender *frameWriter, frm frame) {
+	reply := frame{
+		Type: frameReply,
+		ID:   frm.ID,
+	}
+
+	var err error
+	switch frm.Type {
+	case framePublish:
+		hub.relay(sender, frm)
+		return
+	case framePut:
+		err = hub.sessions.Put(frm.Key, frm.Data)
+	case frameGet:
+		reply.Data, reply.Found, err = hub.sessions.Get(frm.Key)
+	case frameDelete:
+		err = hub.sessions.Delete(frm.Key)
+	default:
+		log.Printf("Unexpected cluster frame type: %s", frm.Type)
+		return
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+
+	if err := sender.Write(reply); err != nil {
+		log.Printf("Couldn't reply to cluster node: %s", err)
+	}
+}
+
+// relay forwards a published event to all nodes except the sender.
+// The nodes are written to without holding the lock
+// for a stalled node to not block nodes from connecting or disconnecting
+func (hub *TCPHub) relay(sender *frameWriter, frm frame) {
+	hub.lock.RLock()
+	receivers := make([]*frameWriter, 0, len(hub.nodes))
+	for node := range hub.nodes {
+		if node != sender {
+			receivers = append(receivers, node)
+		}
+	}
+	hub.lock.RUnlock()
+
+	for _, node := range receivers {
+		if err := node.Write(frm); err != nil {
+			log.Printf("Couldn't relay event to cluster node: %s", err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+// TCPNode implements both the Bus and the SessionStore interface
+// for a node connected to a TCP hub. Events are handled by the goroutine
+// reading from the hub connection, the event handler must therefore not
+// use the session store
+type TCPNode struct {
+	id           string
+	conn         net.Conn
+	writer       *frameWriter
+	handler      func(Event)
+	lastID       uint64
+	pending      map[uint64]chan frame
+	disconnected bool
+	lock         sync.Mutex
+}
+
+// DialTCP connects a new node to the TCP hub at the given address
+func DialTCP(addr string) (*TCPNode, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	node := &TCPNode{
+		id:      newNodeID(),
+		conn:    conn,
+		writer:  &frameWriter{writer: conn},
+		pending: make(map[uint64]chan frame),
+	}
+	go node.read()
+	return node, nil
+}
+
+// read dispatches incoming frames until the connection is closed
+func (node *TCPNode) read() {
+	err := readFrames(node.conn, func(frm frame) {
+		switch frm.Type {
+		case framePublish:
+			node.lock.Lock()
+			handler := node.handler
+			node.lock.Unlock()
+			if handler != nil && frm.Event != nil {
+				handler(*frm.Event)
+			}
+		case frameReply:
+			node.lock.Lock()
+			reply, exists := node.pending[frm.ID]
+			delete(node.pending, frm.ID)
+			node.lock.Unlock()
+			if exists {
+				reply <- frm
+			}
+		}
+	})
+	log.Printf("Disconnected from cluster hub: %s", err)
+
+	// Fail all pending requests
+	node.lock.Lock()
+	node.disconnected = true
+	for id, reply := range node.pending {
+		reply <- frame{Type: frameReply, Error: "disconnected from hub"}
+		delete(node.pending, id)
+	}
+	node.lock.Unlock()
+}
+
+// request sends a session store request to the hub and awaits the reply
+func (node *TCPNode) request(frm frame) (frame, error) {
+	reply := make(chan frame, 1)
+	node.lock.Lock()
+	if node.disconnected {
+		node.lock.Unlock()
+		return frame{}, errors.New("disconnected from hub")
+	}
+	node.lastID++
+	frm.ID = node.lastID
+	node.pending[frm.ID] = reply
+	node.lock.Unlock()
+
+	if err := node.writer.Write(frm); err != nil {
+		node.lock.Lock()
+		delete(node.pending, frm.ID)
+		node.lock.Unlock()
+		return frame{}, fmt.Errorf("Couldn't send %s request: %s", frm.Type, err)
+	}
+
+	result := <-reply
+	if result.Error != "" {
+		return frame{}, errors.New(result.Error)
+	}
+	return result, nil
+}
+
+// NodeID implements the Bus interface
+func (node *TCPNode) NodeID() string {
+	return node.id
+}
+
+// Publish implements the Bus interface
+func (node *TCPNode) Publish(kind string, data interface{}) error {
+	event, err := newEvent(node.id, kind, data)
+	if err != nil {
+		return err
+	}
+	return node.writer.Write(frame{
+		Type:  framePublish,
+		Event: &event,
+	})
+}
+
+// OnEvent implements the Bus interface
+func (node *TCPNode) OnEvent(handler func(Event)) {
+	node.lock.Lock()
+	node.handler = handler
+	node.lock.Unlock()
+}
+
+// Close implements the Bus interface
+func (node *TCPNode) Close() error {
+	return node.conn.Close()
+}
+
+// Put implements the SessionStore interface
+func (node *TCPNode) Put(key string, record []byte) error {
+	_, err := node.request(frame{
+		Type: framePut,
+		Key:  key,
+		Data: record,
+	})
+	return err
+}
+
+// Get implements the SessionStore interface
+func (node *TCPNode) Get(key string) ([]byte, bool, error) {
+	reply, err := node.request(frame{
+		Type: frameGet,
+		Key:  key,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return reply.Data, reply.Found, nil
+}
+
+// Delete implements the SessionStore interface
+func (node *TCPNode) Delete(key string) error {
+	_, err := node.request(frame{
+		Type: frameDelete,
+		Key:  key,
+	})
+	return err
+}
@@ -0,0 +1,143 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// testTimeout defines how long the tests wait for an expected event
+const testTimeout = 5 * time.Second
+
+// startHub starts a TCP hub on an ephemeral port
+func startHub(t *testing.T) *TCPHub {
+	hub, err := ListenTCPHub("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("couldn't start hub: %s", err)
+	}
+	go hub.Serve()
+	return hub
+}
+
+// joinHub connects a new node to the given hub
+// passing all events it receives to the returned channel
+func joinHub(t *testing.T, hub *TCPHub) (*TCPNode, chan Event) {
+	node, err := DialTCP(hub.Addr().String())
+	if err != nil {
+		t.Fatalf("couldn't join hub: %s", err)
+	}
+	events := make(chan Event, 16)
+	node.OnEvent(func(event Event) {
+		events <- event
+	})
+	return node, events
+}
+
+// TestTCPBus tests relaying events to all nodes except the publishing one
+func TestTCPBus(t *testing.T) {
+	hub := startHub(t)
+	defer hub.Close()
+
+	nodes := make([]*TCPNode, 3)
+	events := make([]chan Event, 3)
+	for i := range nodes {
+		nodes[i], events[i] = joinHub(t, hub)
+		defer nodes[i].Close()
+	}
+
+	// Make sure all nodes are registered by the hub before publishing
+	for _, node := range nodes {
+		if _, _, err := node.Get("sync"); err != nil {
+			t.Fatalf("request to the hub failed: %s", err)
+		}
+	}
+
+	if err := nodes[0].Publish("test", map[string]string{
+		"msg": "You shall not pass",
+	}); err != nil {
+		t.Fatalf("publishing failed: %s", err)
+	}
+
+	for _, received := range events[1:] {
+		select {
+		case event := <-received:
+			if event.Node != nodes[0].NodeID() || event.Kind != "test" {
+				t.Fatalf("unexpected event: %+v", event)
+			}
+			var data map[string]string
+			if err := json.Unmarshal(event.Data, &data); err != nil {
+				t.Fatalf("couldn't parse event data: %s", err)
+			}
+			if data["msg"] != "You shall not pass" {
+				t.Fatalf("unexpected event data: %v", data)
+			}
+		case <-time.After(testTimeout):
+			t.Fatal("event not relayed")
+		}
+	}
+
+	select {
+	case event := <-events[0]:
+		t.Fatalf("event relayed back to the publisher: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestTCPSessionStore tests storing, reading and deleting session records
+// through different nodes of the same hub
+func TestTCPSessionStore(t *testing.T) {
+	hub := startHub(t)
+	defer hub.Close()
+
+	writer, _ := joinHub(t, hub)
+	defer writer.Close()
+	reader, _ := joinHub(t, hub)
+	defer reader.Close()
+
+	if _, found, err := reader.Get("key"); err != nil || found {
+		t.Fatalf("unexpected record before storing: %t, %v", found, err)
+	}
+
+	record := []byte(`{"i":{"username":"Frodo"}}`)
+	if err := writer.Put("key", record); err != nil {
+		t.Fatalf("storing failed: %s", err)
+	}
+	stored, found, err := reader.Get("key")
+	if err != nil || !found {
+		t.Fatalf("stored record not found: %v", err)
+	}
+	if !bytes.Equal(stored, record) {
+		t.Fatalf("unexpected record: %s", stored)
+	}
+
+	if err := reader.Delete("key"); err != nil {
+		t.Fatalf("deleting failed: %s", err)
+	}
+	if _, found, err := writer.Get("key"); err != nil || found {
+		t.Fatalf("unexpected record after deleting: %t, %v", found, err)
+	}
+}
+
+// TestTCPNodeDisconnected tests failing session store requests
+// after the connection to the hub was lost
+func TestTCPNodeDisconnected(t *testing.T) {
+	hub := startHub(t)
+	defer hub.Close()
+
+	node, _ := joinHub(t, hub)
+	node.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- node.Put("key", []byte("{}"))
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("request succeeded after disconnecting")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("request blocked after disconnecting")
+	}
+}
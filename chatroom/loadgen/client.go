@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+	wwrclt "github.com/qbeon/webwire-go-client"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// messagePrefix marks the messages sent by the load generator
+const messagePrefix = "loadgen"
+
+// encodeMessage embeds the message identifier
+// and the send time into a chat message
+func encodeMessage(id uint64, sentAt time.Time) string {
+	return fmt.Sprintf("%s %d %d", messagePrefix, id, sentAt.UnixNano())
+}
+
+// decodeMessage extracts the message identifier and the send time
+// from a chat message. Returns false for messages not sent by the generator
+func decodeMessage(msg string) (uint64, time.Time, bool) {
+	fields := strings.Fields(msg)
+	if len(fields) != 3 || fields[0] != messagePrefix {
+		return 0, time.Time{}, false
+	}
+	id, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	return id, time.Unix(0, nanos), true
+}
+
+// loadClient implements the wwrclt.Implementation interface
+// recording the delivery of all generated messages
+type loadClient struct {
+	index      uint32
+	room       string
+	codec      shared.Codec
+	connection wwrclt.Client
+	recorder   *recorder
+	seq        uint32
+}
+
+// OnDisconnected implements the wwrclt.Implementation interface
+func (clt *loadClient) OnDisconnected() {}
+
+// OnSessionCreated implements the wwrclt.Implementation interface
+func (clt *loadClient) OnSessionCreated(*wwr.Session) {}
+
+// OnSessionClosed implements the wwrclt.Implementation interface
+func (clt *loadClient) OnSessionClosed() {}
+
+// OnSignal implements the wwrclt.Implementation interface
+func (clt *loadClient) OnSignal(msg wwr.Message) {
+	if string(msg.Name()) != shared.SignalChat {
+		return
+	}
+	var chatMsg shared.ChatMessage
+	if err := shared.Decode(msg, &chatMsg); err != nil {
+		return
+	}
+
+	// The clients share the accounts and the server echoes messages
+	// to all clients of the sender's account regardless of their room.
+	// Only deliveries to the members of the room are expected
+	if chatMsg.Room != clt.room {
+		return
+	}
+	if id, sentAt, ok := decodeMessage(chatMsg.Msg); ok {
+		clt.recorder.Delivered(id, sentAt)
+	}
+}
+
+// request sends a request and discards the reply
+func (clt *loadClient) request(name string, data []byte) error {
+	reply, err := clt.connection.Request(
+		context.Background(),
+		[]byte(name),
+		wwr.Payload{
+			Encoding: wwr.EncodingUtf8,
+			Data:     data,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	reply.Close()
+	return nil
+}
+
+// setup connects the client, signs in and joins the client's room
+func (clt *loadClient) setup(username string) error {
+	if err := clt.connection.Connect(context.Background()); err != nil {
+		return fmt.Errorf("Couldn't connect: %s", err)
+	}
+	handshake, err := json.Marshal(shared.HandshakeRequest{
+		Version: shared.ProtocolVersion,
+		Client:  "chatroom-loadgen",
+	})
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal handshake: %s", err)
+	}
+	if err := clt.request(shared.RequestHandshake, handshake); err != nil {
+		return fmt.Errorf("Protocol negotiation failed: %s", err)
+	}
+	credentials, err := json.Marshal(shared.AuthenticationCredentials{
+		Name:     username,
+		Password: shared.UserAccounts[username],
+		Codec:    clt.codec.Name(),
+	})
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal credentials: %s", err)
+	}
+	if err := clt.request(shared.RequestAuth, credentials); err != nil {
+		return fmt.Errorf("Couldn't authenticate as %s: %s", username, err)
+	}
+	if err := clt.request(shared.RequestJoin, []byte(clt.room)); err != nil {
+		return fmt.Errorf("Couldn't join room %s: %s", clt.room, err)
+	}
+	return nil
+}
+
+// send posts a generated message to the client's room. The message is
+// expected to be delivered to the given number of clients
+func (clt *loadClient) send(expected int) {
+	clt.seq++
+	id := uint64(clt.index)<<32 | uint64(clt.seq)
+	sentAt := time.Now()
+
+	err := clt.request(
+		shared.RequestMessage,
+		[]byte(encodeMessage(id, sentAt)),
+	)
+	switch err := err.(type) {
+	case nil:
+		clt.recorder.Sent(id, sentAt, expected)
+	case wwr.ErrRequest:
+		clt.recorder.Failed(id, sentAt, err.Code)
+	default:
+		clt.recorder.Failed(id, sentAt, fmt.Sprintf("%T", err))
+	}
+}
+
+// run sends messages at the given rate until the context is canceled
+func (clt *loadClient) run(
+	ctx context.Context,
+	interval time.Duration,
+	expected int,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			clt.send(expected)
+		}
+	}
+}
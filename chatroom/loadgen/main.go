@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	wwr "github.com/qbeon/webwire-go"
+	wwrclt "github.com/qbeon/webwire-go-client"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+	wwrgorilla "github.com/qbeon/webwire-go-gorilla"
+)
+
+var argServerAddr = flag.String("addr", "localhost:9090", "server address")
+var argClients = flag.Int("clients", 1000, "number of concurrent clients")
+var argSenders = flag.Float64(
+	"senders",
+	0.1,
+	"fraction of the clients sending messages, all clients receive",
+)
+var argRate = flag.Float64(
+	"rate",
+	0.5,
+	"messages sent per second by each sending client",
+)
+var argRooms = flag.String(
+	"rooms",
+	"lobby=1",
+	"comma-separated rooms and their weights the clients are distributed to",
+)
+var argRampUp = flag.Duration(
+	"ramp-up",
+	10*time.Second,
+	"duration over which the clients are connected",
+)
+var argDuration = flag.Duration(
+	"duration",
+	1*time.Minute,
+	"duration messages are sent for",
+)
+var argGrace = flag.Duration(
+	"grace",
+	5*time.Second,
+	"time to wait for in-flight messages after sending stopped",
+)
+var argCodec = flag.String(
+	"codec",
+	"json",
+	"payload codec selected by the clients (json or msgpack)",
+)
+var argTimelineFilePath = flag.String(
+	"csv",
+	"./timeline.csv",
+	"path to the CSV timeline file, no timeline is written if empty",
+)
+
+// roomWeight represents a room and the share of clients joined to it
+type roomWeight struct {
+	name   string
+	weight int
+}
+
+// parseRooms parses a room distribution such as "lobby=3,council=1"
+func parseRooms(spec string) ([]roomWeight, error) {
+	var rooms []roomWeight
+	for _, item := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		room := roomWeight{name: parts[0], weight: 1}
+		if room.name == "" {
+			return nil, fmt.Errorf("empty room name in '%s'", spec)
+		}
+		if len(parts) == 2 {
+			weight, err := strconv.Atoi(parts[1])
+			if err != nil || weight < 1 {
+				return nil, fmt.Errorf("invalid weight of room %s", room.name)
+			}
+			room.weight = weight
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, nil
+}
+
+// assignRoom returns the room of the client with the given index
+// distributing the clients according to the room weights
+func assignRoom(rooms []roomWeight, index int) string {
+	total := 0
+	for _, room := range rooms {
+		total += room.weight
+	}
+	slot := index % total
+	for _, room := range rooms {
+		if slot < room.weight {
+			return room.name
+		}
+		slot -= room.weight
+	}
+	return rooms[0].name
+}
+
+// newLoadClient creates a new client
+func newLoadClient(
+	index int,
+	room string,
+	codec shared.Codec,
+	serverAddr url.URL,
+	rec *recorder,
+) (*loadClient, error) {
+	clt := &loadClient{
+		index:    uint32(index),
+		room:     room,
+		codec:    codec,
+		recorder: rec,
+	}
+	connection, err := wwrclt.NewClient(
+		clt,
+		wwrclt.Options{
+			DefaultRequestTimeout: 10 * time.Second,
+			Autoconnect:           wwr.Disabled,
+			SessionInfoParser:     shared.SessionInfoParser,
+			SubProtocolName:       []byte(shared.SubProtocolName),
+			WarnLog:               log.New(ioutil.Discard, "", 0),
+			ErrorLog:              log.New(os.Stderr, "ERR: ", log.Ltime),
+		},
+		&wwrgorilla.ClientTransport{
+			ServerAddress: serverAddr,
+			Dialer: websocket.Dialer{
+				// The example server uses a self-signed certificate
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	clt.connection = connection
+	return clt, nil
+}
+
+func main() {
+	// Parse command line arguments
+	flag.Parse()
+
+	rooms, err := parseRooms(*argRooms)
+	if err != nil {
+		log.Fatalf("Invalid room distribution: %s", err)
+	}
+	if *argClients < 1 || *argRate <= 0 ||
+		*argSenders <= 0 || *argSenders > 1 {
+		log.Fatal("Invalid load: clients, rate and senders must be positive")
+	}
+	codec, supported := shared.CodecByName(*argCodec)
+	if !supported {
+		log.Fatalf("Unsupported codec: %s", *argCodec)
+	}
+
+	serverAddr := url.URL{
+		Scheme: "https",
+		Host:   *argServerAddr,
+		Path:   "/",
+	}
+	usernames := make([]string, 0, len(shared.UserAccounts))
+	for username := range shared.UserAccounts {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	// Connect all clients spreading the connections over the ramp-up
+	log.Printf("Connecting %d clients to %s...", *argClients, *argServerAddr)
+	rec := newRecorder()
+	clients := make([]*loadClient, *argClients)
+	var setup sync.WaitGroup
+	var failedLock sync.Mutex
+	failed := 0
+	for index := range clients {
+		clt, err := newLoadClient(
+			index,
+			assignRoom(rooms, index),
+			codec,
+			serverAddr,
+			rec,
+		)
+		if err != nil {
+			log.Fatalf("Couldn't create client: %s", err)
+		}
+		clients[index] = clt
+
+		setup.Add(1)
+		go func(username string) {
+			defer setup.Done()
+			if err := clt.setup(username); err != nil {
+				log.Printf("Client %d failed: %s", clt.index, err)
+				failedLock.Lock()
+				failed++
+				failedLock.Unlock()
+			}
+		}(usernames[index%len(usernames)])
+
+		time.Sleep(*argRampUp / time.Duration(*argClients))
+	}
+	setup.Wait()
+	if failed > 0 {
+		log.Fatalf("%d clients failed to connect", failed)
+	}
+
+	// Every message is delivered to all clients of the sender's room
+	members := make(map[string]int)
+	for _, clt := range clients {
+		members[clt.room]++
+	}
+
+	senders := int(float64(*argClients) * *argSenders)
+	if senders < 1 {
+		senders = 1
+	}
+	log.Printf(
+		"Sending %.2f messages per second from %d clients for %s...",
+		*argRate*float64(senders),
+		senders,
+		*argDuration,
+	)
+	interval := time.Duration(float64(time.Second) / *argRate)
+	ctx, cancel := context.WithTimeout(context.Background(), *argDuration)
+	var running sync.WaitGroup
+	for _, clt := range clients[:senders] {
+		running.Add(1)
+		go func(clt *loadClient) {
+			defer running.Done()
+			// Don't let all clients send at the same time
+			time.Sleep(time.Duration(rand.Int63n(int64(interval))))
+			clt.run(ctx, interval, members[clt.room])
+		}(clt)
+	}
+	running.Wait()
+	cancel()
+
+	log.Printf("Waiting %s for in-flight messages...", *argGrace)
+	time.Sleep(*argGrace)
+	for _, clt := range clients {
+		clt.connection.Close()
+	}
+
+	rec.Report(os.Stdout)
+	if *argTimelineFilePath != "" {
+		file, err := os.Create(*argTimelineFilePath)
+		if err != nil {
+			log.Fatalf("Couldn't create timeline file: %s", err)
+		}
+		defer file.Close()
+		if err := rec.WriteTimeline(file); err != nil {
+			log.Fatalf("Couldn't write timeline: %s", err)
+		}
+		log.Printf("Timeline written to %s", *argTimelineFilePath)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// histogramPrecision defines the relative width of a histogram bucket
+const histogramPrecision = 1.01
+
+// histogramBuckets defines the number of histogram buckets,
+// enough to record latencies of up to about 10 minutes in microseconds
+const histogramBuckets = 2100
+
+// histogram records latencies in logarithmic buckets
+// keeping the relative error of the percentiles below 1%
+type histogram struct {
+	buckets [histogramBuckets]uint64
+	count   uint64
+	max     time.Duration
+}
+
+// Record adds a latency to the histogram
+func (hist *histogram) Record(latency time.Duration) {
+	micros := float64(latency / time.Microsecond)
+	index := 0
+	if micros > 1 {
+		index = int(math.Log(micros) / math.Log(histogramPrecision))
+	}
+	if index >= histogramBuckets {
+		index = histogramBuckets - 1
+	}
+	hist.buckets[index]++
+	hist.count++
+	if latency > hist.max {
+		hist.max = latency
+	}
+}
+
+// Percentile returns the latency below which
+// the given percentage of all recorded latencies fall
+func (hist *histogram) Percentile(percent float64) time.Duration {
+	if hist.count < 1 {
+		return 0
+	}
+	rank := uint64(math.Ceil(percent / 100 * float64(hist.count)))
+	var seen uint64
+	for index, count := range hist.buckets {
+		seen += count
+		if seen >= rank {
+			upper := time.Duration(
+				math.Pow(histogramPrecision, float64(index+1)),
+			) * time.Microsecond
+			if upper > hist.max {
+				return hist.max
+			}
+			return upper
+		}
+	}
+	return hist.max
+}
+
+// second represents the statistics of a single second of the test
+type second struct {
+	sent      uint64
+	failed    uint64
+	delivered uint64
+	latencies histogram
+}
+
+// pendingMessage represents a sent message and its expected deliveries
+type pendingMessage struct {
+	expected int
+	received int
+}
+
+// recorder collects the statistics of a load test
+type recorder struct {
+	start    time.Time
+	seconds  []*second
+	total    histogram
+	messages map[uint64]*pendingMessage
+	errors   map[string]uint64
+	lock     sync.Mutex
+}
+
+// newRecorder creates a new recorder starting now
+func newRecorder() *recorder {
+	return &recorder{
+		start:    time.Now(),
+		messages: make(map[uint64]*pendingMessage),
+		errors:   make(map[string]uint64),
+	}
+}
+
+// second returns the statistics of the second the given time falls in.
+// The caller is expected to hold the lock
+func (rec *recorder) second(at time.Time) *second {
+	index := int(at.Sub(rec.start) / time.Second)
+	if index < 0 {
+		index = 0
+	}
+	for len(rec.seconds) <= index {
+		rec.seconds = append(rec.seconds, &second{})
+	}
+	return rec.seconds[index]
+}
+
+// Sent records a message accepted by the server
+// which is expected to be delivered to the given number of clients
+func (rec *recorder) Sent(id uint64, at time.Time, expected int) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	rec.second(at).sent++
+	pending, exists := rec.messages[id]
+	if !exists {
+		// The acknowledgement arrived before any delivery
+		pending = &pendingMessage{}
+		rec.messages[id] = pending
+	}
+	pending.expected = expected
+}
+
+// Failed records a message that wasn't accepted by the server
+func (rec *recorder) Failed(id uint64, at time.Time, reason string) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	rec.second(at).failed++
+	rec.errors[reason]++
+	delete(rec.messages, id)
+}
+
+// Delivered records the delivery of a message sent at the given time
+func (rec *recorder) Delivered(id uint64, sentAt time.Time) {
+	now := time.Now()
+	latency := now.Sub(sentAt)
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	sec := rec.second(now)
+	sec.delivered++
+	sec.latencies.Record(latency)
+	rec.total.Record(latency)
+
+	pending, exists := rec.messages[id]
+	if !exists {
+		pending = &pendingMessage{}
+		rec.messages[id] = pending
+	}
+	pending.received++
+}
+
+// Loss returns the number of expected and missing deliveries
+func (rec *recorder) Loss() (expected, missing uint64) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	for _, pending := range rec.messages {
+		expected += uint64(pending.expected)
+		if pending.received < pending.expected {
+			missing += uint64(pending.expected - pending.received)
+		}
+	}
+	return expected, missing
+}
+
+// Report writes a summary of the test
+func (rec *recorder) Report(out io.Writer) {
+	expected, missing := rec.Loss()
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	var sent, failed uint64
+	for _, sec := range rec.seconds {
+		sent += sec.sent
+		failed += sec.failed
+	}
+	loss := 0.0
+	if expected > 0 {
+		loss = 100 * float64(missing) / float64(expected)
+	}
+
+	fmt.Fprintf(out, "Messages sent:      %d\n", sent)
+	fmt.Fprintf(out, "Messages failed:    %d\n", failed)
+	for reason, count := range rec.errors {
+		fmt.Fprintf(out, "  %s: %d\n", reason, count)
+	}
+	fmt.Fprintf(out, "Deliveries:         %d\n", rec.total.count)
+	fmt.Fprintf(
+		out,
+		"Lost deliveries:    %d of %d (%.3f%%)\n",
+		missing,
+		expected,
+		loss,
+	)
+	fmt.Fprintln(out, "Delivery latency:")
+	for _, percent := range []float64{50, 90, 99, 99.9} {
+		fmt.Fprintf(
+			out,
+			"  p%-5s %s\n",
+			strconv.FormatFloat(percent, 'f', -1, 64),
+			rec.total.Percentile(percent),
+		)
+	}
+	fmt.Fprintf(out, "  max    %s\n", rec.total.max)
+}
+
+// WriteTimeline writes the per-second statistics as CSV
+func (rec *recorder) WriteTimeline(out io.Writer) error {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	writer := csv.NewWriter(out)
+	if err := writer.Write([]string{
+		"second",
+		"sent",
+		"failed",
+		"delivered",
+		"p50_ms",
+		"p90_ms",
+		"p99_ms",
+		"max_ms",
+	}); err != nil {
+		return err
+	}
+	millis := func(duration time.Duration) string {
+		return strconv.FormatFloat(
+			float64(duration)/float64(time.Millisecond),
+			'f',
+			3,
+			64,
+		)
+	}
+	for index, sec := range rec.seconds {
+		if err := writer.Write([]string{
+			strconv.Itoa(index),
+			strconv.FormatUint(sec.sent, 10),
+			strconv.FormatUint(sec.failed, 10),
+			strconv.FormatUint(sec.delivered, 10),
+			millis(sec.latencies.Percentile(50)),
+			millis(sec.latencies.Percentile(90)),
+			millis(sec.latencies.Percentile(99)),
+			millis(sec.latencies.max),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	wwr "github.com/qbeon/webwire-go"
+)
+
+// remoteHost returns the host part of the remote address of the given client
+func remoteHost(client wwr.Connection) string {
+	addr := client.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// handleLockouts replies with all currently locked out
+// accounts and remote addresses
+func (srv *ChatRoomServer) handleLockouts(
+	_ context.Context,
+	client wwr.Connection,
+	_ wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyAdmin(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	return srv.reply(client, srv.lockouts.List())
+}
+
+// handleClearLockout lifts the lockout
+// of the account or remote address given in the payload
+func (srv *ChatRoomServer) handleClearLockout(
+	_ context.Context,
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyAdmin(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	key, err := message.PayloadUtf8()
+	if err != nil {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "DECODING_FAILURE",
+			Message: fmt.Sprintf("Failed decoding message: %s", err),
+		}
+	}
+
+	if !srv.lockouts.Clear(string(key)) {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "LOCKOUT_NOT_FOUND",
+			Message: fmt.Sprintf("No failed attempts recorded for '%s'", key),
+		}
+	}
+	srv.publish(eventLockout, lockoutEvent{
+		Kind: lockoutClear,
+		Key:  string(key),
+	})
+	srv.auditAdminAction(client, "clear-lockout", map[string]string{
+		"key": string(key),
+	})
+
+	return wwr.Payload{}, nil
+}
+
+// handleMetrics replies with the number of signals
+// that couldn't be delivered to clients
+func (srv *ChatRoomServer) handleMetrics(
+	_ context.Context,
+	client wwr.Connection,
+	_ wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyAdmin(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	return srv.reply(client, srv.metrics.Snapshot())
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// archiveVersion defines the version of the archive format
+const archiveVersion = 1
+
+// Archive record kinds in the order they appear in an archive
+const (
+	recordHeader  = "header"
+	recordRoom    = "room"
+	recordMessage = "message"
+	recordEnd     = "end"
+)
+
+// archiveRecord represents a single line of a room archive.
+// The checksum is the SHA-256 hash of the raw data
+type archiveRecord struct {
+	Kind     string          `json:"kind"`
+	Data     json.RawMessage `json:"data"`
+	Checksum string          `json:"checksum"`
+}
+
+// archiveHeader represents the first record of a room archive
+type archiveHeader struct {
+	Version  int       `json:"version"`
+	Room     string    `json:"room"`
+	Exported time.Time `json:"exported"`
+}
+
+// archiveEnd represents the last record of a room archive. The digest is
+// the SHA-256 hash over the checksums of all preceding records and reveals
+// removed, reordered or truncated records
+type archiveEnd struct {
+	Messages int    `json:"messages"`
+	Digest   string `json:"digest"`
+}
+
+// checksum returns the hex encoded SHA-256 hash of the given data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// archiveWriter writes the records of an archive
+type archiveWriter struct {
+	out    io.Writer
+	digest []byte
+}
+
+// Write appends a record of the given kind
+func (wrt *archiveWriter) Write(kind string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal %s record: %s", kind, err)
+	}
+	record := archiveRecord{
+		Kind:     kind,
+		Data:     data,
+		Checksum: checksum(data),
+	}
+	wrt.digest = append(wrt.digest, record.Checksum...)
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal %s record: %s", kind, err)
+	}
+	if _, err := wrt.out.Write(append(encoded, '\n')); err != nil {
+		return fmt.Errorf("Couldn't write archive: %s", err)
+	}
+	return nil
+}
+
+// exportRoom writes the metadata, the invited members and the message
+// history of the given room to an archive. Returns the number of messages
+func exportRoom(
+	rooms *roomStore,
+	history *historyStore,
+	name string,
+	out io.Writer,
+) (int, error) {
+	r, exists := rooms.Get(name)
+	if !exists {
+		return 0, fmt.Errorf("No such room: '%s'", name)
+	}
+	messages, err := history.Messages(name)
+	if err != nil {
+		return 0, err
+	}
+
+	wrt := &archiveWriter{out: out}
+	if err := wrt.Write(recordHeader, archiveHeader{
+		Version:  archiveVersion,
+		Room:     name,
+		Exported: time.Now().UTC(),
+	}); err != nil {
+		return 0, err
+	}
+	if err := wrt.Write(recordRoom, r); err != nil {
+		return 0, err
+	}
+	for _, message := range messages {
+		if err := wrt.Write(recordMessage, message); err != nil {
+			return 0, err
+		}
+	}
+	if err := wrt.Write(recordEnd, archiveEnd{
+		Messages: len(messages),
+		Digest:   checksum(wrt.digest),
+	}); err != nil {
+		return 0, err
+	}
+	return len(messages), nil
+}
+
+// roomArchive represents the verified contents of a room archive
+type roomArchive struct {
+	header   archiveHeader
+	room     *room
+	messages []historyMessage
+}
+
+// readArchive reads and verifies a complete room archive
+func readArchive(in io.Reader) (*roomArchive, error) {
+	archive := &roomArchive{}
+	var digest []byte
+	var end *archiveEnd
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if end != nil {
+			return nil, fmt.Errorf("line %d: data after the end record", line)
+		}
+
+		var record archiveRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: malformed record: %s", line, err)
+		}
+		if checksum(record.Data) != record.Checksum {
+			return nil, fmt.Errorf("line %d: checksum mismatch", line)
+		}
+
+		// Verify the order of the records
+		expected := recordMessage
+		switch line {
+		case 1:
+			expected = recordHeader
+		case 2:
+			expected = recordRoom
+		}
+		if record.Kind != expected &&
+			!(line > 2 && record.Kind == recordEnd) {
+			return nil, fmt.Errorf(
+				"line %d: unexpected %s record",
+				line,
+				record.Kind,
+			)
+		}
+
+		var err error
+		switch record.Kind {
+		case recordHeader:
+			err = json.Unmarshal(record.Data, &archive.header)
+			if err == nil && archive.header.Version != archiveVersion {
+				err = fmt.Errorf(
+					"unsupported archive version %d",
+					archive.header.Version,
+				)
+			}
+			if err == nil {
+				err = shared.ValidateName("room", archive.header.Room)
+			}
+		case recordRoom:
+			err = json.Unmarshal(record.Data, &archive.room)
+			if err == nil && (archive.room == nil ||
+				archive.room.Name != archive.header.Room) {
+				err = errors.New("room doesn't match the header")
+			}
+			if err == nil && !archive.room.Access.Valid() {
+				err = fmt.Errorf(
+					"invalid access mode '%s'",
+					archive.room.Access,
+				)
+			}
+		case recordMessage:
+			var message historyMessage
+			err = json.Unmarshal(record.Data, &message)
+			if err == nil && message.Room != archive.header.Room {
+				err = errors.New("message of another room")
+			}
+			archive.messages = append(archive.messages, message)
+		case recordEnd:
+			end = &archiveEnd{}
+			err = json.Unmarshal(record.Data, end)
+			if err == nil && end.Digest != checksum(digest) {
+				err = errors.New("digest mismatch, records were modified")
+			}
+			if err == nil && end.Messages != len(archive.messages) {
+				err = fmt.Errorf(
+					"expected %d messages, found %d",
+					end.Messages,
+					len(archive.messages),
+				)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		digest = append(digest, record.Checksum...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Couldn't read archive: %s", err)
+	}
+	if end == nil {
+		return nil, errors.New("archive is truncated, end record missing")
+	}
+	return archive, nil
+}
+
+// importRoom verifies an archive and imports it merging its message history
+// and replacing the metadata and the invited members of the room.
+// Nothing is imported if the archive is corrupted. The history is written
+// first since importing the same archive again doesn't change anything,
+// which makes retrying an import that failed to replace the room safe.
+// Returns the name of the room and the number of imported messages
+func importRoom(
+	rooms *roomStore,
+	history *historyStore,
+	in io.Reader,
+) (string, int, error) {
+	archive, err := readArchive(in)
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid archive: %s", err)
+	}
+	imported, err := history.Import(archive.room.Name, archive.messages)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := rooms.Replace(archive.room); err != nil {
+		return "", 0, fmt.Errorf(
+			"Imported %d messages but couldn't replace the room: %s",
+			imported,
+			err,
+		)
+	}
+	return archive.room.Name, imported, nil
+}
+
+// openStores opens the room and history stores used by the given
+// configuration for the export and import subcommands.
+// Fails while the server is running since it locks the history
+func openStores(conf config) (*roomStore, *historyStore, error) {
+	history, err := newHistoryStore(conf.HistoryDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed opening history: %s", err)
+	}
+	rooms, err := newRoomStore(conf.Rooms)
+	if err != nil {
+		history.Close()
+		return nil, nil, fmt.Errorf("Failed loading rooms: %s", err)
+	}
+	return rooms, history, nil
+}
+
+// runExport implements the export subcommand
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	configFilePath := flags.String(
+		"config",
+		"./config.json",
+		"path to the server configuration file",
+	)
+	roomName := flags.String("room", "", "name of the exported room")
+	outFilePath := flags.String(
+		"out",
+		"",
+		"path to the archive file, written to stdout if empty",
+	)
+	flags.Parse(args)
+
+	conf, err := loadConfig(*configFilePath)
+	if err != nil {
+		return err
+	}
+	rooms, history, err := openStores(conf)
+	if err != nil {
+		return err
+	}
+	defer history.Close()
+
+	out := io.Writer(os.Stdout)
+	if *outFilePath != "" {
+		file, err := os.OpenFile(
+			*outFilePath,
+			os.O_CREATE|os.O_EXCL|os.O_WRONLY,
+			0600,
+		)
+		if err != nil {
+			return fmt.Errorf("Couldn't create archive: %s", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	exported, err := exportRoom(rooms, history, *roomName, out)
+	if err != nil {
+		if *outFilePath != "" {
+			os.Remove(*outFilePath)
+		}
+		return err
+	}
+	log.Printf("Exported room %s with %d messages", *roomName, exported)
+	return nil
+}
+
+// runImport implements the import subcommand
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	configFilePath := flags.String(
+		"config",
+		"./config.json",
+		"path to the server configuration file",
+	)
+	inFilePath := flags.String(
+		"in",
+		"",
+		"path to the archive file, read from stdin if empty",
+	)
+	flags.Parse(args)
+
+	conf, err := loadConfig(*configFilePath)
+	if err != nil {
+		return err
+	}
+	rooms, history, err := openStores(conf)
+	if err != nil {
+		return err
+	}
+	defer history.Close()
+
+	in := io.Reader(os.Stdin)
+	if *inFilePath != "" {
+		file, err := os.Open(*inFilePath)
+		if err != nil {
+			return fmt.Errorf("Couldn't open archive: %s", err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	room, imported, err := importRoom(rooms, history, in)
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported room %s, %d new messages", room, imported)
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces the file at the given path by the given data.
+// The data is written to a temporary file first which is then renamed
+// to never leave a corrupted file behind
+func writeFileAtomic(filePath string, data []byte) error {
+	tmpFile, err := ioutil.TempFile(
+		filepath.Dir(filePath),
+		"."+filepath.Base(filePath),
+	)
+	if err != nil {
+		return fmt.Errorf("Couldn't create temporary file: %s", err)
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return fmt.Errorf("Couldn't write temporary file: %s", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return fmt.Errorf("Couldn't close temporary file: %s", err)
+	}
+	if err := os.Rename(tmpFile.Name(), filePath); err != nil {
+		os.Remove(tmpFile.Name())
+		return fmt.Errorf("Couldn't replace %s: %s", filePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/audit"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// banList keeps the banned accounts
+// and persists them to a JSON file on every change
+type banList struct {
+	filePath string
+	bans     map[string]shared.Ban
+	lock     sync.RWMutex
+}
+
+// newBanList loads the bans from the given file if it exists
+func newBanList(filePath string) (*banList, error) {
+	list := &banList{
+		filePath: filePath,
+		bans:     make(map[string]shared.Ban),
+	}
+
+	contents, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return list, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Couldn't read ban file: %s", err)
+	}
+	if err := json.Unmarshal(contents, &list.bans); err != nil {
+		return nil, fmt.Errorf("Couldn't parse ban file: %s", err)
+	}
+	return list, nil
+}
+
+// save writes all bans to the ban file.
+// The lock must be held by the caller
+func (list *banList) save() error {
+	encoded, err := json.MarshalIndent(list.bans, "", "\t")
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal bans: %s", err)
+	}
+	if err := writeFileAtomic(list.filePath, encoded); err != nil {
+		return fmt.Errorf("Couldn't save bans: %s", err)
+	}
+	return nil
+}
+
+// Check returns the ban of the given account if it's currently banned
+func (list *banList) Check(username string) (shared.Ban, bool) {
+	list.lock.RLock()
+	defer list.lock.RUnlock()
+	ban, exists := list.bans[username]
+	if !exists || ban.Expired(time.Now()) {
+		return shared.Ban{}, false
+	}
+	return ban, true
+}
+
+// Put bans an account replacing any previous ban of the account
+func (list *banList) Put(ban shared.Ban) error {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	list.bans[ban.User] = ban
+	return list.save()
+}
+
+// Lift lifts the ban of the given account.
+// Returns false if the account isn't banned
+func (list *banList) Lift(username string) (bool, error) {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	ban, exists := list.bans[username]
+	if !exists {
+		return false, nil
+	}
+	delete(list.bans, username)
+	if err := list.save(); err != nil {
+		return false, err
+	}
+	return !ban.Expired(time.Now()), nil
+}
+
+// List returns all bans in effect sorted by the username
+func (list *banList) List() []shared.Ban {
+	list.lock.RLock()
+	defer list.lock.RUnlock()
+	now := time.Now()
+	bans := []shared.Ban{}
+	for _, ban := range list.bans {
+		if !ban.Expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].User < bans[j].User
+	})
+	return bans
+}
+
+// handleBan bans an account and disconnects all of its connections.
+// Banned accounts can neither sign in nor restore their sessions
+func (srv *ChatRoomServer) handleBan(
+	_ context.Context,
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyModerator(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	var req shared.BanRequest
+	if err := parseRequest(
+		message,
+		shared.CodecForEncoding(message.PayloadEncoding()),
+		&req,
+	); err != nil {
+		return wwr.Payload{}, err
+	}
+	if _, exists := userAccounts[req.User]; !exists {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "USER_NOT_FOUND",
+			Message: fmt.Sprintf("No such user: %s", req.User),
+		}
+	}
+
+	moderator := client.SessionInfo("username").(string)
+	ban := shared.Ban{
+		User:   req.User,
+		Reason: req.Reason,
+		By:     moderator,
+		Since:  time.Now().UTC(),
+	}
+	if req.Duration > 0 {
+		ban.Until = ban.Since.Add(time.Duration(req.Duration) * time.Second)
+	}
+	if err := srv.bans.Put(ban); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	srv.publish(eventBan, ban)
+	connections := srv.disconnectUser(req.User)
+
+	details := map[string]string{
+		"banned":      req.User,
+		"connections": strconv.Itoa(connections),
+	}
+	if req.Reason != "" {
+		details["reason"] = req.Reason
+	}
+	if !ban.Until.IsZero() {
+		details["until"] = ban.Until.Format(time.RFC3339)
+	}
+	srv.audit(audit.EventBan, client, moderator, details)
+	log.Printf("User %s was banned by %s", req.User, moderator)
+
+	return wwr.Payload{}, nil
+}
+
+// handleUnban lifts the ban of the account given in the payload
+func (srv *ChatRoomServer) handleUnban(
+	_ context.Context,
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyModerator(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	username, err := parseName(message, "user")
+	if err != nil {
+		return wwr.Payload{}, err
+	}
+
+	lifted, err := srv.bans.Lift(username)
+	if err != nil {
+		return wwr.Payload{}, err
+	}
+	if !lifted {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "BAN_NOT_FOUND",
+			Message: fmt.Sprintf("User '%s' isn't banned", username),
+		}
+	}
+
+	srv.publish(eventUnban, userEvent{User: username})
+
+	moderator := client.SessionInfo("username").(string)
+	srv.audit(
+		audit.EventUnban,
+		client,
+		moderator,
+		map[string]string{"unbanned": username},
+	)
+	log.Printf("User %s was unbanned by %s", username, moderator)
+
+	return wwr.Payload{}, nil
+}
+
+// handleBans replies with all banned accounts
+func (srv *ChatRoomServer) handleBans(
+	_ context.Context,
+	client wwr.Connection,
+	_ wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyModerator(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	return srv.reply(client, srv.bans.List())
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/qbeon/webwire-go-examples/chatroom/cluster"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// Kinds of events exchanged between the nodes of a cluster
+const (
+	eventChat       = "chat"
+	eventRoom       = "room"
+	eventPresence   = "presence"
+	eventDirect     = "dm"
+	eventKey        = "key"
+	eventKick       = "kick"
+	eventBan        = "ban"
+	eventUnban      = "unban"
+	eventRevoke     = "revoke"
+	eventSignout    = "signout"
+	eventLockout    = "lockout"
+	eventGuestBlock = "guest-block"
+)
+
+// Kinds of lockout events
+const (
+	lockoutFailure = "failure"
+	lockoutSuccess = "success"
+	lockoutClear   = "clear"
+)
+
+// chatEvent represents a chat message posted on another node
+type chatEvent struct {
+	ID     string    `json:"id"`
+	Room   string    `json:"room"`
+	Sender string    `json:"sender"`
+	Msg    string    `json:"msg"`
+	Posted time.Time `json:"posted"`
+}
+
+// roomEvent represents a room changed on another node
+type roomEvent struct {
+	Op roomOp `json:"op"`
+
+	// Notify is true if the clients in the room are to be notified
+	Notify bool `json:"notify"`
+}
+
+// presenceEvent represents the list of users connected to another node
+// and the devices of the signed in users
+type presenceEvent struct {
+	Users   []string                   `json:"users"`
+	Devices map[string][]shared.Device `json:"devices,omitempty"`
+}
+
+// userEvent represents a user kicked or unbanned on another node
+type userEvent struct {
+	User string `json:"user"`
+}
+
+// revokeEvent represents a session revoked on another node
+type revokeEvent struct {
+	Key string `json:"key"`
+}
+
+// signoutEvent represents a device connected to another node
+// signed out by its user
+type signoutEvent struct {
+	User     string `json:"user"`
+	DeviceID uint64 `json:"deviceId"`
+}
+
+// guestBlockEvent represents the address of a guest
+// kicked on another node
+type guestBlockEvent struct {
+	Address string    `json:"address"`
+	Until   time.Time `json:"until"`
+}
+
+// lockoutEvent represents a change of the lockouts on another node
+type lockoutEvent struct {
+	Kind    string `json:"kind"`
+	Account string `json:"account,omitempty"`
+	Address string `json:"address,omitempty"`
+
+	// Key is the account or address of cleared lockouts
+	Key string `json:"key,omitempty"`
+}
+
+// keyEvent represents a public key published on another node
+type keyEvent struct {
+	User string `json:"user"`
+	Key  []byte `json:"key"`
+}
+
+// nodePresence represents the users connected to another node
+type nodePresence struct {
+	users    []string
+	devices  map[string][]shared.Device
+	received time.Time
+}
+
+// nodeTag derives the 16 bit tag of the node with the given identifier
+// which is included in identifiers that must be unique among all nodes.
+// Node identifiers are random, two nodes share a tag with a chance of 1:65536
+func nodeTag(nodeID string) uint16 {
+	hash := fnv.New32a()
+	hash.Write([]byte(nodeID))
+	return uint16(hash.Sum32())
+}
+
+// publish sends an event to all other nodes of the cluster.
+// Must not be called while holding the server lock
+func (srv *ChatRoomServer) publish(kind string, data interface{}) {
+	if err := srv.bus.Publish(kind, data); err != nil {
+		log.Printf("WARNING: failed publishing %s event: %s", kind, err)
+	}
+}
+
+// handleClusterEvent handles events published by other nodes.
+// Sessions must not be closed by the handler itself since closing a session
+// may use the session store which the TCP bus doesn't allow while handling
+// an event, the according events are handled in separate goroutines instead
+func (srv *ChatRoomServer) handleClusterEvent(event cluster.Event) {
+	var err error
+	switch event.Kind {
+	case eventChat:
+		var chat chatEvent
+		if err = json.Unmarshal(event.Data, &chat); err == nil {
+			srv.deliverMessage(chat)
+		}
+	case eventRoom:
+		var update roomEvent
+		if err = json.Unmarshal(event.Data, &update); err == nil {
+			var metadata shared.RoomMetadata
+			metadata, err = srv.rooms.Apply(&update.Op)
+			if err == nil && update.Notify {
+				srv.deliverRoomUpdate(metadata)
+			}
+		}
+	case eventPresence:
+		var presence presenceEvent
+		if err = json.Unmarshal(event.Data, &presence); err == nil {
+			srv.presenceLock.Lock()
+			srv.presence[event.Node] = nodePresence{
+				users:    presence.Users,
+				devices:  presence.Devices,
+				received: time.Now(),
+			}
+			srv.presenceLock.Unlock()
+		}
+	case eventDirect:
+		var dm directEvent
+		if err = json.Unmarshal(event.Data, &dm); err == nil {
+			srv.deliverDirectMessage(dm.To, dm.Message)
+		}
+	case eventKey:
+		var key keyEvent
+		if err = json.Unmarshal(event.Data, &key); err == nil {
+			_, err = srv.keys.Put(key.User, key.Key)
+		}
+	case eventKick:
+		var kick userEvent
+		if err = json.Unmarshal(event.Data, &kick); err == nil {
+			go srv.disconnectUser(kick.User)
+		}
+	case eventBan:
+		var ban shared.Ban
+		if err = json.Unmarshal(event.Data, &ban); err == nil {
+			if err = srv.bans.Put(ban); err == nil {
+				go srv.disconnectUser(ban.User)
+			}
+		}
+	case eventUnban:
+		var unban userEvent
+		if err = json.Unmarshal(event.Data, &unban); err == nil {
+			_, err = srv.bans.Lift(unban.User)
+		}
+	case eventRevoke:
+		var revoke revokeEvent
+		if err = json.Unmarshal(event.Data, &revoke); err == nil {
+			go srv.closeSession(revoke.Key)
+		}
+	case eventSignout:
+		var signout signoutEvent
+		if err = json.Unmarshal(event.Data, &signout); err == nil {
+			go func() {
+				if _, err := srv.signoutDevice(
+					signout.User,
+					signout.DeviceID,
+				); err != nil {
+					log.Printf("WARNING: failed signing out device: %s", err)
+				}
+			}()
+		}
+	case eventGuestBlock:
+		var block guestBlockEvent
+		if err = json.Unmarshal(event.Data, &block); err == nil {
+			srv.guestBlocks.BlockUntil(block.Address, block.Until)
+		}
+	case eventLockout:
+		var lockout lockoutEvent
+		if err = json.Unmarshal(event.Data, &lockout); err == nil {
+			switch lockout.Kind {
+			case lockoutFailure:
+				srv.lockouts.Failure(lockout.Account, lockout.Address)
+			case lockoutSuccess:
+				srv.lockouts.Success(lockout.Account)
+			case lockoutClear:
+				srv.lockouts.Clear(lockout.Key)
+			}
+		}
+	default:
+		log.Printf("WARNING: unexpected cluster event: %s", event.Kind)
+	}
+	if err != nil {
+		log.Printf(
+			"WARNING: failed handling %s event of node %s: %s",
+			event.Kind,
+			event.Node,
+			err,
+		)
+	}
+}
+
+// localUsers returns the names of all users and guests connected to this node
+func (srv *ChatRoomServer) localUsers() []string {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+
+	names := make(map[string]bool)
+	for client, state := range srv.connected {
+		if name := identity(client, state); name != "" {
+			names[name] = true
+		}
+	}
+
+	users := make([]string, 0, len(names))
+	for name := range names {
+		users = append(users, name)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// localDevices returns the devices of all users connected to this node
+func (srv *ChatRoomServer) localDevices() map[string][]shared.Device {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+
+	devices := make(map[string][]shared.Device)
+	for client, state := range srv.connected {
+		info := sessionInfo(client)
+		if info == nil {
+			continue
+		}
+		devices[info.Username] = append(
+			devices[info.Username],
+			newDevice(client, *state),
+		)
+	}
+	return devices
+}
+
+// publishPresence publishes the users and guests connected to this node
+// and the devices of the signed in users
+func (srv *ChatRoomServer) publishPresence() {
+	srv.publish(eventPresence, presenceEvent{
+		Users:   srv.localUsers(),
+		Devices: srv.localDevices(),
+	})
+}
+
+// remoteDevices returns the devices of the given user
+// connected to other nodes of the cluster
+func (srv *ChatRoomServer) remoteDevices(username string) []shared.Device {
+	srv.presenceLock.Lock()
+	defer srv.presenceLock.Unlock()
+
+	devices := []shared.Device{}
+	for _, presence := range srv.presence {
+		if time.Since(presence.received) > 3*srv.presenceInterval {
+			continue
+		}
+		devices = append(devices, presence.devices[username]...)
+	}
+	return devices
+}
+
+// onlineUsers returns the names of all users and guests connected to any node
+// of the cluster. Nodes that didn't publish their presence for more than
+// three presence intervals are considered gone
+func (srv *ChatRoomServer) onlineUsers() []string {
+	names := make(map[string]bool)
+	for _, name := range srv.localUsers() {
+		names[name] = true
+	}
+
+	srv.presenceLock.Lock()
+	for node, presence := range srv.presence {
+		if time.Since(presence.received) > 3*srv.presenceInterval {
+			delete(srv.presence, node)
+			continue
+		}
+		for _, name := range presence.users {
+			names[name] = true
+		}
+	}
+	srv.presenceLock.Unlock()
+
+	users := make([]string, 0, len(names))
+	for name := range names {
+		users = append(users, name)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// isOnline returns true if the given user or guest
+// is connected to any node of the cluster
+func (srv *ChatRoomServer) isOnline(name string) bool {
+	for _, online := range srv.onlineUsers() {
+		if online == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RunPresence periodically publishes the users connected to this node.
+// Blocks the calling goroutine
+func (srv *ChatRoomServer) RunPresence() {
+	for {
+		srv.publishPresence()
+		time.Sleep(srv.presenceInterval)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/cluster"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// newClusterHarnesses starts the given number of chatroom servers
+// connected through a TCP hub. The returned function tears down
+// all servers and stops the hub
+func newClusterHarnesses(t *testing.T, num int) ([]*harness, func()) {
+	hub, err := cluster.ListenTCPHub("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("couldn't start cluster hub: %s", err)
+	}
+	go hub.Serve()
+
+	nodes := make([]*harness, 0, num)
+	teardown := func() {
+		for _, node := range nodes {
+			node.teardown()
+		}
+		hub.Close()
+	}
+
+	// Tear down the nodes already started if starting another one fails
+	started := false
+	defer func() {
+		if !started {
+			teardown()
+		}
+	}()
+	for i := 0; i < num; i++ {
+		node := newHarness(t, func(conf *config) {
+			conf.Cluster.Hub = hub.Addr().String()
+		})
+		nodes = append(nodes, node)
+	}
+	started = true
+	return nodes, teardown
+}
+
+// eventually fails the test unless the given condition
+// becomes true within the test timeout
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestClusterSessions tests restoring a session on another node
+// and revoking it on all nodes by signing out a device of another node
+func TestClusterSessions(t *testing.T) {
+	nodes, teardown := newClusterHarnesses(t, 2)
+	defer teardown()
+	first := nodes[0].connect(2)
+	second := nodes[1].connect(2)
+
+	session := first[0].login("Frodo")
+	first[1].login("Frodo")
+	if err := second[0].connection.RestoreSession(
+		context.Background(),
+		[]byte(session.Key),
+	); err != nil {
+		t.Fatalf("restoring the session on another node failed: %s", err)
+	}
+	second[0].expectSession()
+
+	// Signing in publishes the devices of the node
+	second[1].login("Frodo")
+
+	reply, err := second[0].request(
+		shared.RequestDevices,
+		wwr.EncodingUtf8,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("listing devices failed: %s", err)
+	}
+	var devices []shared.Device
+	if err := json.Unmarshal(reply, &devices); err != nil {
+		t.Fatalf("couldn't parse devices: %s", err)
+	}
+	var deviceID uint64
+	for _, device := range devices {
+		if device.Current {
+			deviceID = device.ID
+		}
+	}
+
+	eventually(t, "the devices of the other node", func() bool {
+		return len(nodes[0].chatRoom.remoteDevices("Frodo")) == 2
+	})
+	if _, err := first[1].request(
+		shared.RequestSignoutDevice,
+		wwr.EncodingUtf8,
+		[]byte(strconv.FormatUint(deviceID, 10)),
+	); err != nil {
+		t.Fatalf("signing out the remote device failed: %s", err)
+	}
+	second[0].expectSessionClosed()
+	first[0].expectSessionClosed()
+	for _, clt := range []*testClient{first[1], second[1]} {
+		if clt.connection.Session() == nil {
+			t.Fatal("session of another device was closed")
+		}
+	}
+	if err := first[0].connection.RestoreSession(
+		context.Background(),
+		[]byte(session.Key),
+	); err == nil {
+		t.Fatal("revoked session restored")
+	}
+}
+
+// TestClusterKick tests kicking a user connected to another node
+func TestClusterKick(t *testing.T) {
+	nodes, teardown := newClusterHarnesses(t, 2)
+	defer teardown()
+	moderator := nodes[0].connect(1)[0]
+	moderator.login("Aragorn")
+	kicked := nodes[1].connect(1)[0]
+	kicked.login("Boromir")
+
+	eventually(t, "the presence of the kicked user", func() bool {
+		return nodes[0].chatRoom.isOnline("Boromir")
+	})
+	if _, err := moderator.request(
+		shared.RequestKick,
+		wwr.EncodingUtf8,
+		[]byte("Boromir"),
+	); err != nil {
+		t.Fatalf("kicking failed: %s", err)
+	}
+	kicked.expectDisconnected()
+}
+
+// TestClusterPins tests merging messages pinned
+// on different nodes at the same time
+func TestClusterPins(t *testing.T) {
+	nodes, teardown := newClusterHarnesses(t, 2)
+	defer teardown()
+	room := nodes[0].conf.Rooms.Default
+
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		moderator := node.connect(1)[0]
+		moderator.login("Galadriel")
+
+		wg.Add(1)
+		go func(i int, moderator *testClient) {
+			defer wg.Done()
+			encoded, err := json.Marshal(shared.PinRequest{
+				Room: room,
+				Msg:  "Pinned on node " + strconv.Itoa(i),
+			})
+			if err != nil {
+				t.Errorf("couldn't marshal pin request: %s", err)
+				return
+			}
+			if _, err := moderator.request(
+				shared.RequestPin,
+				wwr.EncodingUtf8,
+				encoded,
+			); err != nil {
+				t.Errorf("pinning on node %d failed: %s", i, err)
+			}
+		}(i, moderator)
+	}
+	wg.Wait()
+
+	for i, node := range nodes {
+		eventually(t, "both pinned messages", func() bool {
+			r, _ := node.chatRoom.rooms.Get(room)
+			return len(r.Pinned) == 2
+		})
+		r, _ := node.chatRoom.rooms.Get(room)
+		if r.Pinned[0].ID == r.Pinned[1].ID {
+			t.Fatalf("pinned messages on node %d share an ID", i)
+		}
+	}
+}
+
+// TestClusterTopics tests resolving topic changes
+// made on different nodes at the same time to the same topic
+func TestClusterTopics(t *testing.T) {
+	nodes, teardown := newClusterHarnesses(t, 2)
+	defer teardown()
+	room := nodes[0].conf.Rooms.Default
+
+	changed := time.Now().UTC()
+	ops := make([]roomOp, len(nodes))
+	for i, node := range nodes {
+		ops[i] = roomOp{
+			Room:  room,
+			Kind:  roomOpTopic,
+			Topic: "Changed on node " + strconv.Itoa(i),
+			Time:  changed,
+			Node:  node.chatRoom.nodeTag,
+		}
+	}
+
+	// Each node applies the operations in a different order
+	topics := make([]string, len(nodes))
+	for i, node := range nodes {
+		for j := range ops {
+			op := ops[(i+j)%len(ops)]
+			if _, err := node.chatRoom.rooms.Apply(&op); err != nil {
+				t.Fatalf("changing the topic on node %d failed: %s", i, err)
+			}
+		}
+		r, _ := node.chatRoom.rooms.Get(room)
+		topics[i] = r.Topic
+	}
+	if topics[0] != topics[1] {
+		t.Fatalf("nodes disagree on the topic: %q", topics)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// encodedPayloads lazily encodes a value once per codec
+// so that broadcasts don't encode the same value for every client.
+// It's not safe for concurrent use
+type encodedPayloads struct {
+	value    interface{}
+	payloads map[shared.Codec]wwr.Payload
+}
+
+// newEncodedPayloads creates a new payload cache for the given value
+func newEncodedPayloads(value interface{}) *encodedPayloads {
+	return &encodedPayloads{
+		value:    value,
+		payloads: make(map[shared.Codec]wwr.Payload, len(shared.Codecs)),
+	}
+}
+
+// For returns the value encoded using the given codec
+func (enc *encodedPayloads) For(codec shared.Codec) wwr.Payload {
+	if payload, encoded := enc.payloads[codec]; encoded {
+		return payload
+	}
+	data, err := codec.Marshal(enc.value)
+	if err != nil {
+		panic(fmt.Errorf("Couldn't marshal %T: %s", enc.value, err))
+	}
+	payload := wwr.Payload{
+		Encoding: codec.Encoding(),
+		Data:     data,
+	}
+	enc.payloads[codec] = payload
+	return payload
+}
+
+// sessionCodec returns the codec selected when the session of the given
+// client was created. The codec is stored in the session info to apply
+// to restored sessions as well. Clients without a session use JSON
+func sessionCodec(client wwr.Connection) shared.Codec {
+	name, _ := client.SessionInfo("codec").(string)
+	codec, supported := shared.CodecByName(name)
+	if !supported {
+		return shared.CodecJSON
+	}
+	return codec
+}
+
+// reply encodes a reply using the codec selected by the given client
+func (srv *ChatRoomServer) reply(
+	client wwr.Connection,
+	value interface{},
+) (wwr.Payload, error) {
+	codec := sessionCodec(client)
+	encoded, err := codec.Marshal(value)
+	if err != nil {
+		return wwr.Payload{}, fmt.Errorf("Couldn't marshal %T: %s", value, err)
+	}
+	return wwr.Payload{
+		Encoding: codec.Encoding(),
+		Data:     encoded,
+	}, nil
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// envPrefix defines the prefix of all environment variables
+// overriding configuration fields
+const envPrefix = "CHATROOM_"
+
+// duration represents a time.Duration that's encoded as a string
+// such as "3s" or "1h30m" in the configuration file
+type duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (dur *duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be a string such as \"3s\"")
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	dur.Duration = parsed
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (dur duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dur.String())
+}
+
+// tlsConfig represents the TLS settings
+type tlsConfig struct {
+	CertFile string `json:"certFile" env:"CERT_FILE"`
+	KeyFile  string `json:"keyFile" env:"KEY_FILE"`
+}
+
+// rateLimitConfig represents the message rate limit settings.
+// Rate limiting is disabled if Messages is 0
+type rateLimitConfig struct {
+	Messages int      `json:"messages" env:"MESSAGES"`
+	Interval duration `json:"interval" env:"INTERVAL"`
+}
+
+// roomsConfig represents the room settings
+type roomsConfig struct {
+	File    string `json:"file" env:"FILE"`
+	Default string `json:"default" env:"DEFAULT"`
+
+	// Topic and Access are the settings of new rooms
+	// such as the default room if it doesn't exist yet
+	Topic  string            `json:"topic" env:"TOPIC"`
+	Access shared.RoomAccess `json:"access" env:"ACCESS"`
+}
+
+// lockoutConfig represents the authentication lockout settings
+type lockoutConfig struct {
+	Threshold    uint     `json:"threshold" env:"THRESHOLD"`
+	BaseDuration duration `json:"baseDuration" env:"BASE_DURATION"`
+	MaxDuration  duration `json:"maxDuration" env:"MAX_DURATION"`
+	MaxEntries   uint     `json:"maxEntries" env:"MAX_ENTRIES"`
+}
+
+// outboxSettings represents the outbound queue settings
+type outboxSettings struct {
+	Size   int    `json:"size" env:"SIZE"`
+	Policy string `json:"policy" env:"POLICY"`
+}
+
+// shutdownConfig represents the graceful shutdown settings
+type shutdownConfig struct {
+	DrainDeadline duration `json:"drainDeadline" env:"DRAIN_DEADLINE"`
+	Downtime      duration `json:"downtime" env:"DOWNTIME"`
+}
+
+// clusterConfig represents the cluster settings.
+// The server runs standalone if both Listen and Hub are empty
+type clusterConfig struct {
+	Listen           string   `json:"listen" env:"LISTEN"`
+	Hub              string   `json:"hub" env:"HUB"`
+	PresenceInterval duration `json:"presenceInterval" env:"PRESENCE_INTERVAL"`
+}
+
+// config represents the configuration of the chatroom server
+type config struct {
+	Addr                  string          `json:"addr" env:"ADDR"`
+	TLS                   tlsConfig       `json:"tls" env:"TLS"`
+	SubProtocol           string          `json:"subProtocol" env:"SUB_PROTOCOL"`
+	ReadTimeout           duration        `json:"readTimeout" env:"READ_TIMEOUT"`
+	MaxSessionConnections uint            `json:"maxSessionConnections" env:"MAX_SESSION_CONNECTIONS"`
+	SessionDir            string          `json:"sessionDir" env:"SESSION_DIR"`
+	AllowedOrigins        []string        `json:"allowedOrigins" env:"ALLOWED_ORIGINS"`
+	RateLimit             rateLimitConfig `json:"rateLimit" env:"RATE_LIMIT"`
+	Anonymous             string          `json:"anonymous" env:"ANONYMOUS"`
+	GuestBlock            duration        `json:"guestBlock" env:"GUEST_BLOCK"`
+	Rooms                 roomsConfig     `json:"rooms" env:"ROOMS"`
+	HistoryDir            string          `json:"historyDir" env:"HISTORY_DIR"`
+	AuditFile             string          `json:"auditFile" env:"AUDIT_FILE"`
+	KeysFile              string          `json:"keysFile" env:"KEYS_FILE"`
+	BansFile              string          `json:"bansFile" env:"BANS_FILE"`
+	FiltersFile           string          `json:"filtersFile" env:"FILTERS_FILE"`
+	Lockout               lockoutConfig   `json:"lockout" env:"LOCKOUT"`
+	Outbox                outboxSettings  `json:"outbox" env:"OUTBOX"`
+	Shutdown              shutdownConfig  `json:"shutdown" env:"SHUTDOWN"`
+	Cluster               clusterConfig   `json:"cluster" env:"CLUSTER"`
+}
+
+// defaultConfig returns the configuration
+// used for all fields not set by the configuration file
+func defaultConfig() config {
+	return config{
+		Addr: ":9090",
+		TLS: tlsConfig{
+			CertFile: "./server.crt",
+			KeyFile:  "./server.key",
+		},
+		SubProtocol:    shared.SubProtocolName,
+		ReadTimeout:    duration{3 * time.Second},
+		AllowedOrigins: []string{"*"},
+		RateLimit: rateLimitConfig{
+			Messages: 20,
+			Interval: duration{10 * time.Second},
+		},
+		Anonymous:  string(anonymousGuest),
+		GuestBlock: duration{1 * time.Minute},
+		Rooms: roomsConfig{
+			File:    "./rooms.json",
+			Default: "lobby",
+			Topic:   "General discussion",
+			Access:  shared.RoomPublic,
+		},
+		HistoryDir:  "./history",
+		AuditFile:   "./audit.log",
+		KeysFile:    "./keys.json",
+		BansFile:    "./bans.json",
+		FiltersFile: "./filters.json",
+		Lockout: lockoutConfig{
+			Threshold:    5,
+			BaseDuration: duration{30 * time.Second},
+			MaxDuration:  duration{1 * time.Hour},
+			MaxEntries:   10000,
+		},
+		Outbox: outboxSettings{
+			Size:   256,
+			Policy: string(dropOldest),
+		},
+		Shutdown: shutdownConfig{
+			DrainDeadline: duration{5 * time.Second},
+		},
+		Cluster: clusterConfig{
+			PresenceInterval: duration{5 * time.Second},
+		},
+	}
+}
+
+// loadConfig reads the configuration file at the given path on top of the
+// default configuration and applies the environment variable overrides.
+// A missing configuration file is not an error
+func loadConfig(filePath string) (config, error) {
+	conf := defaultConfig()
+
+	contents, err := ioutil.ReadFile(filePath)
+	if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&conf); err != nil {
+			return config{}, fmt.Errorf("Couldn't parse config file: %s", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return config{}, fmt.Errorf("Couldn't read config file: %s", err)
+	}
+
+	if err := applyEnv(envPrefix, reflect.ValueOf(&conf).Elem()); err != nil {
+		return config{}, err
+	}
+
+	return conf, conf.Validate()
+}
+
+// applyEnv overrides the fields of the given struct value by the environment
+// variables named after the env tags of the fields. The names of nested
+// fields are joined by an underscore (e.g. CHATROOM_TLS_CERT_FILE)
+func applyEnv(prefix string, value reflect.Value) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		name := prefix + value.Type().Field(i).Tag.Get("env")
+
+		// Recurse into nested sections
+		if field.Kind() == reflect.Struct &&
+			field.Type() != reflect.TypeOf(duration{}) {
+			if err := applyEnv(name+"_", field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		text, isSet := os.LookupEnv(name)
+		if !isSet {
+			continue
+		}
+		if err := setField(field, text); err != nil {
+			return fmt.Errorf("Invalid value of %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// setField parses the given text into the given field
+func setField(field reflect.Value, text string) error {
+	switch field.Interface().(type) {
+	case duration:
+		parsed, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(duration{parsed}))
+		return nil
+	case []string:
+		items := []string{}
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(text)
+	case reflect.Int:
+		parsed, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+	case reflect.Uint:
+		parsed, err := strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(parsed)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// errInvalidConfig lists all invalid fields of a configuration
+type errInvalidConfig []string
+
+// Error implements the error interface
+func (err errInvalidConfig) Error() string {
+	return "Invalid configuration:\n  " + strings.Join(err, "\n  ")
+}
+
+// Validate verifies all fields of the configuration
+// and returns an error listing every invalid field
+func (conf config) Validate() error {
+	var problems errInvalidConfig
+	invalid := func(field string, format string, args ...interface{}) {
+		problems = append(
+			problems,
+			field+": "+fmt.Sprintf(format, args...),
+		)
+	}
+	requireFile := func(field string, filePath string) {
+		if filePath == "" {
+			invalid(field, "must not be empty")
+		} else if _, err := os.Stat(filePath); err != nil {
+			invalid(field, "%s", err)
+		}
+	}
+
+	if conf.Addr == "" {
+		invalid("addr", "must not be empty")
+	}
+	requireFile("tls.certFile", conf.TLS.CertFile)
+	requireFile("tls.keyFile", conf.TLS.KeyFile)
+	if conf.SubProtocol == "" {
+		invalid("subProtocol", "must not be empty")
+	}
+	if conf.ReadTimeout.Duration < 1*time.Second {
+		invalid("readTimeout", "must be at least 1s")
+	}
+	if conf.RateLimit.Messages < 0 {
+		invalid("rateLimit.messages", "must not be negative")
+	}
+	if conf.RateLimit.Messages > 0 && conf.RateLimit.Interval.Duration <= 0 {
+		invalid("rateLimit.interval", "must be positive")
+	}
+	if conf.Rooms.File == "" {
+		invalid("rooms.file", "must not be empty")
+	}
+	if conf.Rooms.Default == "" {
+		invalid("rooms.default", "must not be empty")
+	}
+	if utf8.RuneCountInString(conf.Rooms.Topic) > shared.MaxTopicLength {
+		invalid("rooms.topic", "exceeds %d characters", shared.MaxTopicLength)
+	}
+	if !conf.Rooms.Access.Valid() {
+		invalid("rooms.access", "unknown access mode '%s'", conf.Rooms.Access)
+	}
+	if conf.HistoryDir == "" {
+		invalid("historyDir", "must not be empty")
+	}
+	if conf.AuditFile == "" {
+		invalid("auditFile", "must not be empty")
+	}
+	if conf.KeysFile == "" {
+		invalid("keysFile", "must not be empty")
+	}
+	if conf.BansFile == "" {
+		invalid("bansFile", "must not be empty")
+	}
+	if conf.Lockout.Threshold < 1 {
+		invalid("lockout.threshold", "must be at least 1")
+	}
+	if conf.Lockout.BaseDuration.Duration <= 0 {
+		invalid("lockout.baseDuration", "must be positive")
+	}
+	if conf.Lockout.MaxDuration.Duration < conf.Lockout.BaseDuration.Duration {
+		invalid("lockout.maxDuration", "must not be below baseDuration")
+	}
+	if conf.Lockout.MaxEntries < 1 {
+		invalid("lockout.maxEntries", "must be at least 1")
+	}
+	if _, err := parseAnonymousPolicy(conf.Anonymous); err != nil {
+		invalid("anonymous", "%s", err)
+	}
+	if conf.GuestBlock.Duration < 0 {
+		invalid("guestBlock", "must not be negative")
+	}
+	if conf.Outbox.Size < 1 {
+		invalid("outbox.size", "must be at least 1")
+	}
+	if _, err := parseOverflowPolicy(conf.Outbox.Policy); err != nil {
+		invalid("outbox.policy", "%s", err)
+	}
+	if conf.Shutdown.DrainDeadline.Duration < 0 {
+		invalid("shutdown.drainDeadline", "must not be negative")
+	}
+	if conf.Shutdown.Downtime.Duration < 0 {
+		invalid("shutdown.downtime", "must not be negative")
+	}
+	if conf.Cluster.Listen != "" && conf.Cluster.Hub != "" {
+		invalid("cluster.hub", "must be empty when cluster.listen is set")
+	}
+	if conf.Cluster.PresenceInterval.Duration <= 0 {
+		invalid("cluster.presenceInterval", "must be positive")
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
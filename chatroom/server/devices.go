@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// userConnections returns all connections either authenticated as the given
+// user or appearing as the given guest mapped to their states
+func (srv *ChatRoomServer) userConnections(
+	username string,
+) map[wwr.Connection]clientState {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+
+	connections := make(map[wwr.Connection]clientState)
+	if username == "" {
+		return connections
+	}
+	for conn, state := range srv.connected {
+		if identity(conn, state) == username {
+			connections[conn] = *state
+		}
+	}
+	return connections
+}
+
+// verifyAuthenticated returns a request error
+// if the given client isn't authenticated
+func verifyAuthenticated(client wwr.Connection) error {
+	if !client.HasSession() {
+		return wwr.ErrRequest{
+			Code:    "NOT_AUTHENTICATED",
+			Message: "Authentication required",
+		}
+	}
+	return nil
+}
+
+// newDevice returns the device of the given connection
+func newDevice(conn wwr.Connection, state clientState) shared.Device {
+	return shared.Device{
+		ID:         state.DeviceID,
+		RemoteAddr: conn.RemoteAddr().String(),
+		Connected:  conn.Creation(),
+		Room:       state.Room,
+	}
+}
+
+// handleDevices replies with a list of all active connections
+// of the authenticated user on all nodes of the cluster
+func (srv *ChatRoomServer) handleDevices(
+	_ context.Context,
+	client wwr.Connection,
+	_ wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyAuthenticated(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	username := client.SessionInfo("username").(string)
+	devices := srv.remoteDevices(username)
+	for conn, state := range srv.userConnections(username) {
+		device := newDevice(conn, state)
+		device.Current = conn == client
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ID < devices[j].ID
+	})
+
+	return srv.reply(client, devices)
+}
+
+// sessionConnections returns all connections the given session is active on
+func (srv *ChatRoomServer) sessionConnections(key string) []wwr.Connection {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+
+	connections := []wwr.Connection{}
+	for conn := range srv.connected {
+		if conn.SessionKey() == key {
+			connections = append(connections, conn)
+		}
+	}
+	return connections
+}
+
+// closeSession closes the given session
+// on all connections to this node it's active on
+func (srv *ChatRoomServer) closeSession(key string) {
+	for _, conn := range srv.sessionConnections(key) {
+		if err := conn.CloseSession(); err != nil {
+			log.Printf(
+				"WARNING: failed closing session of client %s : %s",
+				conn.RemoteAddr(),
+				err,
+			)
+		}
+	}
+}
+
+// revokeSession closes the given session on all connections to any node
+// and deletes it for it to never be restored again
+func (srv *ChatRoomServer) revokeSession(key string) error {
+	if err := srv.sessions.Revoke(key, func() {
+		srv.closeSession(key)
+	}); err != nil {
+		return err
+	}
+	srv.publish(eventRevoke, revokeEvent{Key: key})
+	return nil
+}
+
+// signoutDevice revokes the session of the given device of the given user
+// if it's connected to this node. Returns false if it isn't
+func (srv *ChatRoomServer) signoutDevice(
+	username string,
+	deviceID uint64,
+) (bool, error) {
+	for conn, state := range srv.userConnections(username) {
+		if state.DeviceID != deviceID {
+			continue
+		}
+		key := conn.SessionKey()
+		if key == "" {
+			return false, nil
+		}
+		if err := srv.revokeSession(key); err != nil {
+			return true, fmt.Errorf(
+				"Couldn't revoke session of device %d: %s",
+				deviceID,
+				err,
+			)
+		}
+		log.Printf(
+			"User %s signed out device %d (%s)",
+			username,
+			deviceID,
+			conn.RemoteAddr(),
+		)
+		return true, nil
+	}
+	return false, nil
+}
+
+// handleSignoutDevice signs out one of the devices of the authenticated user
+// revoking the session of the device's connection. The session is closed
+// on all connections it's active on, including other devices sharing it.
+// Devices connected to other nodes are signed out by the node they're
+// connected to
+func (srv *ChatRoomServer) handleSignoutDevice(
+	_ context.Context,
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyAuthenticated(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	deviceID, err := strconv.ParseUint(string(message.Payload()), 10, 64)
+	if err != nil {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "DECODING_FAILURE",
+			Message: fmt.Sprintf("Invalid device id: %s", err),
+		}
+	}
+
+	username := client.SessionInfo("username").(string)
+	found, err := srv.signoutDevice(username, deviceID)
+	if err != nil {
+		return wwr.Payload{}, err
+	}
+	if found {
+		return wwr.Payload{}, nil
+	}
+
+	for _, device := range srv.remoteDevices(username) {
+		if device.ID == deviceID {
+			srv.publish(eventSignout, signoutEvent{
+				User:     username,
+				DeviceID: deviceID,
+			})
+			return wwr.Payload{}, nil
+		}
+	}
+
+	return wwr.Payload{}, wwr.ErrRequest{
+		Code:    "DEVICE_NOT_FOUND",
+		Message: fmt.Sprintf("No such device: %d", deviceID),
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// errorSharingViolation is returned when opening a file
+// another process opened without sharing it
+const errorSharingViolation syscall.Errno = 32
+
+// lockFile opens the given file without sharing it with other processes.
+// The lock is released when the file is closed or the process exits.
+// Returns errLocked if another process holds the lock
+func lockFile(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(
+		name,
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		0,
+		nil,
+		syscall.OPEN_ALWAYS,
+		syscall.FILE_ATTRIBUTE_NORMAL,
+		0,
+	)
+	if err == errorSharingViolation {
+		return nil, errLocked
+	} else if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// directEvent represents an encrypted direct message sent on another node
+type directEvent struct {
+	To      string `json:"to"`
+	Message []byte `json:"message"`
+}
+
+// deliverDirectMessage sends an encoded direct message
+// to all connections of the recipient on this node
+func (srv *ChatRoomServer) deliverDirectMessage(to string, encoded []byte) {
+	for _, state := range srv.userConnections(to) {
+		state.Outbox.Enqueue([]byte(shared.SignalDirectMessage), wwr.Payload{
+			Encoding: wwr.EncodingBinary,
+			Data:     encoded,
+		})
+	}
+}
+
+// handleDirectMessage relays an end-to-end encrypted direct message
+// to another user connected to any node of the cluster.
+// The server can't read the message, thus no content filters are applied
+func (srv *ChatRoomServer) handleDirectMessage(
+	_ context.Context,
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	if srv.isDraining() {
+		return wwr.Payload{}, errShuttingDown
+	}
+	if err := verifyAuthenticated(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	srv.lock.RLock()
+	state, exists := srv.connected[client]
+	srv.lock.RUnlock()
+	if !exists {
+		return wwr.Payload{}, errClientGone
+	}
+	if err := state.RateLimit.Allow(); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	var dm shared.DirectMessage
+	if message.PayloadEncoding() != wwr.EncodingBinary {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "DECODING_FAILURE",
+			Message: "Direct messages must be binary encoded",
+		}
+	}
+	if err := dm.UnmarshalBinary(message.Payload()); err != nil {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "DECODING_FAILURE",
+			Message: fmt.Sprintf("Failed decoding direct message: %s", err),
+		}
+	}
+
+	// The sender is authenticated as additional data of the ciphertext,
+	// thus it must be set by the client and can't be replaced here
+	username := client.SessionInfo("username").(string)
+	if dm.From != username {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code: "SENDER_MISMATCH",
+			Message: fmt.Sprintf(
+				"Direct messages must be sent from '%s'",
+				username,
+			),
+		}
+	}
+
+	if !srv.isOnline(dm.To) {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "USER_NOT_ONLINE",
+			Message: fmt.Sprintf("User '%s' isn't online", dm.To),
+		}
+	}
+
+	encoded := append([]byte(nil), message.Payload()...)
+	srv.deliverDirectMessage(dm.To, encoded)
+	srv.publish(eventDirect, directEvent{
+		To:      dm.To,
+		Message: encoded,
+	})
+
+	log.Printf("Direct message from %s to %s", dm.From, dm.To)
+
+	return wwr.Payload{}, nil
+}
+
+// handleWho replies with the names of all users
+// connected to any node of the cluster
+func (srv *ChatRoomServer) handleWho(
+	_ context.Context,
+	client wwr.Connection,
+	_ wwr.Message,
+) (wwr.Payload, error) {
+	return srv.reply(client, srv.onlineUsers())
+}
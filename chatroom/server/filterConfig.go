@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// filterConfig represents the configuration of a single message filter
+type filterConfig struct {
+	// Type is either "redact", "max-length" or "block"
+	Type string `json:"type"`
+
+	// Pattern and Replacement configure "redact" filters
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+
+	// Limit configures "max-length" filters
+	Limit int `json:"limit"`
+
+	// Words configures "block" filters
+	Words []string `json:"words"`
+}
+
+// filterFile represents the structure of a message filter configuration file
+type filterFile struct {
+	Filters []filterConfig `json:"filters"`
+}
+
+// build constructs the filter described by the configuration
+func (conf filterConfig) build() (messageFilter, error) {
+	switch conf.Type {
+	case "redact":
+		pattern, err := regexp.Compile(conf.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %s", err)
+		}
+		replacement := conf.Replacement
+		if replacement == "" {
+			replacement = "[REDACTED]"
+		}
+		return &redactFilter{pattern, replacement}, nil
+	case "max-length":
+		if conf.Limit < 1 {
+			return nil, fmt.Errorf("invalid limit: %d", conf.Limit)
+		}
+		return &maxLengthFilter{conf.Limit}, nil
+	case "block":
+		words := make([]string, 0, len(conf.Words))
+		for _, word := range conf.Words {
+			if word = strings.TrimSpace(word); word != "" {
+				words = append(words, strings.ToLower(word))
+			}
+		}
+		return &blockFilter{words}, nil
+	}
+	return nil, fmt.Errorf("unknown filter type: '%s'", conf.Type)
+}
+
+// loadFilters reads the message filter configuration file
+// and constructs the filters in the configured order
+func loadFilters(filePath string) ([]messageFilter, error) {
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read filter file: %s", err)
+	}
+
+	var file filterFile
+	if err := json.Unmarshal(contents, &file); err != nil {
+		return nil, fmt.Errorf("Couldn't parse filter file: %s", err)
+	}
+
+	filters := make([]messageFilter, len(file.Filters))
+	for index, conf := range file.Filters {
+		filter, err := conf.build()
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Invalid filter %d (%s): %s",
+				index,
+				conf.Type,
+				err,
+			)
+		}
+		filters[index] = filter
+	}
+
+	return filters, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// filterAction defines what a message filter decided to do with a message
+type filterAction int
+
+const (
+	// filterAllow passes the message on unchanged
+	filterAllow filterAction = iota
+
+	// filterRewrite passes the message on in a modified form
+	filterRewrite
+
+	// filterReject prevents the message from being broadcast
+	filterReject
+)
+
+// filterVerdict represents the decision of a message filter
+type filterVerdict struct {
+	Action filterAction
+
+	// Msg is the rewritten message, only set when Action is filterRewrite
+	Msg string
+
+	// Reason explains the rejection, only set when Action is filterReject
+	Reason string
+}
+
+// messageFilter defines the interface of a message content filter
+type messageFilter interface {
+	// Filter inspects the given message and decides whether it's to be
+	// allowed, rewritten or rejected
+	Filter(msg string) filterVerdict
+}
+
+// filterChain applies a list of message filters in order.
+// The list of filters can be replaced at runtime
+type filterChain struct {
+	filters []messageFilter
+	lock    sync.RWMutex
+}
+
+// newFilterChain constructs a new filter chain
+func newFilterChain(filters ...messageFilter) *filterChain {
+	return &filterChain{
+		filters: filters,
+	}
+}
+
+// Replace atomically replaces all filters of the chain
+func (chain *filterChain) Replace(filters []messageFilter) {
+	chain.lock.Lock()
+	chain.filters = filters
+	chain.lock.Unlock()
+}
+
+// Apply passes the message through all filters of the chain.
+// Returns the eventually rewritten message or a rejection reason
+// if any of the filters rejected the message
+func (chain *filterChain) Apply(msg string) (
+	filtered string,
+	rejected bool,
+	reason string,
+) {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	for _, filter := range chain.filters {
+		verdict := filter.Filter(msg)
+		switch verdict.Action {
+		case filterRewrite:
+			msg = verdict.Msg
+		case filterReject:
+			return "", true, verdict.Reason
+		}
+	}
+	return msg, false, ""
+}
+
+/****************************************************************\
+	Built-in Filters
+\****************************************************************/
+
+// redactFilter replaces all matches of a regular expression
+type redactFilter struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// Filter implements the messageFilter interface
+func (filter *redactFilter) Filter(msg string) filterVerdict {
+	if !filter.pattern.MatchString(msg) {
+		return filterVerdict{Action: filterAllow}
+	}
+	return filterVerdict{
+		Action: filterRewrite,
+		Msg:    filter.pattern.ReplaceAllLiteralString(msg, filter.replacement),
+	}
+}
+
+// maxLengthFilter rejects messages exceeding a number of characters
+type maxLengthFilter struct {
+	limit int
+}
+
+// Filter implements the messageFilter interface
+func (filter *maxLengthFilter) Filter(msg string) filterVerdict {
+	if length := utf8.RuneCountInString(msg); length > filter.limit {
+		return filterVerdict{
+			Action: filterReject,
+			Reason: fmt.Sprintf(
+				"Message too long (%d characters, max: %d)",
+				length,
+				filter.limit,
+			),
+		}
+	}
+	return filterVerdict{Action: filterAllow}
+}
+
+// blockFilter rejects messages containing any of the blocked words
+type blockFilter struct {
+	// words contains the lower-case blocked words
+	words []string
+}
+
+// Filter implements the messageFilter interface
+func (filter *blockFilter) Filter(msg string) filterVerdict {
+	lowerMsg := strings.ToLower(msg)
+	for _, word := range filter.words {
+		if strings.Contains(lowerMsg, word) {
+			return filterVerdict{
+				Action: filterReject,
+				Reason: "Message contains a blocked word",
+			}
+		}
+	}
+	return filterVerdict{Action: filterAllow}
+}
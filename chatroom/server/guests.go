@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// anonymousPolicy defines how connections without a session are treated
+type anonymousPolicy string
+
+const (
+	// anonymousDisallow only allows authenticated users to post,
+	// anonymous connections can read but don't appear in the presence
+	anonymousDisallow anonymousPolicy = "disallow"
+
+	// anonymousGuest assigns a guest nickname to every anonymous connection
+	// which is used to post messages
+	anonymousGuest anonymousPolicy = "guest"
+
+	// anonymousReadOnly assigns guest nicknames like anonymousGuest
+	// but doesn't allow guests to post
+	anonymousReadOnly anonymousPolicy = "read-only"
+)
+
+// parseAnonymousPolicy parses the name of an anonymous policy
+func parseAnonymousPolicy(name string) (anonymousPolicy, error) {
+	switch policy := anonymousPolicy(name); policy {
+	case anonymousDisallow, anonymousGuest, anonymousReadOnly:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown anonymous policy: '%s'", name)
+}
+
+// newGuestName generates a random guest nickname
+func newGuestName() string {
+	var id [3]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(fmt.Errorf("Couldn't generate guest name: %s", err))
+	}
+	return shared.GuestPrefix + hex.EncodeToString(id[:])
+}
+
+// identity returns the name the given client appears as, which is either
+// the name of the authenticated user or the guest nickname of the connection.
+// Returns an empty string for anonymous clients without a nickname
+func identity(client wwr.Connection, state *clientState) string {
+	if name, _ := client.SessionInfo("username").(string); name != "" {
+		return name
+	}
+	return state.Guest
+}
+
+// verifyAnonymousPost returns a request error if the anonymous policy
+// doesn't allow the given client to post
+func (srv *ChatRoomServer) verifyAnonymousPost(client wwr.Connection) error {
+	if client.HasSession() {
+		return nil
+	}
+	switch srv.anonymous {
+	case anonymousDisallow:
+		return wwr.ErrRequest{
+			Code:    "NOT_AUTHENTICATED",
+			Message: "Sign in to post messages",
+		}
+	case anonymousReadOnly:
+		return wwr.ErrRequest{
+			Code:    "READ_ONLY_GUEST",
+			Message: "Guests can only read, sign in to post messages",
+		}
+	}
+	return nil
+}
+
+// handleGuests replies with the anonymous guests connected to this node
+func (srv *ChatRoomServer) handleGuests(
+	_ context.Context,
+	client wwr.Connection,
+	_ wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyModerator(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	srv.lock.RLock()
+	guests := make([]shared.Guest, 0)
+	for conn, state := range srv.connected {
+		if state.Guest == "" || conn.HasSession() {
+			continue
+		}
+		guests = append(guests, shared.Guest{
+			Name:       state.Guest,
+			RemoteAddr: conn.RemoteAddr().String(),
+			Connected:  conn.Creation(),
+			Room:       state.Room,
+			ReadOnly:   srv.anonymous == anonymousReadOnly,
+		})
+	}
+	srv.lock.RUnlock()
+
+	sort.Slice(guests, func(i, j int) bool {
+		return guests[i].Name < guests[j].Name
+	})
+	return srv.reply(client, guests)
+}
+
+// guestBlocks keeps the remote addresses of kicked guests.
+// Guests have no account to ban, blocking their address for a while
+// prevents them from reconnecting right after being kicked
+type guestBlocks struct {
+	duration time.Duration
+	until    map[string]time.Time
+	lock     sync.Mutex
+}
+
+// newGuestBlocks creates a new block list blocking addresses
+// for the given duration. Addresses aren't blocked if it's zero
+func newGuestBlocks(duration time.Duration) *guestBlocks {
+	return &guestBlocks{
+		duration: duration,
+		until:    make(map[string]time.Time),
+	}
+}
+
+// Block blocks the given address for the configured duration
+// and returns the time the block expires at.
+// Returns the zero time if blocking is disabled
+func (blocks *guestBlocks) Block(address string) time.Time {
+	if blocks.duration <= 0 {
+		return time.Time{}
+	}
+	until := time.Now().Add(blocks.duration)
+	blocks.BlockUntil(address, until)
+	return until
+}
+
+// BlockUntil blocks the given address until the given time
+// pruning all expired blocks
+func (blocks *guestBlocks) BlockUntil(address string, until time.Time) {
+	blocks.lock.Lock()
+	defer blocks.lock.Unlock()
+	now := time.Now()
+	for blocked, expires := range blocks.until {
+		if !expires.After(now) {
+			delete(blocks.until, blocked)
+		}
+	}
+	if until.After(blocks.until[address]) {
+		blocks.until[address] = until
+	}
+}
+
+// Blocked returns true if the given address is currently blocked
+func (blocks *guestBlocks) Blocked(address string) bool {
+	blocks.lock.Lock()
+	defer blocks.lock.Unlock()
+	return blocks.until[address].After(time.Now())
+}
+
+// blockGuest blocks the remote address of the given guest connection
+// on all nodes of the cluster
+func (srv *ChatRoomServer) blockGuest(conn wwr.Connection) {
+	address := remoteHost(conn)
+	until := srv.guestBlocks.Block(address)
+	if until.IsZero() {
+		return
+	}
+	srv.publish(eventGuestBlock, guestBlockEvent{
+		Address: address,
+		Until:   until,
+	})
+	log.Printf("Blocked address %s until %s", address, until.Format(time.RFC3339))
+}
+
+// beforeUpgrade refuses connections from the addresses of kicked guests.
+// Signed in users connecting from a blocked address are refused as well
+// since the connection isn't authenticated yet
+func (srv *ChatRoomServer) beforeUpgrade(
+	resp http.ResponseWriter,
+	req *http.Request,
+) wwr.ConnectionOptions {
+	address, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		address = req.RemoteAddr
+	}
+	if srv.guestBlocks.Blocked(address) {
+		http.Error(resp, "address temporarily blocked", http.StatusForbidden)
+		return wwr.ConnectionOptions{Connection: wwr.Refuse}
+	}
+	return wwr.ConnectionOptions{
+		Connection: wwr.Accept,
+		Info: map[int]interface{}{
+			0: []byte(req.UserAgent()),
+		},
+	}
+}
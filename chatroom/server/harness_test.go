@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	wwr "github.com/qbeon/webwire-go"
+	wwrclt "github.com/qbeon/webwire-go-client"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+	wwrgorilla "github.com/qbeon/webwire-go-gorilla"
+)
+
+// testTimeout defines how long the tests wait for expected signals
+const testTimeout = 5 * time.Second
+
+// testSignal represents a signal received by a test client
+type testSignal struct {
+	name     string
+	encoding wwr.PayloadEncoding
+	payload  []byte
+}
+
+// PayloadEncoding implements the shared.EncodedPayload interface
+func (sig testSignal) PayloadEncoding() wwr.PayloadEncoding {
+	return sig.encoding
+}
+
+// Payload implements the shared.EncodedPayload interface
+func (sig testSignal) Payload() []byte {
+	return sig.payload
+}
+
+// testClient implements the wwrclt.Implementation interface
+// recording all signals and session changes
+type testClient struct {
+	t            *testing.T
+	codec        string
+	connection   wwrclt.Client
+	signals      chan testSignal
+	sessions     chan *wwr.Session
+	closed       chan struct{}
+	disconnected chan struct{}
+}
+
+// OnDisconnected implements the wwrclt.Implementation interface
+func (clt *testClient) OnDisconnected() {
+	select {
+	case clt.disconnected <- struct{}{}:
+	default:
+	}
+}
+
+// OnSignal implements the wwrclt.Implementation interface
+func (clt *testClient) OnSignal(msg wwr.Message) {
+	clt.signals <- testSignal{
+		name:     string(msg.Name()),
+		encoding: msg.PayloadEncoding(),
+		payload:  append([]byte(nil), msg.Payload()...),
+	}
+}
+
+// OnSessionCreated implements the wwrclt.Implementation interface
+func (clt *testClient) OnSessionCreated(session *wwr.Session) {
+	clt.sessions <- session
+}
+
+// OnSessionClosed implements the wwrclt.Implementation interface
+func (clt *testClient) OnSessionClosed() {
+	clt.closed <- struct{}{}
+}
+
+// request sends a request and returns the reply payload
+func (clt *testClient) request(
+	name string,
+	encoding wwr.PayloadEncoding,
+	data []byte,
+) ([]byte, error) {
+	reply, err := clt.connection.Request(
+		context.Background(),
+		[]byte(name),
+		wwr.Payload{
+			Encoding: encoding,
+			Data:     data,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer reply.Close()
+	return append([]byte(nil), reply.Payload()...), nil
+}
+
+// handshake negotiates the protocol version
+func (clt *testClient) handshake(version int) error {
+	encoded, err := json.Marshal(shared.HandshakeRequest{Version: version})
+	if err != nil {
+		clt.t.Fatalf("couldn't marshal handshake: %s", err)
+	}
+	_, err = clt.request(shared.RequestHandshake, wwr.EncodingUtf8, encoded)
+	return err
+}
+
+// auth tries to sign in using the given credentials
+// selecting the client's codec
+func (clt *testClient) auth(name, password string) error {
+	encoded, err := json.Marshal(shared.AuthenticationCredentials{
+		Name:     name,
+		Password: password,
+		Codec:    clt.codec,
+	})
+	if err != nil {
+		clt.t.Fatalf("couldn't marshal credentials: %s", err)
+	}
+	_, err = clt.request(shared.RequestAuth, wwr.EncodingBinary, encoded)
+	return err
+}
+
+// login signs in and waits for the session to be created
+func (clt *testClient) login(name string) *wwr.Session {
+	if err := clt.auth(name, userAccounts[name]); err != nil {
+		clt.t.Fatalf("authentication of %s failed: %s", name, err)
+	}
+	return clt.expectSession()
+}
+
+// post posts a chat message to the current room
+func (clt *testClient) post(msg string) error {
+	_, err := clt.request(shared.RequestMessage, wwr.EncodingUtf8, []byte(msg))
+	return err
+}
+
+// expectSignal waits for the next signal with the given name
+// skipping all other signals
+func (clt *testClient) expectSignal(name string) testSignal {
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case sig := <-clt.signals:
+			if sig.name == name {
+				return sig
+			}
+		case <-timeout:
+			clt.t.Fatalf("no '%s' signal received", name)
+		}
+	}
+}
+
+// expectChat waits for the next chat message
+func (clt *testClient) expectChat() shared.ChatMessage {
+	var msg shared.ChatMessage
+	if err := shared.Decode(clt.expectSignal(shared.SignalChat), &msg); err != nil {
+		clt.t.Fatalf("couldn't parse chat message: %s", err)
+	}
+	return msg
+}
+
+// expectSession waits for a session to be created
+func (clt *testClient) expectSession() *wwr.Session {
+	select {
+	case session := <-clt.sessions:
+		return session
+	case <-time.After(testTimeout):
+		clt.t.Fatal("no session created")
+	}
+	return nil
+}
+
+// expectSessionClosed waits for the session to be closed
+func (clt *testClient) expectSessionClosed() {
+	select {
+	case <-clt.closed:
+	case <-time.After(testTimeout):
+		clt.t.Fatal("session not closed")
+	}
+}
+
+// expectDisconnected waits for the connection to be lost
+func (clt *testClient) expectDisconnected() {
+	select {
+	case <-clt.disconnected:
+	case <-time.After(testTimeout):
+		clt.t.Fatal("client not disconnected")
+	}
+}
+
+// harness runs a chatroom server on an ephemeral port
+// with all of its files in a temporary directory
+type harness struct {
+	t        *testing.T
+	dir      string
+	conf     config
+	server   wwr.Server
+	chatRoom *ChatRoomServer
+	clients  []*testClient
+	cleanup  func()
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// testConfig returns the default configuration of a test server
+// listening on an ephemeral port with all of its files in the given directory
+func testConfig(dir string) config {
+	conf := defaultConfig()
+	conf.Addr = "127.0.0.1:0"
+	conf.SessionDir = filepath.Join(dir, "sessions")
+	conf.Rooms.File = filepath.Join(dir, "rooms.json")
+	conf.HistoryDir = filepath.Join(dir, "history")
+	conf.AuditFile = filepath.Join(dir, "audit.log")
+	conf.KeysFile = filepath.Join(dir, "keys.json")
+	conf.BansFile = filepath.Join(dir, "bans.json")
+	conf.FiltersFile = filepath.Join(dir, "filters.json")
+	conf.Shutdown.DrainDeadline = duration{200 * time.Millisecond}
+	return conf
+}
+
+// newHarness starts a new chatroom server. The given functions
+// may adjust the configuration before the server is set up.
+// The harness must be torn down by the test
+func newHarness(t *testing.T, configure ...func(*config)) *harness {
+	dir, err := ioutil.TempDir("", "chatroom-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporary directory: %s", err)
+	}
+
+	conf := testConfig(dir)
+	for _, fn := range configure {
+		fn(&conf)
+	}
+	if err := conf.Validate(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	server, chatRoom, cleanup, err := setupServer(conf)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("couldn't set up server: %s", err)
+	}
+
+	hrn := &harness{
+		t:        t,
+		dir:      dir,
+		conf:     conf,
+		server:   server,
+		chatRoom: chatRoom,
+		cleanup:  cleanup,
+		stopped:  make(chan struct{}),
+	}
+	go func() {
+		defer close(hrn.stopped)
+		if err := server.Run(); err != nil {
+			log.Printf("Server stopped: %s", err)
+		}
+	}()
+
+	return hrn
+}
+
+// teardown closes all clients, stops the server
+// and removes the temporary directory
+func (hrn *harness) teardown() {
+	for _, clt := range hrn.clients {
+		clt.connection.Close()
+	}
+	hrn.shutdown()
+	hrn.cleanup()
+	if err := os.RemoveAll(hrn.dir); err != nil {
+		hrn.t.Errorf("couldn't remove temporary directory: %s", err)
+	}
+}
+
+// shutdown drains and stops the server
+func (hrn *harness) shutdown() {
+	hrn.stopOnce.Do(func() {
+		hrn.chatRoom.Drain(
+			"test",
+			hrn.conf.Shutdown.DrainDeadline.Duration,
+			0,
+		)
+		if err := hrn.server.Shutdown(); err != nil {
+			hrn.t.Errorf("server shutdown failed: %s", err)
+		}
+		<-hrn.stopped
+	})
+}
+
+// connect connects the given number of new clients to the server.
+// Waits for each client to be joined to the default room
+// and negotiates the protocol version
+func (hrn *harness) connect(num int) []*testClient {
+	caCert, err := ioutil.ReadFile("./wwrexampleCA.pem")
+	if err != nil {
+		hrn.t.Fatalf("couldn't read CA certificate: %s", err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AppendCertsFromPEM(caCert)
+
+	addr := hrn.server.Address()
+	clients := make([]*testClient, num)
+	for i := range clients {
+		clt := &testClient{
+			t:            hrn.t,
+			signals:      make(chan testSignal, 256),
+			sessions:     make(chan *wwr.Session, 8),
+			closed:       make(chan struct{}, 8),
+			disconnected: make(chan struct{}, 1),
+		}
+		clt.connection, err = wwrclt.NewClient(
+			clt,
+			wwrclt.Options{
+				DefaultRequestTimeout: testTimeout,
+				Autoconnect:           wwr.Disabled,
+				SessionInfoParser:     shared.SessionInfoParser,
+				SubProtocolName:       []byte(hrn.conf.SubProtocol),
+				WarnLog:               log.New(ioutil.Discard, "", 0),
+				ErrorLog:              log.New(ioutil.Discard, "", 0),
+			},
+			&wwrgorilla.ClientTransport{
+				ServerAddress: url.URL{
+					Scheme: "https",
+					Host:   "localhost:" + addr.Port(),
+					Path:   "/",
+				},
+				Dialer: websocket.Dialer{
+					TLSClientConfig: &tls.Config{RootCAs: caPool},
+				},
+			},
+		)
+		if err != nil {
+			hrn.t.Fatalf("couldn't create client: %s", err)
+		}
+		if err := clt.connection.Connect(context.Background()); err != nil {
+			hrn.t.Fatalf("couldn't connect client: %s", err)
+		}
+		hrn.clients = append(hrn.clients, clt)
+		clt.expectSignal(shared.SignalJoined)
+		if err := clt.handshake(shared.ProtocolVersion); err != nil {
+			hrn.t.Fatalf("handshake failed: %s", err)
+		}
+		clients[i] = clt
+	}
+	return clients
+}
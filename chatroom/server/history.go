@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// historyMessage represents a chat message persisted in the history
+type historyMessage struct {
+	// ID uniquely identifies the message across all nodes and servers
+	ID     string    `json:"id"`
+	Room   string    `json:"room"`
+	User   string    `json:"user"`
+	Msg    string    `json:"msg"`
+	Guest  bool      `json:"guest,omitempty"`
+	Posted time.Time `json:"posted"`
+}
+
+// newMessageID generates a random message identifier
+func newMessageID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(fmt.Errorf("Couldn't generate message ID: %s", err))
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// historyLockFileName defines the name of the lock file
+// in the history directory
+const historyLockFileName = ".lock"
+
+// errLocked is returned by lockFile
+// if the file is locked by another process
+var errLocked = errors.New("locked by another process")
+
+// historyStore persists the messages of each room
+// to a separate JSON Lines file in the history directory
+type historyStore struct {
+	dir      string
+	files    map[string]*os.File
+	lockFile *os.File
+	lock     sync.Mutex
+}
+
+// newHistoryStore opens the history in the given directory
+// creating the directory if it doesn't exist yet.
+// The directory is locked until the store is closed
+// to prevent other processes such as the import command
+// from modifying the history while it's in use
+func newHistoryStore(dir string) (*historyStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Couldn't create history directory: %s", err)
+	}
+	lock, err := lockFile(filepath.Join(dir, historyLockFileName))
+	if err == errLocked {
+		return nil, fmt.Errorf(
+			"History directory %s is in use by another process, "+
+				"stop the server first",
+			dir,
+		)
+	} else if err != nil {
+		return nil, fmt.Errorf("Couldn't lock history directory: %s", err)
+	}
+	return &historyStore{
+		dir:      dir,
+		files:    make(map[string]*os.File),
+		lockFile: lock,
+	}, nil
+}
+
+// filePath returns the path of the history file of the given room.
+// The room name is escaped for it to be a valid file name
+func (str *historyStore) filePath(room string) string {
+	return filepath.Join(str.dir, url.PathEscape(room)+".jsonl")
+}
+
+// Append appends a message to the history of its room
+func (str *historyStore) Append(message historyMessage) error {
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal message: %s", err)
+	}
+
+	str.lock.Lock()
+	defer str.lock.Unlock()
+
+	file, isOpen := str.files[message.Room]
+	if !isOpen {
+		file, err = os.OpenFile(
+			str.filePath(message.Room),
+			os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+			0600,
+		)
+		if err != nil {
+			return fmt.Errorf("Couldn't open history file: %s", err)
+		}
+		str.files[message.Room] = file
+	}
+	if _, err := file.Write(append(encoded, '\n')); err != nil {
+		return fmt.Errorf("Couldn't write history: %s", err)
+	}
+	return nil
+}
+
+// read reads the history of the given room.
+// The caller is expected to hold the lock
+func (str *historyStore) read(room string) ([]historyMessage, error) {
+	contents, err := ioutil.ReadFile(str.filePath(room))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Couldn't read history: %s", err)
+	}
+
+	var messages []historyMessage
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var message historyMessage
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			return nil, fmt.Errorf(
+				"Malformed history of room %s, line %d: %s",
+				room,
+				line,
+				err,
+			)
+		}
+		messages = append(messages, message)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Couldn't read history: %s", err)
+	}
+	return messages, nil
+}
+
+// Messages returns all messages of the given room in the order posted
+func (str *historyStore) Messages(room string) ([]historyMessage, error) {
+	str.lock.Lock()
+	defer str.lock.Unlock()
+	return str.read(room)
+}
+
+// Import merges the given messages into the history of the given room
+// skipping all messages already contained in it, which makes importing
+// the same messages again a no-op. Returns the number of added messages
+func (str *historyStore) Import(
+	room string,
+	messages []historyMessage,
+) (int, error) {
+	str.lock.Lock()
+	defer str.lock.Unlock()
+
+	existing, err := str.read(room)
+	if err != nil {
+		return 0, err
+	}
+	known := make(map[string]bool, len(existing))
+	for _, message := range existing {
+		known[message.ID] = true
+	}
+
+	merged := existing
+	for _, message := range messages {
+		if known[message.ID] {
+			continue
+		}
+		known[message.ID] = true
+		merged = append(merged, message)
+	}
+	added := len(merged) - len(existing)
+	if added < 1 {
+		return 0, nil
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Posted.Before(merged[j].Posted)
+	})
+
+	var encoded bytes.Buffer
+	for _, message := range merged {
+		line, err := json.Marshal(message)
+		if err != nil {
+			return 0, fmt.Errorf("Couldn't marshal message: %s", err)
+		}
+		encoded.Write(append(line, '\n'))
+	}
+
+	// The appending file handle would point to the replaced file
+	if file, isOpen := str.files[room]; isOpen {
+		file.Close()
+		delete(str.files, room)
+	}
+	if err := writeFileAtomic(str.filePath(room), encoded.Bytes()); err != nil {
+		return 0, fmt.Errorf("Couldn't save history: %s", err)
+	}
+	return added, nil
+}
+
+// Close flushes and closes all history files
+func (str *historyStore) Close() error {
+	str.lock.Lock()
+	defer str.lock.Unlock()
+
+	var firstErr error
+	for room, file := range str.files {
+		if err := file.Sync(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(str.files, room)
+	}
+	if str.lockFile != nil {
+		if err := str.lockFile.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		str.lockFile = nil
+	}
+	return firstErr
+}
@@ -2,63 +2,361 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/subtle"
 	"fmt"
 	"log"
+	"strconv"
 	"sync"
+	"time"
 
 	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/audit"
+	"github.com/qbeon/webwire-go-examples/chatroom/cluster"
 	"github.com/qbeon/webwire-go-examples/chatroom/shared"
 )
 
+// clientState represents the state of a connected client
+type clientState struct {
+	// DeviceID identifies the connection among the devices of a user
+	DeviceID uint64
+
+	// Room is the name of the room the client is currently in
+	Room string
+
+	// Outbox queues the signals to be sent to the client
+	Outbox *outbox
+
+	// RateLimit limits the messages sent by the client
+	RateLimit *rateLimiter
+
+	// Protocol is the negotiated protocol version,
+	// 0 until the client completed the handshake
+	Protocol int
+
+	// Guest is the nickname the client appears as while not authenticated,
+	// empty if the anonymous policy doesn't assign guest nicknames
+	Guest string
+}
+
+// errClientGone is returned for requests of clients
+// that disconnected before the request was handled
+var errClientGone = wwr.ErrRequest{
+	Code:    "CLIENT_GONE",
+	Message: "The client is no longer connected",
+}
+
 // ChatRoomServer implements the webwire.ServerImplementation interface
 type ChatRoomServer struct {
-	connected map[wwr.Connection]bool
+	connected map[wwr.Connection]*clientState
+	deviceIDs uint64
+	rooms     *roomStore
+	history   *historyStore
+	filters   *filterChain
+	auditLog  *audit.Log
+	lockouts  *lockoutTracker
+	bans      *banList
+	sessions  *storedSessionManager
+	outboxes  outboxConfig
+	metrics   *outboxMetrics
+	rateLimit rateLimitPolicy
+	anonymous anonymousPolicy
+	keys      *keyDirectory
 	lock      sync.RWMutex
+
+	// guestBlocks keeps the addresses of kicked guests
+	guestBlocks *guestBlocks
+
+	// draining is set to 1 when the server is shutting down
+	draining int32
+
+	// shutdownNotice is the JSON encoded notice sent to the clients
+	// connecting while draining, nil before. It's guarded by the lock
+	shutdownNotice *wwr.Payload
+
+	// bus connects this server to the other nodes of the cluster
+	bus cluster.Bus
+
+	// nodeTag is included in the device IDs
+	// for them to be unique among all nodes
+	nodeTag uint16
+
+	// presence maps the identifiers of other nodes
+	// to the users connected to them
+	presence         map[string]nodePresence
+	presenceInterval time.Duration
+	presenceLock     sync.Mutex
 }
 
 // NewChatRoomServer constructs a new
 // webwire server implementation instance
-func NewChatRoomServer() *ChatRoomServer {
-	return &ChatRoomServer{
-		make(map[wwr.Connection]bool),
-		sync.RWMutex{},
+func NewChatRoomServer(
+	rooms *roomStore,
+	history *historyStore,
+	filters *filterChain,
+	auditLog *audit.Log,
+	lockouts *lockoutTracker,
+	bans *banList,
+	sessions *storedSessionManager,
+	outboxes outboxConfig,
+	rateLimit rateLimitPolicy,
+	anonymous anonymousPolicy,
+	guestBlock time.Duration,
+	keys *keyDirectory,
+	bus cluster.Bus,
+	presenceInterval time.Duration,
+) *ChatRoomServer {
+	srv := &ChatRoomServer{
+		connected:        make(map[wwr.Connection]*clientState),
+		rooms:            rooms,
+		history:          history,
+		filters:          filters,
+		auditLog:         auditLog,
+		lockouts:         lockouts,
+		bans:             bans,
+		sessions:         sessions,
+		outboxes:         outboxes,
+		metrics:          &outboxMetrics{},
+		rateLimit:        rateLimit,
+		anonymous:        anonymous,
+		guestBlocks:      newGuestBlocks(guestBlock),
+		keys:             keys,
+		bus:              bus,
+		nodeTag:          nodeTag(bus.NodeID()),
+		presence:         make(map[string]nodePresence),
+		presenceInterval: presenceInterval,
 	}
+	rooms.SetNodeTag(srv.nodeTag)
+	bus.OnEvent(srv.handleClusterEvent)
+	return srv
+}
+
+// audit records an event caused by the given client in the audit log
+func (srv *ChatRoomServer) audit(
+	event audit.Event,
+	client wwr.Connection,
+	user string,
+	details map[string]string,
+) {
+	if err := srv.auditLog.Record(
+		event,
+		user,
+		client.RemoteAddr().String(),
+		details,
+	); err != nil {
+		log.Printf("ERROR: couldn't record %s audit event: %s", event, err)
+	}
+}
+
+// auditAdminAction records an administrative action
+// performed by the given client in the audit log
+func (srv *ChatRoomServer) auditAdminAction(
+	client wwr.Connection,
+	action string,
+	details map[string]string,
+) {
+	if details == nil {
+		details = make(map[string]string)
+	}
+	details["action"] = action
+	srv.audit(
+		audit.EventAdminAction,
+		client,
+		client.SessionInfo("username").(string),
+		details,
+	)
 }
 
 /****************************************************************\
 	Message Broadcaster
 \****************************************************************/
 
-// broadcastMessage sends a message on behalf of the given user
-// to all connected clients
-func (srv *ChatRoomServer) broadcastMessage(name string, msg string) {
-	// Marshal message
-	encoded, err := json.Marshal(shared.ChatMessage{
-		User: name,
-		Msg:  msg,
-	})
+// broadcastMessage sends a message on behalf of the given user to all clients
+// in the given room on all nodes of the cluster.
+// The sender is anonymous if sender is empty
+func (srv *ChatRoomServer) broadcastMessage(room, sender, msg string) {
+	chat := chatEvent{
+		ID:     newMessageID(),
+		Room:   room,
+		Sender: sender,
+		Msg:    msg,
+		Posted: time.Now().UTC(),
+	}
+	srv.deliverMessage(chat)
+	srv.publish(eventChat, chat)
+}
+
+// deliverMessage records a message posted on behalf of the given user or
+// guest in the history of this node and sends it to all clients in the room
+// connected to this node. The message is also echoed to all other devices
+// of the sender tagged as own message.
+// The sender is anonymous if sender is empty
+func (srv *ChatRoomServer) deliverMessage(chat chatEvent) {
+	room, sender, msg := chat.Room, chat.Sender, chat.Msg
+	name := sender
+	if name == "" {
+		name = "Anonymous"
+	}
+
+	if err := srv.history.Append(historyMessage{
+		ID:     chat.ID,
+		Room:   room,
+		User:   name,
+		Msg:    msg,
+		Guest:  shared.IsGuestName(sender),
+		Posted: chat.Posted,
+	}); err != nil {
+		log.Printf("ERROR: couldn't record message in history: %s", err)
+	}
+
+	// Encode the message at most once per codec
+	chatMsg := shared.ChatMessage{
+		Room:  room,
+		User:  name,
+		Msg:   msg,
+		Guest: shared.IsGuestName(sender),
+	}
+	encoded := newEncodedPayloads(chatMsg)
+	chatMsg.Own = true
+	encodedOwn := newEncodedPayloads(chatMsg)
+
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+
+	log.Printf("Broadcast message to room %s", room)
+	for client, state := range srv.connected {
+		own := sender != "" && identity(client, state) == sender
+		if !own && state.Room != room {
+			continue
+		}
+
+		if own {
+			state.Outbox.Enqueue(nil, encodedOwn.For(sessionCodec(client)))
+		} else {
+			state.Outbox.Enqueue(nil, encoded.For(sessionCodec(client)))
+		}
+	}
+}
+
+// changeRoom applies the given operation to its room and replicates it
+// to all other nodes of the cluster. The updated metadata of the room
+// is sent to all clients in the room on all nodes if notify is true
+func (srv *ChatRoomServer) changeRoom(
+	op roomOp,
+	notify bool,
+) (shared.RoomMetadata, error) {
+	metadata, err := srv.rooms.Apply(&op)
 	if err != nil {
-		panic(fmt.Errorf("Couldn't marshal chat message: %s", err))
+		return shared.RoomMetadata{}, err
+	}
+	if notify {
+		srv.deliverRoomUpdate(metadata)
 	}
+	srv.publish(eventRoom, roomEvent{
+		Op:     op,
+		Notify: notify,
+	})
+	return metadata, nil
+}
+
+// deliverRoomUpdate sends the updated metadata of a room
+// to all clients in that room connected to this node
+func (srv *ChatRoomServer) deliverRoomUpdate(metadata shared.RoomMetadata) {
+	srv.signalRoom(metadata.Name, []byte(shared.SignalRoom), metadata)
+}
 
-	// Send message as a signal to each connected client
+// signalRoom sends a named signal to all clients in the given room
+// encoding the value once per codec
+func (srv *ChatRoomServer) signalRoom(
+	room string,
+	name []byte,
+	value interface{},
+) {
 	srv.lock.RLock()
-	log.Printf("Broadcast message to %d clients", len(srv.connected))
-	for client := range srv.connected {
-		// Send message as signal
-		if err := client.Signal(nil, wwr.Payload{
-			Encoding: wwr.EncodingUtf8,
-			Data:     encoded,
-		}); err != nil {
-			log.Printf(
-				"WARNING: failed sending signal to client %s : %s",
-				client.RemoteAddr(),
-				err,
-			)
+	defer srv.lock.RUnlock()
+
+	encoded := newEncodedPayloads(value)
+	log.Printf("Broadcast signal to room %s", room)
+	for client, state := range srv.connected {
+		if state.Room == room {
+			state.Outbox.Enqueue(name, encoded.For(sessionCodec(client)))
 		}
 	}
-	srv.lock.RUnlock()
+}
+
+/****************************************************************\
+	Permissions
+\****************************************************************/
+
+// sessionInfo returns the session info of the given client
+// or nil if the client isn't authenticated
+func sessionInfo(client wwr.Connection) *shared.SessionInfo {
+	session := client.Session()
+	if session == nil {
+		return nil
+	}
+	info, _ := session.Info.(*shared.SessionInfo)
+	return info
+}
+
+// isModerator returns true if the given client
+// is authenticated as either a moderator or an administrator
+func isModerator(client wwr.Connection) bool {
+	info := sessionInfo(client)
+	return info != nil && info.HasRole(roleModerator, roleAdmin)
+}
+
+// verifyModerator returns a request error
+// if the given client isn't allowed to manage rooms
+func verifyModerator(client wwr.Connection) error {
+	if !isModerator(client) {
+		return wwr.ErrRequest{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only moderators are allowed to manage rooms",
+		}
+	}
+	return nil
+}
+
+// verifyAdmin returns a request error
+// if the given client isn't authenticated as an administrator
+func verifyAdmin(client wwr.Connection) error {
+	if info := sessionInfo(client); info == nil || !info.HasRole(roleAdmin) {
+		return wwr.ErrRequest{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only administrators are allowed to do this",
+		}
+	}
+	return nil
+}
+
+// verifyJoin returns a request error
+// if the given client isn't allowed to join the given room
+func verifyJoin(client wwr.Connection, r *room) error {
+	if r.Access != shared.RoomInviteOnly || isModerator(client) {
+		return nil
+	}
+	if info := sessionInfo(client); info != nil && r.isInvited(info.Username) {
+		return nil
+	}
+	return wwr.ErrRequest{
+		Code:    "NOT_INVITED",
+		Message: fmt.Sprintf("Room '%s' is invite-only", r.Name),
+	}
+}
+
+// verifyPost returns a request error
+// if the given client isn't allowed to post in the given room
+func verifyPost(client wwr.Connection, r *room) error {
+	if err := verifyJoin(client, r); err != nil {
+		return err
+	}
+	if r.Access == shared.RoomAnnouncement && !isModerator(client) {
+		return wwr.ErrRequest{
+			Code:    "READ_ONLY_ROOM",
+			Message: fmt.Sprintf("Only moderators can post in '%s'", r.Name),
+		}
+	}
+	return nil
 }
 
 /****************************************************************\
@@ -74,54 +372,108 @@ func (srv *ChatRoomServer) handleAuth(
 	client wwr.Connection,
 	message wwr.Message,
 ) (wwr.Payload, error) {
-	credentialsText, err := message.PayloadUtf8()
-	if err != nil {
-		return wwr.Payload{}, wwr.ErrRequest{
-			Code:    "DECODING_FAILURE",
-			Message: fmt.Sprintf("Failed decoding message: %s", err),
-		}
-	}
-
 	log.Printf("Client attempts authentication: %s", client.RemoteAddr())
 
 	// Try to parse credentials
 	var credentials shared.AuthenticationCredentials
-	if err := json.Unmarshal(
-		[]byte(credentialsText),
-		&credentials,
-	); err != nil {
-		return wwr.Payload{}, fmt.Errorf("Failed parsing credentials: %s", err)
+	if err := parseRequest(message, shared.CodecJSON, &credentials); err != nil {
+		return wwr.Payload{}, err
 	}
 
-	// Verify username
+	// Reject the attempt without verifying the credentials
+	// if either the account or the address is locked out
+	address := remoteHost(client)
+	if lockedUntil, locked := srv.lockouts.Check(
+		credentials.Name,
+		address,
+	); locked {
+		srv.audit(
+			audit.EventLoginFailed,
+			client,
+			credentials.Name,
+			map[string]string{"reason": "locked out"},
+		)
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code: "AUTH_LOCKED",
+			Message: fmt.Sprintf(
+				"Too many failed attempts, retry after %s",
+				lockedUntil.Format(time.RFC3339),
+			),
+		}
+	}
+
+	// Verify username and password. Both failures are reported to the client
+	// the same way to not reveal which usernames exist
 	password, userExists := userAccounts[credentials.Name]
-	if !userExists {
+	if !userExists || subtle.ConstantTimeCompare(
+		[]byte(password),
+		[]byte(credentials.Password),
+	) != 1 {
+		reason := "wrong password"
+		if !userExists {
+			reason = "inexistent user"
+		}
+		srv.lockouts.Failure(credentials.Name, address)
+		srv.publish(eventLockout, lockoutEvent{
+			Kind:    lockoutFailure,
+			Account: credentials.Name,
+			Address: address,
+		})
+		srv.audit(
+			audit.EventLoginFailed,
+			client,
+			credentials.Name,
+			map[string]string{"reason": reason},
+		)
 		return wwr.Payload{}, wwr.ErrRequest{
-			Code:    "INEXISTENT_USER",
-			Message: fmt.Sprintf("No such user: '%s'", credentials.Name),
+			Code:    "AUTH_FAILED",
+			Message: "Invalid username or password",
 		}
 	}
+	srv.lockouts.Success(credentials.Name)
+	srv.publish(eventLockout, lockoutEvent{
+		Kind:    lockoutSuccess,
+		Account: credentials.Name,
+	})
 
-	// Verify password
-	if password != credentials.Password {
+	// Reject banned accounts only after verifying the credentials
+	// to not reveal bans to anyone but the account owner
+	if ban, banned := srv.bans.Check(credentials.Name); banned {
+		srv.audit(
+			audit.EventLoginFailed,
+			client,
+			credentials.Name,
+			map[string]string{"reason": "banned"},
+		)
+		until := "permanently"
+		if !ban.Until.IsZero() {
+			until = "until " + ban.Until.Format(time.RFC3339)
+		}
 		return wwr.Payload{}, wwr.ErrRequest{
-			Code:    "WRONG_PASSWORD",
-			Message: "Provided password is wrong",
+			Code:    "AUTH_BANNED",
+			Message: fmt.Sprintf("The account is banned %s", until),
 		}
 	}
 
-	// Finally create a new session
+	// Finally create a new session switching the connection
+	// to the selected codec
+	codec, _ := shared.CodecByName(credentials.Codec)
 	if err := client.CreateSession(&shared.SessionInfo{
 		Username: credentials.Name,
+		Roles:    userRoles[credentials.Name],
+		Codec:    codec.Name(),
 	}); err != nil {
 		return wwr.Payload{}, fmt.Errorf("Couldn't create session: %s", err)
 	}
 
 	log.Printf(
-		"Created session for user %s (%s)",
+		"Created session for user %s (%s, %s)",
 		client.RemoteAddr(),
 		credentials.Name,
+		codec.Name(),
 	)
+	srv.audit(audit.EventLogin, client, credentials.Name, nil)
+	srv.publishPresence()
 
 	// Reply to the request, use default binary encoding
 	return wwr.Payload{
@@ -149,6 +501,17 @@ func (srv *ChatRoomServer) handleMessage(
 		)
 		return wwr.Payload{}, nil
 	}
+	if err := shared.ValidateText(
+		"msg",
+		string(msgStr),
+		shared.MaxMessageLength,
+	); err != nil {
+		return wwr.Payload{}, fieldError(err)
+	}
+
+	if srv.isDraining() {
+		return wwr.Payload{}, errShuttingDown
+	}
 
 	log.Printf(
 		"Received message from %s: '%s' (%d, %s)",
@@ -158,13 +521,305 @@ func (srv *ChatRoomServer) handleMessage(
 		message.PayloadEncoding().String(),
 	)
 
-	name := "Anonymous"
-	// Try to read the name from the session
-	if client.HasSession() {
-		name = client.SessionInfo("username").(string)
+	if err := srv.verifyAnonymousPost(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	srv.lock.RLock()
+	state, exists := srv.connected[client]
+	if !exists {
+		srv.lock.RUnlock()
+		return wwr.Payload{}, errClientGone
+	}
+	sender := identity(client, state)
+	roomName := state.Room
+	srv.lock.RUnlock()
+
+	if err := state.RateLimit.Allow(); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	r, exists := srv.rooms.Get(roomName)
+	if !exists {
+		return wwr.Payload{}, errRoomNotFound(roomName)
+	}
+	if err := verifyPost(client, r); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	// Pass the message through the content filters
+	filtered, rejected, reason := srv.filters.Apply(string(msgStr))
+	if rejected {
+		log.Printf(
+			"Rejected message from %s: %s",
+			client.RemoteAddr(),
+			reason,
+		)
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "MESSAGE_REJECTED",
+			Message: reason,
+		}
+	}
+
+	srv.broadcastMessage(roomName, sender, filtered)
+
+	return wwr.Payload{}, nil
+}
+
+/****************************************************************\
+	Room Handlers
+\****************************************************************/
+
+// handleJoin moves the client into the requested room
+// and replies with the metadata of the room
+func (srv *ChatRoomServer) handleJoin(
+	_ context.Context,
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	roomName, err := parseName(message, "room")
+	if err != nil {
+		return wwr.Payload{}, err
+	}
+
+	r, exists := srv.rooms.Get(roomName)
+	if !exists {
+		return wwr.Payload{}, errRoomNotFound(roomName)
+	}
+	if err := verifyJoin(client, r); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	srv.lock.Lock()
+	state, exists := srv.connected[client]
+	if exists {
+		state.Room = r.Name
+	}
+	srv.lock.Unlock()
+	if !exists {
+		return wwr.Payload{}, errClientGone
+	}
+
+	log.Printf("Client %s joined room %s", client.RemoteAddr(), r.Name)
+
+	return srv.reply(client, r.Metadata())
+}
+
+// handleSetTopic changes the topic of a room
+func (srv *ChatRoomServer) handleSetTopic(
+	_ context.Context,
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyModerator(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	var req shared.SetTopicRequest
+	if err := parseRequest(
+		message,
+		shared.CodecForEncoding(message.PayloadEncoding()),
+		&req,
+	); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	metadata, err := srv.changeRoom(roomOp{
+		Room:  req.Room,
+		Kind:  roomOpTopic,
+		Topic: req.Topic,
+		Time:  time.Now().UTC(),
+		Node:  srv.nodeTag,
+	}, true)
+	if err != nil {
+		return wwr.Payload{}, err
+	}
+	srv.auditAdminAction(client, "set-topic", map[string]string{
+		"room":  req.Room,
+		"topic": req.Topic,
+	})
+
+	return srv.reply(client, metadata)
+}
+
+// handlePin pins a message to a room
+func (srv *ChatRoomServer) handlePin(
+	_ context.Context,
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyModerator(client); err != nil {
+		return wwr.Payload{}, err
 	}
 
-	srv.broadcastMessage(name, string(msgStr))
+	var req shared.PinRequest
+	if err := parseRequest(
+		message,
+		shared.CodecForEncoding(message.PayloadEncoding()),
+		&req,
+	); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	metadata, err := srv.changeRoom(roomOp{
+		Room: req.Room,
+		Kind: roomOpPin,
+		Pin: &shared.PinnedMessage{
+			User: client.SessionInfo("username").(string),
+			Msg:  req.Msg,
+			Time: time.Now().UTC(),
+		},
+	}, true)
+	if err != nil {
+		return wwr.Payload{}, err
+	}
+	srv.auditAdminAction(client, "pin", map[string]string{
+		"room": req.Room,
+		"msg":  req.Msg,
+	})
+
+	return srv.reply(client, metadata)
+}
+
+// handleUnpin removes a pinned message from a room
+func (srv *ChatRoomServer) handleUnpin(
+	_ context.Context,
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyModerator(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	var req shared.UnpinRequest
+	if err := parseRequest(
+		message,
+		shared.CodecForEncoding(message.PayloadEncoding()),
+		&req,
+	); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	metadata, err := srv.changeRoom(roomOp{
+		Room: req.Room,
+		Kind: roomOpUnpin,
+		ID:   req.ID,
+	}, true)
+	if err != nil {
+		return wwr.Payload{}, err
+	}
+	srv.auditAdminAction(client, "unpin", map[string]string{
+		"room": req.Room,
+		"id":   strconv.FormatUint(req.ID, 10),
+	})
+
+	return srv.reply(client, metadata)
+}
+
+// handleInvite invites a user to an invite-only room
+func (srv *ChatRoomServer) handleInvite(
+	_ context.Context,
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyModerator(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	var req shared.InviteRequest
+	if err := parseRequest(
+		message,
+		shared.CodecForEncoding(message.PayloadEncoding()),
+		&req,
+	); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	if _, userExists := userAccounts[req.User]; !userExists {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "INEXISTENT_USER",
+			Message: fmt.Sprintf("No such user: '%s'", req.User),
+		}
+	}
+
+	metadata, err := srv.changeRoom(roomOp{
+		Room: req.Room,
+		Kind: roomOpInvite,
+		User: req.User,
+	}, false)
+	if err != nil {
+		return wwr.Payload{}, err
+	}
+	srv.auditAdminAction(client, "invite", map[string]string{
+		"room": req.Room,
+		"user": req.User,
+	})
+	log.Printf("User %s was invited to room %s", req.User, req.Room)
+
+	return srv.reply(client, metadata)
+}
+
+// disconnectUser closes the sessions and connections of a user
+// or the connection of a guest and returns the number of connections closed
+func (srv *ChatRoomServer) disconnectUser(username string) int {
+	connections := srv.userConnections(username)
+	for conn := range connections {
+		if !conn.HasSession() {
+			// Guests have no session to close, their address is blocked
+			// to keep them from reconnecting right away
+			srv.blockGuest(conn)
+			conn.Close()
+			continue
+		}
+		if err := conn.CloseSession(); err != nil {
+			log.Printf(
+				"WARNING: failed closing session of client %s : %s",
+				conn.RemoteAddr(),
+				err,
+			)
+		}
+		conn.Close()
+	}
+	return len(connections)
+}
+
+// handleKick closes the sessions and connections of a user
+// or the connection of a guest
+func (srv *ChatRoomServer) handleKick(
+	_ context.Context,
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyModerator(client); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	username, err := parseName(message, "user")
+	if err != nil {
+		return wwr.Payload{}, err
+	}
+
+	// Kick the user from the other nodes as well
+	online := srv.isOnline(username)
+	kicked := srv.disconnectUser(username)
+	if !online && kicked < 1 {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "USER_NOT_CONNECTED",
+			Message: fmt.Sprintf("User '%s' isn't connected", username),
+		}
+	}
+	srv.publish(eventKick, userEvent{User: username})
+
+	srv.audit(
+		audit.EventKick,
+		client,
+		client.SessionInfo("username").(string),
+		map[string]string{
+			"kicked":      string(username),
+			"connections": strconv.Itoa(kicked),
+		},
+	)
+	log.Printf("User %s was kicked", username)
 
 	return wwr.Payload{}, nil
 }
@@ -189,11 +844,57 @@ func (srv *ChatRoomServer) OnRequest(
 	client wwr.Connection,
 	message wwr.Message,
 ) (response wwr.Payload, err error) {
-	switch string(message.Name()) {
-	case "auth":
+	name := string(message.Name())
+	if name == shared.RequestHandshake {
+		return srv.handleHandshake(ctx, client, message)
+	}
+	if !srv.isNegotiated(client) {
+		return wwr.Payload{}, errHandshakeRequired
+	}
+
+	switch name {
+	case shared.RequestAuth:
 		return srv.handleAuth(ctx, client, message)
-	case "msg":
+	case shared.RequestMessage:
 		return srv.handleMessage(ctx, client, message)
+	case shared.RequestJoin:
+		return srv.handleJoin(ctx, client, message)
+	case shared.RequestSetTopic:
+		return srv.handleSetTopic(ctx, client, message)
+	case shared.RequestPin:
+		return srv.handlePin(ctx, client, message)
+	case shared.RequestUnpin:
+		return srv.handleUnpin(ctx, client, message)
+	case shared.RequestInvite:
+		return srv.handleInvite(ctx, client, message)
+	case shared.RequestKick:
+		return srv.handleKick(ctx, client, message)
+	case shared.RequestBan:
+		return srv.handleBan(ctx, client, message)
+	case shared.RequestUnban:
+		return srv.handleUnban(ctx, client, message)
+	case shared.RequestBans:
+		return srv.handleBans(ctx, client, message)
+	case shared.RequestLockouts:
+		return srv.handleLockouts(ctx, client, message)
+	case shared.RequestClearLockout:
+		return srv.handleClearLockout(ctx, client, message)
+	case shared.RequestDevices:
+		return srv.handleDevices(ctx, client, message)
+	case shared.RequestSignoutDevice:
+		return srv.handleSignoutDevice(ctx, client, message)
+	case shared.RequestDirectMessage:
+		return srv.handleDirectMessage(ctx, client, message)
+	case shared.RequestPublishKey:
+		return srv.handlePublishKey(ctx, client, message)
+	case shared.RequestPublicKey:
+		return srv.handlePublicKey(ctx, client, message)
+	case shared.RequestWho:
+		return srv.handleWho(ctx, client, message)
+	case shared.RequestGuests:
+		return srv.handleGuests(ctx, client, message)
+	case shared.RequestMetrics:
+		return srv.handleMetrics(ctx, client, message)
 	}
 	return wwr.Payload{}, wwr.ErrRequest{
 		Code:    "BAD_REQUEST",
@@ -202,7 +903,8 @@ func (srv *ChatRoomServer) OnRequest(
 }
 
 // OnClientConnected implements the webwire.ServerImplementation interface.
-// Registers new connected clients
+// Registers new connected clients in the default room
+// and notifies them about it and about a pending shutdown
 func (srv *ChatRoomServer) OnClientConnected(
 	connOpts wwr.ConnectionOptions,
 	newClient wwr.Connection,
@@ -212,9 +914,39 @@ func (srv *ChatRoomServer) OnClientConnected(
 		newClient.RemoteAddr(),
 		connOpts.Info[0].([]byte),
 	)
+	state := &clientState{
+		Room:      srv.rooms.DefaultRoom(),
+		Outbox:    newOutbox(newClient, srv.outboxes, srv.metrics),
+		RateLimit: newRateLimiter(srv.rateLimit),
+	}
+	if srv.anonymous != anonymousDisallow {
+		state.Guest = newGuestName()
+	}
 	srv.lock.Lock()
-	srv.connected[newClient] = true
+	srv.deviceIDs++
+	state.DeviceID = srv.deviceIDs<<16 | uint64(srv.nodeTag)
+	srv.connected[newClient] = state
+	shutdownNotice := srv.shutdownNotice
 	srv.lock.Unlock()
+	if state.Guest != "" {
+		srv.publishPresence()
+	}
+
+	// Tell the client which room it was joined to
+	if r, exists := srv.rooms.Get(state.Room); exists {
+		state.Outbox.Enqueue(
+			[]byte(shared.SignalJoined),
+			newEncodedPayloads(r.Metadata()).For(shared.CodecJSON),
+		)
+	}
+
+	// Clients connecting while draining are notified as well
+	if shutdownNotice != nil {
+		state.Outbox.Enqueue(
+			[]byte(shared.SignalShutdown),
+			*shutdownNotice,
+		)
+	}
 }
 
 // OnClientDisconnected implements the webwire.ServerImplementation interface.
@@ -229,6 +961,10 @@ func (srv *ChatRoomServer) OnClientDisconnected(
 		reason,
 	)
 	srv.lock.Lock()
-	delete(srv.connected, client)
+	if state, exists := srv.connected[client]; exists {
+		state.Outbox.Close()
+		delete(srv.connected, client)
+	}
 	srv.lock.Unlock()
+	srv.publishPresence()
 }
@@ -0,0 +1,750 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// requireErrorCode fails the test unless the given error
+// is a request error with the given code
+func requireErrorCode(t *testing.T, err error, code string) {
+	t.Helper()
+	reqErr, isReqErr := err.(wwr.ErrRequest)
+	if !isReqErr {
+		t.Fatalf("expected request error %s, got: %v", code, err)
+	}
+	if reqErr.Code != code {
+		t.Fatalf("expected request error %s, got: %s", code, reqErr.Code)
+	}
+}
+
+// TestAuthentication tests successful and failed authentication
+func TestAuthentication(t *testing.T) {
+	hrn := newHarness(t)
+	defer hrn.teardown()
+	clt := hrn.connect(1)[0]
+
+	requireErrorCode(t, clt.auth("Gandalf", "wrong"), "AUTH_FAILED")
+	requireErrorCode(t, clt.auth("Nobody", "wrong"), "AUTH_FAILED")
+	_, err := clt.request(shared.RequestAuth, wwr.EncodingBinary, []byte("{"))
+	requireErrorCode(t, err, "DECODING_FAILURE")
+
+	session := clt.login("Gandalf")
+	if session.Key == "" {
+		t.Fatal("missing session key")
+	}
+	if name := session.Info.Value("username"); name != "Gandalf" {
+		t.Fatalf("unexpected session username: %v", name)
+	}
+	if name := clt.connection.SessionInfo("username"); name != "Gandalf" {
+		t.Fatalf("unexpected client session username: %v", name)
+	}
+}
+
+// TestHandshake tests the negotiation of the protocol version
+func TestHandshake(t *testing.T) {
+	hrn := newHarness(t)
+	defer hrn.teardown()
+	clt := hrn.connect(1)[0]
+
+	// Open a second connection without negotiating the protocol
+	clt.connection.Close()
+	clt.expectDisconnected()
+	if err := clt.connection.Connect(context.Background()); err != nil {
+		t.Fatalf("reconnecting failed: %s", err)
+	}
+	requireErrorCode(t, clt.post("Hello"), "HANDSHAKE_REQUIRED")
+
+	requireErrorCode(
+		t,
+		clt.handshake(shared.ProtocolVersion+1),
+		"INCOMPATIBLE_PROTOCOL",
+	)
+	requireErrorCode(t, clt.handshake(0), "INVALID_VERSION")
+	requireErrorCode(t, clt.post("Hello"), "HANDSHAKE_REQUIRED")
+
+	if err := clt.handshake(shared.ProtocolVersion); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if err := clt.post("Hello"); err != nil {
+		t.Fatalf("posting after the handshake failed: %s", err)
+	}
+}
+
+// TestValidation tests the field-level validation of requests
+func TestValidation(t *testing.T) {
+	hrn := newHarness(t)
+	defer hrn.teardown()
+	clt := hrn.connect(1)[0]
+
+	requireErrorCode(t, clt.auth("", "secret"), "INVALID_NAME")
+	requireErrorCode(t, clt.auth("Gandalf", ""), "INVALID_PASS")
+	clt.codec = "xml"
+	requireErrorCode(t, clt.auth("Gandalf", "secret"), "INVALID_CODEC")
+	clt.codec = ""
+	requireErrorCode(t, clt.post("  "), "INVALID_MSG")
+
+	_, err := clt.request(shared.RequestJoin, wwr.EncodingUtf8, nil)
+	requireErrorCode(t, err, "INVALID_ROOM")
+
+	clt.login("Gandalf")
+	encoded, err := json.Marshal(shared.SetTopicRequest{
+		Room:  hrn.conf.Rooms.Default,
+		Topic: strings.Repeat("x", shared.MaxTopicLength+1),
+	})
+	if err != nil {
+		t.Fatalf("couldn't marshal request: %s", err)
+	}
+	_, err = clt.request(shared.RequestSetTopic, wwr.EncodingUtf8, encoded)
+	requireErrorCode(t, err, "INVALID_TOPIC")
+}
+
+// TestRoomDefaults tests creating the default room
+// with the configured settings of new rooms
+func TestRoomDefaults(t *testing.T) {
+	hrn := newHarness(t, func(conf *config) {
+		conf.Rooms.Default = "prancing-pony"
+		conf.Rooms.Topic = "Beer and rumors"
+		conf.Rooms.Access = shared.RoomAnnouncement
+	})
+	defer hrn.teardown()
+	r, exists := hrn.chatRoom.rooms.Get("prancing-pony")
+	if !exists {
+		t.Fatal("default room wasn't created")
+	}
+	if r.Topic != "Beer and rumors" || r.Access != shared.RoomAnnouncement {
+		t.Fatalf("unexpected default room: %+v", r.Metadata())
+	}
+}
+
+// TestBroadcast tests the delivery of chat messages to all clients
+// of a room, including the echo to the sender's devices
+func TestBroadcast(t *testing.T) {
+	hrn := newHarness(t)
+	defer hrn.teardown()
+	clients := hrn.connect(3)
+	clients[0].login("Frodo")
+
+	if err := clients[0].post("Hello Middle-earth"); err != nil {
+		t.Fatalf("posting failed: %s", err)
+	}
+	for i, clt := range clients {
+		msg := clt.expectChat()
+		if msg.User != "Frodo" || msg.Msg != "Hello Middle-earth" ||
+			msg.Room != hrn.conf.Rooms.Default {
+			t.Fatalf("client %d received unexpected message: %+v", i, msg)
+		}
+		if msg.Own != (i == 0) {
+			t.Fatalf("client %d received unexpected own flag: %t", i, msg.Own)
+		}
+	}
+
+	// Anonymous clients post using their guest nicknames
+	if err := clients[1].post("Who's there?"); err != nil {
+		t.Fatalf("anonymous posting failed: %s", err)
+	}
+	guest := ""
+	for i, clt := range clients {
+		msg := clt.expectChat()
+		if !shared.IsGuestName(msg.User) || !msg.Guest ||
+			msg.Own != (i == 1) {
+			t.Fatalf("client %d received unexpected message: %+v", i, msg)
+		}
+		if guest != "" && msg.User != guest {
+			t.Fatalf("client %d received another guest name: %s", i, msg.User)
+		}
+		guest = msg.User
+	}
+}
+
+// TestCodecs tests clients using different codecs in the same room
+// and keeping the selected codec when restoring a session
+func TestCodecs(t *testing.T) {
+	hrn := newHarness(t)
+	defer hrn.teardown()
+	clients := hrn.connect(2)
+	clients[0].codec = shared.CodecMsgpack.Name()
+	session := clients[0].login("Aragorn")
+	clients[1].login("Samweis")
+
+	if err := clients[1].post("Po-tay-toes"); err != nil {
+		t.Fatalf("posting failed: %s", err)
+	}
+
+	// Each client receives the message encoded using its own codec
+	codecs := []shared.Codec{shared.CodecMsgpack, shared.CodecJSON}
+	for i, codec := range codecs {
+		sig := clients[i].expectSignal(shared.SignalChat)
+		if sig.encoding != codec.Encoding() {
+			t.Fatalf("client %d received %s payload", i, sig.encoding.String())
+		}
+		var msg shared.ChatMessage
+		if err := codec.Unmarshal(sig.payload, &msg); err != nil {
+			t.Fatalf("client %d couldn't parse message: %s", i, err)
+		}
+		if msg.User != "Samweis" || msg.Msg != "Po-tay-toes" ||
+			msg.Own != (i == 1) {
+			t.Fatalf("client %d received unexpected message: %+v", i, msg)
+		}
+	}
+
+	// Requests are decoded according to their payload encoding
+	// while replies are encoded using the selected codec
+	encoded, err := shared.CodecMsgpack.Marshal(shared.SetTopicRequest{
+		Room:  hrn.conf.Rooms.Default,
+		Topic: "Second breakfast",
+	})
+	if err != nil {
+		t.Fatalf("couldn't marshal request: %s", err)
+	}
+	reply, err := clients[0].request(
+		shared.RequestSetTopic,
+		wwr.EncodingBinary,
+		encoded,
+	)
+	if err != nil {
+		t.Fatalf("setting the topic failed: %s", err)
+	}
+	var metadata shared.RoomMetadata
+	if err := shared.CodecMsgpack.Unmarshal(reply, &metadata); err != nil {
+		t.Fatalf("couldn't parse room metadata: %s", err)
+	}
+	if metadata.Topic != "Second breakfast" {
+		t.Fatalf("unexpected room metadata: %+v", metadata)
+	}
+
+	for i, codec := range codecs {
+		sig := clients[i].expectSignal(shared.SignalRoom)
+		var update shared.RoomMetadata
+		if err := codec.Unmarshal(sig.payload, &update); err != nil {
+			t.Fatalf("client %d couldn't parse room update: %s", i, err)
+		}
+		if update.Topic != metadata.Topic {
+			t.Fatalf("client %d received unexpected update: %+v", i, update)
+		}
+	}
+
+	// The codec is stored in the session and applies to restored sessions
+	restored := hrn.connect(1)[0]
+	if err := restored.connection.RestoreSession(
+		context.Background(),
+		[]byte(session.Key),
+	); err != nil {
+		t.Fatalf("restoring the session failed: %s", err)
+	}
+	restored.expectSession()
+	if err := clients[1].post("Mashed"); err != nil {
+		t.Fatalf("posting failed: %s", err)
+	}
+	if sig := restored.expectSignal(shared.SignalChat); sig.encoding !=
+		shared.CodecMsgpack.Encoding() {
+		t.Fatalf("restored session received %s payload", sig.encoding.String())
+	}
+}
+
+// TestAnonymousPolicy tests the policies restricting anonymous clients
+// and the moderation of guests
+func TestAnonymousPolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy anonymousPolicy
+		code   string
+		guests int
+	}{
+		{anonymousDisallow, "NOT_AUTHENTICATED", 0},
+		{anonymousReadOnly, "READ_ONLY_GUEST", 1},
+	} {
+		t.Run(string(test.policy), func(t *testing.T) {
+			hrn := newHarness(t, func(conf *config) {
+				conf.Anonymous = string(test.policy)
+			})
+			defer hrn.teardown()
+			clients := hrn.connect(2)
+			requireErrorCode(t, clients[0].post("Let me in"), test.code)
+
+			// Guests appear in the presence and the guest list
+			clients[1].login("Galadriel")
+			reply, err := clients[1].request(
+				shared.RequestWho,
+				wwr.EncodingUtf8,
+				nil,
+			)
+			if err != nil {
+				t.Fatalf("listing users failed: %s", err)
+			}
+			var users []string
+			if err := json.Unmarshal(reply, &users); err != nil {
+				t.Fatalf("couldn't parse users: %s", err)
+			}
+			if len(users) != 1+test.guests {
+				t.Fatalf("unexpected online users: %v", users)
+			}
+
+			reply, err = clients[1].request(
+				shared.RequestGuests,
+				wwr.EncodingUtf8,
+				nil,
+			)
+			if err != nil {
+				t.Fatalf("listing guests failed: %s", err)
+			}
+			var guests []shared.Guest
+			if err := json.Unmarshal(reply, &guests); err != nil {
+				t.Fatalf("couldn't parse guests: %s", err)
+			}
+			if len(guests) != test.guests {
+				t.Fatalf("unexpected guests: %+v", guests)
+			}
+			if test.guests < 1 {
+				return
+			}
+
+			// Moderators can kick guests by their nickname
+			if _, err := clients[1].request(
+				shared.RequestKick,
+				wwr.EncodingUtf8,
+				[]byte(guests[0].Name),
+			); err != nil {
+				t.Fatalf("kicking the guest failed: %s", err)
+			}
+			clients[0].expectDisconnected()
+
+			// The address of the kicked guest is refused for a while
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = guests[0].RemoteAddr
+			recorder := httptest.NewRecorder()
+			options := hrn.chatRoom.beforeUpgrade(recorder, req)
+			if options.Connection != wwr.Refuse ||
+				recorder.Code != http.StatusForbidden {
+				t.Fatalf("connection of the kicked guest wasn't refused")
+			}
+		})
+	}
+}
+
+// TestBan tests banning an account, which disconnects its connections
+// and refuses both logins and session restorations until it's lifted
+func TestBan(t *testing.T) {
+	hrn := newHarness(t)
+	defer hrn.teardown()
+	clients := hrn.connect(4)
+	clients[0].login("Galadriel")
+	clients[1].login("Saruman")
+
+	// The session of a disconnected device remains restorable until banned
+	session := clients[2].login("Saruman")
+	clients[2].connection.Close()
+	clients[2].expectDisconnected()
+
+	encoded, err := json.Marshal(shared.BanRequest{
+		User:   "Saruman",
+		Reason: "betrayal",
+	})
+	if err != nil {
+		t.Fatalf("couldn't marshal ban request: %s", err)
+	}
+	if _, err := clients[0].request(
+		shared.RequestBan,
+		wwr.EncodingUtf8,
+		encoded,
+	); err != nil {
+		t.Fatalf("banning failed: %s", err)
+	}
+	clients[1].expectDisconnected()
+
+	// Neither logins nor session restorations are accepted
+	requireErrorCode(
+		t,
+		clients[3].auth("Saruman", userAccounts["Saruman"]),
+		"AUTH_BANNED",
+	)
+	if err := clients[3].connection.RestoreSession(
+		context.Background(),
+		[]byte(session.Key),
+	); err == nil {
+		t.Fatal("session of a banned user restored")
+	}
+
+	auditLog, err := ioutil.ReadFile(hrn.conf.AuditFile)
+	if err != nil {
+		t.Fatalf("couldn't read audit log: %s", err)
+	}
+	if !bytes.Contains(auditLog, []byte(`"event":"ban"`)) {
+		t.Fatal("ban not recorded in the audit log")
+	}
+
+	if _, err := clients[0].request(
+		shared.RequestUnban,
+		wwr.EncodingUtf8,
+		[]byte("Saruman"),
+	); err != nil {
+		t.Fatalf("lifting the ban failed: %s", err)
+	}
+	clients[3].login("Saruman")
+}
+
+// TestLogout tests closing the session
+func TestLogout(t *testing.T) {
+	hrn := newHarness(t)
+	defer hrn.teardown()
+	clt := hrn.connect(1)[0]
+	clt.login("Samweis")
+
+	if err := clt.connection.CloseSession(); err != nil {
+		t.Fatalf("closing the session failed: %s", err)
+	}
+	if clt.connection.Session() != nil {
+		t.Fatal("session still active after logout")
+	}
+
+	// Messages are posted as guest after logging out
+	if err := clt.post("Mr. Frodo?"); err != nil {
+		t.Fatalf("posting failed: %s", err)
+	}
+	if msg := clt.expectChat(); !msg.Guest || !msg.Own {
+		t.Fatalf("unexpected message after logout: %+v", msg)
+	}
+}
+
+// TestDeviceSignout tests closing the session of another device
+// on the server side
+func TestDeviceSignout(t *testing.T) {
+	hrn := newHarness(t)
+	defer hrn.teardown()
+	clients := hrn.connect(2)
+	clients[0].login("Frodo")
+	clients[1].login("Frodo")
+
+	reply, err := clients[0].request(
+		shared.RequestDevices,
+		wwr.EncodingUtf8,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("listing devices failed: %s", err)
+	}
+	var devices []shared.Device
+	if err := json.Unmarshal(reply, &devices); err != nil {
+		t.Fatalf("couldn't parse devices: %s", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("unexpected number of devices: %d", len(devices))
+	}
+
+	for _, device := range devices {
+		if device.Current {
+			continue
+		}
+		if _, err := clients[0].request(
+			shared.RequestSignoutDevice,
+			wwr.EncodingUtf8,
+			[]byte(strconv.FormatUint(device.ID, 10)),
+		); err != nil {
+			t.Fatalf("signing out device %d failed: %s", device.ID, err)
+		}
+	}
+	clients[1].expectSessionClosed()
+	if clients[0].connection.Session() == nil {
+		t.Fatal("session of the current device was closed")
+	}
+}
+
+// TestDeviceRevocation tests that signing out a device revokes its session
+// on all devices sharing it and that the session can't be restored anymore
+func TestDeviceRevocation(t *testing.T) {
+	hrn := newHarness(t)
+	defer hrn.teardown()
+	clients := hrn.connect(4)
+	session := clients[0].login("Meriadoc")
+	clients[2].login("Meriadoc")
+
+	// The second device shares the session of the first one
+	if err := clients[1].connection.RestoreSession(
+		context.Background(),
+		[]byte(session.Key),
+	); err != nil {
+		t.Fatalf("restoring the session failed: %s", err)
+	}
+	clients[1].expectSession()
+
+	reply, err := clients[1].request(
+		shared.RequestDevices,
+		wwr.EncodingUtf8,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("listing devices failed: %s", err)
+	}
+	var devices []shared.Device
+	if err := json.Unmarshal(reply, &devices); err != nil {
+		t.Fatalf("couldn't parse devices: %s", err)
+	}
+	var deviceID uint64
+	for _, device := range devices {
+		if device.Current {
+			deviceID = device.ID
+		}
+	}
+
+	if _, err := clients[2].request(
+		shared.RequestSignoutDevice,
+		wwr.EncodingUtf8,
+		[]byte(strconv.FormatUint(deviceID, 10)),
+	); err != nil {
+		t.Fatalf("signing out device %d failed: %s", deviceID, err)
+	}
+	clients[0].expectSessionClosed()
+	clients[1].expectSessionClosed()
+	if clients[2].connection.Session() == nil {
+		t.Fatal("session of the signing out device was closed")
+	}
+
+	if err := clients[3].connection.RestoreSession(
+		context.Background(),
+		[]byte(session.Key),
+	); err == nil {
+		t.Fatal("revoked session restored")
+	}
+}
+
+// TestSessionRestoration tests the restoration
+// of the session after reconnecting
+func TestSessionRestoration(t *testing.T) {
+	hrn := newHarness(t)
+	defer hrn.teardown()
+	clt := hrn.connect(1)[0]
+	session := clt.login("Legolas")
+
+	clt.connection.Close()
+	clt.expectDisconnected()
+	if err := clt.connection.Connect(context.Background()); err != nil {
+		t.Fatalf("reconnecting failed: %s", err)
+	}
+	restored := clt.expectSession()
+	if restored.Key != session.Key {
+		t.Fatalf("unexpected restored session: %s", restored.Key)
+	}
+	clt.expectSignal(shared.SignalJoined)
+	if err := clt.handshake(shared.ProtocolVersion); err != nil {
+		t.Fatalf("handshake after reconnecting failed: %s", err)
+	}
+
+	if err := clt.post("They're taking the hobbits to Isengard"); err != nil {
+		t.Fatalf("posting failed: %s", err)
+	}
+	if msg := clt.expectChat(); msg.User != "Legolas" {
+		t.Fatalf("unexpected sender after restoration: %s", msg.User)
+	}
+}
+
+// TestShutdown tests the shutdown notice, the rejection of messages
+// while draining and the disconnection of all clients
+func TestShutdown(t *testing.T) {
+	hrn := newHarness(t, func(conf *config) {
+		conf.Shutdown.DrainDeadline = duration{time.Second}
+	})
+	defer hrn.teardown()
+	clients := hrn.connect(2)
+	clients[0].login("Gimli")
+
+	// A client that doesn't read keeps the server draining
+	// until the announced shutdown time
+	stalled := newStalledConnection()
+	hrn.chatRoom.OnClientConnected(
+		wwr.ConnectionOptions{Info: map[int]interface{}{0: []byte("stalled")}},
+		stalled,
+	)
+	defer hrn.chatRoom.OnClientDisconnected(stalled, nil)
+	stalled.expectSignal(t, shared.SignalJoined)
+
+	go hrn.shutdown()
+
+	expectNotice := func(clt *testClient) {
+		var notice shared.ShutdownNotice
+		if err := shared.Decode(
+			clt.expectSignal(shared.SignalShutdown),
+			&notice,
+		); err != nil {
+			t.Fatalf("couldn't parse shutdown notice: %s", err)
+		}
+		if notice.Reason != "test" || notice.ShutdownAt.IsZero() {
+			t.Fatalf("received unexpected notice: %+v", notice)
+		}
+	}
+	for _, clt := range clients {
+		expectNotice(clt)
+	}
+
+	// Messages are rejected while draining
+	// and clients connecting meanwhile are notified as well
+	requireErrorCode(t, clients[0].post("Never!"), "SERVER_SHUTTING_DOWN")
+	clients = append(clients, hrn.connect(1)...)
+	expectNotice(clients[2])
+
+	for _, clt := range clients {
+		clt.expectDisconnected()
+	}
+}
+
+// TestShutdownDrained tests closing the connections
+// as soon as all queued signals were sent
+func TestShutdownDrained(t *testing.T) {
+	hrn := newHarness(t, func(conf *config) {
+		conf.Shutdown.DrainDeadline = duration{time.Minute}
+	})
+	defer hrn.teardown()
+	clients := hrn.connect(2)
+
+	start := time.Now()
+	hrn.shutdown()
+	if elapsed := time.Since(start); elapsed > testTimeout {
+		t.Fatalf("draining took %s", elapsed)
+	}
+	for _, clt := range clients {
+		clt.expectSignal(shared.SignalShutdown)
+		clt.expectDisconnected()
+	}
+}
+
+// TestArchive tests exporting a room and importing it into another server,
+// including the rejection of tampered archives and repeated imports
+func TestArchive(t *testing.T) {
+	hrn := newHarness(t)
+	defer hrn.teardown()
+	clients := hrn.connect(2)
+	clients[0].login("Elrond")
+	for _, msg := range []string{"Strangers from distant lands", "Friends of old"} {
+		if err := clients[0].post(msg); err != nil {
+			t.Fatalf("posting failed: %s", err)
+		}
+		clients[1].expectChat()
+	}
+	if _, err := hrn.chatRoom.rooms.Apply(&roomOp{
+		Room: hrn.conf.Rooms.Default,
+		Kind: roomOpInvite,
+		User: "Frodo",
+	}); err != nil {
+		t.Fatalf("inviting failed: %s", err)
+	}
+
+	// The subcommands refuse to open the stores of a running server
+	if _, _, err := openStores(hrn.conf); err == nil {
+		t.Fatal("opened the history of a running server")
+	}
+	hrn.shutdown()
+
+	var archive bytes.Buffer
+	exported, err := exportRoom(
+		hrn.chatRoom.rooms,
+		hrn.chatRoom.history,
+		hrn.conf.Rooms.Default,
+		&archive,
+	)
+	if err != nil {
+		t.Fatalf("export failed: %s", err)
+	}
+	if exported != 2 {
+		t.Fatalf("unexpected number of exported messages: %d", exported)
+	}
+
+	dir, err := ioutil.TempDir("", "chatroom-import")
+	if err != nil {
+		t.Fatalf("couldn't create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	roomsConf := hrn.conf.Rooms
+	roomsConf.File = filepath.Join(dir, "rooms.json")
+	rooms, err := newRoomStore(roomsConf)
+	if err != nil {
+		t.Fatalf("couldn't create room store: %s", err)
+	}
+	history, err := newHistoryStore(filepath.Join(dir, "history"))
+	if err != nil {
+		t.Fatalf("couldn't create history store: %s", err)
+	}
+	defer history.Close()
+
+	// Tampered archives are rejected without importing anything
+	tampered := bytes.Replace(archive.Bytes(), []byte("old"), []byte("new"), 1)
+	if _, _, err := importRoom(
+		rooms,
+		history,
+		bytes.NewReader(tampered),
+	); err == nil {
+		t.Fatal("tampered archive was imported")
+	}
+	truncated := archive.Bytes()[:bytes.LastIndexByte(
+		archive.Bytes()[:archive.Len()-1],
+		'\n',
+	)+1]
+	if _, _, err := importRoom(
+		rooms,
+		history,
+		bytes.NewReader(truncated),
+	); err == nil {
+		t.Fatal("truncated archive was imported")
+	}
+
+	// Importing the same archive twice imports every message once
+	for i, expected := range []int{2, 0} {
+		room, imported, err := importRoom(
+			rooms,
+			history,
+			bytes.NewReader(archive.Bytes()),
+		)
+		if err != nil {
+			t.Fatalf("import %d failed: %s", i, err)
+		}
+		if room != hrn.conf.Rooms.Default || imported != expected {
+			t.Fatalf("import %d imported %d messages into %s", i, imported, room)
+		}
+	}
+	messages, err := history.Messages(hrn.conf.Rooms.Default)
+	if err != nil {
+		t.Fatalf("couldn't read history: %s", err)
+	}
+	if len(messages) != 2 || messages[1].Msg != "Friends of old" ||
+		messages[1].User != "Elrond" {
+		t.Fatalf("unexpected history: %+v", messages)
+	}
+	if r, _ := rooms.Get(hrn.conf.Rooms.Default); !r.isInvited("Frodo") {
+		t.Fatalf("invited members weren't imported: %+v", r)
+	}
+}
+
+// TestSetupFailure tests closing the resources opened
+// before the server setup failed
+func TestSetupFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chatroom-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := testConfig(dir)
+	if err := ioutil.WriteFile(conf.BansFile, []byte("{"), 0600); err != nil {
+		t.Fatalf("couldn't write ban file: %s", err)
+	}
+	if _, _, _, err := setupServer(conf); err == nil {
+		t.Fatal("set up the server with a malformed ban file")
+	}
+
+	// The history directory must have been unlocked
+	history, err := newHistoryStore(conf.HistoryDir)
+	if err != nil {
+		t.Fatalf("history still in use: %s", err)
+	}
+	history.Close()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// keyDirectory keeps the public keys of all users
+// and persists them to a JSON file on every change.
+// The private keys never leave the clients
+type keyDirectory struct {
+	filePath string
+	keys     map[string][]byte
+	lock     sync.RWMutex
+}
+
+// newKeyDirectory loads the public keys from the given file
+// if it exists
+func newKeyDirectory(filePath string) (*keyDirectory, error) {
+	dir := &keyDirectory{
+		filePath: filePath,
+		keys:     make(map[string][]byte),
+	}
+
+	contents, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return dir, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Couldn't read key file: %s", err)
+	}
+	if err := json.Unmarshal(contents, &dir.keys); err != nil {
+		return nil, fmt.Errorf("Couldn't parse key file: %s", err)
+	}
+	return dir, nil
+}
+
+// Get returns the public key of the given user
+func (dir *keyDirectory) Get(username string) ([]byte, bool) {
+	dir.lock.RLock()
+	defer dir.lock.RUnlock()
+	key, exists := dir.keys[username]
+	return key, exists
+}
+
+// Put sets the public key of the given user.
+// Returns true if the key changed
+func (dir *keyDirectory) Put(username string, key []byte) (bool, error) {
+	dir.lock.Lock()
+	defer dir.lock.Unlock()
+
+	previous, exists := dir.keys[username]
+	if exists && bytes.Equal(previous, key) {
+		return false, nil
+	}
+	dir.keys[username] = key
+
+	encoded, err := json.MarshalIndent(dir.keys, "", "\t")
+	if err != nil {
+		return false, fmt.Errorf("Couldn't marshal keys: %s", err)
+	}
+	if err := writeFileAtomic(dir.filePath, encoded); err != nil {
+		return false, fmt.Errorf("Couldn't save keys: %s", err)
+	}
+	return true, nil
+}
+
+// handlePublishKey sets the public key of the authenticated user
+func (srv *ChatRoomServer) handlePublishKey(
+	_ context.Context,
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	if err := verifyAuthenticated(client); err != nil {
+		return wwr.Payload{}, err
+	}
+	key := message.Payload()
+	if message.PayloadEncoding() != wwr.EncodingBinary ||
+		len(key) != shared.PublicKeySize {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code: "INVALID_KEY",
+			Message: fmt.Sprintf(
+				"Public key must be %d binary encoded bytes",
+				shared.PublicKeySize,
+			),
+		}
+	}
+	key = append([]byte(nil), key...)
+	username := client.SessionInfo("username").(string)
+
+	changed, err := srv.keys.Put(username, key)
+	if err != nil {
+		return wwr.Payload{}, err
+	}
+	if changed {
+		log.Printf("Public key of %s changed", username)
+		srv.publish(eventKey, keyEvent{
+			User: username,
+			Key:  key,
+		})
+	}
+	return wwr.Payload{}, nil
+}
+
+// handlePublicKey replies with the public key of the requested user
+func (srv *ChatRoomServer) handlePublicKey(
+	_ context.Context,
+	_ wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	username, err := parseName(message, "user")
+	if err != nil {
+		return wwr.Payload{}, err
+	}
+	key, exists := srv.keys.Get(username)
+	if !exists {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "KEY_NOT_FOUND",
+			Message: fmt.Sprintf("User '%s' didn't publish a key", username),
+		}
+	}
+	return wwr.Payload{
+		Encoding: wwr.EncodingBinary,
+		Data:     key,
+	}, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// lockoutPolicy defines when and for how long
+// accounts and remote addresses are locked out
+type lockoutPolicy struct {
+	// Threshold defines the number of consecutive failed authentication
+	// attempts after which the account or address is locked out
+	Threshold uint
+
+	// BaseDuration defines the duration of the first lockout.
+	// The duration doubles with every further failed attempt
+	BaseDuration time.Duration
+
+	// MaxDuration caps the lockout duration. Failed attempts are forgotten
+	// once no further attempt failed for this duration
+	MaxDuration time.Duration
+
+	// MaxEntries limits the number of both accounts and addresses
+	// tracked at a time. The least recently failed ones are forgotten first
+	MaxEntries uint
+}
+
+// duration returns the lockout duration for the given number of failures
+func (policy lockoutPolicy) duration(failures uint) time.Duration {
+	if failures < policy.Threshold {
+		return 0
+	}
+	duration := policy.BaseDuration
+	for i := policy.Threshold; i < failures; i++ {
+		duration *= 2
+		if duration >= policy.MaxDuration {
+			return policy.MaxDuration
+		}
+	}
+	return duration
+}
+
+// lockoutState keeps track of the failed attempts
+// of a single account or address
+type lockoutState struct {
+	key         string
+	failures    uint
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// lockoutTable keeps the lockout states of either accounts or addresses
+// ordered by the time of their last failure, the least recent first.
+// The order allows forgetting expired states and evicting the least recent
+// state once the table is full without scanning the entire table
+type lockoutTable struct {
+	states map[string]*list.Element
+	order  *list.List
+	limit  uint
+}
+
+// newLockoutTable constructs a new lockout table
+// keeping at most the given number of states
+func newLockoutTable(limit uint) *lockoutTable {
+	return &lockoutTable{
+		states: make(map[string]*list.Element),
+		order:  list.New(),
+		limit:  limit,
+	}
+}
+
+// get returns the state of the given key or nil if there's none
+func (tbl *lockoutTable) get(key string) *lockoutState {
+	if element, exists := tbl.states[key]; exists {
+		return element.Value.(*lockoutState)
+	}
+	return nil
+}
+
+// fail increments the failure counter of the given key
+// and locks it out if the threshold of the given policy is reached.
+// The least recently failed key is evicted if the table is full
+func (tbl *lockoutTable) fail(
+	key string,
+	now time.Time,
+	policy lockoutPolicy,
+) {
+	element, exists := tbl.states[key]
+	if exists {
+		tbl.order.MoveToBack(element)
+	} else {
+		if uint(len(tbl.states)) >= tbl.limit {
+			tbl.remove(tbl.order.Front())
+		}
+		element = tbl.order.PushBack(&lockoutState{key: key})
+		tbl.states[key] = element
+	}
+	state := element.Value.(*lockoutState)
+	state.failures++
+	state.lastFailure = now
+	if duration := policy.duration(state.failures); duration > 0 {
+		state.lockedUntil = now.Add(duration)
+	}
+}
+
+// remove removes the state of the given list element
+func (tbl *lockoutTable) remove(element *list.Element) {
+	tbl.order.Remove(element)
+	delete(tbl.states, element.Value.(*lockoutState).key)
+}
+
+// delete forgets about the given key. Returns false if it's unknown
+func (tbl *lockoutTable) delete(key string) bool {
+	element, exists := tbl.states[key]
+	if exists {
+		tbl.remove(element)
+	}
+	return exists
+}
+
+// prune forgets about all keys that didn't fail
+// for at least the given maximum lockout duration.
+// Lockouts never last longer than the maximum duration,
+// thus none of the forgotten keys is still locked
+func (tbl *lockoutTable) prune(now time.Time, maxDuration time.Duration) {
+	for element := tbl.order.Front(); element != nil; {
+		state := element.Value.(*lockoutState)
+		if now.Sub(state.lastFailure) <= maxDuration {
+			return
+		}
+		next := element.Next()
+		tbl.remove(element)
+		element = next
+	}
+}
+
+// lockoutTracker tracks failed authentication attempts
+// both per account and per remote address
+type lockoutTracker struct {
+	policy    lockoutPolicy
+	accounts  *lockoutTable
+	addresses *lockoutTable
+	lock      sync.Mutex
+}
+
+// newLockoutTracker constructs a new lockout tracker
+func newLockoutTracker(policy lockoutPolicy) *lockoutTracker {
+	return &lockoutTracker{
+		policy:    policy,
+		accounts:  newLockoutTable(policy.MaxEntries),
+		addresses: newLockoutTable(policy.MaxEntries),
+	}
+}
+
+// Check returns the time until which either the given account
+// or the given address is locked out. Returns false if neither is locked
+func (trk *lockoutTracker) Check(
+	account string,
+	address string,
+) (lockedUntil time.Time, locked bool) {
+	trk.lock.Lock()
+	defer trk.lock.Unlock()
+
+	now := time.Now()
+	for _, state := range []*lockoutState{
+		trk.accounts.get(account),
+		trk.addresses.get(address),
+	} {
+		if state != nil && state.lockedUntil.After(lockedUntil) {
+			lockedUntil = state.lockedUntil
+		}
+	}
+	return lockedUntil, lockedUntil.After(now)
+}
+
+// Failure records a failed authentication attempt
+func (trk *lockoutTracker) Failure(account string, address string) {
+	trk.lock.Lock()
+	defer trk.lock.Unlock()
+
+	now := time.Now()
+	for _, tbl := range []*lockoutTable{trk.accounts, trk.addresses} {
+		tbl.prune(now, trk.policy.MaxDuration)
+	}
+	trk.accounts.fail(account, now, trk.policy)
+	trk.addresses.fail(address, now, trk.policy)
+}
+
+// Success resets the failed attempts of the given account.
+// The failed attempts of the address are kept until they expire
+// for an attacker to not be able to reset them by signing in
+// to an account of their own in between
+func (trk *lockoutTracker) Success(account string) {
+	trk.lock.Lock()
+	trk.accounts.delete(account)
+	trk.lock.Unlock()
+}
+
+// List returns all currently locked out accounts and addresses
+func (trk *lockoutTracker) List() []shared.Lockout {
+	trk.lock.Lock()
+	defer trk.lock.Unlock()
+
+	now := time.Now()
+	lockouts := []shared.Lockout{}
+	for kind, tbl := range map[string]*lockoutTable{
+		"account": trk.accounts,
+		"address": trk.addresses,
+	} {
+		for element := tbl.order.Front(); element != nil; element = element.Next() {
+			state := element.Value.(*lockoutState)
+			if !state.lockedUntil.After(now) {
+				continue
+			}
+			lockouts = append(lockouts, shared.Lockout{
+				Kind:        kind,
+				Key:         state.key,
+				Failures:    state.failures,
+				LockedUntil: state.lockedUntil,
+			})
+		}
+	}
+
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].LockedUntil.Before(lockouts[j].LockedUntil)
+	})
+
+	return lockouts
+}
+
+// Clear resets the account or address identified by the given key.
+// Returns false if there was nothing to clear
+func (trk *lockoutTracker) Clear(key string) bool {
+	trk.lock.Lock()
+	defer trk.lock.Unlock()
+
+	isAccount := trk.accounts.delete(key)
+	isAddress := trk.addresses.delete(key)
+	return isAccount || isAddress
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testLockoutPolicy locks out after three failures for one second,
+// doubling up to ten seconds
+var testLockoutPolicy = lockoutPolicy{
+	Threshold:    3,
+	BaseDuration: time.Second,
+	MaxDuration:  10 * time.Second,
+	MaxEntries:   2,
+}
+
+// TestLockoutDuration tests the threshold, the exponential growth
+// and the cap of the lockout duration
+func TestLockoutDuration(t *testing.T) {
+	for failures, expected := range map[uint]time.Duration{
+		0:  0,
+		2:  0,
+		3:  time.Second,
+		4:  2 * time.Second,
+		6:  8 * time.Second,
+		7:  10 * time.Second,
+		64: 10 * time.Second,
+	} {
+		if duration := testLockoutPolicy.duration(failures); duration != expected {
+			t.Fatalf(
+				"%d failures lock out for %s, expected %s",
+				failures,
+				duration,
+				expected,
+			)
+		}
+	}
+}
+
+// TestLockoutTracker tests locking out accounts and addresses
+// and resetting only the account on success
+func TestLockoutTracker(t *testing.T) {
+	trk := newLockoutTracker(testLockoutPolicy)
+	for i := 0; i < 2; i++ {
+		trk.Failure("Sauron", "10.0.0.1")
+	}
+	if _, locked := trk.Check("Sauron", "10.0.0.1"); locked {
+		t.Fatal("locked out below the threshold")
+	}
+
+	trk.Failure("Sauron", "10.0.0.1")
+	lockedUntil, locked := trk.Check("Sauron", "10.0.0.2")
+	if !locked {
+		t.Fatal("account not locked out at the threshold")
+	}
+	if remaining := time.Until(lockedUntil); remaining > time.Second {
+		t.Fatalf("account locked out for %s", remaining)
+	}
+	if _, locked := trk.Check("Saruman", "10.0.0.1"); !locked {
+		t.Fatal("address not locked out at the threshold")
+	}
+	if lockouts := trk.List(); len(lockouts) != 2 {
+		t.Fatalf("unexpected lockouts: %+v", lockouts)
+	}
+
+	// Signing in doesn't reset the address
+	trk.Success("Sauron")
+	if _, locked := trk.Check("Sauron", "10.0.0.2"); locked {
+		t.Fatal("account still locked out after success")
+	}
+	if _, locked := trk.Check("Saruman", "10.0.0.1"); !locked {
+		t.Fatal("address reset by success")
+	}
+
+	if !trk.Clear("10.0.0.1") {
+		t.Fatal("address not cleared")
+	}
+	if trk.Clear("10.0.0.1") {
+		t.Fatal("address cleared twice")
+	}
+	if lockouts := trk.List(); len(lockouts) != 0 {
+		t.Fatalf("unexpected lockouts after clearing: %+v", lockouts)
+	}
+}
+
+// TestLockoutTableBounds tests evicting the least recently failed key
+// from a full table and forgetting keys that didn't fail for long
+func TestLockoutTableBounds(t *testing.T) {
+	tbl := newLockoutTable(testLockoutPolicy.MaxEntries)
+	now := time.Now()
+	tbl.fail("a", now, testLockoutPolicy)
+	tbl.fail("b", now.Add(time.Second), testLockoutPolicy)
+	tbl.fail("a", now.Add(2*time.Second), testLockoutPolicy)
+	tbl.fail("c", now.Add(3*time.Second), testLockoutPolicy)
+
+	if tbl.get("b") != nil {
+		t.Fatal("least recently failed key not evicted")
+	}
+	if state := tbl.get("a"); state == nil || state.failures != 2 {
+		t.Fatalf("unexpected state of a: %+v", state)
+	}
+
+	tbl.prune(now.Add(12*time.Second+time.Millisecond), testLockoutPolicy.MaxDuration)
+	if tbl.get("a") != nil || tbl.get("c") == nil {
+		t.Fatal("unexpected keys pruned")
+	}
+	tbl.prune(now.Add(14*time.Second), testLockoutPolicy.MaxDuration)
+	if len(tbl.states) != 0 || tbl.order.Len() != 0 {
+		t.Fatal("expired keys not pruned")
+	}
+}
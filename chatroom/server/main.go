@@ -8,33 +8,234 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/gorilla/websocket"
 	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/audit"
+	"github.com/qbeon/webwire-go-examples/chatroom/cluster"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
 	wwrgorilla "github.com/qbeon/webwire-go-gorilla"
 )
 
-var argServerAddr = flag.String("addr", ":9090", "server address")
-var argCertFilePath = flag.String(
-	"sslcert",
-	"./server.crt",
-	"path to the SSL certificate file",
-)
-var argPrivateKeyFile = flag.String(
-	"sslkey",
-	"./server.key",
-	"path to the SSL private-key file",
+var argConfigFilePath = flag.String(
+	"config",
+	"./config.json",
+	"path to the server configuration file, defaults are used if missing",
 )
 
-func main() {
-	// Parse command line arguments
-	flag.Parse()
+// checkOrigin returns an origin check function
+// accepting only the given origins. "*" allows any origin.
+// Requests without an Origin header (non-browser clients) are always accepted
+func checkOrigin(allowedOrigins []string) func(*http.Request) bool {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		allowed[origin] = true
+	}
+	return func(req *http.Request) bool {
+		origin := req.Header.Get("Origin")
+		return origin == "" || allowed["*"] || allowed[origin]
+	}
+}
+
+// setupFilters loads the message filters and reloads them whenever the
+// process receives a SIGHUP. The previous filters remain active if
+// the reloaded configuration is invalid
+func setupFilters(filePath string) (*filterChain, error) {
+	chain := newFilterChain()
+
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		log.Printf("No filter file found at %s, filtering disabled", filePath)
+	} else {
+		filters, err := loadFilters(filePath)
+		if err != nil {
+			return nil, err
+		}
+		chain.Replace(filters)
+		log.Printf("Loaded %d message filters", len(filters))
+	}
+
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	go func() {
+		for range reloadSignals {
+			filters, err := loadFilters(filePath)
+			if err != nil {
+				log.Printf("Failed reloading message filters: %s", err)
+				continue
+			}
+			chain.Replace(filters)
+			log.Printf("Reloaded %d message filters", len(filters))
+		}
+	}()
+
+	return chain, nil
+}
+
+// setupCluster connects the server to the cluster bus. Returns the shared
+// session store or nil if the server runs standalone
+func setupCluster(
+	listenAddr string,
+	hubAddr string,
+) (cluster.Bus, cluster.SessionStore, error) {
+	// Host the hub in this process
+	var hub *cluster.TCPHub
+	if listenAddr != "" {
+		var err error
+		hub, err = cluster.ListenTCPHub(listenAddr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Couldn't host cluster hub: %s", err)
+		}
+		go func() {
+			if err := hub.Serve(); err != nil {
+				log.Printf("Cluster hub stopped: %s", err)
+			}
+		}()
+		hubAddr = hub.Addr().String()
+		log.Printf("Hosting cluster hub on %s", hubAddr)
+	}
+
+	// Run standalone without any other nodes
+	if hubAddr == "" {
+		return cluster.NewLocalHub().Join(), nil, nil
+	}
+
+	node, err := cluster.DialTCP(hubAddr)
+	if err != nil {
+		if hub != nil {
+			hub.Close()
+		}
+		return nil, nil, fmt.Errorf("Couldn't join cluster hub: %s", err)
+	}
+	log.Printf("Joined cluster hub %s as node %s", hubAddr, node.NodeID())
+	return node, node, nil
+}
+
+// setupServer sets up the chatroom server according to the given
+// configuration. The returned cleanup function closes the resources
+// used by the server and must be called after the server is shut down.
+// The resources opened so far are closed if the setup fails
+func setupServer(
+	conf config,
+) (wwr.Server, *ChatRoomServer, func(), error) {
+	// closers close the opened resources in reverse order
+	var closers []func()
+	cleanup := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+	fail := func(err error) (wwr.Server, *ChatRoomServer, func(), error) {
+		cleanup()
+		return nil, nil, nil, err
+	}
+
+	// Load the room metadata
+	rooms, err := newRoomStore(conf.Rooms)
+	if err != nil {
+		return fail(fmt.Errorf("Failed loading rooms: %s", err))
+	}
+
+	// Open the message history
+	history, err := newHistoryStore(conf.HistoryDir)
+	if err != nil {
+		return fail(fmt.Errorf("Failed opening history: %s", err))
+	}
+	closers = append(closers, func() {
+		if err := history.Close(); err != nil {
+			log.Printf("ERROR: couldn't close history: %s", err)
+		}
+	})
+
+	// Load the public key directory
+	keys, err := newKeyDirectory(conf.KeysFile)
+	if err != nil {
+		return fail(fmt.Errorf("Failed loading public keys: %s", err))
+	}
+
+	// Load the banned accounts
+	bans, err := newBanList(conf.BansFile)
+	if err != nil {
+		return fail(fmt.Errorf("Failed loading bans: %s", err))
+	}
+
+	lockouts := newLockoutTracker(lockoutPolicy{
+		Threshold:    conf.Lockout.Threshold,
+		BaseDuration: conf.Lockout.BaseDuration.Duration,
+		MaxDuration:  conf.Lockout.MaxDuration.Duration,
+		MaxEntries:   conf.Lockout.MaxEntries,
+	})
+
+	// The policies were verified by the configuration validation
+	outboxPolicy, _ := parseOverflowPolicy(conf.Outbox.Policy)
+	anonymous, _ := parseAnonymousPolicy(conf.Anonymous)
+
+	// Load the message filters
+	filters, err := setupFilters(conf.FiltersFile)
+	if err != nil {
+		return fail(fmt.Errorf("Failed loading message filters: %s", err))
+	}
+
+	// Open the audit log
+	auditLog, err := audit.Open(conf.AuditFile)
+	if err != nil {
+		return fail(fmt.Errorf("Failed opening audit log: %s", err))
+	}
+	closers = append(closers, func() { auditLog.Close() })
+
+	// Join the cluster
+	bus, sessionStore, err := setupCluster(conf.Cluster.Listen, conf.Cluster.Hub)
+	if err != nil {
+		return fail(err)
+	}
+	closers = append(closers, func() { bus.Close() })
+
+	// Keep the sessions in the shared store when running in a cluster
+	// for them to be restorable on any node
+	if sessionStore == nil {
+		fileStore, err := newFileSessionStore(conf.SessionDir)
+		if err != nil {
+			return fail(err)
+		}
+		sessionStore = fileStore
+	}
+	sessions := newStoredSessionManager(sessionStore)
+
+	chatRoom := NewChatRoomServer(
+		rooms,
+		history,
+		filters,
+		auditLog,
+		lockouts,
+		bans,
+		sessions,
+		outboxConfig{
+			Size:   conf.Outbox.Size,
+			Policy: outboxPolicy,
+		},
+		rateLimitPolicy{
+			Messages: conf.RateLimit.Messages,
+			Interval: conf.RateLimit.Interval.Duration,
+		},
+		anonymous,
+		conf.GuestBlock.Duration,
+		keys,
+		bus,
+		conf.Cluster.PresenceInterval.Duration,
+	)
 
 	// Setup a new webwire server instance
 	server, err := wwr.NewServer(
-		NewChatRoomServer(),
+		chatRoom,
 		wwr.ServerOptions{
+			SessionManager: newAuditedSessionManager(sessions, auditLog, bans),
+
+			// Session info parser function must override the default one
+			// for the session info object to be typed as shared.SessionInfo
+			// after a session restoration
+			SessionInfoParser: shared.SessionInfoParser,
+
+			MaxSessionConnections: conf.MaxSessionConnections,
+
 			WarnLog: log.New(
 				os.Stdout,
 				"WARN: ",
@@ -45,26 +246,62 @@ func main() {
 				"ERR: ",
 				log.Ldate|log.Ltime|log.Lshortfile,
 			),
-			ReadTimeout:     3 * time.Second,
-			SubProtocolName: []byte("chatroom-example-protocol"),
+			ReadTimeout:     conf.ReadTimeout.Duration,
+			SubProtocolName: []byte(conf.SubProtocol),
 		},
 		&wwrgorilla.Transport{
-			Host: *argServerAddr,
+			Host: conf.Addr,
 			TLS: &wwrgorilla.TLS{
-				CertFilePath:       *argCertFilePath,
-				PrivateKeyFilePath: *argPrivateKeyFile,
+				CertFilePath:       conf.TLS.CertFile,
+				PrivateKeyFilePath: conf.TLS.KeyFile,
 				Config:             nil,
 			},
 			Upgrader: &websocket.Upgrader{
-				CheckOrigin: func(req *http.Request) bool {
-					return true
-				},
+				CheckOrigin: checkOrigin(conf.AllowedOrigins),
 			},
+			BeforeUpgrade: chatRoom.beforeUpgrade,
 		},
 	)
 	if err != nil {
-		panic(fmt.Errorf("Failed setting up WebWire server: %s", err))
+		return fail(fmt.Errorf("Failed setting up WebWire server: %s", err))
+	}
+
+	return server, chatRoom, cleanup, nil
+}
+
+func main() {
+	// Run the archive subcommands instead of the server if requested
+	if len(os.Args) > 1 {
+		var run func([]string) error
+		switch os.Args[1] {
+		case "export":
+			run = runExport
+		case "import":
+			run = runImport
+		}
+		if run != nil {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	// Parse command line arguments
+	flag.Parse()
+
+	// Load the configuration
+	conf, err := loadConfig(*argConfigFilePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server, chatRoom, cleanup, err := setupServer(conf)
+	if err != nil {
+		panic(err)
 	}
+	defer cleanup()
+	go chatRoom.RunPresence()
 
 	// Listen for OS signals and shutdown server in case of demanded termination
 	osSignals := make(chan os.Signal, 1)
@@ -72,6 +309,11 @@ func main() {
 	go func() {
 		sig := <-osSignals
 		log.Printf("Termination demanded by the OS (%s), shutting down...", sig)
+		chatRoom.Drain(
+			"maintenance",
+			conf.Shutdown.DrainDeadline.Duration,
+			conf.Shutdown.Downtime.Duration,
+		)
 		if err := server.Shutdown(); err != nil {
 			log.Printf("Error during server shutdown: %s", err)
 		}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// overflowPolicy defines what happens when a signal is to be sent to a client
+// whose outbound queue is full
+type overflowPolicy string
+
+const (
+	// dropOldest drops the oldest queued signal to make room for the new one
+	dropOldest overflowPolicy = "drop-oldest"
+
+	// dropNewest drops the new signal
+	dropNewest overflowPolicy = "drop-newest"
+
+	// disconnect closes the connection of the slow client
+	disconnect overflowPolicy = "disconnect"
+)
+
+// parseOverflowPolicy parses the name of an overflow policy
+func parseOverflowPolicy(name string) (overflowPolicy, error) {
+	switch policy := overflowPolicy(name); policy {
+	case dropOldest, dropNewest, disconnect:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy: '%s'", name)
+}
+
+// outboxConfig defines the outbound queue settings of all clients
+type outboxConfig struct {
+	// Size defines the maximum number of signals queued per client
+	Size int
+
+	// Policy defines how a full queue is handled
+	Policy overflowPolicy
+}
+
+// outboxMetrics counts the signals that couldn't be delivered
+// either due to full outbound queues or due to transmission failures
+type outboxMetrics struct {
+	droppedOldest uint64
+	droppedNewest uint64
+	disconnected  uint64
+	failed        uint64
+}
+
+// Snapshot returns the current values of all counters
+func (metrics *outboxMetrics) Snapshot() shared.OutboxMetrics {
+	return shared.OutboxMetrics{
+		DroppedOldest: atomic.LoadUint64(&metrics.droppedOldest),
+		DroppedNewest: atomic.LoadUint64(&metrics.droppedNewest),
+		Disconnected:  atomic.LoadUint64(&metrics.disconnected),
+		Failed:        atomic.LoadUint64(&metrics.failed),
+	}
+}
+
+// outboundSignal represents a signal waiting to be sent to a client
+type outboundSignal struct {
+	name    []byte
+	payload wwr.Payload
+}
+
+// outbox queues the signals to be sent to a single client and sends them
+// from a separate goroutine so that a slow client doesn't block the sender
+type outbox struct {
+	client    wwr.Connection
+	config    outboxConfig
+	metrics   *outboxMetrics
+	queue     chan outboundSignal
+	pending   int64
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// disconnectOnce makes sure a slow client is disconnected only once
+	disconnectOnce sync.Once
+}
+
+// newOutbox constructs a new outbox and starts sending queued signals
+func newOutbox(
+	client wwr.Connection,
+	config outboxConfig,
+	metrics *outboxMetrics,
+) *outbox {
+	box := &outbox{
+		client:  client,
+		config:  config,
+		metrics: metrics,
+		queue:   make(chan outboundSignal, config.Size),
+		closed:  make(chan struct{}),
+	}
+	go box.run()
+	return box
+}
+
+// run sends queued signals until the outbox is closed
+func (box *outbox) run() {
+	for {
+		select {
+		case <-box.closed:
+			return
+		case sig := <-box.queue:
+			if err := box.client.Signal(sig.name, sig.payload); err != nil {
+				atomic.AddUint64(&box.metrics.failed, 1)
+				log.Printf(
+					"WARNING: failed sending signal to client %s : %s",
+					box.client.RemoteAddr(),
+					err,
+				)
+			}
+			atomic.AddInt64(&box.pending, -1)
+		}
+	}
+}
+
+// Enqueue queues a named signal without ever blocking.
+// The overflow policy is applied if the queue is full.
+// The payload data must not be modified after it was enqueued
+func (box *outbox) Enqueue(name []byte, payload wwr.Payload) {
+	box.enqueue(outboundSignal{name, payload})
+}
+
+// enqueue queues the given signal applying the overflow policy
+func (box *outbox) enqueue(sig outboundSignal) {
+	atomic.AddInt64(&box.pending, 1)
+	select {
+	case box.queue <- sig:
+		return
+	default:
+	}
+
+	switch box.config.Policy {
+	case dropOldest:
+		select {
+		case <-box.queue:
+			atomic.AddInt64(&box.pending, -1)
+			atomic.AddUint64(&box.metrics.droppedOldest, 1)
+		default:
+		}
+		select {
+		case box.queue <- sig:
+		default:
+			// Another sender refilled the queue in the meantime
+			atomic.AddInt64(&box.pending, -1)
+			atomic.AddUint64(&box.metrics.droppedNewest, 1)
+		}
+	case dropNewest:
+		atomic.AddInt64(&box.pending, -1)
+		atomic.AddUint64(&box.metrics.droppedNewest, 1)
+	case disconnect:
+		atomic.AddInt64(&box.pending, -1)
+		box.disconnectOnce.Do(func() {
+			atomic.AddUint64(&box.metrics.disconnected, 1)
+			log.Printf(
+				"WARNING: disconnecting slow client %s, outbound queue full",
+				box.client.RemoteAddr(),
+			)
+			go box.client.Close()
+		})
+	}
+}
+
+// Pending returns the number of signals that are either queued
+// or currently being sent
+func (box *outbox) Pending() int {
+	return int(atomic.LoadInt64(&box.pending))
+}
+
+// Close stops sending queued signals
+func (box *outbox) Close() {
+	box.closeOnce.Do(func() {
+		close(box.closed)
+	})
+}
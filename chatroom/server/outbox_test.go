@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// stalledConnection is the connection of a client that doesn't read.
+// Sending a signal blocks until it's released or the connection is closed
+type stalledConnection struct {
+	signals   chan string
+	release   chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newStalledConnection creates a new stalled connection
+func newStalledConnection() *stalledConnection {
+	return &stalledConnection{
+		signals: make(chan string, 64),
+		release: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (conn *stalledConnection) IsActive() bool {
+	select {
+	case <-conn.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+func (conn *stalledConnection) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+}
+
+func (conn *stalledConnection) Creation() time.Time { return time.Time{} }
+
+func (conn *stalledConnection) Info(_ int) interface{} { return nil }
+
+// Signal records the name of the signal and blocks
+// until it's released or the connection is closed
+func (conn *stalledConnection) Signal(name []byte, _ wwr.Payload) error {
+	conn.signals <- string(name)
+	select {
+	case <-conn.release:
+		return nil
+	case <-conn.closed:
+		return errors.New("connection closed")
+	}
+}
+
+func (conn *stalledConnection) CreateSession(_ wwr.SessionInfo) error {
+	return errors.New("sessions not supported")
+}
+
+func (conn *stalledConnection) CloseSession() error { return nil }
+
+func (conn *stalledConnection) HasSession() bool { return false }
+
+func (conn *stalledConnection) Session() *wwr.Session { return nil }
+
+func (conn *stalledConnection) SessionKey() string { return "" }
+
+func (conn *stalledConnection) SessionCreation() time.Time {
+	return time.Time{}
+}
+
+func (conn *stalledConnection) SessionInfo(_ string) interface{} { return nil }
+
+func (conn *stalledConnection) Close() {
+	conn.closeOnce.Do(func() {
+		close(conn.closed)
+	})
+}
+
+// expectSignal waits for the sending of the named signal to begin
+func (conn *stalledConnection) expectSignal(t *testing.T, name string) {
+	t.Helper()
+	select {
+	case sent := <-conn.signals:
+		if sent != name {
+			t.Fatalf("expected signal %s, got %s", name, sent)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("signal %s not sent", name)
+	}
+}
+
+// TestOutboxOverflow tests the overflow policies
+// and the metrics counting the dropped signals
+func TestOutboxOverflow(t *testing.T) {
+	for _, test := range []struct {
+		policy   overflowPolicy
+		sent     []string
+		expected shared.OutboxMetrics
+	}{
+		{dropOldest, []string{"a", "c", "d"}, shared.OutboxMetrics{
+			DroppedOldest: 1,
+		}},
+		{dropNewest, []string{"a", "b", "c"}, shared.OutboxMetrics{
+			DroppedNewest: 1,
+		}},
+		{disconnect, []string{"a"}, shared.OutboxMetrics{
+			Disconnected: 1,
+			Failed:       3,
+		}},
+	} {
+		conn := newStalledConnection()
+		metrics := &outboxMetrics{}
+		box := newOutbox(conn, outboxConfig{
+			Size:   2,
+			Policy: test.policy,
+		}, metrics)
+
+		// The first signal is being sent while the others are queued
+		box.Enqueue([]byte("a"), wwr.Payload{})
+		conn.expectSignal(t, "a")
+		for _, name := range []string{"b", "c", "d"} {
+			box.Enqueue([]byte(name), wwr.Payload{})
+		}
+
+		if test.policy == disconnect {
+			select {
+			case <-conn.closed:
+			case <-time.After(testTimeout):
+				t.Fatalf("%s: slow client not disconnected", test.policy)
+			}
+		} else {
+			if pending := box.Pending(); pending != 3 {
+				t.Fatalf("%s: unexpected pending signals: %d", test.policy, pending)
+			}
+			for _, name := range test.sent[1:] {
+				conn.release <- struct{}{}
+				conn.expectSignal(t, name)
+			}
+			conn.release <- struct{}{}
+		}
+
+		// The queued signals fail once the connection is closed
+		deadline := time.Now().Add(testTimeout)
+		for box.Pending() > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		box.Close()
+		conn.Close()
+		if snapshot := metrics.Snapshot(); snapshot != test.expected {
+			t.Fatalf(
+				"%s: unexpected metrics: %+v, expected %+v",
+				test.policy,
+				snapshot,
+				test.expected,
+			)
+		}
+	}
+}
+
+// TestBroadcastSlowClient tests broadcasting messages
+// without being blocked by a client that doesn't read
+func TestBroadcastSlowClient(t *testing.T) {
+	hrn := newHarness(t, func(conf *config) {
+		conf.Outbox.Size = 4
+		conf.Outbox.Policy = string(dropNewest)
+	})
+	defer hrn.teardown()
+
+	stalled := newStalledConnection()
+	hrn.chatRoom.OnClientConnected(
+		wwr.ConnectionOptions{Info: map[int]interface{}{0: []byte("stalled")}},
+		stalled,
+	)
+	defer hrn.chatRoom.OnClientDisconnected(stalled, nil)
+	stalled.expectSignal(t, shared.SignalJoined)
+
+	clients := hrn.connect(2)
+	clients[0].login("Gandalf")
+	for _, msg := range strings.Fields("Fool of a Took throw yourself in next time and rid us") {
+		if err := clients[0].post(msg); err != nil {
+			t.Fatalf("posting failed: %s", err)
+		}
+		if chat := clients[1].expectChat(); chat.Msg != msg {
+			t.Fatalf("unexpected message: %+v", chat)
+		}
+	}
+
+	// At most four messages are queued, the others are dropped
+	if metrics := hrn.chatRoom.metrics.Snapshot(); metrics.DroppedNewest < 5 {
+		t.Fatalf("unexpected metrics: %+v", metrics)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// errHandshakeRequired is returned for requests
+// sent before the protocol version was negotiated
+var errHandshakeRequired = wwr.ErrRequest{
+	Code: "HANDSHAKE_REQUIRED",
+	Message: fmt.Sprintf(
+		"The protocol version must be negotiated using a '%s' request first",
+		shared.RequestHandshake,
+	),
+}
+
+// fieldError converts an invalid request field
+// into a request error with a field-level error code
+func fieldError(err error) error {
+	if invalid, isFieldErr := err.(shared.FieldError); isFieldErr {
+		return wwr.ErrRequest{
+			Code:    invalid.Code(),
+			Message: invalid.Error(),
+		}
+	}
+	return err
+}
+
+// parseRequest decodes the payload of a request using the given codec into
+// the given target and validates its fields if the target is a shared.Validator
+func parseRequest(
+	message wwr.Message,
+	codec shared.Codec,
+	target interface{},
+) error {
+	if err := codec.Unmarshal(message.Payload(), target); err != nil {
+		return wwr.ErrRequest{
+			Code:    "DECODING_FAILURE",
+			Message: fmt.Sprintf("Failed decoding request: %s", err),
+		}
+	}
+	if validator, isValidator := target.(shared.Validator); isValidator {
+		if err := validator.Validate(); err != nil {
+			return fieldError(err)
+		}
+	}
+	return nil
+}
+
+// parseName decodes a plain user or room name payload
+// and validates it as the given field
+func parseName(message wwr.Message, field string) (string, error) {
+	name, err := message.PayloadUtf8()
+	if err != nil {
+		return "", wwr.ErrRequest{
+			Code:    "DECODING_FAILURE",
+			Message: fmt.Sprintf("Failed decoding message: %s", err),
+		}
+	}
+	if err := shared.ValidateName(field, string(name)); err != nil {
+		return "", fieldError(err)
+	}
+	return string(name), nil
+}
+
+// isNegotiated returns true if the client completed the handshake
+func (srv *ChatRoomServer) isNegotiated(client wwr.Connection) bool {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	state, exists := srv.connected[client]
+	return exists && state.Protocol > 0
+}
+
+// handleHandshake negotiates the protocol version with the client.
+// Clients implementing an unsupported version are rejected
+func (srv *ChatRoomServer) handleHandshake(
+	_ context.Context,
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	var req shared.HandshakeRequest
+	if err := parseRequest(message, shared.CodecJSON, &req); err != nil {
+		return wwr.Payload{}, err
+	}
+
+	if req.Version < shared.MinProtocolVersion ||
+		req.Version > shared.ProtocolVersion {
+		outdated := "server"
+		if req.Version < shared.MinProtocolVersion {
+			outdated = "client"
+		}
+		log.Printf(
+			"Rejected client %s (%s) implementing protocol version %d",
+			client.RemoteAddr(),
+			req.Client,
+			req.Version,
+		)
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code: "INCOMPATIBLE_PROTOCOL",
+			Message: fmt.Sprintf(
+				"The client implements protocol version %d "+
+					"while the server supports versions %d to %d, "+
+					"please upgrade the %s",
+				req.Version,
+				shared.MinProtocolVersion,
+				shared.ProtocolVersion,
+				outdated,
+			),
+		}
+	}
+
+	srv.lock.Lock()
+	if state, exists := srv.connected[client]; exists {
+		state.Protocol = req.Version
+	}
+	srv.lock.Unlock()
+
+	return srv.reply(client, shared.HandshakeReply{
+		Version: shared.ProtocolVersion,
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+)
+
+// rateLimitPolicy defines how many messages a client may send
+// within an interval. Rate limiting is disabled if Messages is 0
+type rateLimitPolicy struct {
+	Messages int
+	Interval time.Duration
+}
+
+// rateLimiter is a token bucket limiting the messages of a single client.
+// The bucket holds up to Messages tokens and is refilled
+// at a rate of Messages tokens per Interval
+type rateLimiter struct {
+	policy     rateLimitPolicy
+	tokens     float64
+	lastRefill time.Time
+	lock       sync.Mutex
+}
+
+// newRateLimiter creates a new full token bucket
+func newRateLimiter(policy rateLimitPolicy) *rateLimiter {
+	return &rateLimiter{
+		policy:     policy,
+		tokens:     float64(policy.Messages),
+		lastRefill: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket.
+// Returns an error if the bucket is empty
+func (lim *rateLimiter) Allow() error {
+	if lim.policy.Messages < 1 {
+		return nil
+	}
+
+	lim.lock.Lock()
+	defer lim.lock.Unlock()
+
+	now := time.Now()
+	capacity := float64(lim.policy.Messages)
+	lim.tokens += now.Sub(lim.lastRefill).Seconds() *
+		capacity / lim.policy.Interval.Seconds()
+	if lim.tokens > capacity {
+		lim.tokens = capacity
+	}
+	lim.lastRefill = now
+
+	if lim.tokens < 1 {
+		return wwr.ErrRequest{
+			Code: "RATE_LIMITED",
+			Message: fmt.Sprintf(
+				"Too many messages, at most %d per %s allowed",
+				lim.policy.Messages,
+				lim.policy.Interval,
+			),
+		}
+	}
+	lim.tokens--
+	return nil
+}
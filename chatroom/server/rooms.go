@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// room represents a chat room and all of its persisted metadata
+type room struct {
+	Name    string                 `json:"name"`
+	Topic   string                 `json:"topic"`
+	Access  shared.RoomAccess      `json:"access"`
+	Pinned  []shared.PinnedMessage `json:"pinned"`
+	Invited []string               `json:"invited"`
+
+	// TopicChanged is the time the topic was last changed at
+	// and TopicNode the tag of the node it was changed on.
+	// Concurrent topic changes on different nodes resolve to the latest,
+	// changes at the same time to the one of the node with the higher tag
+	TopicChanged time.Time `json:"topicChanged,omitempty"`
+	TopicNode    uint16    `json:"topicNode,omitempty"`
+
+	// LastPinID is the sequence number of the latest pinned message
+	// of any node, see pinID
+	LastPinID uint64 `json:"lastPinId"`
+}
+
+// copy returns a deep copy of the room
+func (r *room) copy() *room {
+	cpy := *r
+	cpy.Pinned = append([]shared.PinnedMessage(nil), r.Pinned...)
+	cpy.Invited = append([]string(nil), r.Invited...)
+	return &cpy
+}
+
+// isInvited returns true if the given user was invited to the room
+func (r *room) isInvited(username string) bool {
+	for _, invited := range r.Invited {
+		if invited == username {
+			return true
+		}
+	}
+	return false
+}
+
+// Metadata returns the publicly visible metadata of the room
+func (r *room) Metadata() shared.RoomMetadata {
+	pinned := make([]shared.PinnedMessage, len(r.Pinned))
+	copy(pinned, r.Pinned)
+	return shared.RoomMetadata{
+		Name:   r.Name,
+		Topic:  r.Topic,
+		Access: r.Access,
+		Pinned: pinned,
+	}
+}
+
+// newRoom returns a new room with the topic and access mode
+// configured for new rooms
+func newRoom(name string, conf roomsConfig) *room {
+	return &room{
+		Name:   name,
+		Topic:  conf.Topic,
+		Access: conf.Access,
+	}
+}
+
+// defaultRooms returns the rooms created when no room file exists yet
+func defaultRooms(conf roomsConfig) map[string]*room {
+	return map[string]*room{
+		conf.Default: newRoom(conf.Default, conf),
+		"announcements": {
+			Name:   "announcements",
+			Topic:  "News from the council",
+			Access: shared.RoomAnnouncement,
+		},
+		"council": {
+			Name:   "council",
+			Topic:  "The council of Elrond",
+			Access: shared.RoomInviteOnly,
+		},
+	}
+}
+
+// Kinds of room operations
+const (
+	roomOpTopic  = "topic"
+	roomOpPin    = "pin"
+	roomOpUnpin  = "unpin"
+	roomOpInvite = "invite"
+)
+
+// roomOp represents a change of a room. Operations rather than entire rooms
+// are replicated to the other nodes of a cluster for concurrent changes
+// on different nodes to merge instead of overwriting each other
+type roomOp struct {
+	Room string `json:"room"`
+	Kind string `json:"kind"`
+
+	// Topic, Time and Node are set for topic changes
+	Topic string    `json:"topic,omitempty"`
+	Time  time.Time `json:"time,omitempty"`
+	Node  uint16    `json:"node,omitempty"`
+
+	// Pin is set for pinned messages. A pin without an ID gets
+	// the next ID of the node applying the operation assigned
+	Pin *shared.PinnedMessage `json:"pin,omitempty"`
+
+	// ID is set for unpinned messages
+	ID uint64 `json:"id,omitempty"`
+
+	// User is set for invitations
+	User string `json:"user,omitempty"`
+}
+
+// supersedesTopic returns true if the topic change isn't older
+// than the last one of the room. Changes at the same time are ordered
+// by the tag of their node and finally by their topic
+// for all nodes to agree on the same topic
+func (op *roomOp) supersedesTopic(r *room) bool {
+	switch {
+	case !op.Time.Equal(r.TopicChanged):
+		return op.Time.After(r.TopicChanged)
+	case op.Node != r.TopicNode:
+		return op.Node > r.TopicNode
+	}
+	return op.Topic >= r.Topic
+}
+
+// pinID returns the ID of a pinned message composed of the given sequence
+// number and the tag of the node the message was pinned on.
+// The IDs are unique among all nodes of a cluster as long as no two nodes
+// share a tag, the sequence numbers of the nodes are kept in sync
+// by advancing them to the highest one seen
+func pinID(seq uint64, nodeTag uint16) uint64 {
+	return seq<<16 | uint64(nodeTag)
+}
+
+// roomStore keeps the metadata of all rooms
+// and persists it to a JSON file on every change
+type roomStore struct {
+	filePath    string
+	defaultRoom string
+	rooms       map[string]*room
+	nodeTag     uint16
+	lock        sync.RWMutex
+}
+
+// newRoomStore loads the rooms from the configured file.
+// Creates the file with the default rooms if it doesn't exist yet.
+// New clients are joined to the configured default room
+func newRoomStore(conf roomsConfig) (*roomStore, error) {
+	store := &roomStore{
+		filePath:    conf.File,
+		defaultRoom: conf.Default,
+		rooms:       make(map[string]*room),
+	}
+
+	contents, err := ioutil.ReadFile(conf.File)
+	if os.IsNotExist(err) {
+		store.rooms = defaultRooms(conf)
+		if err := store.save(); err != nil {
+			return nil, err
+		}
+		return store, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Couldn't read room file: %s", err)
+	}
+
+	var rooms []*room
+	if err := json.Unmarshal(contents, &rooms); err != nil {
+		return nil, fmt.Errorf("Couldn't parse room file: %s", err)
+	}
+	for _, r := range rooms {
+		if !r.Access.Valid() {
+			return nil, fmt.Errorf(
+				"Invalid access mode of room '%s': '%s'",
+				r.Name,
+				r.Access,
+			)
+		}
+		store.rooms[r.Name] = r
+	}
+
+	// Make sure the default room always exists
+	if _, exists := store.rooms[conf.Default]; !exists {
+		store.rooms[conf.Default] = newRoom(conf.Default, conf)
+		if err := store.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return store, nil
+}
+
+// DefaultRoom returns the name of the room new clients are joined to
+func (str *roomStore) DefaultRoom() string {
+	return str.defaultRoom
+}
+
+// save writes all rooms to the room file.
+// The caller is expected to hold the lock
+func (str *roomStore) save() error {
+	rooms := make([]*room, 0, len(str.rooms))
+	for _, r := range str.rooms {
+		rooms = append(rooms, r)
+	}
+
+	encoded, err := json.MarshalIndent(rooms, "", "\t")
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal rooms: %s", err)
+	}
+
+	if err := writeFileAtomic(str.filePath, encoded); err != nil {
+		return fmt.Errorf("Couldn't save rooms: %s", err)
+	}
+	return nil
+}
+
+// Get returns a copy of the room identified by the given name
+func (str *roomStore) Get(name string) (*room, bool) {
+	str.lock.RLock()
+	defer str.lock.RUnlock()
+	r, exists := str.rooms[name]
+	if !exists {
+		return nil, false
+	}
+	return r.copy(), true
+}
+
+// Update applies the given mutation to a copy of the room identified by the
+// given name and persists it. The room is left untouched if either the
+// mutation or the persistence fails
+func (str *roomStore) Update(
+	name string,
+	mutate func(*room) error,
+) (shared.RoomMetadata, error) {
+	str.lock.Lock()
+	defer str.lock.Unlock()
+
+	original, exists := str.rooms[name]
+	if !exists {
+		return shared.RoomMetadata{}, errRoomNotFound(name)
+	}
+
+	updated := original.copy()
+	if err := mutate(updated); err != nil {
+		return shared.RoomMetadata{}, err
+	}
+
+	str.rooms[name] = updated
+	if err := str.save(); err != nil {
+		str.rooms[name] = original
+		return shared.RoomMetadata{}, err
+	}
+
+	return updated.Metadata(), nil
+}
+
+// SetNodeTag sets the tag of this node included in the IDs
+// of the messages pinned on this node
+func (str *roomStore) SetNodeTag(tag uint16) {
+	str.lock.Lock()
+	str.nodeTag = tag
+	str.lock.Unlock()
+}
+
+// Apply merges the given operation into its room and persists the room.
+// Applying an operation more than once doesn't change the room any further.
+// Pins without an ID get the next ID of this node assigned
+func (str *roomStore) Apply(op *roomOp) (shared.RoomMetadata, error) {
+	return str.Update(op.Room, func(r *room) error {
+		switch op.Kind {
+		case roomOpTopic:
+			if !op.supersedesTopic(r) {
+				return nil
+			}
+			r.Topic = op.Topic
+			r.TopicChanged = op.Time
+			r.TopicNode = op.Node
+		case roomOpPin:
+			if op.Pin == nil {
+				return fmt.Errorf("Pin operation without pinned message")
+			}
+			if op.Pin.ID == 0 {
+				r.LastPinID++
+				op.Pin.ID = pinID(r.LastPinID, str.nodeTag)
+			} else if seq := op.Pin.ID >> 16; seq > r.LastPinID {
+				r.LastPinID = seq
+			}
+			for _, pinned := range r.Pinned {
+				if pinned.ID == op.Pin.ID {
+					return nil
+				}
+			}
+			r.Pinned = append(r.Pinned, *op.Pin)
+			sort.SliceStable(r.Pinned, func(i, j int) bool {
+				return r.Pinned[i].Time.Before(r.Pinned[j].Time)
+			})
+		case roomOpUnpin:
+			for index, pinned := range r.Pinned {
+				if pinned.ID == op.ID {
+					r.Pinned = append(r.Pinned[:index], r.Pinned[index+1:]...)
+					return nil
+				}
+			}
+			return wwr.ErrRequest{
+				Code:    "PIN_NOT_FOUND",
+				Message: fmt.Sprintf("No pinned message with id %d", op.ID),
+			}
+		case roomOpInvite:
+			if !r.isInvited(op.User) {
+				r.Invited = append(r.Invited, op.User)
+			}
+		default:
+			return fmt.Errorf("Unknown room operation: %s", op.Kind)
+		}
+		return nil
+	})
+}
+
+// Replace replaces the room identified by the name of the given room
+// creating it if it doesn't exist yet
+func (str *roomStore) Replace(r *room) error {
+	if !r.Access.Valid() {
+		return fmt.Errorf(
+			"Invalid access mode of room '%s': '%s'",
+			r.Name,
+			r.Access,
+		)
+	}
+
+	str.lock.Lock()
+	defer str.lock.Unlock()
+
+	original, existed := str.rooms[r.Name]
+	str.rooms[r.Name] = r.copy()
+	if err := str.save(); err != nil {
+		if existed {
+			str.rooms[r.Name] = original
+		} else {
+			delete(str.rooms, r.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// errRoomNotFound returns a request error for an inexistent room
+func errRoomNotFound(name string) error {
+	return wwr.ErrRequest{
+		Code:    "ROOM_NOT_FOUND",
+		Message: fmt.Sprintf("No such room: '%s'", name),
+	}
+}
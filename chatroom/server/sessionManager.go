@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/audit"
+	"github.com/qbeon/webwire-go-examples/chatroom/cluster"
+)
+
+// auditedSessionManager wraps a stored session manager
+// recording session closures in the audit log
+// and refusing to restore the sessions of banned users
+type auditedSessionManager struct {
+	*storedSessionManager
+	auditLog *audit.Log
+	bans     *banList
+}
+
+// newAuditedSessionManager constructs a new audited session manager
+// wrapping the given session manager
+func newAuditedSessionManager(
+	manager *storedSessionManager,
+	auditLog *audit.Log,
+	bans *banList,
+) *auditedSessionManager {
+	return &auditedSessionManager{
+		manager,
+		auditLog,
+		bans,
+	}
+}
+
+// OnSessionLookup implements the webwire.SessionManager interface.
+// Sessions of banned users are reported as inexistent
+func (mng *auditedSessionManager) OnSessionLookup(key string) (
+	wwr.SessionLookupResult,
+	error,
+) {
+	result, err := mng.storedSessionManager.OnSessionLookup(key)
+	if err != nil || result == nil {
+		return result, err
+	}
+	username, _ := result.Info()["username"].(string)
+	if _, banned := mng.bans.Check(username); banned {
+		log.Printf("Refused to restore session of banned user %s", username)
+		return nil, nil
+	}
+	return result, nil
+}
+
+// OnSessionClosed implements the webwire.SessionManager interface.
+// It records the closure before closing the session
+func (mng *auditedSessionManager) OnSessionClosed(sessionKey string) error {
+	// Read the session info without looking it up
+	// to not touch the last lookup time of the session
+	username := ""
+	if info, err := mng.SessionInfo(sessionKey); err == nil && info != nil {
+		username, _ = info["username"].(string)
+	}
+
+	if err := mng.auditLog.Record(
+		audit.EventSessionClosed,
+		username,
+		"",
+		nil,
+	); err != nil {
+		log.Printf("ERROR: couldn't record session closure: %s", err)
+	}
+
+	return mng.storedSessionManager.OnSessionClosed(sessionKey)
+}
+
+// sessionRecord represents the serialization structure
+// of a session in the shared session store
+type sessionRecord struct {
+	Creation   time.Time              `json:"c"`
+	LastLookup time.Time              `json:"l"`
+	Info       map[string]interface{} `json:"i"`
+}
+
+// storedSessionManager implements the webwire.SessionManager interface
+// keeping the sessions in a session store. When running in a cluster
+// the store is shared by all nodes which allows sessions to be restored
+// on any node
+type storedSessionManager struct {
+	store cluster.SessionStore
+
+	// revoking contains the keys of the sessions being revoked
+	// which must not be restored in the meantime
+	revoking map[string]struct{}
+	lock     sync.Mutex
+}
+
+// newStoredSessionManager constructs a new stored session manager
+func newStoredSessionManager(
+	store cluster.SessionStore,
+) *storedSessionManager {
+	return &storedSessionManager{
+		store:    store,
+		revoking: make(map[string]struct{}),
+	}
+}
+
+// Revoke revokes the given session for it to never be restored again.
+// The given function is expected to close the session on all connections.
+// Lookups of the session are refused until it's deleted from the store
+func (mng *storedSessionManager) Revoke(
+	key string,
+	closeSession func(),
+) error {
+	mng.lock.Lock()
+	mng.revoking[key] = struct{}{}
+	mng.lock.Unlock()
+
+	defer func() {
+		mng.lock.Lock()
+		delete(mng.revoking, key)
+		mng.lock.Unlock()
+	}()
+
+	// The session was deleted already if closing it
+	// on the last connection destroyed it
+	closeSession()
+	if err := mng.store.Delete(key); err != nil {
+		return fmt.Errorf("Couldn't delete session record: %s", err)
+	}
+	return nil
+}
+
+// isRevoking returns true if the given session is being revoked
+func (mng *storedSessionManager) isRevoking(key string) bool {
+	mng.lock.Lock()
+	defer mng.lock.Unlock()
+	_, revoking := mng.revoking[key]
+	return revoking
+}
+
+// save writes a session record to the shared store
+func (mng *storedSessionManager) save(
+	key string,
+	record sessionRecord,
+) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal session record: %s", err)
+	}
+	if err := mng.store.Put(key, encoded); err != nil {
+		return fmt.Errorf("Couldn't store session record: %s", err)
+	}
+	return nil
+}
+
+// OnSessionCreated implements the webwire.SessionManager interface
+func (mng *storedSessionManager) OnSessionCreated(conn wwr.Connection) error {
+	sess := conn.Session()
+	return mng.save(conn.SessionKey(), sessionRecord{
+		Creation:   sess.Creation,
+		LastLookup: sess.LastLookup,
+		Info:       wwr.SessionInfoToVarMap(sess.Info),
+	})
+}
+
+// load reads a session record from the store.
+// Returns nil if there's no such session
+func (mng *storedSessionManager) load(key string) (*sessionRecord, error) {
+	encoded, found, err := mng.store.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't load session record: %s", err)
+	}
+	if !found {
+		return nil, nil
+	}
+
+	var record sessionRecord
+	if err := json.Unmarshal(encoded, &record); err != nil {
+		return nil, fmt.Errorf("Couldn't parse session record: %s", err)
+	}
+	return &record, nil
+}
+
+// SessionInfo returns the info of the given session without updating
+// its last lookup field. Returns nil if there's no such session
+func (mng *storedSessionManager) SessionInfo(
+	key string,
+) (map[string]interface{}, error) {
+	record, err := mng.load(key)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	return record.Info, nil
+}
+
+// OnSessionLookup implements the webwire.SessionManager interface.
+// It also updates the last lookup field of the found session
+func (mng *storedSessionManager) OnSessionLookup(key string) (
+	wwr.SessionLookupResult,
+	error,
+) {
+	if mng.isRevoking(key) {
+		return nil, nil
+	}
+
+	record, err := mng.load(key)
+	if err != nil || record == nil {
+		return nil, err
+	}
+
+	// Update last lookup
+	lastLookup := record.LastLookup
+	record.LastLookup = time.Now().UTC()
+	if err := mng.save(key, *record); err != nil {
+		return nil, err
+	}
+
+	return wwr.NewSessionLookupResult(
+		record.Creation,
+		lastLookup,
+		record.Info,
+	), nil
+}
+
+// OnSessionClosed implements the webwire.SessionManager interface
+func (mng *storedSessionManager) OnSessionClosed(sessionKey string) error {
+	if err := mng.store.Delete(sessionKey); err != nil {
+		return fmt.Errorf("Couldn't delete session record: %s", err)
+	}
+	return nil
+}
+
+// fileSessionStore implements the cluster.SessionStore interface
+// keeping each session record in a file of a directory.
+// The files are compatible with those of the webwire default session manager
+type fileSessionStore struct {
+	dir string
+}
+
+// newFileSessionStore creates the given session directory if it doesn't
+// exist yet. The directory defaults to "wwrsess" next to the executable
+func newFileSessionStore(dir string) (*fileSessionStore, error) {
+	if dir == "" {
+		executableDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Couldn't determine default session directory: %s",
+				err,
+			)
+		}
+		dir = filepath.Join(executableDir, "wwrsess")
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("Couldn't create session directory: %s", err)
+	}
+	return &fileSessionStore{dir}, nil
+}
+
+// path returns the path of the file of the given session
+func (store *fileSessionStore) path(key string) string {
+	return filepath.Join(store.dir, key+".wwrsess")
+}
+
+// Put implements the cluster.SessionStore interface
+func (store *fileSessionStore) Put(key string, record []byte) error {
+	return writeFileAtomic(store.path(key), record)
+}
+
+// Get implements the cluster.SessionStore interface
+func (store *fileSessionStore) Get(key string) ([]byte, bool, error) {
+	record, err := ioutil.ReadFile(store.path(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return record, true, nil
+}
+
+// Delete implements the cluster.SessionStore interface
+func (store *fileSessionStore) Delete(key string) error {
+	if err := os.Remove(store.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/chatroom/shared"
+)
+
+// errShuttingDown is returned for requests
+// that are no longer accepted during the shutdown
+var errShuttingDown = wwr.ErrRequest{
+	Code:    "SERVER_SHUTTING_DOWN",
+	Message: "The server is shutting down and doesn't accept new messages",
+}
+
+// isDraining returns true if the server is shutting down
+func (srv *ChatRoomServer) isDraining() bool {
+	return atomic.LoadInt32(&srv.draining) == 1
+}
+
+// pendingSignals returns the number of signals
+// still queued for the clients connected to this node
+func (srv *ChatRoomServer) pendingSignals() int {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	pending := 0
+	for _, state := range srv.connected {
+		pending += state.Outbox.Pending()
+	}
+	return pending
+}
+
+// drainPollInterval defines how often the outbound queues are checked
+// while draining
+const drainPollInterval = 50 * time.Millisecond
+
+// Drain prepares the server for shutdown. It notifies all connected clients
+// and those connecting while draining, stops accepting new messages
+// and waits until all queued signals are sent or the deadline is exceeded
+// before closing the connections.
+// The expected downtime is sent to the clients unless it's zero
+func (srv *ChatRoomServer) Drain(
+	reason string,
+	deadline time.Duration,
+	downtime time.Duration,
+) {
+	if !atomic.CompareAndSwapInt32(&srv.draining, 0, 1) {
+		return
+	}
+
+	shutdownAt := time.Now().Add(deadline)
+	notice := shared.ShutdownNotice{
+		Reason:     reason,
+		ShutdownAt: shutdownAt.UTC(),
+	}
+	if downtime > 0 {
+		notice.BackAt = shutdownAt.Add(downtime).UTC()
+	}
+	encoded := newEncodedPayloads(notice)
+	jsonNotice := encoded.For(shared.CodecJSON)
+
+	srv.lock.Lock()
+	srv.shutdownNotice = &jsonNotice
+	log.Printf("Notifying %d clients about the shutdown", len(srv.connected))
+	for client, state := range srv.connected {
+		state.Outbox.Enqueue(
+			[]byte(shared.SignalShutdown),
+			encoded.For(sessionCodec(client)),
+		)
+	}
+	srv.lock.Unlock()
+
+	// Give the clients a chance to receive all queued signals
+	// until the announced shutdown time
+	pending := srv.pendingSignals()
+	for pending > 0 && time.Now().Before(shutdownAt) {
+		time.Sleep(drainPollInterval)
+		pending = srv.pendingSignals()
+	}
+	if pending > 0 {
+		log.Printf(
+			"WARNING: drain deadline exceeded, %d signals weren't sent",
+			pending,
+		)
+	}
+
+	// The HTTP server doesn't close the hijacked websocket connections
+	srv.closeConnections()
+}
+
+// closeConnections closes the connections of all connected clients
+func (srv *ChatRoomServer) closeConnections() {
+	srv.lock.RLock()
+	clients := make([]wwr.Connection, 0, len(srv.connected))
+	for client := range srv.connected {
+		clients = append(clients, client)
+	}
+	srv.lock.RUnlock()
+
+	for _, client := range clients {
+		client.Close()
+	}
+}
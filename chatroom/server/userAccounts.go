@@ -1,21 +1,23 @@
 package main
 
+import "github.com/qbeon/webwire-go-examples/chatroom/shared"
+
+const (
+	// roleModerator allows managing rooms
+	roleModerator = "moderator"
+
+	// roleAdmin allows managing rooms and users
+	roleAdmin = "admin"
+)
+
 // userAccounts maps usernames to corresponding passwords
-var userAccounts = map[string]string{
-	"Gandalf":   "gandalf1234",
-	"Sauron":    "sauron1234",
-	"Galadriel": "galadriel1234",
-	"Legolas":   "legolas1234",
-	"Aragorn":   "aragorn1234",
-	"Melkor":    "melkor1234",
-	"Thranduil": "thranduil1234",
-	"Frodo":     "frodo1234",
-	"Samweis":   "samweis1234",
-	"Gollum":    "gollum1234",
-	"Gimli":     "gimli1234",
-	"Peregrin":  "peregrin1234",
-	"Meriadoc":  "meriadoc1234",
-	"Boromir":   "boromir1234",
-	"Saruman":   "saruman1234",
-	"Elrond":    "elrond1234",
+var userAccounts = shared.UserAccounts
+
+// userRoles maps usernames to the roles assigned to them.
+// Users not listed here have no special roles
+var userRoles = map[string][]string{
+	"Gandalf":   {roleAdmin},
+	"Elrond":    {roleAdmin},
+	"Galadriel": {roleModerator},
+	"Aragorn":   {roleModerator},
 }
@@ -4,4 +4,24 @@ package shared
 type AuthenticationCredentials struct {
 	Name     string `json:"name"`
 	Password string `json:"pass"`
+
+	// Codec optionally selects the codec of the connection, JSON by default
+	Codec string `json:"codec,omitempty"`
+}
+
+// Validate implements the Validator interface
+func (creds *AuthenticationCredentials) Validate() error {
+	if err := ValidateName("name", creds.Name); err != nil {
+		return err
+	}
+	if IsGuestName(creds.Name) {
+		return FieldError{"name", "is reserved for guests"}
+	}
+	if creds.Password == "" {
+		return FieldError{"pass", "is missing"}
+	}
+	if _, supported := CodecByName(creds.Codec); !supported {
+		return FieldError{"codec", "is not supported"}
+	}
+	return nil
 }
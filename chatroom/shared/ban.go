@@ -0,0 +1,55 @@
+package shared
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxBanReasonLength defines the maximum length of the reason of a ban
+const MaxBanReasonLength = 200
+
+// Ban represents a banned account
+type Ban struct {
+	User   string `json:"user"`
+	Reason string `json:"reason,omitempty"`
+
+	// By is the name of the moderator who banned the account
+	By    string    `json:"by"`
+	Since time.Time `json:"since"`
+
+	// Until is zero if the ban is permanent
+	Until time.Time `json:"until,omitempty"`
+}
+
+// Expired returns true if the ban was lifted by time
+func (ban Ban) Expired(now time.Time) bool {
+	return !ban.Until.IsZero() && !now.Before(ban.Until)
+}
+
+// BanRequest represents the payload of a ban request
+type BanRequest struct {
+	User   string `json:"user"`
+	Reason string `json:"reason,omitempty"`
+
+	// Duration is the number of seconds the ban lasts,
+	// the ban is permanent if it's 0
+	Duration uint64 `json:"duration,omitempty"`
+}
+
+// Validate implements the Validator interface
+func (req *BanRequest) Validate() error {
+	if err := ValidateName("user", req.User); err != nil {
+		return err
+	}
+	if IsGuestName(req.User) {
+		return FieldError{"user", "must not be a guest"}
+	}
+	if utf8.RuneCountInString(req.Reason) > MaxBanReasonLength {
+		return FieldError{
+			"reason",
+			fmt.Sprintf("exceeds %d characters", MaxBanReasonLength),
+		}
+	}
+	return nil
+}
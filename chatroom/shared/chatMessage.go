@@ -1,7 +1,16 @@
 package shared
 
 // ChatMessage represents a chat message containing the senders name
+// and the name of the room it was posted in
 type ChatMessage struct {
+	Room string `json:"room"`
 	User string `json:"user"`
 	Msg  string `json:"msg"`
+
+	// Own is true if the message was sent by the receiving user,
+	// either from the receiving device or from another one
+	Own bool `json:"own,omitempty"`
+
+	// Guest is true if the message was posted by an anonymous guest
+	Guest bool `json:"guest,omitempty"`
 }
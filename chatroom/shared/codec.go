@@ -0,0 +1,109 @@
+package shared
+
+import (
+	"encoding/json"
+
+	wwr "github.com/qbeon/webwire-go"
+)
+
+// Codec encodes the shared types exchanged between server and clients.
+// The codec of a connection is negotiated during authentication,
+// until then all payloads are JSON encoded
+type Codec interface {
+	// Name returns the name identifying the codec during authentication
+	Name() string
+
+	// Encoding returns the webwire payload encoding of encoded values
+	Encoding() wwr.PayloadEncoding
+
+	// Marshal encodes the given value
+	Marshal(value interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into the value the given pointer points to
+	Unmarshal(data []byte, target interface{}) error
+}
+
+// jsonCodec encodes values as UTF8 encoded JSON
+type jsonCodec struct{}
+
+// Name implements the Codec interface
+func (jsonCodec) Name() string { return "json" }
+
+// Encoding implements the Codec interface
+func (jsonCodec) Encoding() wwr.PayloadEncoding { return wwr.EncodingUtf8 }
+
+// Marshal implements the Codec interface
+func (jsonCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// Unmarshal implements the Codec interface
+func (jsonCodec) Unmarshal(data []byte, target interface{}) error {
+	return json.Unmarshal(data, target)
+}
+
+// msgpackCodec encodes values as binary MessagePack
+type msgpackCodec struct{}
+
+// Name implements the Codec interface
+func (msgpackCodec) Name() string { return "msgpack" }
+
+// Encoding implements the Codec interface
+func (msgpackCodec) Encoding() wwr.PayloadEncoding { return wwr.EncodingBinary }
+
+// Marshal implements the Codec interface
+func (msgpackCodec) Marshal(value interface{}) ([]byte, error) {
+	return MarshalMsgpack(value)
+}
+
+// Unmarshal implements the Codec interface
+func (msgpackCodec) Unmarshal(data []byte, target interface{}) error {
+	return UnmarshalMsgpack(data, target)
+}
+
+var (
+	// CodecJSON is the default codec
+	CodecJSON Codec = jsonCodec{}
+
+	// CodecMsgpack is the compact binary MessagePack codec
+	CodecMsgpack Codec = msgpackCodec{}
+)
+
+// Codecs lists all supported codecs
+var Codecs = []Codec{CodecJSON, CodecMsgpack}
+
+// CodecByName returns the codec with the given name.
+// An empty name selects the default JSON codec
+func CodecByName(name string) (Codec, bool) {
+	if name == "" {
+		return CodecJSON, true
+	}
+	for _, codec := range Codecs {
+		if codec.Name() == name {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// CodecForEncoding returns the codec of a payload with the given encoding
+func CodecForEncoding(encoding wwr.PayloadEncoding) Codec {
+	if encoding == wwr.EncodingBinary {
+		return CodecMsgpack
+	}
+	return CodecJSON
+}
+
+// EncodedPayload is implemented by received webwire messages and replies
+type EncodedPayload interface {
+	PayloadEncoding() wwr.PayloadEncoding
+	Payload() []byte
+}
+
+// Decode decodes a received payload using the codec matching its encoding
+func Decode(payload EncodedPayload, target interface{}) error {
+	return CodecForEncoding(payload.PayloadEncoding()).Unmarshal(
+		payload.Payload(),
+		target,
+	)
+}
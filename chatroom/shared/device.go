@@ -0,0 +1,14 @@
+package shared
+
+import "time"
+
+// Device represents one of the active connections of a user
+type Device struct {
+	ID         uint64    `json:"id"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Connected  time.Time `json:"connected"`
+	Room       string    `json:"room"`
+
+	// Current is true for the connection that requested the device list
+	Current bool `json:"current"`
+}
@@ -0,0 +1,102 @@
+package shared
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// DirectMessageVersion defines the version of the direct message encoding
+const DirectMessageVersion byte = 1
+
+// PublicKeySize defines the size of an X25519 public key in bytes
+const PublicKeySize = 32
+
+// NonceSize defines the size of the AEAD nonce in bytes
+const NonceSize = 12
+
+// DirectMessage represents an end-to-end encrypted message sent from one
+// user to another. Only the sender and the recipient can decrypt
+// the ciphertext, the server merely relays it
+type DirectMessage struct {
+	From string
+	To   string
+
+	// EphemeralKey is the public X25519 key
+	// generated by the sender for this message only
+	EphemeralKey [PublicKeySize]byte
+
+	Nonce      [NonceSize]byte
+	Ciphertext []byte
+}
+
+// Header returns the encoded version, sender, recipient and ephemeral key
+// which are authenticated as additional data of the ciphertext
+func (dm *DirectMessage) Header() []byte {
+	header := make([]byte, 0, 3+len(dm.From)+len(dm.To)+PublicKeySize)
+	header = append(header, DirectMessageVersion)
+	header = append(header, byte(len(dm.From)))
+	header = append(header, dm.From...)
+	header = append(header, byte(len(dm.To)))
+	header = append(header, dm.To...)
+	header = append(header, dm.EphemeralKey[:]...)
+	return header
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+// The message is encoded as the header followed by the nonce,
+// the length of the ciphertext and the ciphertext
+func (dm *DirectMessage) MarshalBinary() ([]byte, error) {
+	if len(dm.From) > 255 || len(dm.To) > 255 {
+		return nil, errors.New("user names must not exceed 255 bytes")
+	}
+	encoded := dm.Header()
+	encoded = append(encoded, dm.Nonce[:]...)
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(dm.Ciphertext)))
+	encoded = append(encoded, length[:]...)
+	return append(encoded, dm.Ciphertext...), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
+func (dm *DirectMessage) UnmarshalBinary(data []byte) error {
+	errTruncated := errors.New("truncated direct message")
+	readString := func() (string, error) {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return "", errTruncated
+		}
+		str := string(data[1 : 1+data[0]])
+		data = data[1+data[0]:]
+		return str, nil
+	}
+
+	if len(data) < 1 {
+		return errTruncated
+	}
+	if data[0] != DirectMessageVersion {
+		return fmt.Errorf("unsupported direct message version: %d", data[0])
+	}
+	data = data[1:]
+
+	var err error
+	if dm.From, err = readString(); err != nil {
+		return err
+	}
+	if dm.To, err = readString(); err != nil {
+		return err
+	}
+	if len(data) < PublicKeySize+NonceSize+4 {
+		return errTruncated
+	}
+	copy(dm.EphemeralKey[:], data)
+	copy(dm.Nonce[:], data[PublicKeySize:])
+	data = data[PublicKeySize+NonceSize:]
+
+	length := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint32(len(data)) != length {
+		return errTruncated
+	}
+	dm.Ciphertext = append([]byte(nil), data...)
+	return nil
+}
@@ -0,0 +1,26 @@
+package shared
+
+import (
+	"strings"
+	"time"
+)
+
+// GuestPrefix prefixes the nicknames generated for anonymous guests.
+// Account names must not start with it for guests to be distinguishable
+const GuestPrefix = "guest-"
+
+// IsGuestName returns true if the given name is a guest nickname
+func IsGuestName(name string) bool {
+	return strings.HasPrefix(name, GuestPrefix)
+}
+
+// Guest represents the connection of an anonymous guest
+type Guest struct {
+	Name       string    `json:"name"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Connected  time.Time `json:"connected"`
+	Room       string    `json:"room"`
+
+	// ReadOnly is true if the guest isn't allowed to post
+	ReadOnly bool `json:"readOnly,omitempty"`
+}
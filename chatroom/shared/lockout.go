@@ -0,0 +1,12 @@
+package shared
+
+import "time"
+
+// Lockout represents the state of a locked out account or remote address
+type Lockout struct {
+	// Kind is either "account" or "address"
+	Kind        string    `json:"kind"`
+	Key         string    `json:"key"`
+	Failures    uint      `json:"failures"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
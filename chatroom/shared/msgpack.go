@@ -0,0 +1,626 @@
+package shared
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// msgpackTimestamp is the MessagePack extension type of timestamps
+const msgpackTimestamp = -1
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// errMsgpackTruncated is returned for incomplete MessagePack data
+var errMsgpackTruncated = errors.New("msgpack: truncated data")
+
+// structField represents an encoded struct field
+type structField struct {
+	index     int
+	name      string
+	omitEmpty bool
+}
+
+// structFields returns the encoded fields of a struct type named after
+// their JSON tags for the MessagePack and JSON encodings to match
+func structFields(typ reflect.Type) []structField {
+	fields := make([]structField, 0, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		omitEmpty := false
+		if tag, hasTag := field.Tag.Lookup("json"); hasTag {
+			options := strings.Split(tag, ",")
+			if options[0] == "-" {
+				continue
+			}
+			if options[0] != "" {
+				name = options[0]
+			}
+			for _, option := range options[1:] {
+				omitEmpty = omitEmpty || option == "omitempty"
+			}
+		}
+		fields = append(fields, structField{i, name, omitEmpty})
+	}
+	return fields
+}
+
+// isEmpty returns true if the value is omitted by the omitempty option
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.String:
+		return value.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.Bool:
+		return !value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return value.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return value.Float() == 0
+	case reflect.Struct:
+		if value.Type() == timeType {
+			return value.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
+
+/****************************************************************\
+	Encoding
+\****************************************************************/
+
+// msgpackEncoder appends MessagePack encoded values to a buffer
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (enc *msgpackEncoder) writeUint(prefix byte, value uint64, size int) {
+	enc.buf = append(enc.buf, prefix)
+	for i := size - 1; i >= 0; i-- {
+		enc.buf = append(enc.buf, byte(value>>(uint(i)*8)))
+	}
+}
+
+func (enc *msgpackEncoder) encodeUint(value uint64) {
+	switch {
+	case value < 0x80:
+		enc.buf = append(enc.buf, byte(value))
+	case value <= math.MaxUint8:
+		enc.writeUint(0xcc, value, 1)
+	case value <= math.MaxUint16:
+		enc.writeUint(0xcd, value, 2)
+	case value <= math.MaxUint32:
+		enc.writeUint(0xce, value, 4)
+	default:
+		enc.writeUint(0xcf, value, 8)
+	}
+}
+
+func (enc *msgpackEncoder) encodeInt(value int64) {
+	switch {
+	case value >= 0:
+		enc.encodeUint(uint64(value))
+	case value >= -32:
+		enc.buf = append(enc.buf, byte(value))
+	case value >= math.MinInt8:
+		enc.writeUint(0xd0, uint64(value), 1)
+	case value >= math.MinInt16:
+		enc.writeUint(0xd1, uint64(value), 2)
+	case value >= math.MinInt32:
+		enc.writeUint(0xd2, uint64(value), 4)
+	default:
+		enc.writeUint(0xd3, uint64(value), 8)
+	}
+}
+
+// encodeLength writes the header of a string, binary, array or map
+func (enc *msgpackEncoder) encodeLength(
+	length int,
+	fixPrefix byte,
+	fixMax int,
+	prefix8 byte,
+	prefix16 byte,
+	prefix32 byte,
+) {
+	switch {
+	case length <= fixMax:
+		enc.buf = append(enc.buf, fixPrefix|byte(length))
+	case prefix8 != 0 && length <= math.MaxUint8:
+		enc.writeUint(prefix8, uint64(length), 1)
+	case length <= math.MaxUint16:
+		enc.writeUint(prefix16, uint64(length), 2)
+	default:
+		enc.writeUint(prefix32, uint64(length), 4)
+	}
+}
+
+func (enc *msgpackEncoder) encodeString(value string) {
+	enc.encodeLength(len(value), 0xa0, 31, 0xd9, 0xda, 0xdb)
+	enc.buf = append(enc.buf, value...)
+}
+
+func (enc *msgpackEncoder) encodeBinary(value []byte) {
+	enc.encodeLength(len(value), 0xc4, -1, 0xc4, 0xc5, 0xc6)
+	enc.buf = append(enc.buf, value...)
+}
+
+// encodeTime writes a timestamp using the 96-bit timestamp extension
+func (enc *msgpackEncoder) encodeTime(value time.Time) {
+	enc.buf = append(enc.buf, 0xc7, 12, byte(0xff))
+	var data [12]byte
+	binary.BigEndian.PutUint32(data[:4], uint32(value.Nanosecond()))
+	binary.BigEndian.PutUint64(data[4:], uint64(value.Unix()))
+	enc.buf = append(enc.buf, data[:]...)
+}
+
+func (enc *msgpackEncoder) encode(value reflect.Value) error {
+	switch value.Kind() {
+	case reflect.Invalid:
+		enc.buf = append(enc.buf, 0xc0)
+	case reflect.Bool:
+		if value.Bool() {
+			enc.buf = append(enc.buf, 0xc3)
+		} else {
+			enc.buf = append(enc.buf, 0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		enc.encodeInt(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		enc.encodeUint(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		enc.buf = append(enc.buf, 0xcb)
+		enc.buf = append(enc.buf, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(
+			enc.buf[len(enc.buf)-8:],
+			math.Float64bits(value.Float()),
+		)
+	case reflect.String:
+		enc.encodeString(value.String())
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			enc.buf = append(enc.buf, 0xc0)
+			return nil
+		}
+		return enc.encode(value.Elem())
+	case reflect.Slice:
+		if value.IsNil() {
+			enc.buf = append(enc.buf, 0xc0)
+			return nil
+		}
+		fallthrough
+	case reflect.Array:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, value.Len())
+			reflect.Copy(reflect.ValueOf(data), value)
+			enc.encodeBinary(data)
+			return nil
+		}
+		enc.encodeLength(value.Len(), 0x90, 15, 0, 0xdc, 0xdd)
+		for i := 0; i < value.Len(); i++ {
+			if err := enc.encode(value.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("msgpack: unsupported map key type %s",
+				value.Type().Key())
+		}
+		if value.IsNil() {
+			enc.buf = append(enc.buf, 0xc0)
+			return nil
+		}
+		enc.encodeLength(value.Len(), 0x80, 15, 0, 0xde, 0xdf)
+		for _, key := range value.MapKeys() {
+			enc.encodeString(key.String())
+			if err := enc.encode(value.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		if value.Type() == timeType {
+			enc.encodeTime(value.Interface().(time.Time))
+			return nil
+		}
+		fields := structFields(value.Type())
+		encoded := fields[:0:0]
+		for _, field := range fields {
+			if field.omitEmpty && isEmpty(value.Field(field.index)) {
+				continue
+			}
+			encoded = append(encoded, field)
+		}
+		enc.encodeLength(len(encoded), 0x80, 15, 0, 0xde, 0xdf)
+		for _, field := range encoded {
+			enc.encodeString(field.name)
+			if err := enc.encode(value.Field(field.index)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", value.Type())
+	}
+	return nil
+}
+
+// MarshalMsgpack encodes the given value using MessagePack.
+// Structs are encoded as maps keyed by the names of their JSON tags
+func MarshalMsgpack(value interface{}) ([]byte, error) {
+	enc := &msgpackEncoder{buf: make([]byte, 0, 64)}
+	if err := enc.encode(reflect.ValueOf(value)); err != nil {
+		return nil, err
+	}
+	return enc.buf, nil
+}
+
+/****************************************************************\
+	Decoding
+\****************************************************************/
+
+// msgpackDecoder parses MessagePack data into generic values:
+// nil, bool, int64, uint64, float64, string, []byte, time.Time,
+// []interface{} and map[string]interface{}
+type msgpackDecoder struct {
+	data []byte
+}
+
+func (dec *msgpackDecoder) read(size int) ([]byte, error) {
+	if size < 0 || len(dec.data) < size {
+		return nil, errMsgpackTruncated
+	}
+	read := dec.data[:size]
+	dec.data = dec.data[size:]
+	return read, nil
+}
+
+func (dec *msgpackDecoder) readUint(size int) (uint64, error) {
+	data, err := dec.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value, nil
+}
+
+// readLength reads a length and verifies that at least
+// minItemSize bytes per item remain to prevent huge allocations
+func (dec *msgpackDecoder) readLength(size int, minItemSize int) (int, error) {
+	length, err := dec.readUint(size)
+	if err != nil {
+		return 0, err
+	}
+	if length*uint64(minItemSize) > uint64(len(dec.data)) {
+		return 0, errMsgpackTruncated
+	}
+	return int(length), nil
+}
+
+func (dec *msgpackDecoder) decodeArray(length int) (interface{}, error) {
+	if length > len(dec.data) {
+		return nil, errMsgpackTruncated
+	}
+	items := make([]interface{}, length)
+	for i := range items {
+		item, err := dec.decode()
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+func (dec *msgpackDecoder) decodeMap(length int) (interface{}, error) {
+	if length*2 > len(dec.data) {
+		return nil, errMsgpackTruncated
+	}
+	items := make(map[string]interface{}, length)
+	for i := 0; i < length; i++ {
+		key, err := dec.decode()
+		if err != nil {
+			return nil, err
+		}
+		name, isString := key.(string)
+		if !isString {
+			return nil, errors.New("msgpack: map keys must be strings")
+		}
+		if items[name], err = dec.decode(); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+func (dec *msgpackDecoder) decodeExtension(length int) (interface{}, error) {
+	extType, err := dec.read(1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := dec.read(length)
+	if err != nil {
+		return nil, err
+	}
+	if int8(extType[0]) != msgpackTimestamp {
+		return nil, fmt.Errorf("msgpack: unsupported extension %d", extType[0])
+	}
+	// Timestamps don't carry a location, thus they're decoded as UTC
+	switch length {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC(), nil
+	case 8:
+		value := binary.BigEndian.Uint64(data)
+		return time.Unix(
+			int64(value&0x3ffffffff),
+			int64(value>>34),
+		).UTC(), nil
+	case 12:
+		return time.Unix(
+			int64(binary.BigEndian.Uint64(data[4:])),
+			int64(binary.BigEndian.Uint32(data[:4])),
+		).UTC(), nil
+	}
+	return nil, errors.New("msgpack: invalid timestamp")
+}
+
+func (dec *msgpackDecoder) decode() (interface{}, error) {
+	prefix, err := dec.read(1)
+	if err != nil {
+		return nil, err
+	}
+	b := prefix[0]
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xe0 == 0xa0:
+		data, err := dec.read(int(b & 0x1f))
+		return string(data), err
+	case b&0xf0 == 0x90:
+		return dec.decodeArray(int(b & 0x0f))
+	case b&0xf0 == 0x80:
+		return dec.decodeMap(int(b & 0x0f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return dec.readUint(1 << (b - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		value, err := dec.readUint(size)
+		shift := uint(64 - size*8)
+		return int64(value<<shift) >> shift, err
+	case 0xca:
+		value, err := dec.readUint(4)
+		return float64(math.Float32frombits(uint32(value))), err
+	case 0xcb:
+		value, err := dec.readUint(8)
+		return math.Float64frombits(value), err
+	case 0xd9, 0xda, 0xdb:
+		length, err := dec.readLength(1<<(b-0xd9), 1)
+		if err != nil {
+			return nil, err
+		}
+		data, err := dec.read(length)
+		return string(data), err
+	case 0xc4, 0xc5, 0xc6:
+		length, err := dec.readLength(1<<(b-0xc4), 1)
+		if err != nil {
+			return nil, err
+		}
+		data, err := dec.read(length)
+		return append([]byte(nil), data...), err
+	case 0xdc, 0xdd:
+		length, err := dec.readLength(2<<(b-0xdc), 1)
+		if err != nil {
+			return nil, err
+		}
+		return dec.decodeArray(length)
+	case 0xde, 0xdf:
+		length, err := dec.readLength(2<<(b-0xde), 2)
+		if err != nil {
+			return nil, err
+		}
+		return dec.decodeMap(length)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return dec.decodeExtension(1 << (b - 0xd4))
+	case 0xc7, 0xc8, 0xc9:
+		length, err := dec.readLength(1<<(b-0xc7), 1)
+		if err != nil {
+			return nil, err
+		}
+		return dec.decodeExtension(length)
+	}
+	return nil, fmt.Errorf("msgpack: invalid prefix 0x%x", b)
+}
+
+// assign stores a decoded generic value into the given target
+func assign(target reflect.Value, value interface{}) error {
+	if value == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+	mismatch := func() error {
+		return fmt.Errorf(
+			"msgpack: cannot decode %T into %s",
+			value,
+			target.Type(),
+		)
+	}
+
+	switch target.Kind() {
+	case reflect.Interface:
+		if target.NumMethod() > 0 {
+			return mismatch()
+		}
+		target.Set(reflect.ValueOf(value))
+	case reflect.Ptr:
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		return assign(target.Elem(), value)
+	case reflect.Bool:
+		flag, isBool := value.(bool)
+		if !isBool {
+			return mismatch()
+		}
+		target.SetBool(flag)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var number int64
+		switch value := value.(type) {
+		case int64:
+			number = value
+		case uint64:
+			if value > math.MaxInt64 {
+				return mismatch()
+			}
+			number = int64(value)
+		default:
+			return mismatch()
+		}
+		if target.OverflowInt(number) {
+			return mismatch()
+		}
+		target.SetInt(number)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		var number uint64
+		switch value := value.(type) {
+		case uint64:
+			number = value
+		case int64:
+			if value < 0 {
+				return mismatch()
+			}
+			number = uint64(value)
+		default:
+			return mismatch()
+		}
+		if target.OverflowUint(number) {
+			return mismatch()
+		}
+		target.SetUint(number)
+	case reflect.Float32, reflect.Float64:
+		switch value := value.(type) {
+		case float64:
+			target.SetFloat(value)
+		case int64:
+			target.SetFloat(float64(value))
+		case uint64:
+			target.SetFloat(float64(value))
+		default:
+			return mismatch()
+		}
+	case reflect.String:
+		text, isString := value.(string)
+		if !isString {
+			return mismatch()
+		}
+		target.SetString(text)
+	case reflect.Slice:
+		if data, isBinary := value.([]byte); isBinary &&
+			target.Type().Elem().Kind() == reflect.Uint8 {
+			target.SetBytes(data)
+			return nil
+		}
+		items, isArray := value.([]interface{})
+		if !isArray {
+			return mismatch()
+		}
+		slice := reflect.MakeSlice(target.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := assign(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+	case reflect.Array:
+		data, isBinary := value.([]byte)
+		if !isBinary || target.Type().Elem().Kind() != reflect.Uint8 ||
+			len(data) != target.Len() {
+			return mismatch()
+		}
+		reflect.Copy(target, reflect.ValueOf(data))
+	case reflect.Map:
+		items, isMap := value.(map[string]interface{})
+		if !isMap || target.Type().Key().Kind() != reflect.String {
+			return mismatch()
+		}
+		mapping := reflect.MakeMapWithSize(target.Type(), len(items))
+		for key, item := range items {
+			elem := reflect.New(target.Type().Elem()).Elem()
+			if err := assign(elem, item); err != nil {
+				return err
+			}
+			mapping.SetMapIndex(reflect.ValueOf(key).Convert(
+				target.Type().Key(),
+			), elem)
+		}
+		target.Set(mapping)
+	case reflect.Struct:
+		if target.Type() == timeType {
+			timestamp, isTime := value.(time.Time)
+			if !isTime {
+				return mismatch()
+			}
+			target.Set(reflect.ValueOf(timestamp))
+			return nil
+		}
+		items, isMap := value.(map[string]interface{})
+		if !isMap {
+			return mismatch()
+		}
+		for _, field := range structFields(target.Type()) {
+			item, exists := items[field.name]
+			if !exists {
+				continue
+			}
+			if err := assign(target.Field(field.index), item); err != nil {
+				return err
+			}
+		}
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+// UnmarshalMsgpack decodes MessagePack data into the value
+// the given pointer points to
+func UnmarshalMsgpack(data []byte, target interface{}) error {
+	pointer := reflect.ValueOf(target)
+	if pointer.Kind() != reflect.Ptr || pointer.IsNil() {
+		return errors.New("msgpack: target must be a non-nil pointer")
+	}
+	dec := &msgpackDecoder{data: data}
+	value, err := dec.decode()
+	if err != nil {
+		return err
+	}
+	if len(dec.data) > 0 {
+		return errors.New("msgpack: unexpected trailing data")
+	}
+	return assign(pointer.Elem(), value)
+}
@@ -0,0 +1,169 @@
+package shared
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// msgpackSample covers all kinds of values supported by the codec
+type msgpackSample struct {
+	Bool     bool              `json:"bool"`
+	Int      int               `json:"int"`
+	Int8     int8              `json:"int8"`
+	Int64    int64             `json:"int64"`
+	Uint16   uint16            `json:"uint16"`
+	Uint64   uint64            `json:"uint64"`
+	Float    float64           `json:"float"`
+	Text     string            `json:"text"`
+	Binary   []byte            `json:"binary"`
+	Key      [4]byte           `json:"key"`
+	List     []string          `json:"list"`
+	Mapping  map[string]int    `json:"mapping"`
+	Nested   *msgpackSample    `json:"nested"`
+	Time     time.Time         `json:"time"`
+	Omitted  string            `json:"omitted,omitempty"`
+	Renamed  RoomAccess        `json:"access"`
+	Any      interface{}       `json:"any"`
+	Untagged map[string]string `json:"-"`
+}
+
+// TestMsgpackRoundTrip tests decoding encoded values into equal values
+func TestMsgpackRoundTrip(t *testing.T) {
+	for name, value := range map[string]msgpackSample{
+		"zero": {},
+		"small": {
+			Bool:   true,
+			Int:    -32,
+			Int8:   127,
+			Int64:  5,
+			Uint16: 128,
+			Uint64: 127,
+			Float:  0.5,
+			Text:   "Gandalf",
+			Binary: []byte{0, 1, 2},
+			Key:    [4]byte{1, 2, 3, 4},
+			List:   []string{"lobby", "council"},
+			Time:   time.Unix(1500000000, 0).UTC(),
+		},
+		"large": {
+			Int:     math.MinInt64,
+			Int8:    math.MinInt8,
+			Int64:   math.MaxInt64,
+			Uint16:  math.MaxUint16,
+			Uint64:  math.MaxUint64,
+			Float:   -math.MaxFloat64,
+			Text:    strings.Repeat("x", 70000),
+			Binary:  bytes.Repeat([]byte{0xff}, 300),
+			List:    make([]string, 20),
+			Mapping: map[string]int{"a": 1, "b": -1, "c": 1 << 40},
+			Time:    time.Unix(1<<40, 123456789).UTC(),
+			Omitted: "not omitted",
+			Renamed: RoomAnnouncement,
+			Any:     "any",
+			Nested: &msgpackSample{
+				Text: strings.Repeat("y", 200),
+				Time: time.Unix(1500000000, 1).UTC(),
+			},
+		},
+	} {
+		encoded, err := MarshalMsgpack(value)
+		if err != nil {
+			t.Fatalf("%s: marshaling failed: %s", name, err)
+		}
+		var decoded msgpackSample
+		if err := UnmarshalMsgpack(encoded, &decoded); err != nil {
+			t.Fatalf("%s: unmarshaling failed: %s", name, err)
+		}
+		if !reflect.DeepEqual(decoded, value) {
+			t.Fatalf("%s: decoded %+v, expected %+v", name, decoded, value)
+		}
+	}
+}
+
+// TestMsgpackEncoding tests the encoding of values
+// against the MessagePack specification
+func TestMsgpackEncoding(t *testing.T) {
+	for _, test := range []struct {
+		value    interface{}
+		expected []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{uint8(1), []byte{0x01}},
+		{-1, []byte{0xff}},
+		{256, []byte{0xcd, 0x01, 0x00}},
+		{"a", []byte{0xa1, 'a'}},
+		{[]byte{7}, []byte{0xc4, 0x01, 0x07}},
+		{[]int{1}, []byte{0x91, 0x01}},
+		{ChatMessage{User: "a"}, []byte{
+			0x83,
+			0xa4, 'r', 'o', 'o', 'm', 0xa0,
+			0xa4, 'u', 's', 'e', 'r', 0xa1, 'a',
+			0xa3, 'm', 's', 'g', 0xa0,
+		}},
+		{time.Unix(1, 2), []byte{
+			0xc7, 0x0c, 0xff,
+			0, 0, 0, 2,
+			0, 0, 0, 0, 0, 0, 0, 1,
+		}},
+	} {
+		encoded, err := MarshalMsgpack(test.value)
+		if err != nil {
+			t.Fatalf("marshaling %#v failed: %s", test.value, err)
+		}
+		if !bytes.Equal(encoded, test.expected) {
+			t.Fatalf("%#v encoded as % x, expected % x",
+				test.value, encoded, test.expected)
+		}
+	}
+
+	if _, err := MarshalMsgpack(map[int]string{1: "a"}); err == nil {
+		t.Fatal("map with integer keys was marshaled")
+	}
+	if _, err := MarshalMsgpack(make(chan int)); err == nil {
+		t.Fatal("channel was marshaled")
+	}
+}
+
+// TestMsgpackMalformed tests rejecting malformed data
+// and values not matching the target type
+func TestMsgpackMalformed(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":              {},
+		"invalid prefix":     {0xc1},
+		"truncated string":   {0xa5, 'a'},
+		"truncated str8":     {0xd9},
+		"truncated uint":     {0xcd, 0x01},
+		"truncated array":    {0x92, 0x01},
+		"truncated map":      {0x81, 0xa1, 'a'},
+		"huge array length":  {0xdd, 0xff, 0xff, 0xff, 0xff},
+		"huge map length":    {0xdf, 0xff, 0xff, 0xff, 0xff},
+		"huge binary length": {0xc6, 0xff, 0xff, 0xff, 0xff},
+		"non-string key":     {0x81, 0x01, 0x01},
+		"trailing data":      {0x80, 0x00},
+		"unknown extension":  {0xd4, 0x01, 0x00},
+		"invalid timestamp":  {0xc7, 0x03, 0xff, 0, 0, 0},
+		"type mismatch":      {0x81, 0xa3, 'i', 'n', 't', 0xa1, 'a'},
+		"int overflow":       {0x81, 0xa4, 'i', 'n', 't', '8', 0xcd, 0x01, 0x00},
+		"negative uint":      {0x81, 0xa6, 'u', 'i', 'n', 't', '6', '4', 0xff},
+		"key length":         {0x81, 0xa3, 'k', 'e', 'y', 0xc4, 0x01, 0x00},
+		"time mismatch":      {0x81, 0xa4, 't', 'i', 'm', 'e', 0x01},
+	} {
+		var decoded msgpackSample
+		if err := UnmarshalMsgpack(data, &decoded); err == nil {
+			t.Fatalf("%s: malformed data decoded: %+v", name, decoded)
+		}
+	}
+
+	var decoded msgpackSample
+	if err := UnmarshalMsgpack([]byte{0x80}, decoded); err == nil {
+		t.Fatal("decoded into a non-pointer")
+	}
+	if err := UnmarshalMsgpack([]byte{0x80}, (*msgpackSample)(nil)); err == nil {
+		t.Fatal("decoded into a nil pointer")
+	}
+}
@@ -0,0 +1,10 @@
+package shared
+
+// OutboxMetrics represents the number of signals the server couldn't deliver
+// to clients either due to full outbound queues or transmission failures
+type OutboxMetrics struct {
+	DroppedOldest uint64 `json:"droppedOldest"`
+	DroppedNewest uint64 `json:"droppedNewest"`
+	Disconnected  uint64 `json:"disconnected"`
+	Failed        uint64 `json:"failed"`
+}
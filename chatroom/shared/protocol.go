@@ -0,0 +1,141 @@
+package shared
+
+// ProtocolVersion defines the version of the chatroom protocol implemented
+// by this package. It's incremented on every incompatible change
+const ProtocolVersion = 1
+
+// MinProtocolVersion defines the oldest protocol version
+// still supported by the server
+const MinProtocolVersion = 1
+
+// SubProtocolName defines the default websocket sub-protocol name
+const SubProtocolName = "chatroom-example-protocol"
+
+// Request names. Each request must be preceded by a successful handshake
+// on the same connection. Payloads are JSON encoded unless noted otherwise
+const (
+	// RequestHandshake negotiates the protocol version.
+	// Payload: HandshakeRequest, reply: HandshakeReply
+	RequestHandshake = "handshake"
+
+	// RequestAuth signs in. Payload: AuthenticationCredentials
+	RequestAuth = "auth"
+
+	// RequestMessage posts a message to the current room.
+	// Payload: the message text
+	RequestMessage = "msg"
+
+	// RequestJoin moves the client into another room.
+	// Payload: the room name, reply: RoomMetadata
+	RequestJoin = "join"
+
+	// RequestSetTopic changes the topic of a room.
+	// Payload: SetTopicRequest, reply: RoomMetadata
+	RequestSetTopic = "set-topic"
+
+	// RequestPin pins a message to a room.
+	// Payload: PinRequest, reply: RoomMetadata
+	RequestPin = "pin"
+
+	// RequestUnpin removes a pinned message from a room.
+	// Payload: UnpinRequest, reply: RoomMetadata
+	RequestUnpin = "unpin"
+
+	// RequestInvite invites a user to an invite-only room.
+	// Payload: InviteRequest, reply: RoomMetadata
+	RequestInvite = "invite"
+
+	// RequestKick disconnects all connections of a user.
+	// Payload: the username
+	RequestKick = "kick"
+
+	// RequestBan bans an account disconnecting all of its connections.
+	// Payload: BanRequest
+	RequestBan = "ban"
+
+	// RequestUnban lifts the ban of an account. Payload: the username
+	RequestUnban = "unban"
+
+	// RequestBans lists all banned accounts. Reply: []Ban
+	RequestBans = "bans"
+
+	// RequestLockouts lists all lockouts. Reply: []Lockout
+	RequestLockouts = "lockouts"
+
+	// RequestClearLockout lifts the lockout of an account or address.
+	// Payload: the account name or address
+	RequestClearLockout = "clear-lockout"
+
+	// RequestDevices lists the connections of the signed in user.
+	// Reply: []Device
+	RequestDevices = "devices"
+
+	// RequestSignoutDevice signs out one of the user's devices.
+	// Payload: the decimal device ID
+	RequestSignoutDevice = "signout-device"
+
+	// RequestDirectMessage sends an encrypted direct message.
+	// Payload: binary encoded DirectMessage
+	RequestDirectMessage = "dm"
+
+	// RequestPublishKey publishes the public key of the signed in user.
+	// Payload: the binary public key
+	RequestPublishKey = "publish-key"
+
+	// RequestPublicKey looks up the public key of a user.
+	// Payload: the username, reply: the binary public key
+	RequestPublicKey = "public-key"
+
+	// RequestWho lists the online users and guests. Reply: []string
+	RequestWho = "who"
+
+	// RequestGuests lists the anonymous guests connected to the server.
+	// Reply: []Guest
+	RequestGuests = "guests"
+
+	// RequestMetrics returns the delivery metrics. Reply: OutboxMetrics
+	RequestMetrics = "metrics"
+)
+
+// Signal names
+const (
+	// SignalChat delivers a chat message. Payload: ChatMessage
+	SignalChat = ""
+
+	// SignalDirectMessage delivers an encrypted direct message.
+	// Payload: binary encoded DirectMessage
+	SignalDirectMessage = "dm"
+
+	// SignalRoom notifies about a changed room. Payload: RoomMetadata
+	SignalRoom = "room"
+
+	// SignalJoined tells a new connection which room it was joined to.
+	// Payload: RoomMetadata
+	SignalJoined = "joined"
+
+	// SignalShutdown announces a server shutdown. Payload: ShutdownNotice
+	SignalShutdown = "shutdown"
+)
+
+// HandshakeRequest represents the payload of a handshake request
+type HandshakeRequest struct {
+	// Version is the protocol version implemented by the client
+	Version int `json:"version"`
+
+	// Client optionally identifies the client software
+	Client string `json:"client,omitempty"`
+}
+
+// Validate implements the Validator interface
+func (req *HandshakeRequest) Validate() error {
+	if req.Version < 1 {
+		return FieldError{"version", "must be a positive protocol version"}
+	}
+	return nil
+}
+
+// HandshakeReply represents the reply to a successful handshake
+type HandshakeReply struct {
+	// Version is the protocol version implemented by the server
+	Version int `json:"version"`
+}
@@ -0,0 +1,42 @@
+package shared
+
+import "time"
+
+// RoomAccess defines who's allowed to join and post in a room
+type RoomAccess string
+
+const (
+	// RoomPublic allows everyone to join and post
+	RoomPublic RoomAccess = "public"
+
+	// RoomInviteOnly allows only invited users and moderators to join
+	RoomInviteOnly RoomAccess = "invite-only"
+
+	// RoomAnnouncement allows everyone to join but only moderators to post
+	RoomAnnouncement RoomAccess = "announcement"
+)
+
+// Valid returns true if the access mode is one of the known modes
+func (acc RoomAccess) Valid() bool {
+	switch acc {
+	case RoomPublic, RoomInviteOnly, RoomAnnouncement:
+		return true
+	}
+	return false
+}
+
+// PinnedMessage represents a message pinned to a room by a moderator
+type PinnedMessage struct {
+	ID   uint64    `json:"id"`
+	User string    `json:"user"`
+	Msg  string    `json:"msg"`
+	Time time.Time `json:"time"`
+}
+
+// RoomMetadata represents the publicly visible metadata of a room
+type RoomMetadata struct {
+	Name   string          `json:"name"`
+	Topic  string          `json:"topic"`
+	Access RoomAccess      `json:"access"`
+	Pinned []PinnedMessage `json:"pinned"`
+}
@@ -0,0 +1,71 @@
+package shared
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// SetTopicRequest represents the payload of a set-topic request
+type SetTopicRequest struct {
+	Room  string `json:"room"`
+	Topic string `json:"topic"`
+}
+
+// PinRequest represents the payload of a pin request
+type PinRequest struct {
+	Room string `json:"room"`
+	Msg  string `json:"msg"`
+}
+
+// UnpinRequest represents the payload of an unpin request
+type UnpinRequest struct {
+	Room string `json:"room"`
+	ID   uint64 `json:"id"`
+}
+
+// InviteRequest represents the payload of an invite request
+type InviteRequest struct {
+	Room string `json:"room"`
+	User string `json:"user"`
+}
+
+// Validate implements the Validator interface
+func (req *SetTopicRequest) Validate() error {
+	if err := ValidateName("room", req.Room); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(req.Topic) > MaxTopicLength {
+		return FieldError{
+			"topic",
+			fmt.Sprintf("exceeds %d characters", MaxTopicLength),
+		}
+	}
+	return nil
+}
+
+// Validate implements the Validator interface
+func (req *PinRequest) Validate() error {
+	if err := ValidateName("room", req.Room); err != nil {
+		return err
+	}
+	return ValidateText("msg", req.Msg, MaxMessageLength)
+}
+
+// Validate implements the Validator interface
+func (req *UnpinRequest) Validate() error {
+	if err := ValidateName("room", req.Room); err != nil {
+		return err
+	}
+	if req.ID < 1 {
+		return FieldError{"id", "is missing"}
+	}
+	return nil
+}
+
+// Validate implements the Validator interface
+func (req *InviteRequest) Validate() error {
+	if err := ValidateName("room", req.Room); err != nil {
+		return err
+	}
+	return ValidateName("user", req.User)
+}
@@ -2,12 +2,17 @@ package shared
 
 import webwire "github.com/qbeon/webwire-go"
 
-var sessionInfoFieldNames = []string{"username"}
+var sessionInfoFieldNames = []string{"username", "roles", "codec"}
 
 // SessionInfo implements the webwire.SessionInfo interface
 // for this particular example
 type SessionInfo struct {
 	Username string
+	Roles    []string
+
+	// Codec is the name of the codec selected during authentication.
+	// It applies to all connections the session is restored on
+	Codec string
 }
 
 // Copy implements the webwire.SessionInfo interface.
@@ -15,6 +20,8 @@ type SessionInfo struct {
 func (sinf *SessionInfo) Copy() webwire.SessionInfo {
 	return &SessionInfo{
 		Username: sinf.Username,
+		Roles:    copyStrings(sinf.Roles),
+		Codec:    sinf.Codec,
 	}
 }
 
@@ -30,14 +37,60 @@ func (sinf *SessionInfo) Value(fieldName string) interface{} {
 	switch fieldName {
 	case "username":
 		return sinf.Username
+	case "roles":
+		return copyStrings(sinf.Roles)
+	case "codec":
+		return sinf.Codec
 	}
 	return nil
 }
 
+// HasRole returns true if any of the given roles
+// is assigned to the session owner
+func (sinf *SessionInfo) HasRole(roles ...string) bool {
+	for _, assigned := range sinf.Roles {
+		for _, role := range roles {
+			if assigned == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // SessionInfoParser parses the given session info data into a
 // webwire.SessionInfo compliant object specific to this application
 func SessionInfoParser(data map[string]interface{}) webwire.SessionInfo {
-	return &SessionInfo{
+	info := &SessionInfo{
 		Username: data["username"].(string),
 	}
+
+	// Sessions created by older servers don't store the codec
+	info.Codec, _ = data["codec"].(string)
+
+	// Roles are either a string slice when the session was just created
+	// or a slice of variants when parsed from a serialized session
+	switch roles := data["roles"].(type) {
+	case []string:
+		info.Roles = copyStrings(roles)
+	case []interface{}:
+		info.Roles = make([]string, 0, len(roles))
+		for _, role := range roles {
+			if name, isString := role.(string); isString {
+				info.Roles = append(info.Roles, name)
+			}
+		}
+	}
+
+	return info
+}
+
+// copyStrings returns a copy of the given string slice
+func copyStrings(original []string) []string {
+	if original == nil {
+		return nil
+	}
+	cpy := make([]string, len(original))
+	copy(cpy, original)
+	return cpy
 }
@@ -0,0 +1,16 @@
+package shared
+
+import "time"
+
+// ShutdownNotice represents the notice sent to all clients
+// when the server is about to shut down
+type ShutdownNotice struct {
+	Reason string `json:"reason"`
+
+	// ShutdownAt is the time at which the server will close all connections
+	ShutdownAt time.Time `json:"shutdownAt"`
+
+	// BackAt is the expected time at which the server will be available again.
+	// It's zero if unknown
+	BackAt time.Time `json:"backAt,omitempty"`
+}
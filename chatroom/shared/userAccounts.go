@@ -0,0 +1,23 @@
+package shared
+
+// UserAccounts maps the usernames of the predefined example accounts
+// to their passwords. The server authenticates against these accounts
+// and the load generator signs its clients in using them
+var UserAccounts = map[string]string{
+	"Gandalf":   "gandalf1234",
+	"Sauron":    "sauron1234",
+	"Galadriel": "galadriel1234",
+	"Legolas":   "legolas1234",
+	"Aragorn":   "aragorn1234",
+	"Melkor":    "melkor1234",
+	"Thranduil": "thranduil1234",
+	"Frodo":     "frodo1234",
+	"Samweis":   "samweis1234",
+	"Gollum":    "gollum1234",
+	"Gimli":     "gimli1234",
+	"Peregrin":  "peregrin1234",
+	"Meriadoc":  "meriadoc1234",
+	"Boromir":   "boromir1234",
+	"Saruman":   "saruman1234",
+	"Elrond":    "elrond1234",
+}
@@ -0,0 +1,79 @@
+package shared
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits of the request fields
+const (
+	// MaxNameLength defines the maximum length of user and room names
+	MaxNameLength = 64
+
+	// MaxTopicLength defines the maximum length of a room topic
+	MaxTopicLength = 200
+
+	// MaxMessageLength defines the maximum length of a message
+	MaxMessageLength = 4096
+)
+
+// Validator is implemented by request payloads
+// verifying their fields after decoding
+type Validator interface {
+	Validate() error
+}
+
+// FieldError represents an invalid field of a request payload
+type FieldError struct {
+	Field   string
+	Problem string
+}
+
+// Error implements the error interface
+func (err FieldError) Error() string {
+	return fmt.Sprintf("%s %s", err.Field, err.Problem)
+}
+
+// Code returns the request error code of the field error
+// such as INVALID_ROOM for the field "room"
+func (err FieldError) Code() string {
+	return "INVALID_" + strings.ToUpper(
+		strings.Replace(err.Field, "-", "_", -1),
+	)
+}
+
+// ValidateName verifies a user or room name in the given field
+func ValidateName(field, name string) error {
+	if name == "" {
+		return FieldError{field, "is missing"}
+	}
+	if utf8.RuneCountInString(name) > MaxNameLength {
+		return FieldError{
+			field,
+			fmt.Sprintf("exceeds %d characters", MaxNameLength),
+		}
+	}
+	for _, char := range name {
+		if unicode.IsSpace(char) || !unicode.IsPrint(char) {
+			return FieldError{field, "must not contain whitespace"}
+		}
+	}
+	return nil
+}
+
+// ValidateText verifies a text in the given field
+// that must neither be empty nor exceed the given length
+func ValidateText(field, text string, maxLength int) error {
+	if strings.TrimSpace(text) == "" {
+		return FieldError{field, "is missing"}
+	}
+	if utf8.RuneCountInString(text) > maxLength {
+		return FieldError{
+			field,
+			fmt.Sprintf("exceeds %d characters", maxLength),
+		}
+	}
+	return nil
+}
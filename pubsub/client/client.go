@@ -2,37 +2,157 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	wwr "github.com/qbeon/webwire-go"
 	wwrclt "github.com/qbeon/webwire-go-client"
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
 	wwrgorilla "github.com/qbeon/webwire-go-gorilla"
 )
 
 var serverAddr = flag.String("addr", ":8081", "server address")
 var counterTarget = flag.Uint("n", 6, "number of signals to listen for")
 
+// Accept -user and -password CLI parameters defining the credentials
+// the client authenticates with, the client stays anonymous if empty
+var username = flag.String("user", "", "name of the user to authenticate as")
+var password = flag.String("password", "", "password of the user")
+
+// Accept -publish CLI parameter defining a JSON encoded message to publish
+// to the topics instead of subscribing to them
+var publishMessage = flag.String(
+	"publish",
+	"",
+	"JSON encoded message to publish to the topics",
+)
+
+// Accept -retain CLI parameter making the server retain
+// the published message
+var retainMessage = flag.Bool(
+	"retain",
+	false,
+	"make the server retain the published message for new subscribers",
+)
+
+// Accept -ttl CLI parameter defining the time to live
+// of the published message
+var messageTTL = flag.Duration(
+	"ttl",
+	0,
+	"time to live of the published message, 0 never expires",
+)
+
+// Accept -reliable CLI parameter enabling reliable delivery
+var reliableDelivery = flag.Bool(
+	"reliable",
+	false,
+	"acknowledge deliveries and have unacknowledged ones retransmitted",
+)
+
+// Accept -durable CLI parameter naming the durable subscriptions
+var durableName = flag.String(
+	"durable",
+	"",
+	"name of the durable subscriptions resumed after reconnecting",
+)
+
+// Accept -offset CLI parameter defining the log offset to replay from
+var replayOffset = flag.Uint64(
+	"offset",
+	0,
+	"replay the logged messages starting at the given offset",
+)
+
+// Accept -since CLI parameter defining the time to replay from
+var replaySince = flag.String(
+	"since",
+	"",
+	"replay the logged messages published since the given RFC 3339 time",
+)
+
+// Accept -filter CLI parameter defining an expression over the message
+// fields the server filters the messages by
+var messageFilter = flag.String(
+	"filter",
+	"",
+	"expression over the message fields to filter the messages by",
+)
+
+// topicList implements the flag.Value interface
+// allowing the -topic flag to be repeated
+type topicList []string
+
+// String implements the flag.Value interface
+func (list *topicList) String() string {
+	return strings.Join(*list, ",")
+}
+
+// Set implements the flag.Value interface
+func (list *topicList) Set(topic string) error {
+	if err := shared.ValidatePattern(topic); err != nil {
+		return err
+	}
+	*list = append(*list, topic)
+	return nil
+}
+
+// Accept repeated -topic CLI parameters defining the subscribed topics
+// or patterns, defaults to the "time" topic
+var topics topicList
+
+func init() {
+	flag.Var(
+		&topics,
+		"topic",
+		"topic or pattern to subscribe to, may be repeated",
+	)
+}
+
 // PubSubClient implements the wwrclt.Implementation interface
 type PubSubClient struct {
 	connection    wwrclt.Client
+	topics        []string
+	durable       string
+	filter        string
+	reliable      bool
+	sequences     *shared.SequenceWindow
 	target        uint
 	counter       uint
 	targetReached sync.WaitGroup
+
+	// offsets maps the subscribed patterns to the highest offset
+	// of the logged messages received through them
+	offsets    map[string]uint64
+	offsetLock sync.Mutex
 }
 
 // NewPubSubClient constructs and returns a new pub-sub client instance
 func NewPubSubClient(
 	serverAddr url.URL,
+	topics []string,
+	durable string,
+	filter string,
+	reliable bool,
 	counterTarget uint,
 ) (*PubSubClient, error) {
 	newPubSubClient := &PubSubClient{
+		topics:        topics,
+		durable:       durable,
+		filter:        filter,
+		reliable:      reliable,
+		sequences:     shared.NewSequenceWindow(),
 		target:        counterTarget,
 		counter:       0,
 		targetReached: sync.WaitGroup{},
+		offsets:       make(map[string]uint64),
 	}
 
 	newPubSubClient.targetReached.Add(int(counterTarget))
@@ -58,8 +178,125 @@ func NewPubSubClient(
 	return newPubSubClient, nil
 }
 
-// OnDisconnected implements the wwrclt.Implementation interface
-func (clt *PubSubClient) OnDisconnected() {}
+// Subscribe subscribes to all topics of the client
+// enabling reliable delivery first if demanded.
+// The subscriptions replay the logged messages starting at the given
+// offset or time if any is given
+func (clt *PubSubClient) Subscribe(offset *uint64, since *time.Time) error {
+	if clt.reliable {
+		if _, err := clt.connection.Request(
+			context.Background(),
+			[]byte(shared.RequestReliable),
+			wwr.Payload{},
+		); err != nil {
+			return fmt.Errorf("Couldn't enable reliable delivery: %s", err)
+		}
+	}
+	for _, topic := range clt.topics {
+		encoded, err := json.Marshal(shared.SubscribeRequest{
+			Pattern: topic,
+			Durable: clt.durable,
+			Offset:  offset,
+			Since:   since,
+			Filter:  clt.filter,
+		})
+		if err != nil {
+			return fmt.Errorf("Couldn't marshal subscribe request: %s", err)
+		}
+		if _, err := clt.connection.Request(
+			context.Background(),
+			[]byte(shared.RequestSubscribe),
+			wwr.Payload{
+				Encoding: wwr.EncodingUtf8,
+				Data:     encoded,
+			},
+		); err != nil {
+			return fmt.Errorf("Couldn't subscribe to %s: %s", topic, err)
+		}
+		log.Printf("Subscribed to %s", topic)
+	}
+	return nil
+}
+
+// Authenticate authenticates the client creating a session.
+// The session is restored automatically after reconnecting
+func (clt *PubSubClient) Authenticate(username, password string) error {
+	encoded, err := json.Marshal(shared.Credentials{
+		Username: username,
+		Password: password,
+	})
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal credentials: %s", err)
+	}
+	if _, err := clt.connection.Request(
+		context.Background(),
+		[]byte(shared.RequestAuth),
+		wwr.Payload{
+			Encoding: wwr.EncodingUtf8,
+			Data:     encoded,
+		},
+	); err != nil {
+		return fmt.Errorf("Couldn't authenticate: %s", err)
+	}
+	log.Printf("Authenticated as %s", username)
+	return nil
+}
+
+// Publish publishes the JSON encoded message to all topics of the client.
+// The message expires after the given time to live unless it's 0
+func (clt *PubSubClient) Publish(
+	message []byte,
+	ttl time.Duration,
+	retain bool,
+) error {
+	for _, topic := range clt.topics {
+		encoded, err := json.Marshal(shared.PublishRequest{
+			Topic:   topic,
+			Message: message,
+			Retain:  retain,
+			TTL:     uint64(ttl / time.Millisecond),
+		})
+		if err != nil {
+			return fmt.Errorf("Couldn't marshal publish request: %s", err)
+		}
+		if _, err := clt.connection.Request(
+			context.Background(),
+			[]byte(shared.RequestPublish),
+			wwr.Payload{
+				Encoding: wwr.EncodingUtf8,
+				Data:     encoded,
+			},
+		); err != nil {
+			return fmt.Errorf("Couldn't publish to %s: %s", topic, err)
+		}
+		log.Printf("Published to %s", topic)
+	}
+	return nil
+}
+
+// resubscribe renews all subscriptions after the connection was lost
+// retrying until the connection is reestablished.
+// Durable subscriptions resume where they left off
+func (clt *PubSubClient) resubscribe() {
+	for {
+		err := clt.Subscribe(nil, nil)
+		if err == nil {
+			return
+		}
+		log.Printf("Resubscription failed: %s", err)
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// OnDisconnected implements the wwrclt.Implementation interface.
+// Subscriptions are bound to the connection and must be renewed
+// after reconnecting. Sequence numbers start over for every connection
+// while offsets are kept to skip logged messages redelivered
+// after reconnecting
+func (clt *PubSubClient) OnDisconnected() {
+	clt.sequences.Reset()
+	go clt.resubscribe()
+}
 
 // OnSessionClosed implements the wwrclt.Implementation interface
 func (clt *PubSubClient) OnSessionClosed() {}
@@ -67,14 +304,83 @@ func (clt *PubSubClient) OnSessionClosed() {}
 // OnSessionCreated implements the wwrclt.Implementation interface
 func (clt *PubSubClient) OnSessionCreated(_ *wwr.Session) {}
 
-// OnSignal implements the wwrclt.Implementation interface
+// ack acknowledges the receipt of a reliable delivery
+func (clt *PubSubClient) ack(seq uint64) {
+	if err := clt.connection.Signal(
+		context.Background(),
+		[]byte(shared.SignalAck),
+		wwr.Payload{
+			Encoding: wwr.EncodingUtf8,
+			Data:     []byte(strconv.FormatUint(seq, 10)),
+		},
+	); err != nil {
+		log.Printf("Couldn't acknowledge delivery %d: %s", seq, err)
+	}
+}
+
+// acceptOffset returns true if the logged message of the topic
+// wasn't received before and raises the highest offsets of the patterns
+// matching the topic. The server delivers the logged messages of
+// a subscription in the order of their offsets and only delivers them again
+// when a durable subscription resumes after reconnecting, so a message
+// is a duplicate if its offset doesn't exceed the highest offsets received
+// through all of the matching patterns
+func (clt *PubSubClient) acceptOffset(topic string, offset uint64) bool {
+	clt.offsetLock.Lock()
+	defer clt.offsetLock.Unlock()
+
+	accepted := false
+	for _, pattern := range clt.topics {
+		if !shared.MatchTopic(pattern, topic) {
+			continue
+		}
+		if clt.offsets[pattern] < offset {
+			clt.offsets[pattern] = offset
+			accepted = true
+		}
+	}
+	return accepted
+}
+
+// OnSignal implements the wwrclt.Implementation interface.
+// Reliable deliveries are acknowledged and duplicates are skipped,
+// retransmissions by their sequence number and logged messages
+// delivered again after reconnecting by their offset
 func (clt *PubSubClient) OnSignal(msg wwr.Message) {
+	message := msg.Payload()
+	if clt.reliable {
+		var delivery shared.Delivery
+		if err := json.Unmarshal(message, &delivery); err != nil {
+			log.Printf("Couldn't decode delivery: %s", err)
+			return
+		}
+
+		// The acknowledgement is sent asynchronously to not block
+		// the connection while reconnecting
+		go clt.ack(delivery.Seq)
+
+		if !clt.sequences.Accept(delivery.Seq) {
+			log.Printf("Skipped duplicate delivery %d", delivery.Seq)
+			return
+		}
+		if delivery.Offset > 0 &&
+			!clt.acceptOffset(string(msg.Name()), delivery.Offset) {
+			log.Printf("Skipped duplicate message %d", delivery.Offset)
+			return
+		}
+		message = delivery.Message
+	}
+
+	if clt.counter >= clt.target {
+		return
+	}
 	clt.counter++
 	log.Printf(
-		"Signal %d of %d received: %s",
+		"Signal %d of %d received (%s): %s",
 		clt.counter,
 		clt.target,
-		string(msg.Payload()),
+		string(msg.Name()),
+		string(message),
 	)
 	clt.targetReached.Done()
 }
@@ -88,9 +394,19 @@ func (clt *PubSubClient) AwaitCounterTargetReached() {
 func main() {
 	// Parse command line arguments
 	flag.Parse()
+	if len(topics) < 1 {
+		topics = topicList{"time"}
+	}
 
 	// Initialize a new pub-sub client instance
-	client, err := NewPubSubClient(url.URL{Host: *serverAddr}, *counterTarget)
+	client, err := NewPubSubClient(
+		url.URL{Host: *serverAddr},
+		topics,
+		*durableName,
+		*messageFilter,
+		*reliableDelivery,
+		*counterTarget,
+	)
 	if err != nil {
 		panic(err)
 	}
@@ -100,6 +416,49 @@ func main() {
 		log.Fatalf("Couldn't establish a connection: %s", err)
 	}
 
+	// Authenticate if credentials are given
+	if *username != "" {
+		if err := client.Authenticate(*username, *password); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Publish the message and disconnect if demanded
+	if *publishMessage != "" {
+		if !json.Valid([]byte(*publishMessage)) {
+			log.Fatal("The published message must be valid JSON")
+		}
+		if *messageTTL < 0 {
+			log.Fatal("The time to live must not be negative")
+		}
+		if err := client.Publish(
+			[]byte(*publishMessage),
+			*messageTTL,
+			*retainMessage,
+		); err != nil {
+			log.Fatal(err)
+		}
+		client.connection.Close()
+		return
+	}
+
+	// Subscribe to the topics
+	var offset *uint64
+	var since *time.Time
+	if *replayOffset > 0 {
+		offset = replayOffset
+	}
+	if *replaySince != "" {
+		parsed, err := time.Parse(time.RFC3339, *replaySince)
+		if err != nil {
+			log.Fatalf("Invalid replay time: %s", err)
+		}
+		since = &parsed
+	}
+	if err := client.Subscribe(offset, since); err != nil {
+		log.Fatal(err)
+	}
+
 	// Wait until N signals are received before disconnecting
 	client.AwaitCounterTargetReached()
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// Access control rule subjects
+const (
+	// subjectEveryone applies to all connections
+	subjectEveryone = "*"
+
+	// subjectAnonymous applies to connections without a session
+	subjectAnonymous = "anonymous"
+
+	// subjectUserPrefix prefixes the name of the user a rule applies to
+	subjectUserPrefix = "user:"
+
+	// subjectRolePrefix prefixes the name of the role a rule applies to
+	subjectRolePrefix = "role:"
+)
+
+// aclUser represents a user account of the access control file
+type aclUser struct {
+	Password string   `json:"password"`
+	Roles    []string `json:"roles"`
+}
+
+// aclRule grants the subject the rights on all topics matching the pattern
+type aclRule struct {
+	Subject   string `json:"subject"`
+	Pattern   string `json:"pattern"`
+	Publish   bool   `json:"publish"`
+	Subscribe bool   `json:"subscribe"`
+}
+
+// accessControl represents the user accounts and the rules granting them
+// rights on topics. Everything not granted by a rule is denied
+type accessControl struct {
+	Users map[string]aclUser `json:"users"`
+	Rules []aclRule          `json:"rules"`
+}
+
+// loadAccessControl loads and validates the access control file.
+// Without a file everyone may subscribe to all topics but nobody may publish
+func loadAccessControl(filePath string) (*accessControl, error) {
+	contents, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return &accessControl{
+			Rules: []aclRule{{
+				Subject:   subjectEveryone,
+				Pattern:   shared.WildcardMulti,
+				Subscribe: true,
+			}},
+		}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Couldn't read access control file: %s", err)
+	}
+	acl := &accessControl{}
+	if err := json.Unmarshal(contents, acl); err != nil {
+		return nil, fmt.Errorf("Couldn't parse access control file: %s", err)
+	}
+
+	for name, user := range acl.Users {
+		if name == "" || user.Password == "" {
+			return nil, fmt.Errorf(
+				"User '%s' requires a name and a password",
+				name,
+			)
+		}
+	}
+	for i, rule := range acl.Rules {
+		if err := shared.ValidatePattern(rule.Pattern); err != nil {
+			return nil, fmt.Errorf("Rule %d: %s", i+1, err)
+		}
+		switch {
+		case rule.Subject == subjectEveryone,
+			rule.Subject == subjectAnonymous,
+			strings.HasPrefix(rule.Subject, subjectUserPrefix) &&
+				len(rule.Subject) > len(subjectUserPrefix),
+			strings.HasPrefix(rule.Subject, subjectRolePrefix) &&
+				len(rule.Subject) > len(subjectRolePrefix):
+		default:
+			return nil, fmt.Errorf(
+				"Rule %d: invalid subject '%s'",
+				i+1,
+				rule.Subject,
+			)
+		}
+	}
+	return acl, nil
+}
+
+// Authenticate verifies the credentials and returns the session info
+// of the user. Returns nil if the credentials are invalid
+func (acl *accessControl) Authenticate(
+	credentials shared.Credentials,
+) *shared.SessionInfo {
+	user, exists := acl.Users[credentials.Username]
+	if !exists || subtle.ConstantTimeCompare(
+		[]byte(user.Password),
+		[]byte(credentials.Password),
+	) != 1 {
+		return nil
+	}
+	return &shared.SessionInfo{
+		Username: credentials.Username,
+		Roles:    user.Roles,
+	}
+}
+
+// applies returns true if the rule applies to the owner of the session,
+// which is nil for anonymous connections
+func (rule aclRule) applies(info *shared.SessionInfo) bool {
+	switch {
+	case rule.Subject == subjectEveryone:
+		return true
+	case rule.Subject == subjectAnonymous:
+		return info == nil
+	case info == nil:
+		return false
+	case strings.HasPrefix(rule.Subject, subjectUserPrefix):
+		return rule.Subject[len(subjectUserPrefix):] == info.Username
+	}
+	return info.HasRole(rule.Subject[len(subjectRolePrefix):])
+}
+
+// MayPublish returns true if the owner of the session
+// is allowed to publish to the given topic
+func (acl *accessControl) MayPublish(
+	info *shared.SessionInfo,
+	topic string,
+) bool {
+	for _, rule := range acl.Rules {
+		if rule.Publish && rule.applies(info) &&
+			shared.MatchTopic(rule.Pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaySubscribe returns true if the owner of the session is allowed to
+// subscribe to the given pattern, which requires a single rule to grant
+// access to all topics the pattern can match
+func (acl *accessControl) MaySubscribe(
+	info *shared.SessionInfo,
+	pattern string,
+) bool {
+	for _, rule := range acl.Rules {
+		if rule.Subscribe && rule.applies(info) &&
+			coversPattern(rule.Pattern, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// coversPattern returns true if every topic matching the pattern
+// also matches the covering pattern
+func coversPattern(covering, pattern string) bool {
+	coveringSegments := strings.Split(covering, shared.TopicSeparator)
+	segments := strings.Split(pattern, shared.TopicSeparator)
+	for i, segment := range coveringSegments {
+		if segment == shared.WildcardMulti {
+			return true
+		}
+		if i >= len(segments) || segments[i] == shared.WildcardMulti {
+			return false
+		}
+		if segment != shared.WildcardSingle && segment != segments[i] {
+			return false
+		}
+	}
+	return len(coveringSegments) == len(segments)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// testACL grants everyone the time topic, publishers all topics
+// and operators the metrics and the logs of all hosts
+var testACL = &accessControl{
+	Rules: []aclRule{
+		{Subject: subjectEveryone, Pattern: "time", Subscribe: true},
+		{Subject: "role:publisher", Pattern: "#", Publish: true},
+		{Subject: "role:operator", Pattern: "metrics.#", Subscribe: true},
+		{Subject: "role:operator", Pattern: "logs.*.error", Subscribe: true},
+		{Subject: subjectAnonymous, Pattern: "public.#", Subscribe: true},
+		{Subject: "user:admin", Pattern: "#", Publish: true, Subscribe: true},
+	},
+}
+
+// Sessions of the test users
+var (
+	testAnonymous *shared.SessionInfo
+	testPublisher = &shared.SessionInfo{
+		Username: "publisher",
+		Roles:    []string{"publisher"},
+	}
+	testOperator = &shared.SessionInfo{
+		Username: "operator",
+		Roles:    []string{"operator"},
+	}
+	testAdmin = &shared.SessionInfo{Username: "admin"}
+)
+
+// TestCoversPattern tests whether every topic matching a pattern
+// also matches the covering pattern
+func TestCoversPattern(t *testing.T) {
+	for _, c := range []struct {
+		covering string
+		pattern  string
+		covers   bool
+	}{
+		{"#", "#", true},
+		{"#", "metrics.*.cpu", true},
+		{"metrics.#", "metrics", true},
+		{"metrics.#", "metrics.#", true},
+		{"metrics.#", "metrics.host1.#", true},
+		{"metrics.#", "#", false},
+		{"metrics.#", "logs.host1", false},
+		{"metrics.*", "metrics.host1", true},
+		{"metrics.*", "metrics.*", true},
+		{"metrics.*", "metrics.#", false},
+		{"metrics.*", "metrics", false},
+		{"metrics.*", "metrics.host1.cpu", false},
+		{"metrics.host1", "metrics.*", false},
+		{"metrics.host1", "metrics.host1", true},
+		{"*.cpu", "metrics.cpu", true},
+		{"*.cpu", "*.mem", false},
+	} {
+		if coversPattern(c.covering, c.pattern) != c.covers {
+			t.Errorf(
+				"expected %s covering %s to be %t",
+				c.covering,
+				c.pattern,
+				c.covers,
+			)
+		}
+	}
+}
+
+// TestMayPublish tests granting and denying publishing to topics
+func TestMayPublish(t *testing.T) {
+	for _, c := range []struct {
+		info  *shared.SessionInfo
+		topic string
+		may   bool
+	}{
+		{testAnonymous, "time", false},
+		{testPublisher, "time", true},
+		{testPublisher, "metrics.host1.cpu", true},
+		{testOperator, "metrics.host1.cpu", false},
+		{testAdmin, "logs.host1.error", true},
+	} {
+		if testACL.MayPublish(c.info, c.topic) != c.may {
+			t.Errorf(
+				"expected %+v publishing to %s to be %t",
+				c.info,
+				c.topic,
+				c.may,
+			)
+		}
+	}
+}
+
+// TestMaySubscribe tests granting and denying subscriptions to patterns
+// which requires a single rule to cover the entire pattern
+func TestMaySubscribe(t *testing.T) {
+	for _, c := range []struct {
+		info    *shared.SessionInfo
+		pattern string
+		may     bool
+	}{
+		{testAnonymous, "time", true},
+		{testAnonymous, "public.#", true},
+		{testAnonymous, "#", false},
+		{testAnonymous, "metrics.host1.cpu", false},
+		{testPublisher, "time", true},
+		{testPublisher, "public.news", false},
+		{testPublisher, "metrics.host1.cpu", false},
+		{testOperator, "metrics.*.cpu", true},
+		{testOperator, "metrics.#", true},
+		{testOperator, "logs.host1.error", true},
+		{testOperator, "logs.*.error", true},
+		{testOperator, "logs.host1.*", false},
+		{testOperator, "logs.#", false},
+		{testOperator, "#", false},
+		{testAdmin, "#", true},
+	} {
+		if testACL.MaySubscribe(c.info, c.pattern) != c.may {
+			t.Errorf(
+				"expected %+v subscribing to %s to be %t",
+				c.info,
+				c.pattern,
+				c.may,
+			)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// maxQueuedDeadLetters defines the maximum number of dead letters
+// waiting to be published to the dead-letter topic
+const maxQueuedDeadLetters = 1024
+
+// deadLetter queues a message that couldn't be delivered to the client
+// for publication to the dead-letter topic. Messages of the dead-letter
+// topic itself are dropped to prevent loops
+func (srv *PubSubServer) deadLetter(
+	client wwr.Connection,
+	topic string,
+	offset uint64,
+	message []byte,
+	reason string,
+) {
+	if srv.deadLetterTopic == "" || topic == srv.deadLetterTopic {
+		log.Printf(
+			"WARNING: dropping undeliverable message of %s "+
+				"for client %s (%s)",
+			topic,
+			client.RemoteAddr(),
+			reason,
+		)
+		return
+	}
+
+	letter := shared.DeadLetter{
+		Topic:   topic,
+		Offset:  offset,
+		Message: append(json.RawMessage(nil), message...),
+		Reason:  reason,
+		Client:  client.RemoteAddr().String(),
+		Failed:  time.Now().UTC(),
+	}
+	select {
+	case srv.deadLetters <- letter:
+	default:
+		log.Printf(
+			"WARNING: dead-letter queue full, dropping message of %s "+
+				"for client %s (%s)",
+			topic,
+			client.RemoteAddr(),
+			reason,
+		)
+	}
+}
+
+// ForwardDeadLetters begins publishing the queued dead letters
+// to the dead-letter topic. Blocks the calling goroutine
+func (srv *PubSubServer) ForwardDeadLetters() {
+	for letter := range srv.deadLetters {
+		encoded, err := json.Marshal(letter)
+		if err != nil {
+			log.Printf("WARNING: couldn't marshal dead letter: %s", err)
+			continue
+		}
+		err = srv.Publish(srv.deadLetterTopic, encoded, 0, false)
+		if err != nil {
+			log.Printf("WARNING: couldn't publish dead letter: %s", err)
+		}
+	}
+}
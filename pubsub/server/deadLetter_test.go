@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// expectDeadLetter waits for the next queued dead letter
+func (srv *testServer) expectDeadLetter(reason string, offset uint64) {
+	srv.t.Helper()
+	select {
+	case letter := <-srv.deadLetters:
+		if letter.Reason != reason || letter.Offset != offset {
+			srv.t.Fatalf(
+				"unexpected dead letter %+v, expected %s of offset %d",
+				letter,
+				reason,
+				offset,
+			)
+		}
+	case <-time.After(testTimeout):
+		srv.t.Fatalf("no dead letter of offset %d (%s)", offset, reason)
+	}
+}
+
+// connectReliable connects a new anonymous client in reliable mode
+// subscribed to the given pattern
+func (srv *testServer) connectReliable(pattern string) *testConnection {
+	conn := srv.connect("")
+	srv.enableReliable(conn)
+	req := shared.SubscribeRequest{Pattern: pattern}
+	if err := srv.subscribe(conn, req); err != nil {
+		srv.t.Fatalf("subscribing failed: %s", err)
+	}
+	return conn
+}
+
+// publishRequest sends a publish request on behalf of the client
+func (srv *testServer) publishRequest(
+	conn *testConnection,
+	req shared.PublishRequest,
+) error {
+	encoded, err := json.Marshal(req)
+	if err != nil {
+		srv.t.Fatalf("couldn't marshal publish request: %s", err)
+	}
+	_, err = srv.handlePublish(conn, testMessage{payload: encoded})
+	return err
+}
+
+// expectRequestError fails unless the error is a request error
+// of the given code
+func expectRequestError(t *testing.T, err error, code string) {
+	t.Helper()
+	if reqErr, ok := err.(wwr.ErrRequest); !ok || reqErr.Code != code {
+		t.Fatalf("expected %s, got %v", code, err)
+	}
+}
+
+// TestDeadLetterClientGone tests dead-lettering the unacknowledged
+// deliveries of a client that disconnected
+func TestDeadLetterClientGone(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.teardown()
+	conn := srv.connectReliable("time")
+
+	srv.publish("time", 1)
+	conn.expectDelivery(t)
+	srv.OnClientDisconnected(conn, nil)
+	srv.expectDeadLetter(shared.DeadLetterClientGone, 1)
+}
+
+// TestDeadLetterQueueFull tests dead-lettering messages
+// while too many deliveries are pending acknowledgement
+func TestDeadLetterQueueFull(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.teardown()
+	srv.connectReliable("time")
+
+	srv.publish("time", maxPendingDeliveries+1)
+	srv.expectDeadLetter(shared.DeadLetterQueueFull, maxPendingDeliveries+1)
+}
+
+// TestDeadLetterRetriesExceeded tests dead-lettering deliveries
+// that weren't acknowledged after the maximum number of attempts
+func TestDeadLetterRetriesExceeded(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.teardown()
+	srv.ackTimeout = 0
+	conn := srv.connectReliable("time")
+
+	srv.publish("time", 1)
+	first := conn.expectDelivery(t)
+	for attempt := 2; attempt <= maxDeliveryAttempts; attempt++ {
+		srv.retransmitDue()
+		if delivery := conn.expectDelivery(t); delivery.Seq != first.Seq {
+			t.Fatalf("unexpected retransmission: %+v", delivery)
+		}
+	}
+	srv.retransmitDue()
+	srv.expectDeadLetter(shared.DeadLetterRetriesExceeded, 1)
+}
+
+// TestDeadLetterExpired tests dead-lettering deliveries
+// that weren't acknowledged before the message expired
+func TestDeadLetterExpired(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.teardown()
+	conn := srv.connectReliable("time")
+
+	if err := srv.Publish("time", []byte("1"), time.Millisecond, false); err != nil {
+		t.Fatalf("publishing failed: %s", err)
+	}
+	conn.expectDelivery(t)
+	time.Sleep(5 * time.Millisecond)
+	srv.retransmitDue()
+	srv.expectDeadLetter(shared.DeadLetterExpired, 1)
+}
+
+// TestDeadLetterLoop tests that undeliverable messages
+// of the dead-letter topic aren't dead-lettered again
+func TestDeadLetterLoop(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.teardown()
+	conn := srv.connectReliable(srv.deadLetterTopic)
+
+	srv.publish(srv.deadLetterTopic, 1)
+	conn.expectDelivery(t)
+	srv.OnClientDisconnected(conn, nil)
+	if queued := len(srv.deadLetters); queued != 0 {
+		t.Fatalf("dead letter of the dead-letter topic queued: %d", queued)
+	}
+
+	// Clients can't publish to the dead-letter topic
+	err := srv.publishRequest(srv.connect(""), shared.PublishRequest{
+		Topic:   srv.deadLetterTopic,
+		Message: json.RawMessage(`"forged"`),
+	})
+	expectRequestError(t, err, "FORBIDDEN")
+}
+
+// TestPublishTTL tests rejecting invalid times to live
+func TestPublishTTL(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.teardown()
+	srv.acl.Rules = append(srv.acl.Rules, aclRule{
+		Subject: subjectEveryone,
+		Pattern: "time",
+		Publish: true,
+	})
+	conn := srv.connect("")
+
+	req := shared.PublishRequest{
+		Topic:   "time",
+		Message: json.RawMessage(`"12:00"`),
+		TTL:     uint64(maxMessageTTL / time.Millisecond),
+	}
+	if err := srv.publishRequest(conn, req); err != nil {
+		t.Fatalf("publishing with the maximum TTL failed: %s", err)
+	}
+
+	req.TTL++
+	expectRequestError(t, srv.publishRequest(conn, req), "INVALID_TTL")
+	req.TTL = 1 << 63
+	expectRequestError(t, srv.publishRequest(conn, req), "INVALID_TTL")
+
+	// Negative times to live can't be decoded
+	_, err := srv.handlePublish(conn, testMessage{
+		payload: []byte(`{"topic":"time","message":1,"ttl":-1}`),
+	})
+	expectRequestError(t, err, "DECODING_FAILURE")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// durableKey identifies a durable subscription.
+// Names are scoped to the user owning the subscription, anonymous clients
+// share the empty owner. The same name can be used for subscriptions
+// to several patterns
+type durableKey struct {
+	Owner   string `json:"owner,omitempty"`
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// durableOffset represents the committed offset of a durable subscription
+type durableOffset struct {
+	durableKey
+	Offset uint64 `json:"offset"`
+}
+
+// durableStore keeps the committed offsets of the durable subscriptions.
+// Commits are kept in memory and saved to a JSON file by Persist
+type durableStore struct {
+	filePath string
+	offsets  map[durableKey]uint64
+	dirty    bool
+	lock     sync.Mutex
+}
+
+// newDurableStore loads the committed offsets from the given file
+func newDurableStore(filePath string) (*durableStore, error) {
+	str := &durableStore{
+		filePath: filePath,
+		offsets:  make(map[durableKey]uint64),
+	}
+	contents, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return str, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Couldn't read durable subscriptions: %s", err)
+	}
+	var offsets []durableOffset
+	if err := json.Unmarshal(contents, &offsets); err != nil {
+		return nil, fmt.Errorf(
+			"Couldn't parse durable subscriptions: %s",
+			err,
+		)
+	}
+	for _, offset := range offsets {
+		if err := validateDurableKey(offset.durableKey); err != nil {
+			return nil, fmt.Errorf(
+				"Invalid durable subscription '%s': %s",
+				offset.Name,
+				err,
+			)
+		}
+		str.offsets[offset.durableKey] = offset.Offset
+	}
+	return str, nil
+}
+
+// Committed returns the committed offset of the given durable subscription.
+// Returns false if no such durable subscription exists
+func (str *durableStore) Committed(key durableKey) (uint64, bool) {
+	str.lock.Lock()
+	defer str.lock.Unlock()
+	offset, exists := str.offsets[key]
+	return offset, exists
+}
+
+// Create creates the durable subscription if it doesn't exist yet
+// committing the given offset
+func (str *durableStore) Create(key durableKey, offset uint64) {
+	str.lock.Lock()
+	defer str.lock.Unlock()
+	if _, exists := str.offsets[key]; !exists {
+		str.offsets[key] = offset
+		str.dirty = true
+	}
+}
+
+// Commit commits the given offset of the durable subscription.
+// Committed offsets never decrease
+func (str *durableStore) Commit(key durableKey, offset uint64) {
+	str.lock.Lock()
+	defer str.lock.Unlock()
+	if committed, exists := str.offsets[key]; exists && offset > committed {
+		str.offsets[key] = offset
+		str.dirty = true
+	}
+}
+
+// Delete deletes the durable subscription
+func (str *durableStore) Delete(key durableKey) {
+	str.lock.Lock()
+	defer str.lock.Unlock()
+	if _, exists := str.offsets[key]; exists {
+		delete(str.offsets, key)
+		str.dirty = true
+	}
+}
+
+// Save saves the committed offsets if they changed since the last save
+func (str *durableStore) Save() error {
+	str.lock.Lock()
+	defer str.lock.Unlock()
+
+	if !str.dirty {
+		return nil
+	}
+	offsets := make([]durableOffset, 0, len(str.offsets))
+	for key, offset := range str.offsets {
+		offsets = append(offsets, durableOffset{key, offset})
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		if offsets[i].Owner != offsets[j].Owner {
+			return offsets[i].Owner < offsets[j].Owner
+		}
+		if offsets[i].Name != offsets[j].Name {
+			return offsets[i].Name < offsets[j].Name
+		}
+		return offsets[i].Pattern < offsets[j].Pattern
+	})
+
+	encoded, err := json.MarshalIndent(offsets, "", "\t")
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal durable subscriptions: %s", err)
+	}
+	if err := writeFileAtomic(str.filePath, encoded); err != nil {
+		return fmt.Errorf("Couldn't save durable subscriptions: %s", err)
+	}
+	str.dirty = false
+	return nil
+}
+
+// Persist begins saving the committed offsets in the given interval.
+// Blocks the calling goroutine
+func (str *durableStore) Persist(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := str.Save(); err != nil {
+			log.Printf("WARNING: %s", err)
+		}
+	}
+}
+
+// validateDurableKey returns an error if the name or the pattern
+// of the durable subscription isn't valid
+func validateDurableKey(key durableKey) error {
+	if err := shared.ValidateDurableName(key.Name); err != nil {
+		return err
+	}
+	return shared.ValidatePattern(key.Pattern)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// TestDurableCommit tests that committed offsets never decrease
+// and survive reloading the store
+func TestDurableCommit(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.teardown()
+	filePath := filepath.Join(srv.dir, "durable.json")
+
+	alice := durableKey{Owner: "alice", Name: "sub", Pattern: "time"}
+	bob := durableKey{Owner: "bob", Name: "sub", Pattern: "time"}
+	str := srv.durables
+	str.Create(alice, 5)
+	str.Create(bob, 1)
+	str.Commit(alice, 7)
+	str.Commit(alice, 6)
+	str.Create(alice, 2)
+	str.Commit(durableKey{Name: "sub", Pattern: "time"}, 9)
+
+	for key, expected := range map[durableKey]uint64{alice: 7, bob: 1} {
+		if offset, exists := str.Committed(key); !exists || offset != expected {
+			t.Fatalf("%+v committed %d, expected %d", key, offset, expected)
+		}
+	}
+	if _, exists := str.Committed(durableKey{Name: "sub", Pattern: "time"}); exists {
+		t.Fatal("durable subscription created by committing")
+	}
+
+	if err := str.Save(); err != nil {
+		t.Fatalf("saving failed: %s", err)
+	}
+	reloaded, err := newDurableStore(filePath)
+	if err != nil {
+		t.Fatalf("reloading failed: %s", err)
+	}
+	if offset, _ := reloaded.Committed(alice); offset != 7 {
+		t.Fatalf("reloaded offset %d, expected 7", offset)
+	}
+
+	str.Delete(alice)
+	if err := str.Save(); err != nil {
+		t.Fatalf("saving failed: %s", err)
+	}
+	if reloaded, err = newDurableStore(filePath); err != nil {
+		t.Fatalf("reloading failed: %s", err)
+	}
+	if _, exists := reloaded.Committed(alice); exists {
+		t.Fatal("deleted durable subscription reloaded")
+	}
+}
+
+// TestDurableOwners tests scoping the names of durable subscriptions
+// to their owners
+func TestDurableOwners(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.teardown()
+	req := shared.SubscribeRequest{Pattern: "time", Durable: "sub"}
+
+	// Different users may use the same name at the same time
+	for _, username := range []string{"alice", "bob"} {
+		if err := srv.subscribe(srv.connect(username), req); err != nil {
+			t.Fatalf("%s failed subscribing: %s", username, err)
+		}
+	}
+
+	// Anonymous clients share their names
+	if err := srv.subscribe(srv.connect(""), req); err != nil {
+		t.Fatalf("subscribing failed: %s", err)
+	}
+	err := srv.subscribe(srv.connect(""), req)
+	if reqErr, ok := err.(wwr.ErrRequest); !ok || reqErr.Code != "DURABLE_IN_USE" {
+		t.Fatalf("expected DURABLE_IN_USE, got %v", err)
+	}
+
+	for _, owner := range []string{"alice", "bob", ""} {
+		key := durableKey{Owner: owner, Name: "sub", Pattern: "time"}
+		if _, exists := srv.durables.Committed(key); !exists {
+			t.Fatalf("durable subscription of %q not created", owner)
+		}
+	}
+}
+
+// TestDurableCommitPending tests that offsets are committed
+// only up to the lowest delivery pending acknowledgement
+func TestDurableCommitPending(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.teardown()
+	conn := srv.connect("alice")
+	srv.enableReliable(conn)
+	req := shared.SubscribeRequest{Pattern: "time", Durable: "sub"}
+	if err := srv.subscribe(conn, req); err != nil {
+		t.Fatalf("subscribing failed: %s", err)
+	}
+	key := durableKey{Owner: "alice", Name: "sub", Pattern: "time"}
+
+	srv.publish("time", 3)
+	for offset := uint64(1); offset <= 3; offset++ {
+		if delivery := conn.expectDelivery(t); delivery.Offset != offset {
+			t.Fatalf("unexpected delivery: %+v", delivery)
+		}
+	}
+
+	for _, step := range []struct {
+		ack       uint64
+		committed uint64
+	}{
+		{2, 0},
+		{1, 1},
+		{3, 3},
+	} {
+		srv.ack(conn, step.ack)
+		if offset, _ := srv.durables.Committed(key); offset != step.committed {
+			t.Fatalf(
+				"committed %d after acknowledging %d, expected %d",
+				offset,
+				step.ack,
+				step.committed,
+			)
+		}
+	}
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits bounding the cost of compiling and evaluating a filter.
+// Evaluation visits each node at most once, which bounds it
+// by the number of nodes
+const (
+	maxFilterLength = 512
+	maxFilterNodes  = 64
+	maxFilterDepth  = 16
+	maxFieldDepth   = 8
+)
+
+// filterNode represents a node of a compiled filter expression
+type filterNode interface {
+	// eval evaluates the node against the decoded message
+	eval(doc interface{}) interface{}
+}
+
+// filter represents a compiled filter expression
+// matching decoded JSON messages
+type filter struct {
+	source string
+	root   filterNode
+}
+
+// Match returns true if the decoded message matches the filter
+func (flt *filter) Match(doc interface{}) bool {
+	return flt.root.eval(doc) == true
+}
+
+// MatchMessage returns true if the JSON encoded message matches the filter
+func (flt *filter) MatchMessage(message []byte) bool {
+	doc := filterDocument{message: message}
+	return flt.Match(doc.Value())
+}
+
+// fieldNode evaluates to the value of a field of the message,
+// nil if the field doesn't exist
+type fieldNode struct {
+	path []string
+}
+
+func (node fieldNode) eval(doc interface{}) interface{} {
+	value := doc
+	for _, key := range node.path {
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// literalNode evaluates to a constant
+type literalNode struct {
+	value interface{}
+}
+
+func (node literalNode) eval(_ interface{}) interface{} {
+	return node.value
+}
+
+// notNode negates its operand
+type notNode struct {
+	operand filterNode
+}
+
+func (node notNode) eval(doc interface{}) interface{} {
+	return node.operand.eval(doc) != true
+}
+
+// logicalNode combines its operands by either && or ||
+type logicalNode struct {
+	and         bool
+	left, right filterNode
+}
+
+func (node logicalNode) eval(doc interface{}) interface{} {
+	left := node.left.eval(doc) == true
+	if left != node.and {
+		return left
+	}
+	return node.right.eval(doc) == true
+}
+
+// compareNode compares its operands
+type compareNode struct {
+	operator    string
+	left, right filterNode
+}
+
+func (node compareNode) eval(doc interface{}) interface{} {
+	left, right := node.left.eval(doc), node.right.eval(doc)
+	switch node.operator {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	}
+
+	// Only numbers and strings are ordered
+	var order int
+	switch l := left.(type) {
+	case float64:
+		r, isNumber := right.(float64)
+		if !isNumber {
+			return false
+		}
+		if l < r {
+			order = -1
+		} else if l > r {
+			order = 1
+		}
+	case string:
+		r, isString := right.(string)
+		if !isString {
+			return false
+		}
+		order = strings.Compare(l, r)
+	default:
+		return false
+	}
+	switch node.operator {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	}
+	return order >= 0
+}
+
+// inNode checks whether its operand equals any of the listed values
+type inNode struct {
+	operand filterNode
+	values  []interface{}
+}
+
+func (node inNode) eval(doc interface{}) interface{} {
+	value := node.operand.eval(doc)
+	for _, candidate := range node.values {
+		if equal(value, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal returns true if both values are equal scalars.
+// Objects and arrays are never equal
+func equal(left, right interface{}) bool {
+	switch left.(type) {
+	case nil, bool, float64, string:
+		return left == right
+	}
+	return false
+}
+
+// filterParser compiles filter expressions by recursive descent:
+//
+//	or         = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | comparison
+//	comparison = operand [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) operand
+//	             | "in" "[" [ literal { "," literal } ] "]" ]
+//	operand    = field | literal | "(" or ")"
+//	literal    = number | string | "true" | "false" | "null"
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	nodes  int
+	depth  int
+}
+
+// filterToken represents a lexical token of a filter expression
+type filterToken struct {
+	kind  string
+	text  string
+	value interface{}
+	pos   int
+}
+
+// Token kinds
+const (
+	tokenField    = "field"
+	tokenLiteral  = "literal"
+	tokenOperator = "operator"
+	tokenEnd      = "end"
+)
+
+// compileFilter compiles the given filter expression
+func compileFilter(source string) (*filter, error) {
+	if len(source) > maxFilterLength {
+		return nil, fmt.Errorf(
+			"filter exceeds %d bytes",
+			maxFilterLength,
+		)
+	}
+	tokens, err := tokenizeFilter(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &filterParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := parser.peek(); token.kind != tokenEnd {
+		return nil, fmt.Errorf(
+			"unexpected '%s' at position %d",
+			token.text,
+			token.pos,
+		)
+	}
+	return &filter{source: source, root: root}, nil
+}
+
+// tokenizeFilter splits the given filter expression into tokens
+func tokenizeFilter(source string) ([]filterToken, error) {
+	var tokens []filterToken
+	for pos := 0; pos < len(source); {
+		char := source[pos]
+		switch {
+		case char == ' ' || char == '\t' || char == '\n' || char == '\r':
+			pos++
+
+		case strings.HasPrefix(source[pos:], "=="),
+			strings.HasPrefix(source[pos:], "!="),
+			strings.HasPrefix(source[pos:], "<="),
+			strings.HasPrefix(source[pos:], ">="),
+			strings.HasPrefix(source[pos:], "&&"),
+			strings.HasPrefix(source[pos:], "||"):
+			tokens = append(tokens, filterToken{
+				kind: tokenOperator,
+				text: source[pos : pos+2],
+				pos:  pos,
+			})
+			pos += 2
+
+		case strings.IndexByte("<>!()[],", char) >= 0:
+			tokens = append(tokens, filterToken{
+				kind: tokenOperator,
+				text: source[pos : pos+1],
+				pos:  pos,
+			})
+			pos++
+
+		case char == '"':
+			end := pos + 1
+			for end < len(source) && source[end] != '"' {
+				if source[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf(
+					"unterminated string at position %d",
+					pos,
+				)
+			}
+			var value string
+			if err := json.Unmarshal(
+				[]byte(source[pos:end+1]),
+				&value,
+			); err != nil {
+				return nil, fmt.Errorf(
+					"invalid string at position %d",
+					pos,
+				)
+			}
+			tokens = append(tokens, filterToken{
+				kind:  tokenLiteral,
+				text:  source[pos : end+1],
+				value: value,
+				pos:   pos,
+			})
+			pos = end + 1
+
+		case char == '-' || (char >= '0' && char <= '9'):
+			end := pos + 1
+			for end < len(source) &&
+				strings.IndexByte("0123456789.eE+-", source[end]) >= 0 {
+				end++
+			}
+			value, err := strconv.ParseFloat(source[pos:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"invalid number at position %d",
+					pos,
+				)
+			}
+			tokens = append(tokens, filterToken{
+				kind:  tokenLiteral,
+				text:  source[pos:end],
+				value: value,
+				pos:   pos,
+			})
+			pos = end
+
+		case isFieldChar(char):
+			end := pos
+			for end < len(source) &&
+				(isFieldChar(source[end]) || source[end] == '.') {
+				end++
+			}
+			token := filterToken{
+				kind: tokenField,
+				text: source[pos:end],
+				pos:  pos,
+			}
+			switch token.text {
+			case "true":
+				token.kind, token.value = tokenLiteral, true
+			case "false":
+				token.kind, token.value = tokenLiteral, false
+			case "null":
+				token.kind, token.value = tokenLiteral, nil
+			case "in":
+				token.kind = tokenOperator
+			}
+			tokens = append(tokens, token)
+			pos = end
+
+		default:
+			return nil, fmt.Errorf(
+				"unexpected character %q at position %d",
+				char,
+				pos,
+			)
+		}
+	}
+	return append(tokens, filterToken{
+		kind: tokenEnd,
+		text: "end of filter",
+		pos:  len(source),
+	}), nil
+}
+
+// isFieldChar returns true if the given character can be part of a field
+func isFieldChar(char byte) bool {
+	return char == '_' ||
+		(char >= 'a' && char <= 'z') ||
+		(char >= 'A' && char <= 'Z') ||
+		(char >= '0' && char <= '9')
+}
+
+// peek returns the current token
+func (prs *filterParser) peek() filterToken {
+	return prs.tokens[prs.pos]
+}
+
+// accept consumes the current token if it's the given operator
+func (prs *filterParser) accept(operator string) bool {
+	token := prs.peek()
+	if token.kind == tokenOperator && token.text == operator {
+		prs.pos++
+		return true
+	}
+	return false
+}
+
+// node counts a new node failing if there are too many
+func (prs *filterParser) node(node filterNode) (filterNode, error) {
+	prs.nodes++
+	if prs.nodes > maxFilterNodes {
+		return nil, fmt.Errorf(
+			"filter exceeds %d nodes",
+			maxFilterNodes,
+		)
+	}
+	return node, nil
+}
+
+func (prs *filterParser) parseOr() (filterNode, error) {
+	prs.depth++
+	defer func() { prs.depth-- }()
+	if prs.depth > maxFilterDepth {
+		return nil, fmt.Errorf(
+			"filter exceeds a nesting depth of %d",
+			maxFilterDepth,
+		)
+	}
+
+	left, err := prs.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for prs.accept("||") {
+		right, err := prs.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = prs.node(logicalNode{
+			left:  left,
+			right: right,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (prs *filterParser) parseAnd() (filterNode, error) {
+	left, err := prs.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for prs.accept("&&") {
+		right, err := prs.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = prs.node(logicalNode{
+			and:   true,
+			left:  left,
+			right: right,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (prs *filterParser) parseUnary() (filterNode, error) {
+	if !prs.accept("!") {
+		return prs.parseComparison()
+	}
+	prs.depth++
+	defer func() { prs.depth-- }()
+	if prs.depth > maxFilterDepth {
+		return nil, fmt.Errorf(
+			"filter exceeds a nesting depth of %d",
+			maxFilterDepth,
+		)
+	}
+	operand, err := prs.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return prs.node(notNode{operand: operand})
+}
+
+func (prs *filterParser) parseComparison() (filterNode, error) {
+	left, err := prs.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if prs.accept("in") {
+		if !prs.accept("[") {
+			return nil, prs.expected("'['")
+		}
+		node := inNode{operand: left}
+		for !prs.accept("]") {
+			if len(node.values) > 0 && !prs.accept(",") {
+				return nil, prs.expected("',' or ']'")
+			}
+			token := prs.peek()
+			if token.kind != tokenLiteral {
+				return nil, prs.expected("a literal")
+			}
+			prs.pos++
+			prs.nodes++
+			node.values = append(node.values, token.value)
+		}
+		return prs.node(node)
+	}
+
+	token := prs.peek()
+	if token.kind != tokenOperator {
+		return left, nil
+	}
+	switch token.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return left, nil
+	}
+	prs.pos++
+	right, err := prs.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return prs.node(compareNode{
+		operator: token.text,
+		left:     left,
+		right:    right,
+	})
+}
+
+func (prs *filterParser) parseOperand() (filterNode, error) {
+	token := prs.peek()
+	switch {
+	case token.kind == tokenLiteral:
+		prs.pos++
+		return prs.node(literalNode{value: token.value})
+
+	case token.kind == tokenField:
+		prs.pos++
+		path := strings.Split(token.text, ".")
+		if len(path) > maxFieldDepth {
+			return nil, fmt.Errorf(
+				"field %s exceeds a depth of %d",
+				token.text,
+				maxFieldDepth,
+			)
+		}
+		for _, key := range path {
+			if key == "" {
+				return nil, fmt.Errorf(
+					"invalid field %s at position %d",
+					token.text,
+					token.pos,
+				)
+			}
+		}
+		return prs.node(fieldNode{path: path})
+
+	case prs.accept("("):
+		node, err := prs.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !prs.accept(")") {
+			return nil, prs.expected("')'")
+		}
+		return node, nil
+	}
+	return nil, prs.expected("a field, a literal or '('")
+}
+
+// expected returns an error describing what was expected
+// instead of the current token
+func (prs *filterParser) expected(what string) error {
+	token := prs.peek()
+	if token.kind == tokenEnd {
+		return errors.New("unexpected end of filter, expected " + what)
+	}
+	return fmt.Errorf(
+		"unexpected '%s' at position %d, expected %s",
+		token.text,
+		token.pos,
+		what,
+	)
+}
+
+// filterDocument lazily decodes a published message for filters.
+// The message is decoded at most once regardless of how many
+// subscriptions evaluate it
+type filterDocument struct {
+	message []byte
+	decoded bool
+	value   interface{}
+}
+
+// Value returns the decoded message, nil if it isn't valid JSON
+func (doc *filterDocument) Value() interface{} {
+	if !doc.decoded {
+		doc.decoded = true
+		if err := json.Unmarshal(doc.message, &doc.value); err != nil {
+			doc.value = nil
+		}
+	}
+	return doc.value
+}
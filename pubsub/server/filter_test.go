@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestFilter tests compiling and evaluating filter expressions
+func TestFilter(t *testing.T) {
+	message := []byte(`{
+		"severity": 4,
+		"level": "warn",
+		"host": {"name": "db1", "tags": {"env": "prod"}},
+		"debug": false,
+		"note": null
+	}`)
+
+	for _, c := range []struct {
+		filter  string
+		matches bool
+	}{
+		{`severity >= 3`, true},
+		{`severity < 3`, false},
+		{`level == "warn"`, true},
+		{`level != "warn"`, false},
+		{`level in ["warn", "error"]`, true},
+		{`level in []`, false},
+		{`host.name == "db1" && host.tags.env == "prod"`, true},
+		{`host.name == "db2" || severity > 3.5`, true},
+		{`!(severity >= 3)`, false},
+		{`!debug`, true},
+		{`debug`, false},
+		{`note == null && missing == null`, true},
+		{`missing.deeply.nested == 1`, false},
+		{`level > 3`, false},
+		{`host == host`, false},
+		{`severity >= 1e0 && level >= "a"`, true},
+	} {
+		flt, err := compileFilter(c.filter)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.filter, err)
+			continue
+		}
+		if flt.MatchMessage(message) != c.matches {
+			t.Errorf("%s: expected match to be %t", c.filter, c.matches)
+		}
+	}
+
+	// Messages that aren't JSON objects only match comparisons to null
+	flt, err := compileFilter(`level == null`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !flt.MatchMessage([]byte(`"plain"`)) || !flt.MatchMessage([]byte(`{`)) {
+		t.Error("expected messages without fields to match")
+	}
+
+	for _, invalid := range []string{
+		``,
+		`severity >=`,
+		`(severity > 3`,
+		`severity > 3)`,
+		`level == "warn`,
+		`level in ["a" "b"]`,
+		`level in [severity]`,
+		`a..b == 1`,
+		`a.b.c.d.e.f.g.h.i == 1`,
+		`severity ~ 3`,
+		`1 2`,
+		strings.Repeat("(", maxFilterDepth+1) + "a" +
+			strings.Repeat(")", maxFilterDepth+1),
+		strings.Repeat("a == 1 && ", maxFilterNodes/3) + "a == 1",
+		strings.Repeat(" ", maxFilterLength+1),
+	} {
+		if _, err := compileFilter(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
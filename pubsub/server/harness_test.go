@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// testMessage implements the webwire.Message interface
+type testMessage struct {
+	name    string
+	payload []byte
+}
+
+func (msg testMessage) Identifier() [8]byte { return [8]byte{} }
+
+func (msg testMessage) Name() []byte { return []byte(msg.name) }
+
+func (msg testMessage) PayloadEncoding() wwr.PayloadEncoding {
+	return wwr.EncodingUtf8
+}
+
+func (msg testMessage) Payload() []byte { return msg.payload }
+
+func (msg testMessage) PayloadUtf8() ([]byte, error) { return msg.payload, nil }
+
+func (msg testMessage) Close() {}
+
+// testSignal represents a signal sent to a test connection
+type testSignal struct {
+	topic   string
+	payload []byte
+}
+
+// testConnection is a connection recording the signals sent to it
+type testConnection struct {
+	wwr.Connection
+	session *wwr.Session
+	signals chan testSignal
+}
+
+func (conn *testConnection) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+}
+
+func (conn *testConnection) Session() *wwr.Session { return conn.session }
+
+func (conn *testConnection) Signal(name []byte, payload wwr.Payload) error {
+	conn.signals <- testSignal{
+		topic:   string(name),
+		payload: append([]byte(nil), payload.Data...),
+	}
+	return nil
+}
+
+func (conn *testConnection) Close() {}
+
+// expectSignal waits for the next signal
+func (conn *testConnection) expectSignal(t *testing.T) testSignal {
+	t.Helper()
+	select {
+	case sig := <-conn.signals:
+		return sig
+	case <-time.After(testTimeout):
+		t.Fatal("no signal received")
+	}
+	return testSignal{}
+}
+
+// expectDelivery waits for the next reliable delivery
+func (conn *testConnection) expectDelivery(t *testing.T) shared.Delivery {
+	t.Helper()
+	var delivery shared.Delivery
+	if err := json.Unmarshal(conn.expectSignal(t).payload, &delivery); err != nil {
+		t.Fatalf("invalid delivery: %s", err)
+	}
+	return delivery
+}
+
+// expectSilence fails if a signal is received within the given duration
+func (conn *testConnection) expectSilence(t *testing.T, duration time.Duration) {
+	t.Helper()
+	select {
+	case sig := <-conn.signals:
+		t.Fatalf("unexpected signal: %s %s", sig.topic, sig.payload)
+	case <-time.After(duration):
+	}
+}
+
+// testServer is a pub-sub server keeping its files in a temporary directory
+type testServer struct {
+	*PubSubServer
+	t   *testing.T
+	dir string
+}
+
+// newTestServer creates a new test server allowing everyone
+// to subscribe to all topics
+func newTestServer(t *testing.T) *testServer {
+	dir, err := ioutil.TempDir("", "pubsub")
+	if err != nil {
+		t.Fatalf("couldn't create directory: %s", err)
+	}
+	acl, err := loadAccessControl(filepath.Join(dir, "acl.json"))
+	if err != nil {
+		t.Fatalf("couldn't load access control: %s", err)
+	}
+	retained, err := newRetainedStore(filepath.Join(dir, "retained.json"))
+	if err != nil {
+		t.Fatalf("couldn't load retained messages: %s", err)
+	}
+	topicLog, err := openTopicLog(filepath.Join(dir, "topics.log"))
+	if err != nil {
+		t.Fatalf("couldn't open topic log: %s", err)
+	}
+	durables, err := newDurableStore(filepath.Join(dir, "durable.json"))
+	if err != nil {
+		t.Fatalf("couldn't load durable subscriptions: %s", err)
+	}
+	return &testServer{
+		PubSubServer: NewPubSubServer(
+			acl,
+			retained,
+			topicLog,
+			durables,
+			time.Hour,
+			4*maxPendingDeliveries,
+			"deadletter",
+		),
+		t:   t,
+		dir: dir,
+	}
+}
+
+// teardown closes the topic log and removes the files of the server
+func (srv *testServer) teardown() {
+	srv.log.Close()
+	os.RemoveAll(srv.dir)
+}
+
+// connect connects a new client authenticated as the given user,
+// anonymous if empty
+func (srv *testServer) connect(username string) *testConnection {
+	conn := &testConnection{signals: make(chan testSignal, 4096)}
+	if username != "" {
+		conn.session = &wwr.Session{
+			Info: &shared.SessionInfo{Username: username},
+		}
+	}
+	srv.OnClientConnected(wwr.ConnectionOptions{}, conn)
+	return conn
+}
+
+// enableReliable enables reliable delivery for the client
+func (srv *testServer) enableReliable(conn *testConnection) {
+	if _, err := srv.handleReliable(conn, testMessage{}); err != nil {
+		srv.t.Fatalf("enabling reliable delivery failed: %s", err)
+	}
+}
+
+// subscribe sends a subscribe request on behalf of the client
+func (srv *testServer) subscribe(
+	conn *testConnection,
+	req shared.SubscribeRequest,
+) error {
+	encoded, err := json.Marshal(req)
+	if err != nil {
+		srv.t.Fatalf("couldn't marshal subscribe request: %s", err)
+	}
+	_, err = srv.handleSubscribe(conn, testMessage{payload: encoded})
+	return err
+}
+
+// publish publishes the given number of messages to the topic
+func (srv *testServer) publish(topic string, count int) {
+	for i := 0; i < count; i++ {
+		if err := srv.Publish(
+			topic,
+			[]byte(strconv.Itoa(i)),
+			0,
+			false,
+		); err != nil {
+			srv.t.Fatalf("publishing failed: %s", err)
+		}
+	}
+}
+
+// ack acknowledges a reliable delivery on behalf of the client
+func (srv *testServer) ack(conn *testConnection, seq uint64) {
+	srv.OnSignal(context.Background(), conn, testMessage{
+		name:    shared.SignalAck,
+		payload: []byte(strconv.FormatUint(seq, 10)),
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	wwr "github.com/qbeon/webwire-go"
+)
+
+// outboundSignal represents a message waiting to be sent to a client
+type outboundSignal struct {
+	topic   string
+	payload []byte
+
+	// offset is the position of the message in the topic log,
+	// 0 for retained messages
+	offset uint64
+
+	// durables are the durable subscriptions to commit the offset of
+	// once the message is sent, reliable deliveries are committed
+	// once they're acknowledged instead
+	durables []durableKey
+
+	// reliable is true for deliveries retransmitted until acknowledged
+	reliable bool
+}
+
+// outbox queues the messages to be sent to a single client and sends them
+// from a separate goroutine so that a slow client doesn't block
+// the publishers. A client whose queue is full is disconnected
+type outbox struct {
+	client  wwr.Connection
+	queue   chan outboundSignal
+	pending int64
+	closed  chan struct{}
+
+	// sent and failed are called for each message after sending it
+	// succeeded or failed, failed is also called for the messages
+	// still queued when the outbox is closed
+	sent   func(outboundSignal)
+	failed func(outboundSignal)
+
+	closeOnce      sync.Once
+	disconnectOnce sync.Once
+}
+
+// newOutbox constructs a new outbox of the given size
+// and starts sending queued messages
+func newOutbox(
+	client wwr.Connection,
+	size int,
+	sent func(outboundSignal),
+	failed func(outboundSignal),
+) *outbox {
+	box := &outbox{
+		client: client,
+		queue:  make(chan outboundSignal, size),
+		closed: make(chan struct{}),
+		sent:   sent,
+		failed: failed,
+	}
+	go box.run()
+	return box
+}
+
+// run sends queued messages until the outbox is closed
+func (box *outbox) run() {
+	for {
+		select {
+		case <-box.closed:
+			return
+		case sig := <-box.queue:
+			err := box.client.Signal([]byte(sig.topic), wwr.Payload{
+				Encoding: wwr.EncodingUtf8,
+				Data:     sig.payload,
+			})
+			if err != nil {
+				log.Printf(
+					"WARNING: failed sending signal to client %s : %s",
+					box.client.RemoteAddr(),
+					err,
+				)
+				box.failed(sig)
+			} else {
+				box.sent(sig)
+			}
+			atomic.AddInt64(&box.pending, -1)
+		}
+	}
+}
+
+// Enqueue queues a message without ever blocking.
+// Returns false and disconnects the client if the queue is full.
+// The payload must not be modified after it was enqueued
+func (box *outbox) Enqueue(sig outboundSignal) bool {
+	atomic.AddInt64(&box.pending, 1)
+	select {
+	case box.queue <- sig:
+		return true
+	default:
+	}
+
+	atomic.AddInt64(&box.pending, -1)
+	box.disconnectOnce.Do(func() {
+		log.Printf(
+			"WARNING: disconnecting slow client %s, outbound queue full",
+			box.client.RemoteAddr(),
+		)
+		go box.client.Close()
+	})
+	return false
+}
+
+// Pending returns the number of messages that are either queued
+// or currently being sent
+func (box *outbox) Pending() int {
+	return int(atomic.LoadInt64(&box.pending))
+}
+
+// Capacity returns the maximum number of queued messages
+func (box *outbox) Capacity() int {
+	return cap(box.queue)
+}
+
+// Close stops sending queued messages and reports the remaining ones failed
+func (box *outbox) Close() {
+	box.closeOnce.Do(func() {
+		close(box.closed)
+		for {
+			select {
+			case sig := <-box.queue:
+				atomic.AddInt64(&box.pending, -1)
+				box.failed(sig)
+			default:
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+)
+
+// testTimeout defines how long tests wait for asynchronous events
+const testTimeout = 5 * time.Second
+
+// stalledConnection is the connection of a client that doesn't read.
+// Sending a signal blocks until it's released or the connection is closed
+type stalledConnection struct {
+	wwr.Connection
+	signals   chan string
+	release   chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newStalledConnection creates a new stalled connection
+func newStalledConnection() *stalledConnection {
+	return &stalledConnection{
+		signals: make(chan string, 64),
+		release: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (conn *stalledConnection) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+}
+
+// Signal records the payload of the signal and blocks
+// until it's released or the connection is closed
+func (conn *stalledConnection) Signal(_ []byte, payload wwr.Payload) error {
+	conn.signals <- string(payload.Data)
+	select {
+	case <-conn.release:
+		return nil
+	case <-conn.closed:
+		return errors.New("connection closed")
+	}
+}
+
+func (conn *stalledConnection) Close() {
+	conn.closeOnce.Do(func() {
+		close(conn.closed)
+	})
+}
+
+// expectSignal waits for the sending of the given payload to begin
+func (conn *stalledConnection) expectSignal(t *testing.T, payload string) {
+	t.Helper()
+	select {
+	case sent := <-conn.signals:
+		if sent != payload {
+			t.Fatalf("expected signal %s, got %s", payload, sent)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("signal %s not sent", payload)
+	}
+}
+
+// outboxRecorder records the messages an outbox sent and failed to send
+type outboxRecorder struct {
+	sent   []string
+	failed []string
+	lock   sync.Mutex
+}
+
+func (rec *outboxRecorder) recordSent(sig outboundSignal) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	rec.sent = append(rec.sent, string(sig.payload))
+}
+
+func (rec *outboxRecorder) recordFailed(sig outboundSignal) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	rec.failed = append(rec.failed, string(sig.payload))
+}
+
+// TestOutboxOrder tests sending the queued messages in order
+// without blocking the caller
+func TestOutboxOrder(t *testing.T) {
+	conn := newStalledConnection()
+	rec := &outboxRecorder{}
+	box := newOutbox(conn, 4, rec.recordSent, rec.recordFailed)
+	defer box.Close()
+
+	messages := []string{"1", "2", "3", "4"}
+	for _, msg := range messages {
+		if !box.Enqueue(outboundSignal{payload: []byte(msg)}) {
+			t.Fatalf("message %s not queued", msg)
+		}
+	}
+	for _, msg := range messages {
+		conn.expectSignal(t, msg)
+		conn.release <- struct{}{}
+	}
+
+	deadline := time.Now().Add(testTimeout)
+	for box.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if len(rec.sent) != len(messages) || len(rec.failed) != 0 {
+		t.Fatalf("sent %v, failed %v", rec.sent, rec.failed)
+	}
+	for i, msg := range messages {
+		if rec.sent[i] != msg {
+			t.Fatalf("sent out of order: %v", rec.sent)
+		}
+	}
+}
+
+// TestOutboxOverflow tests disconnecting a client whose queue is full
+// and failing the queued messages once the outbox is closed
+func TestOutboxOverflow(t *testing.T) {
+	conn := newStalledConnection()
+	rec := &outboxRecorder{}
+	box := newOutbox(conn, 2, rec.recordSent, rec.recordFailed)
+
+	// The first message is being sent while the others are queued
+	box.Enqueue(outboundSignal{payload: []byte("a")})
+	conn.expectSignal(t, "a")
+	for _, msg := range []string{"b", "c"} {
+		if !box.Enqueue(outboundSignal{payload: []byte(msg)}) {
+			t.Fatalf("message %s not queued", msg)
+		}
+	}
+	if box.Enqueue(outboundSignal{payload: []byte("d")}) {
+		t.Fatal("message queued beyond the capacity")
+	}
+	select {
+	case <-conn.closed:
+	case <-time.After(testTimeout):
+		t.Fatal("slow client not disconnected")
+	}
+
+	box.Close()
+	deadline := time.Now().Add(testTimeout)
+	for box.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if len(rec.sent) != 0 || len(rec.failed) != 3 {
+		t.Fatalf("sent %v, failed %v", rec.sent, rec.failed)
+	}
+}
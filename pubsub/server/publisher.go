@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// Publisher produces the messages the server publishes
+// to the topic the publisher is mapped to
+type Publisher interface {
+	// Run begins passing the JSON encoded messages to the given function
+	// until the publisher is stopped or its source is exhausted.
+	// Blocks the calling goroutine
+	Run(publish func(message []byte)) error
+
+	// Stop makes Run return
+	Stop()
+}
+
+// hostedPublisher maps a publisher to the topic it publishes to
+type hostedPublisher struct {
+	topic     string
+	publisher Publisher
+}
+
+// AddPublisher maps the publisher to the given topic.
+// Publishers must be added before they're started
+func (srv *PubSubServer) AddPublisher(topic string, publisher Publisher) error {
+	if err := shared.ValidateTopic(topic); err != nil {
+		return err
+	}
+	if topic == srv.deadLetterTopic {
+		return errors.New("The dead-letter topic is reserved to the server")
+	}
+	srv.publishers = append(srv.publishers, hostedPublisher{
+		topic:     topic,
+		publisher: publisher,
+	})
+	return nil
+}
+
+// StartPublishers runs each publisher in a separate goroutine
+// publishing its messages to the topic it's mapped to
+func (srv *PubSubServer) StartPublishers() {
+	for _, hosted := range srv.publishers {
+		srv.runningPublishers.Add(1)
+		go func(hosted hostedPublisher) {
+			defer srv.runningPublishers.Done()
+
+			err := hosted.publisher.Run(func(message []byte) {
+				err := srv.Publish(hosted.topic, message, 0, false)
+				if err != nil {
+					log.Printf(
+						"WARNING: couldn't publish to %s: %s",
+						hosted.topic,
+						err,
+					)
+				}
+			})
+			if err != nil {
+				log.Printf(
+					"WARNING: publisher of %s failed: %s",
+					hosted.topic,
+					err,
+				)
+				return
+			}
+			log.Printf("Publisher of %s stopped", hosted.topic)
+		}(hosted)
+	}
+}
+
+// StopPublishers stops all publishers and waits for them to return
+func (srv *PubSubServer) StopPublishers() {
+	for _, hosted := range srv.publishers {
+		hosted.publisher.Stop()
+	}
+	srv.runningPublishers.Wait()
+}
+
+// parsePublisher parses a publisher definition of the form
+// TOPIC=KIND[:ARGUMENT] and returns the topic and the publisher
+func parsePublisher(definition string) (string, Publisher, error) {
+	eq := strings.Index(definition, "=")
+	if eq < 0 {
+		return "", nil, fmt.Errorf(
+			"Publisher '%s' must be of the form TOPIC=KIND[:ARGUMENT]",
+			definition,
+		)
+	}
+	topic := definition[:eq]
+	kind, argument := definition[eq+1:], ""
+	if colon := strings.Index(kind, ":"); colon > -1 {
+		kind, argument = kind[:colon], kind[colon+1:]
+	}
+
+	switch kind {
+	case "ticker":
+		interval := 1 * time.Second
+		if argument != "" {
+			var err error
+			if interval, err = time.ParseDuration(argument); err != nil {
+				return "", nil, fmt.Errorf("Invalid ticker interval: %s", err)
+			}
+		}
+		if interval <= 0 {
+			return "", nil, errors.New("The ticker interval must be positive")
+		}
+		return topic, newTickerPublisher(interval), nil
+	case "file":
+		if argument == "" {
+			return "", nil, errors.New("The file publisher requires a path")
+		}
+		return topic, newFilePublisher(argument), nil
+	case "stdin":
+		return topic, newStdinPublisher(), nil
+	case "exec":
+		command := strings.Fields(argument)
+		if len(command) < 1 {
+			return "", nil, errors.New("The exec publisher requires a command")
+		}
+		return topic, newExecPublisher(command[0], command[1:]...), nil
+	}
+	return "", nil, fmt.Errorf("Unknown publisher kind '%s'", kind)
+}
+
+// publisherList implements the flag.Value interface
+// allowing the -publisher flag to be repeated
+type publisherList []string
+
+// String implements the flag.Value interface
+func (list *publisherList) String() string {
+	return strings.Join(*list, ",")
+}
+
+// Set implements the flag.Value interface
+func (list *publisherList) Set(definition string) error {
+	*list = append(*list, definition)
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParsePublisher tests parsing publisher definitions
+func TestParsePublisher(t *testing.T) {
+	for _, c := range []struct {
+		definition string
+		topic      string
+		check      func(Publisher) bool
+	}{
+		{"time=ticker", "time", func(pub Publisher) bool {
+			ticker, ok := pub.(*tickerPublisher)
+			return ok && ticker.interval == time.Second
+		}},
+		{"time=ticker:500ms", "time", func(pub Publisher) bool {
+			ticker, ok := pub.(*tickerPublisher)
+			return ok && ticker.interval == 500*time.Millisecond
+		}},
+		{"logs.app=file:/var/log/app.log", "logs.app", func(pub Publisher) bool {
+			file, ok := pub.(*filePublisher)
+			return ok && file.filePath == "/var/log/app.log"
+		}},
+		{"input=stdin", "input", func(pub Publisher) bool {
+			_, ok := pub.(*stdinPublisher)
+			return ok
+		}},
+		{"metrics=exec:vmstat -n 1", "metrics", func(pub Publisher) bool {
+			cmd, ok := pub.(*execPublisher)
+			return ok && cmd.name == "vmstat" &&
+				strings.Join(cmd.args, " ") == "-n 1"
+		}},
+	} {
+		topic, pub, err := parsePublisher(c.definition)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.definition, err)
+			continue
+		}
+		if topic != c.topic || !c.check(pub) {
+			t.Errorf(
+				"%s: unexpected publisher of %s: %+v",
+				c.definition,
+				topic,
+				pub,
+			)
+		}
+	}
+
+	for _, invalid := range []string{
+		"time",
+		"time=",
+		"time=clock",
+		"time=ticker:soon",
+		"time=ticker:0s",
+		"time=ticker:-1s",
+		"logs=file",
+		"logs=file:",
+		"metrics=exec",
+		"metrics=exec:  ",
+	} {
+		if _, _, err := parsePublisher(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+// TestPublishLines tests publishing the non-empty lines
+// as JSON messages
+func TestPublishLines(t *testing.T) {
+	var published []string
+	err := publishLines(
+		strings.NewReader("{\"a\": 1}\n\n  plain text  \n42\n\"quoted\""),
+		make(chan struct{}),
+		func(message []byte) {
+			published = append(published, string(message))
+		},
+	)
+	if err != nil {
+		t.Fatalf("publishing failed: %s", err)
+	}
+	expected := []string{`{"a": 1}`, `"plain text"`, `42`, `"quoted"`}
+	if strings.Join(published, "|") != strings.Join(expected, "|") {
+		t.Fatalf("published %v, expected %v", published, expected)
+	}
+}
+
+// TestFilePublisher tests publishing the lines appended to a file
+// starting over once it's truncated or replaced
+func TestFilePublisher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pubsub")
+	if err != nil {
+		t.Fatalf("couldn't create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "input.log")
+	if err := ioutil.WriteFile(filePath, []byte("old\n"), 0600); err != nil {
+		t.Fatalf("couldn't write file: %s", err)
+	}
+
+	messages := make(chan string, 16)
+	pub := newFilePublisher(filePath)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- pub.Run(func(message []byte) {
+			messages <- string(message)
+		})
+	}()
+	defer func() {
+		pub.Stop()
+		if err := <-stopped; err != nil {
+			t.Errorf("publisher failed: %s", err)
+		}
+	}()
+
+	// Wait for the publisher to skip the existing lines
+	time.Sleep(filePollInterval / 2)
+
+	expect := func(expected ...string) {
+		t.Helper()
+		for _, message := range expected {
+			select {
+			case published := <-messages:
+				if published != message {
+					t.Fatalf("published %s, expected %s", published, message)
+				}
+			case <-time.After(testTimeout):
+				t.Fatalf("%s not published", message)
+			}
+		}
+	}
+	appendFile := func(contents string) {
+		t.Helper()
+		file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatalf("couldn't open file: %s", err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(contents); err != nil {
+			t.Fatalf("couldn't append to file: %s", err)
+		}
+	}
+
+	// Lines are published once they're terminated
+	appendFile("{\"level\":\"info\"}\n\nunterminated")
+	expect(`{"level":"info"}`)
+	appendFile(" line\n")
+	expect(`"unterminated line"`)
+
+	// A truncated file is read from the start
+	if err := ioutil.WriteFile(filePath, []byte("a\n"), 0600); err != nil {
+		t.Fatalf("couldn't truncate file: %s", err)
+	}
+	expect(`"a"`)
+
+	// A replaced file is read from the start
+	replacement := filepath.Join(dir, "replacement.log")
+	if err := ioutil.WriteFile(
+		replacement,
+		[]byte("first\nsecond\n"),
+		0600,
+	); err != nil {
+		t.Fatalf("couldn't write file: %s", err)
+	}
+	if err := os.Rename(replacement, filePath); err != nil {
+		t.Fatalf("couldn't replace file: %s", err)
+	}
+	expect(`"first"`, `"second"`)
+
+	select {
+	case message := <-messages:
+		t.Fatalf("unexpected message %s", message)
+	case <-time.After(2 * filePollInterval):
+	}
+}
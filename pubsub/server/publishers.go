@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// filePollInterval defines how often the file publisher
+// checks the file for new lines
+const filePollInterval = 250 * time.Millisecond
+
+// stopper implements the Stop method of the publishers
+type stopper struct {
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newStopper creates a new stopper
+func newStopper() stopper {
+	return stopper{stop: make(chan struct{})}
+}
+
+// Stop implements the Publisher interface
+func (stp *stopper) Stop() {
+	stp.stopOnce.Do(func() { close(stp.stop) })
+}
+
+// lineMessage returns the JSON encoded message of a line.
+// Lines that are valid JSON are published as is,
+// other lines are published as JSON strings
+func lineMessage(line []byte) ([]byte, error) {
+	line = bytes.TrimSpace(line)
+	if json.Valid(line) {
+		return append([]byte(nil), line...), nil
+	}
+	return json.Marshal(string(line))
+}
+
+// publishLines publishes each non-empty line read from the reader
+// until the reader is exhausted or the stop channel is closed
+func publishLines(
+	reader io.Reader,
+	stop <-chan struct{},
+	publish func(message []byte),
+) error {
+	// The lines are scanned in a separate goroutine
+	// since reads can't be interrupted
+	lines := make(chan []byte)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			select {
+			case lines <- append([]byte(nil), scanner.Bytes()...):
+			case <-stop:
+				return
+			}
+		}
+		scanErr <- scanner.Err()
+	}()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				select {
+				case err := <-scanErr:
+					return err
+				default:
+					return nil
+				}
+			}
+			if len(bytes.TrimSpace(line)) < 1 {
+				continue
+			}
+			message, err := lineMessage(line)
+			if err != nil {
+				return err
+			}
+			publish(message)
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// tickerPublisher publishes the current time in a fixed interval
+type tickerPublisher struct {
+	stopper
+	interval time.Duration
+}
+
+// newTickerPublisher creates a new ticker publisher
+func newTickerPublisher(interval time.Duration) *tickerPublisher {
+	return &tickerPublisher{
+		stopper:  newStopper(),
+		interval: interval,
+	}
+}
+
+// Run implements the Publisher interface
+func (pub *tickerPublisher) Run(publish func(message []byte)) error {
+	ticker := time.NewTicker(pub.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			message, err := json.Marshal(now.String())
+			if err != nil {
+				return fmt.Errorf("Couldn't marshal time: %s", err)
+			}
+			publish(message)
+		case <-pub.stop:
+			return nil
+		}
+	}
+}
+
+// filePublisher publishes the lines appended to a file.
+// The file is read from the start again if it's truncated or replaced
+type filePublisher struct {
+	stopper
+	filePath string
+}
+
+// newFilePublisher creates a new file publisher
+func newFilePublisher(filePath string) *filePublisher {
+	return &filePublisher{
+		stopper:  newStopper(),
+		filePath: filePath,
+	}
+}
+
+// Run implements the Publisher interface.
+// Only lines appended after the publisher was started are published
+func (pub *filePublisher) Run(publish func(message []byte)) error {
+	file, err := os.Open(pub.filePath)
+	if err != nil {
+		return fmt.Errorf("Couldn't open file: %s", err)
+	}
+	defer func() { file.Close() }()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("Couldn't seek file: %s", err)
+	}
+	reader := bufio.NewReader(file)
+
+	// partial is the last line read that wasn't terminated yet
+	var partial []byte
+
+	ticker := time.NewTicker(filePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-pub.stop:
+			return nil
+		}
+
+		// Start over if the file was truncated or replaced
+		opened, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("Couldn't stat file: %s", err)
+		}
+		current, err := os.Stat(pub.filePath)
+		if err == nil && !os.SameFile(opened, current) {
+			replaced, err := os.Open(pub.filePath)
+			if err != nil {
+				return fmt.Errorf("Couldn't reopen file: %s", err)
+			}
+			file.Close()
+			file, opened = replaced, current
+			offset = 0
+			reader.Reset(file)
+			partial = nil
+		}
+		if opened.Size() < offset {
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("Couldn't seek file: %s", err)
+			}
+			offset = 0
+			reader.Reset(file)
+			partial = nil
+		}
+
+		for {
+			line, err := reader.ReadBytes('\n')
+			offset += int64(len(line))
+			if err == io.EOF {
+				partial = append(partial, line...)
+				break
+			} else if err != nil {
+				return fmt.Errorf("Couldn't read file: %s", err)
+			}
+			line = append(partial, line...)
+			partial = nil
+			if len(bytes.TrimSpace(line)) < 1 {
+				continue
+			}
+			message, err := lineMessage(line)
+			if err != nil {
+				return err
+			}
+			publish(message)
+		}
+	}
+}
+
+// stdinPublisher publishes the lines read from the standard input
+type stdinPublisher struct {
+	stopper
+}
+
+// newStdinPublisher creates a new standard input publisher
+func newStdinPublisher() *stdinPublisher {
+	return &stdinPublisher{stopper: newStopper()}
+}
+
+// Run implements the Publisher interface
+func (pub *stdinPublisher) Run(publish func(message []byte)) error {
+	return publishLines(os.Stdin, pub.stop, publish)
+}
+
+// execPublisher runs a local command and publishes
+// the lines it writes to its standard output
+type execPublisher struct {
+	stopper
+	name string
+	args []string
+}
+
+// newExecPublisher creates a new exec publisher
+func newExecPublisher(name string, args ...string) *execPublisher {
+	return &execPublisher{
+		stopper: newStopper(),
+		name:    name,
+		args:    args,
+	}
+}
+
+// Run implements the Publisher interface.
+// The command is killed once the publisher is stopped
+func (pub *execPublisher) Run(publish func(message []byte)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-pub.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	cmd := exec.CommandContext(ctx, pub.name, pub.args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("Couldn't pipe command output: %s", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Couldn't start command: %s", err)
+	}
+
+	if err := publishLines(stdout, pub.stop, publish); err != nil {
+		cancel()
+		cmd.Wait()
+		return err
+	}
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("Command failed: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// maxPendingDeliveries defines the maximum number of unacknowledged
+// deliveries kept for retransmission per connection
+const maxPendingDeliveries = 1024
+
+// maxDeliveryAttempts defines how many times a delivery is sent
+// before the server gives up on it
+const maxDeliveryAttempts = 5
+
+// errQueueFull is returned by Prepare if too many deliveries are pending
+var errQueueFull = errors.New("too many deliveries pending acknowledgement")
+
+// pendingDelivery represents a delivery awaiting acknowledgement
+type pendingDelivery struct {
+	seq      uint64
+	offset   uint64
+	topic    string
+	message  json.RawMessage
+	expires  *time.Time
+	payload  []byte
+	durables []durableKey
+	sent     time.Time
+	attempts int
+}
+
+// reliableDelivery keeps track of the unacknowledged deliveries
+// of a connection in reliable mode
+type reliableDelivery struct {
+	lastSeq uint64
+	pending map[uint64]*pendingDelivery
+	lock    sync.Mutex
+}
+
+// newReliableDelivery creates a new delivery tracker
+func newReliableDelivery() *reliableDelivery {
+	return &reliableDelivery{
+		pending: make(map[uint64]*pendingDelivery),
+	}
+}
+
+// Prepare assigns the next sequence number to the logged message and
+// registers it pending together with the matching durable subscriptions.
+// Returns errQueueFull if too many deliveries are pending
+func (rel *reliableDelivery) Prepare(
+	entry logEntry,
+	durables []durableKey,
+) (*pendingDelivery, error) {
+	rel.lock.Lock()
+	defer rel.lock.Unlock()
+
+	if len(rel.pending) >= maxPendingDeliveries {
+		return nil, errQueueFull
+	}
+
+	rel.lastSeq++
+	payload, err := json.Marshal(shared.Delivery{
+		Seq:     rel.lastSeq,
+		Offset:  entry.Offset,
+		Message: entry.Message,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't marshal delivery: %s", err)
+	}
+	delivery := &pendingDelivery{
+		seq:      rel.lastSeq,
+		offset:   entry.Offset,
+		topic:    entry.Topic,
+		message:  append(json.RawMessage(nil), entry.Message...),
+		expires:  entry.Expires,
+		payload:  payload,
+		durables: durables,
+		sent:     time.Now(),
+		attempts: 1,
+	}
+	rel.pending[delivery.seq] = delivery
+	return delivery, nil
+}
+
+// Ack removes and returns the acknowledged delivery.
+// Returns nil if no such delivery is pending
+func (rel *reliableDelivery) Ack(seq uint64) *pendingDelivery {
+	rel.lock.Lock()
+	defer rel.lock.Unlock()
+
+	delivery, isPending := rel.pending[seq]
+	if !isPending {
+		return nil
+	}
+	delete(rel.pending, seq)
+	return delivery
+}
+
+// Pending returns the number of deliveries pending acknowledgement
+func (rel *reliableDelivery) Pending() int {
+	rel.lock.Lock()
+	defer rel.lock.Unlock()
+	return len(rel.pending)
+}
+
+// LowestPending returns the lowest offset of the pending deliveries
+// matching the given durable subscription, 0 if there are none
+func (rel *reliableDelivery) LowestPending(key durableKey) uint64 {
+	rel.lock.Lock()
+	defer rel.lock.Unlock()
+
+	var lowest uint64
+	for _, delivery := range rel.pending {
+		if delivery.offset < 1 ||
+			(lowest > 0 && delivery.offset >= lowest) {
+			continue
+		}
+		for _, durable := range delivery.durables {
+			if durable == key {
+				lowest = delivery.offset
+				break
+			}
+		}
+	}
+	return lowest
+}
+
+// Due returns the deliveries that weren't acknowledged within the timeout
+// in the order of their sequence numbers and counts a new attempt for each.
+// Deliveries that exceeded the maximum number of attempts and deliveries
+// of expired messages are removed and returned separately
+func (rel *reliableDelivery) Due(timeout time.Duration) (
+	due []*pendingDelivery,
+	exhausted []*pendingDelivery,
+	expired []*pendingDelivery,
+) {
+	rel.lock.Lock()
+	defer rel.lock.Unlock()
+
+	now := time.Now()
+	for seq, delivery := range rel.pending {
+		if delivery.expires != nil && !now.Before(*delivery.expires) {
+			delete(rel.pending, seq)
+			expired = append(expired, delivery)
+			continue
+		}
+		if now.Sub(delivery.sent) < timeout {
+			continue
+		}
+		if delivery.attempts >= maxDeliveryAttempts {
+			delete(rel.pending, seq)
+			exhausted = append(exhausted, delivery)
+			continue
+		}
+		delivery.attempts++
+		delivery.sent = now
+		due = append(due, delivery)
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].seq < due[j].seq
+	})
+	return due, exhausted, expired
+}
+
+// Drain removes and returns all pending deliveries
+// in the order of their sequence numbers
+func (rel *reliableDelivery) Drain() []*pendingDelivery {
+	rel.lock.Lock()
+	defer rel.lock.Unlock()
+
+	drained := make([]*pendingDelivery, 0, len(rel.pending))
+	for _, delivery := range rel.pending {
+		drained = append(drained, delivery)
+	}
+	rel.pending = make(map[uint64]*pendingDelivery)
+	sort.Slice(drained, func(i, j int) bool {
+		return drained[i].seq < drained[j].seq
+	})
+	return drained
+}
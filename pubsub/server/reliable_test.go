@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// testEntry returns a logged message of the time topic
+func testEntry(offset uint64) logEntry {
+	return logEntry{
+		Offset:  offset,
+		Topic:   "time",
+		Message: json.RawMessage(`"12:00"`),
+	}
+}
+
+// TestReliablePrepareAck tests numbering the deliveries
+// and removing them once acknowledged
+func TestReliablePrepareAck(t *testing.T) {
+	rel := newReliableDelivery()
+	for seq := uint64(1); seq <= 3; seq++ {
+		delivery, err := rel.Prepare(testEntry(seq+10), nil)
+		if err != nil {
+			t.Fatalf("preparing failed: %s", err)
+		}
+		if delivery.seq != seq || delivery.attempts != 1 {
+			t.Fatalf("unexpected delivery: %+v", delivery)
+		}
+
+		var decoded shared.Delivery
+		if err := json.Unmarshal(delivery.payload, &decoded); err != nil {
+			t.Fatalf("invalid payload: %s", err)
+		}
+		if decoded.Seq != seq || decoded.Offset != seq+10 {
+			t.Fatalf("unexpected payload: %+v", decoded)
+		}
+	}
+
+	if delivery := rel.Ack(2); delivery == nil || delivery.offset != 12 {
+		t.Fatalf("unexpected acknowledged delivery: %+v", delivery)
+	}
+	if delivery := rel.Ack(2); delivery != nil {
+		t.Fatal("delivery acknowledged twice")
+	}
+	if pending := rel.Pending(); pending != 2 {
+		t.Fatalf("unexpected pending deliveries: %d", pending)
+	}
+}
+
+// TestReliableQueueFull tests rejecting deliveries
+// while too many are pending acknowledgement
+func TestReliableQueueFull(t *testing.T) {
+	rel := newReliableDelivery()
+	for i := 0; i < maxPendingDeliveries; i++ {
+		if _, err := rel.Prepare(testEntry(uint64(i+1)), nil); err != nil {
+			t.Fatalf("preparing failed: %s", err)
+		}
+	}
+	if _, err := rel.Prepare(testEntry(0), nil); err != errQueueFull {
+		t.Fatalf("expected errQueueFull, got %v", err)
+	}
+
+	rel.Ack(1)
+	if _, err := rel.Prepare(testEntry(0), nil); err != nil {
+		t.Fatalf("preparing failed after acknowledgement: %s", err)
+	}
+}
+
+// TestReliableDue tests retransmitting unacknowledged deliveries
+// in order until they exceed the maximum number of attempts
+func TestReliableDue(t *testing.T) {
+	rel := newReliableDelivery()
+	for offset := uint64(1); offset <= 3; offset++ {
+		rel.Prepare(testEntry(offset), nil)
+	}
+
+	if due, exhausted, _ := rel.Due(time.Hour); len(due) != 0 ||
+		len(exhausted) != 0 {
+		t.Fatalf("deliveries due before the timeout: %v", due)
+	}
+
+	rel.Ack(2)
+	for attempt := 2; attempt <= maxDeliveryAttempts; attempt++ {
+		due, exhausted, _ := rel.Due(0)
+		if len(due) != 2 || len(exhausted) != 0 {
+			t.Fatalf("attempt %d: unexpected due deliveries: %v", attempt, due)
+		}
+		if due[0].seq != 1 || due[1].seq != 3 {
+			t.Fatalf("attempt %d: deliveries out of order", attempt)
+		}
+		if due[0].attempts != attempt {
+			t.Fatalf("unexpected attempts: %d", due[0].attempts)
+		}
+	}
+
+	due, exhausted, _ := rel.Due(0)
+	if len(due) != 0 || len(exhausted) != 2 {
+		t.Fatalf("deliveries not exhausted: %v, %v", due, exhausted)
+	}
+	if pending := rel.Pending(); pending != 0 {
+		t.Fatalf("exhausted deliveries still pending: %d", pending)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// replayPageSize defines the maximum number of log entries
+// read at once while replaying
+const replayPageSize = 256
+
+// maxPendingReplayed defines the number of reliable deliveries
+// pending acknowledgement at which a replay pauses until acknowledgements
+// arrive. The rest of the queue is left to the live messages
+const maxPendingReplayed = maxPendingDeliveries / 2
+
+// replayPollInterval defines how often a paused replay checks
+// whether the outbox of the client drained
+const replayPollInterval = 100 * time.Millisecond
+
+// replayStatus represents the state of a replay after delivering a page
+type replayStatus int
+
+const (
+	// replayProceeding indicates the replay can proceed with the next page
+	replayProceeding replayStatus = iota
+
+	// replayPaused indicates the replay waits for acknowledgements
+	// or for the outbox of the client to drain
+	replayPaused
+
+	// replayEnded indicates the replay caught up with the log,
+	// was cancelled or the client is gone
+	replayEnded
+)
+
+// replay represents the replay of the logged messages
+// to a subscription resuming from an offset.
+// The messages published while replaying are skipped by Publish
+// and delivered by the replay instead
+type replay struct {
+	client  wwr.Connection
+	state   *clientState
+	pattern string
+	filter  *filter
+
+	// from is the offset the replay started at
+	from uint64
+
+	// next is the offset of the next entry to replay,
+	// it's only accessed by the replaying goroutine
+	next uint64
+
+	replayed int
+
+	// resume is signaled when acknowledgements arrive
+	resume chan struct{}
+
+	// done is closed when the replay is cancelled
+	done chan struct{}
+}
+
+// newReplay creates a new replay of the subscription
+// starting at the given offset
+func newReplay(
+	client wwr.Connection,
+	state *clientState,
+	pattern string,
+	flt *filter,
+	from uint64,
+) *replay {
+	return &replay{
+		client:  client,
+		state:   state,
+		pattern: pattern,
+		filter:  flt,
+		from:    from,
+		next:    from,
+		resume:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// matches returns true if the entry is replayed to the subscription.
+// Expired messages are skipped
+func (rp *replay) matches(entry logEntry, now time.Time) bool {
+	if !shared.MatchTopic(rp.pattern, entry.Topic) || entry.Expired(now) {
+		return false
+	}
+	return rp.filter == nil || rp.filter.MatchMessage(entry.Message)
+}
+
+// readPage reads the next page of the log and returns the matching entries
+// and the offset of the entry following the page
+func (rp *replay) readPage(tlog *topicLog) ([]logEntry, uint64, error) {
+	var page []logEntry
+	next := rp.next
+	now := time.Now()
+	err := tlog.Read(rp.next, func(entry logEntry) bool {
+		next = entry.Offset + 1
+		if rp.matches(entry, now) {
+			page = append(page, entry)
+		}
+		return next-rp.next < replayPageSize
+	})
+	return page, next, err
+}
+
+// Resume wakes the replay up if it's paused
+func (rp *replay) Resume() {
+	select {
+	case rp.resume <- struct{}{}:
+	default:
+	}
+}
+
+// Cancel stops the replay
+func (rp *replay) Cancel() {
+	close(rp.done)
+}
+
+// replaying returns true if the message is delivered by a replay
+// of the client rather than live
+func replaying(state *clientState, topic string, doc *filterDocument) bool {
+	for pattern := range state.replays {
+		if !shared.MatchTopic(pattern, topic) {
+			continue
+		}
+		flt, isFiltered := state.filters[pattern]
+		if !isFiltered || flt.Match(doc.Value()) {
+			return true
+		}
+	}
+	return false
+}
+
+// resumeReplays wakes up the paused replays of the client
+// once reliable deliveries are no longer pending.
+// The caller is expected to hold the lock
+func resumeReplays(state *clientState) {
+	for _, rp := range state.replays {
+		rp.Resume()
+	}
+}
+
+// busy returns true if too many reliable deliveries are pending
+// acknowledgement or the outbox of the client is half full.
+// The rest of the queues is left to the live messages
+func (rp *replay) busy() bool {
+	if rp.state.outbox.Pending() >= rp.state.outbox.Capacity()/2 {
+		return true
+	}
+	return rp.state.reliable != nil &&
+		rp.state.reliable.Pending() >= maxPendingReplayed
+}
+
+// deliverPage queues the replayed entries for the client
+// and advances the replay to the given offset. The replay pauses before
+// an entry while the client is busy.
+// The caller is expected to hold the lock
+func (srv *PubSubServer) deliverPage(
+	rp *replay,
+	page []logEntry,
+	next uint64,
+) replayStatus {
+	if rp.state.replays[rp.pattern] != rp {
+		// Cancelled
+		return replayEnded
+	}
+	for _, entry := range page {
+		if rp.busy() {
+			rp.next = entry.Offset
+			return replayPaused
+		}
+		if !srv.deliver(rp.client, rp.state, entry) {
+			return replayEnded
+		}
+		rp.replayed++
+	}
+	rp.next = next
+	return replayProceeding
+}
+
+// catchUp delivers the entries appended since the last page
+// while publishers are blocked by the lock and ends the replay
+// once it reached the end of the log
+func (srv *PubSubServer) catchUp(rp *replay) (replayStatus, error) {
+	srv.mapLock.Lock()
+	defer srv.mapLock.Unlock()
+
+	page, next, err := rp.readPage(srv.log)
+	if err != nil {
+		return replayEnded, err
+	}
+	status := srv.deliverPage(rp, page, next)
+	if status != replayProceeding || rp.next <= srv.log.End() {
+		return status, nil
+	}
+
+	// Caught up, further messages are delivered live
+	delete(rp.state.replays, rp.pattern)
+	log.Printf(
+		"Replayed %d messages from offset %d to client %s",
+		rp.replayed,
+		rp.from,
+		rp.client.RemoteAddr(),
+	)
+	return replayEnded, nil
+}
+
+// replay delivers the logged messages page by page. Pages are read
+// without holding the lock and delivered holding the read lock,
+// only catching up with the end of the log blocks the publishers.
+// The replay pauses while the client is busy and resumes as
+// acknowledgements arrive or the outbox of the client drains.
+// Blocks the calling goroutine until the replay ended
+func (srv *PubSubServer) replay(rp *replay) {
+	for {
+		page, next, err := rp.readPage(srv.log)
+		status := replayProceeding
+		switch {
+		case err != nil:
+			status = replayEnded
+		case next == rp.next:
+			status, err = srv.catchUp(rp)
+		default:
+			srv.mapLock.RLock()
+			status = srv.deliverPage(rp, page, next)
+			srv.mapLock.RUnlock()
+		}
+		if err != nil {
+			log.Printf(
+				"WARNING: replay to client %s failed : %s",
+				rp.client.RemoteAddr(),
+				err,
+			)
+			srv.cancelReplay(rp)
+			return
+		}
+
+		switch status {
+		case replayEnded:
+			return
+		case replayPaused:
+			select {
+			case <-rp.resume:
+			case <-time.After(replayPollInterval):
+			case <-rp.done:
+				return
+			}
+		}
+	}
+}
+
+// cancelReplay cancels the replay unless it already ended
+func (srv *PubSubServer) cancelReplay(rp *replay) {
+	srv.mapLock.Lock()
+	defer srv.mapLock.Unlock()
+	if rp.state.replays[rp.pattern] == rp {
+		delete(rp.state.replays, rp.pattern)
+		rp.Cancel()
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// TestReplayPause tests pausing a reliable replay while too many
+// deliveries are pending acknowledgement and resuming it
+// as acknowledgements arrive
+func TestReplayPause(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.teardown()
+	srv.publish("time", maxPendingReplayed+10)
+
+	conn := srv.connect("")
+	srv.enableReliable(conn)
+	offset := uint64(1)
+	if err := srv.subscribe(conn, shared.SubscribeRequest{
+		Pattern: "time",
+		Offset:  &offset,
+	}); err != nil {
+		t.Fatalf("subscribing failed: %s", err)
+	}
+
+	var deliveries []shared.Delivery
+	for len(deliveries) < maxPendingReplayed {
+		deliveries = append(deliveries, conn.expectDelivery(t))
+	}
+	conn.expectSilence(t, 3*replayPollInterval)
+
+	for _, delivery := range deliveries[:5] {
+		srv.ack(conn, delivery.Seq)
+	}
+	for i := 0; i < 5; i++ {
+		delivery := conn.expectDelivery(t)
+		if expected := uint64(maxPendingReplayed + i + 1); delivery.Offset != expected {
+			t.Fatalf("replayed offset %d, expected %d", delivery.Offset, expected)
+		}
+	}
+	conn.expectSilence(t, 3*replayPollInterval)
+}
+
+// TestReplayCatchUp tests handing a replay over to the live delivery
+// without losing or duplicating the messages published meanwhile
+func TestReplayCatchUp(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.teardown()
+	srv.publish("time", maxPendingReplayed+10)
+
+	conn := srv.connect("")
+	srv.enableReliable(conn)
+	offset := uint64(1)
+	if err := srv.subscribe(conn, shared.SubscribeRequest{
+		Pattern: "time",
+		Offset:  &offset,
+	}); err != nil {
+		t.Fatalf("subscribing failed: %s", err)
+	}
+
+	// Messages published while paused are delivered by the replay
+	srv.publish("time", 10)
+
+	expected := uint64(1)
+	for expected <= uint64(maxPendingReplayed+20) {
+		delivery := conn.expectDelivery(t)
+		if delivery.Offset != expected {
+			t.Fatalf("delivered offset %d, expected %d", delivery.Offset, expected)
+		}
+		srv.ack(conn, delivery.Seq)
+		expected++
+	}
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		srv.mapLock.RLock()
+		replaying := len(srv.connectedClients[conn].replays) > 0
+		srv.mapLock.RUnlock()
+		if !replaying {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("replay didn't end")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	srv.publish("time", 1)
+	if delivery := conn.expectDelivery(t); delivery.Offset != expected {
+		t.Fatalf("delivered offset %d live, expected %d", delivery.Offset, expected)
+	}
+	conn.expectSilence(t, 3*replayPollInterval)
+}
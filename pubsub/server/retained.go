@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// retainedMessage represents the last retained message of a topic
+type retainedMessage struct {
+	Topic   string          `json:"-"`
+	Message json.RawMessage `json:"message"`
+
+	// Expires is the time the message expires, nil if it never expires
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// retainedStore keeps the last retained message of each topic
+// persisting them to a JSON file
+type retainedStore struct {
+	filePath string
+	messages map[string]retainedMessage
+	lock     sync.RWMutex
+}
+
+// newRetainedStore loads the retained messages from the given file.
+// The file is created once the first message is retained
+func newRetainedStore(filePath string) (*retainedStore, error) {
+	str := &retainedStore{
+		filePath: filePath,
+		messages: make(map[string]retainedMessage),
+	}
+	contents, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return str, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Couldn't read retained messages: %s", err)
+	}
+	if err := json.Unmarshal(contents, &str.messages); err != nil {
+		return nil, fmt.Errorf("Couldn't parse retained messages: %s", err)
+	}
+	for topic, retained := range str.messages {
+		if err := shared.ValidateTopic(topic); err != nil {
+			return nil, fmt.Errorf(
+				"Invalid retained topic '%s': %s",
+				topic,
+				err,
+			)
+		}
+		retained.Topic = topic
+		str.messages[topic] = retained
+	}
+	return str, nil
+}
+
+// Retain replaces the retained message of the given topic.
+// The message is no longer retained once it expires unless expires is nil.
+// A null message removes the retained message
+func (str *retainedStore) Retain(
+	topic string,
+	message []byte,
+	expires *time.Time,
+) error {
+	str.lock.Lock()
+	defer str.lock.Unlock()
+
+	if bytes.Equal(bytes.TrimSpace(message), []byte("null")) {
+		if _, exists := str.messages[topic]; !exists {
+			return nil
+		}
+		delete(str.messages, topic)
+	} else {
+		// Copy the message, the buffer of the request could be reused
+		str.messages[topic] = retainedMessage{
+			Topic:   topic,
+			Message: append(json.RawMessage(nil), message...),
+			Expires: expires,
+		}
+	}
+
+	encoded, err := json.Marshal(str.messages)
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal retained messages: %s", err)
+	}
+	if err := writeFileAtomic(str.filePath, encoded); err != nil {
+		return fmt.Errorf("Couldn't save retained messages: %s", err)
+	}
+	return nil
+}
+
+// Matching returns the unexpired retained messages of all topics
+// matching the given pattern ordered by topic
+func (str *retainedStore) Matching(pattern string) []retainedMessage {
+	str.lock.RLock()
+	defer str.lock.RUnlock()
+
+	now := time.Now()
+	var matching []retainedMessage
+	for topic, retained := range str.messages {
+		if retained.Expires != nil && !now.Before(*retained.Expires) {
+			continue
+		}
+		if shared.MatchTopic(pattern, topic) {
+			matching = append(matching, retained)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].Topic < matching[j].Topic
+	})
+	return matching
+}
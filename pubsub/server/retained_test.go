@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestRetainedStore creates an empty retained store
+// in a new temporary directory
+func newTestRetainedStore(t *testing.T) (*retainedStore, string) {
+	dir, err := ioutil.TempDir("", "pubsub")
+	if err != nil {
+		t.Fatalf("couldn't create directory: %s", err)
+	}
+	str, err := newRetainedStore(filepath.Join(dir, "retained.json"))
+	if err != nil {
+		t.Fatalf("couldn't create retained store: %s", err)
+	}
+	return str, dir
+}
+
+// retainTopics retains a message of each given topic
+func retainTopics(t *testing.T, str *retainedStore, topics ...string) {
+	for _, topic := range topics {
+		if err := str.Retain(topic, []byte(`"`+topic+`"`), nil); err != nil {
+			t.Fatalf("retaining failed: %s", err)
+		}
+	}
+}
+
+// expectRetained fails unless the retained messages matching the pattern
+// are those of the given topics
+func expectRetained(
+	t *testing.T,
+	str *retainedStore,
+	pattern string,
+	topics ...string,
+) {
+	t.Helper()
+	matching := str.Matching(pattern)
+	if len(matching) != len(topics) {
+		t.Fatalf("%s matched %+v, expected %v", pattern, matching, topics)
+	}
+	for i, retained := range matching {
+		if retained.Topic != topics[i] ||
+			string(retained.Message) != `"`+topics[i]+`"` {
+			t.Fatalf("%s matched %+v, expected %v", pattern, matching, topics)
+		}
+	}
+}
+
+// TestRetainedMatching tests looking up the retained messages
+// of the topics matching a pattern ordered by topic
+func TestRetainedMatching(t *testing.T) {
+	str, dir := newTestRetainedStore(t)
+	defer os.RemoveAll(dir)
+	retainTopics(t, str, "metrics.host2.cpu", "metrics.host1.cpu", "time")
+
+	expectRetained(t, str, "#", "metrics.host1.cpu", "metrics.host2.cpu", "time")
+	expectRetained(t, str, "metrics.*.cpu", "metrics.host1.cpu", "metrics.host2.cpu")
+	expectRetained(t, str, "metrics.host1.#", "metrics.host1.cpu")
+	expectRetained(t, str, "logs.#")
+
+	// Retaining a message replaces the previous one
+	if err := str.Retain("time", []byte(`"12:00"`), nil); err != nil {
+		t.Fatalf("retaining failed: %s", err)
+	}
+	if matching := str.Matching("time"); len(matching) != 1 ||
+		string(matching[0].Message) != `"12:00"` {
+		t.Fatalf("retained message not replaced: %+v", matching)
+	}
+}
+
+// TestRetainedNull tests removing retained messages by null messages
+func TestRetainedNull(t *testing.T) {
+	str, dir := newTestRetainedStore(t)
+	defer os.RemoveAll(dir)
+	retainTopics(t, str, "a", "b")
+
+	if err := str.Retain("a", []byte(" null\n"), nil); err != nil {
+		t.Fatalf("removing failed: %s", err)
+	}
+	expectRetained(t, str, "#", "b")
+
+	// Removing a topic without a retained message is a no-op
+	if err := str.Retain("c", []byte("null"), nil); err != nil {
+		t.Fatalf("removing failed: %s", err)
+	}
+	expectRetained(t, str, "#", "b")
+}
+
+// TestRetainedExpiry tests skipping expired retained messages
+func TestRetainedExpiry(t *testing.T) {
+	str, dir := newTestRetainedStore(t)
+	defer os.RemoveAll(dir)
+	retainTopics(t, str, "a")
+
+	expired := time.Now().Add(-time.Second)
+	expires := time.Now().Add(time.Hour)
+	if err := str.Retain("b", []byte(`"b"`), &expires); err != nil {
+		t.Fatalf("retaining failed: %s", err)
+	}
+	if err := str.Retain("c", []byte(`"c"`), &expired); err != nil {
+		t.Fatalf("retaining failed: %s", err)
+	}
+	expectRetained(t, str, "#", "a", "b")
+}
+
+// TestRetainedReload tests loading the retained messages
+// saved by a previous store
+func TestRetainedReload(t *testing.T) {
+	str, dir := newTestRetainedStore(t)
+	defer os.RemoveAll(dir)
+	retainTopics(t, str, "a", "b.c")
+	if err := str.Retain("a", []byte("null"), nil); err != nil {
+		t.Fatalf("removing failed: %s", err)
+	}
+
+	reloaded, err := newRetainedStore(str.filePath)
+	if err != nil {
+		t.Fatalf("reloading failed: %s", err)
+	}
+	expectRetained(t, reloaded, "#", "b.c")
+
+	// Files with invalid topics are rejected
+	if err := ioutil.WriteFile(
+		str.filePath,
+		[]byte(`{"a..b": {"message": 1}}`),
+		0600,
+	); err != nil {
+		t.Fatalf("couldn't write file: %s", err)
+	}
+	if _, err := newRetainedStore(str.filePath); err == nil {
+		t.Fatal("invalid retained topic loaded")
+	}
+}
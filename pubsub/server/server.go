@@ -2,55 +2,506 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
 	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
 	wwrgorilla "github.com/qbeon/webwire-go-gorilla"
 )
 
+// maxSubscriptions defines the maximum number of patterns
+// a single connection can be subscribed to
+const maxSubscriptions = 100
+
+// maxMessageTTL defines the maximum time to live of a published message
+const maxMessageTTL = 30 * 24 * time.Hour
+
+// clientState represents the state of a connected client
+type clientState struct {
+	// patterns is the set of patterns the client is subscribed to
+	patterns map[string]bool
+
+	// filters maps the patterns of filtered subscriptions
+	// to their compiled filters
+	filters map[string]*filter
+
+	// durables maps the patterns of durable subscriptions
+	// to their keys
+	durables map[string]durableKey
+
+	// replays maps the patterns of subscriptions replaying
+	// the logged messages to their replays
+	replays map[string]*replay
+
+	// reliable tracks the unacknowledged deliveries
+	// if the client enabled reliable delivery, otherwise nil
+	reliable *reliableDelivery
+
+	// outbox queues the messages to be sent to the client
+	outbox *outbox
+}
+
 // PubSubServer implements the webwire.ServerImplementation interface
 type PubSubServer struct {
-	broadcastInterval time.Duration
-	connectedClients  map[wwr.Connection]bool
-	mapLock           sync.Mutex
+	acl               *accessControl
+	connectedClients  map[wwr.Connection]*clientState
+	subscriptions     *topicTrie
+	retained          *retainedStore
+	log               *topicLog
+	durables          *durableStore
+	durableClients    map[durableKey]wwr.Connection
+	ackTimeout        time.Duration
+	outboxSize        int
+	deadLetterTopic   string
+	deadLetters       chan shared.DeadLetter
+	publishers        []hostedPublisher
+	runningPublishers sync.WaitGroup
+	mapLock           sync.RWMutex
+
+	// publishLock serializes appending messages to the log and queuing them
+	// for the subscribers, which keeps them in the order of their offsets
+	publishLock sync.Mutex
 }
 
 // NewPubSubServer constructs a new pub-sub
 // webwire server implementation instance
-func NewPubSubServer() *PubSubServer {
+func NewPubSubServer(
+	acl *accessControl,
+	retained *retainedStore,
+	topicLog *topicLog,
+	durables *durableStore,
+	ackTimeout time.Duration,
+	outboxSize int,
+	deadLetterTopic string,
+) *PubSubServer {
 	return &PubSubServer{
-		1 * time.Second,
-		make(map[wwr.Connection]bool),
-		sync.Mutex{},
+		acl:              acl,
+		connectedClients: make(map[wwr.Connection]*clientState),
+		subscriptions:    newTopicTrie(),
+		retained:         retained,
+		log:              topicLog,
+		durables:         durables,
+		durableClients:   make(map[durableKey]wwr.Connection),
+		ackTimeout:       ackTimeout,
+		outboxSize:       outboxSize,
+		deadLetterTopic:  deadLetterTopic,
+		deadLetters:      make(chan shared.DeadLetter, maxQueuedDeadLetters),
+	}
+}
+
+// sessionInfo returns the session info of the given client
+// or nil if the client isn't authenticated
+func sessionInfo(client wwr.Connection) *shared.SessionInfo {
+	session := client.Session()
+	if session == nil {
+		return nil
+	}
+	info, _ := session.Info.(*shared.SessionInfo)
+	return info
+}
+
+// handleAuth authenticates the client and creates a session
+func (srv *PubSubServer) handleAuth(
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	if client.HasSession() {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "RESTRICTED",
+			Message: "Already authenticated",
+		}
+	}
+
+	var credentials shared.Credentials
+	if err := json.Unmarshal(message.Payload(), &credentials); err != nil {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "DECODING_FAILURE",
+			Message: fmt.Sprintf("Failed decoding credentials: %s", err),
+		}
+	}
+
+	// Both unknown users and wrong passwords are reported the same way
+	// to not reveal which usernames exist
+	info := srv.acl.Authenticate(credentials)
+	if info == nil {
+		log.Printf("Client %s failed to authenticate", client.RemoteAddr())
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "AUTH_FAILED",
+			Message: "Invalid username or password",
+		}
+	}
+	if err := client.CreateSession(info); err != nil {
+		return wwr.Payload{}, fmt.Errorf("Couldn't create session: %s", err)
+	}
+
+	log.Printf(
+		"Client %s authenticated as %s",
+		client.RemoteAddr(),
+		info.Username,
+	)
+	return wwr.Payload{}, nil
+}
+
+// handleReliable enables reliable delivery for the client
+func (srv *PubSubServer) handleReliable(
+	client wwr.Connection,
+	_ wwr.Message,
+) (wwr.Payload, error) {
+	srv.mapLock.Lock()
+	defer srv.mapLock.Unlock()
+
+	state, connected := srv.connectedClients[client]
+	if connected && state.reliable == nil {
+		state.reliable = newReliableDelivery()
+		log.Printf("Client %s enabled reliable delivery", client.RemoteAddr())
+	}
+	return wwr.Payload{}, nil
+}
+
+// handlePublish publishes a message on behalf of a client
+// allowed to publish to the topic
+func (srv *PubSubServer) handlePublish(
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	var req shared.PublishRequest
+	if err := json.Unmarshal(message.Payload(), &req); err != nil {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "DECODING_FAILURE",
+			Message: fmt.Sprintf("Failed decoding publish request: %s", err),
+		}
+	}
+	if err := shared.ValidateTopic(req.Topic); err != nil {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "INVALID_TOPIC",
+			Message: err.Error(),
+		}
+	}
+	if len(req.Message) < 1 {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "INVALID_MESSAGE",
+			Message: "The message is missing",
+		}
+	}
+	if req.TTL > uint64(maxMessageTTL/time.Millisecond) {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code: "INVALID_TTL",
+			Message: fmt.Sprintf(
+				"The time to live must not exceed %d milliseconds",
+				maxMessageTTL/time.Millisecond,
+			),
+		}
+	}
+	if req.Topic == srv.deadLetterTopic {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "FORBIDDEN",
+			Message: "The dead-letter topic is reserved to the server",
+		}
+	}
+	if !srv.acl.MayPublish(sessionInfo(client), req.Topic) {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "FORBIDDEN",
+			Message: fmt.Sprintf("Not allowed to publish to %s", req.Topic),
+		}
+	}
+
+	if err := srv.Publish(
+		req.Topic,
+		req.Message,
+		time.Duration(req.TTL)*time.Millisecond,
+		req.Retain,
+	); err != nil {
+		return wwr.Payload{}, err
+	}
+	return wwr.Payload{}, nil
+}
+
+// deliver queues a logged message for the client. Messages to clients
+// in reliable mode are numbered and kept until acknowledged, the offsets
+// of matching durable subscriptions are committed once acknowledged.
+// Otherwise they're committed once sent. Messages that couldn't be queued
+// are dead-lettered and false is returned.
+// The caller is expected to hold the lock
+func (srv *PubSubServer) deliver(
+	client wwr.Connection,
+	state *clientState,
+	entry logEntry,
+) bool {
+	durables := matchingDurables(state, entry.Topic)
+	if state.reliable == nil {
+		if !state.outbox.Enqueue(outboundSignal{
+			topic:    entry.Topic,
+			payload:  entry.Message,
+			offset:   entry.Offset,
+			durables: durables,
+		}) {
+			srv.deadLetter(
+				client,
+				entry.Topic,
+				entry.Offset,
+				entry.Message,
+				shared.DeadLetterQueueFull,
+			)
+			return false
+		}
+		return true
+	}
+
+	delivery, err := state.reliable.Prepare(entry, durables)
+	if err == errQueueFull {
+		srv.deadLetter(
+			client,
+			entry.Topic,
+			entry.Offset,
+			entry.Message,
+			shared.DeadLetterQueueFull,
+		)
+		return false
+	} else if err != nil {
+		log.Printf(
+			"WARNING: dropping message to %s for client %s : %s",
+			entry.Topic,
+			client.RemoteAddr(),
+			err,
+		)
+		return false
+	}
+	// Deliveries that couldn't be queued or sent are retransmitted
+	// or dead-lettered once the client is gone
+	return state.outbox.Enqueue(outboundSignal{
+		topic:    delivery.topic,
+		payload:  delivery.payload,
+		offset:   delivery.offset,
+		reliable: true,
+	})
+}
+
+// sent commits the offsets of the durable subscriptions
+// an unreliable delivery matched once it was sent
+func (srv *PubSubServer) sent(sig outboundSignal) {
+	if sig.reliable || sig.offset < 1 {
+		return
+	}
+	for _, key := range sig.durables {
+		srv.durables.Commit(key, sig.offset)
 	}
 }
 
-// OnSignal implements the webwire.ServerImplementation interface
-// Does nothing, not needed in this example
+// undeliverable returns the function dead-lettering the unreliable
+// deliveries that couldn't be sent to the client
+func (srv *PubSubServer) undeliverable(
+	client wwr.Connection,
+) func(outboundSignal) {
+	return func(sig outboundSignal) {
+		if sig.reliable {
+			return
+		}
+		srv.deadLetter(
+			client,
+			sig.topic,
+			sig.offset,
+			sig.payload,
+			shared.DeadLetterClientGone,
+		)
+	}
+}
+
+// Publish sends the JSON encoded message to all clients
+// subscribed to patterns matching the given topic.
+// Subscriptions still replaying receive it from their replay.
+// The message expires after the given time to live unless it's 0.
+// A retained message replaces the retained message of the topic
+// and is delivered to future subscribers, a retained null message
+// removes it
+func (srv *PubSubServer) Publish(
+	topic string,
+	message []byte,
+	ttl time.Duration,
+	retain bool,
+) error {
+	srv.publishLock.Lock()
+	defer srv.publishLock.Unlock()
+	srv.mapLock.RLock()
+	defer srv.mapLock.RUnlock()
+
+	entry, err := srv.log.Append(topic, message, ttl)
+	if err != nil {
+		return err
+	}
+	if retain {
+		err := srv.retained.Retain(topic, message, entry.Expires)
+		if err != nil {
+			return err
+		}
+	}
+
+	subscribers := srv.subscriptions.Match(topic)
+	if len(subscribers) < 1 {
+		return nil
+	}
+
+	log.Printf(
+		"Publishing message to %s, %d subscribers",
+		topic,
+		len(subscribers),
+	)
+	doc := &filterDocument{message: message}
+	for client := range subscribers {
+		state := srv.connectedClients[client]
+		if accepts(state, topic, doc) && !replaying(state, topic, doc) {
+			srv.deliver(client, state, entry)
+		}
+	}
+	return nil
+}
+
+// OnSignal implements the webwire.ServerImplementation interface.
+// Handles the acknowledgements of reliable deliveries committing
+// the offsets of the durable subscriptions they matched
+// and resuming paused replays
 func (srv *PubSubServer) OnSignal(
 	_ context.Context,
-	_ wwr.Connection,
-	_ wwr.Message,
+	client wwr.Connection,
+	message wwr.Message,
 ) {
+	if string(message.Name()) != shared.SignalAck {
+		return
+	}
+	seq, err := strconv.ParseUint(string(message.Payload()), 10, 64)
+	if err != nil {
+		log.Printf(
+			"WARNING: invalid acknowledgement from client %s : %s",
+			client.RemoteAddr(),
+			err,
+		)
+		return
+	}
+
+	srv.mapLock.RLock()
+	defer srv.mapLock.RUnlock()
+
+	state, connected := srv.connectedClients[client]
+	if !connected || state.reliable == nil {
+		return
+	}
+	delivery := state.reliable.Ack(seq)
+	if delivery == nil {
+		return
+	}
+	resumeReplays(state)
+	if delivery.offset < 1 {
+		return
+	}
+	for _, key := range delivery.durables {
+		// Offsets of pending deliveries must not be committed
+		// before they're acknowledged
+		offset := delivery.offset
+		if lowest := state.reliable.LowestPending(key); lowest > 0 &&
+			lowest <= offset {
+			offset = lowest - 1
+		}
+		srv.durables.Commit(key, offset)
+	}
+}
+
+// Retransmit begins retransmitting the reliable deliveries
+// that weren't acknowledged in time. Blocks the calling goroutine
+func (srv *PubSubServer) Retransmit() {
+	for {
+		time.Sleep(srv.ackTimeout / 2)
+		srv.retransmitDue()
+	}
+}
+
+// retransmitDue retransmits the reliable deliveries that weren't
+// acknowledged in time. Deliveries that exceeded the maximum number
+// of attempts or expired are dead-lettered
+func (srv *PubSubServer) retransmitDue() {
+	srv.mapLock.RLock()
+	defer srv.mapLock.RUnlock()
+
+	for client, state := range srv.connectedClients {
+		if state.reliable == nil {
+			continue
+		}
+		due, exhausted, expired := state.reliable.Due(srv.ackTimeout)
+		for _, delivery := range due {
+			log.Printf(
+				"Retransmitting delivery %d of %s to client %s",
+				delivery.seq,
+				delivery.topic,
+				client.RemoteAddr(),
+			)
+			state.outbox.Enqueue(outboundSignal{
+				topic:    delivery.topic,
+				payload:  delivery.payload,
+				offset:   delivery.offset,
+				reliable: true,
+			})
+		}
+		for _, delivery := range exhausted {
+			log.Printf(
+				"WARNING: giving up delivery %d of %s "+
+					"to client %s after %d attempts",
+				delivery.seq,
+				delivery.topic,
+				client.RemoteAddr(),
+				delivery.attempts,
+			)
+			srv.deadLetter(
+				client,
+				delivery.topic,
+				delivery.offset,
+				delivery.message,
+				shared.DeadLetterRetriesExceeded,
+			)
+		}
+		for _, delivery := range expired {
+			srv.deadLetter(
+				client,
+				delivery.topic,
+				delivery.offset,
+				delivery.message,
+				shared.DeadLetterExpired,
+			)
+		}
+		if len(exhausted) > 0 || len(expired) > 0 {
+			resumeReplays(state)
+		}
+	}
 }
 
 // OnRequest implements the webwire.ServerImplementation interface.
-// Does nothing, not needed in this example
+// Dispatches the request to the according handler
 func (srv *PubSubServer) OnRequest(
 	_ context.Context,
-	_ wwr.Connection,
-	_ wwr.Message,
+	client wwr.Connection,
+	message wwr.Message,
 ) (response wwr.Payload, err error) {
+	switch string(message.Name()) {
+	case shared.RequestSubscribe:
+		return srv.handleSubscribe(client, message)
+	case shared.RequestUnsubscribe:
+		return srv.handleUnsubscribe(client, message)
+	case shared.RequestAuth:
+		return srv.handleAuth(client, message)
+	case shared.RequestReliable:
+		return srv.handleReliable(client, message)
+	case shared.RequestPublish:
+		return srv.handlePublish(client, message)
+	}
 	return wwr.Payload{}, wwr.ErrRequest{
 		Code:    "REQ_NOT_SUPPORTED",
-		Message: "Requests are not supported on this server",
+		Message: fmt.Sprintf("Unsupported request name: %s", message.Name()),
 	}
 }
 
@@ -61,67 +512,204 @@ func (srv *PubSubServer) OnClientConnected(
 	client wwr.Connection,
 ) {
 	srv.mapLock.Lock()
-	srv.connectedClients[client] = true
+	srv.connectedClients[client] = &clientState{
+		patterns: make(map[string]bool),
+		durables: make(map[string]durableKey),
+		filters:  make(map[string]*filter),
+		replays:  make(map[string]*replay),
+		outbox: newOutbox(
+			client,
+			srv.outboxSize,
+			srv.sent,
+			srv.undeliverable(client),
+		),
+	}
 	srv.mapLock.Unlock()
 }
 
 // OnClientDisconnected implements the webwire.ServerImplementation interface
-// Deregisters a gone client
+// Deregisters a gone client and cancels all of its subscriptions.
+// Queued and unacknowledged reliable deliveries are dead-lettered unless
+// they're replayed once the durable subscriptions they matched are resumed
 func (srv *PubSubServer) OnClientDisconnected(
 	client wwr.Connection,
 	_ error,
 ) {
 	srv.mapLock.Lock()
-	delete(srv.connectedClients, client)
+	if state, connected := srv.connectedClients[client]; connected {
+		state.outbox.Close()
+		if state.reliable != nil {
+			for _, delivery := range state.reliable.Drain() {
+				if len(delivery.durables) > 0 && delivery.offset > 0 {
+					continue
+				}
+				srv.deadLetter(
+					client,
+					delivery.topic,
+					delivery.offset,
+					delivery.message,
+					shared.DeadLetterClientGone,
+				)
+			}
+		}
+		for pattern := range state.patterns {
+			srv.unsubscribe(client, state, pattern)
+		}
+		delete(srv.connectedClients, client)
+	}
 	srv.mapLock.Unlock()
 }
 
-// Broadcast begins sending the current time in 1 second intervals.
-// Blocks the calling goroutine
-func (srv *PubSubServer) Broadcast() {
-	for {
-		time.Sleep(1 * time.Second)
+// Accept -addr CLI parameter defining the server address, default to :8081
+var serverAddr = flag.String("addr", ":8081", "server address")
 
-		srv.mapLock.Lock()
+// Accept -acl-file CLI parameter defining the path to the access control
+// file defining the user accounts and their rights on topics
+var aclFilePath = flag.String(
+	"acl-file",
+	"./acl.json",
+	"path to the access control file",
+)
 
-		if len(srv.connectedClients) < 1 {
-			log.Println("No clients connected, aborting broadcast")
-			srv.mapLock.Unlock()
-			continue
-		}
+// Accept -retained-file CLI parameter defining the path to the file
+// the retained messages are persisted to
+var retainedFilePath = flag.String(
+	"retained-file",
+	"./retained.json",
+	"path to the file the retained messages are persisted to",
+)
 
-		msg := time.Now().String()
+// Accept -ack-timeout CLI parameter defining the duration after which
+// unacknowledged reliable deliveries are retransmitted
+var ackTimeout = flag.Duration(
+	"ack-timeout",
+	5*time.Second,
+	"duration after which unacknowledged reliable deliveries are retransmitted",
+)
 
-		log.Printf(
-			"Broadcasting message '%s', to %d clients",
-			msg,
-			len(srv.connectedClients),
-		)
+// Accept -outbox-size CLI parameter defining the maximum number of messages
+// queued per client, slow clients exceeding it are disconnected
+var outboxSize = flag.Int(
+	"outbox-size",
+	1024,
+	"maximum number of messages queued per client",
+)
 
-		for client := range srv.connectedClients {
-			client.Signal(nil, wwr.Payload{
-				Encoding: wwr.EncodingBinary,
-				Data:     []byte(msg),
-			})
-		}
-		srv.mapLock.Unlock()
-	}
+// Accept -dead-letter-topic CLI parameter defining the topic undeliverable
+// messages are published to, dead-lettering is disabled if empty
+var deadLetterTopic = flag.String(
+	"dead-letter-topic",
+	"deadletter",
+	"topic undeliverable messages are published to",
+)
+
+// Accept repeated -publisher CLI parameters mapping publishers to topics,
+// defaults to publishing the current time to the "time" topic every second
+var publishers publisherList
+
+func init() {
+	flag.Var(
+		&publishers,
+		"publisher",
+		"publisher of the form TOPIC=KIND[:ARGUMENT] "+
+			"where KIND is ticker, file, stdin or exec, may be repeated",
+	)
 }
 
-// Accept -addr CLI parameter defining the server address, default to :8081
-var serverAddr = flag.String("addr", ":8081", "server address")
+// Accept -log-file CLI parameter defining the path to the topic log
+var logFilePath = flag.String(
+	"log-file",
+	"./topics.log",
+	"path to the log of all published messages",
+)
+
+// Accept -durable-file CLI parameter defining the path to the file
+// the committed offsets of the durable subscriptions are persisted to
+var durableFilePath = flag.String(
+	"durable-file",
+	"./durable.json",
+	"path to the file the durable subscriptions are persisted to",
+)
 
 func main() {
 	// Parse command line arguments
 	flag.Parse()
+	if *ackTimeout <= 0 {
+		log.Fatal("The acknowledgement timeout must be positive")
+	}
+	if *outboxSize <= 0 {
+		log.Fatal("The outbox size must be positive")
+	}
+	if *deadLetterTopic != "" {
+		if err := shared.ValidateTopic(*deadLetterTopic); err != nil {
+			log.Fatalf("Invalid dead-letter topic: %s", err)
+		}
+	}
+
+	// Load the access control rules
+	acl, err := loadAccessControl(*aclFilePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Load the retained messages
+	retained, err := newRetainedStore(*retainedFilePath)
+	if err != nil {
+		panic(fmt.Errorf("Failed loading retained messages: %s", err))
+	}
+
+	// Open the topic log and the durable subscriptions
+	topicLog, err := openTopicLog(*logFilePath)
+	if err != nil {
+		panic(fmt.Errorf("Failed opening topic log: %s", err))
+	}
+	durables, err := newDurableStore(*durableFilePath)
+	if err != nil {
+		panic(fmt.Errorf("Failed loading durable subscriptions: %s", err))
+	}
+	defer func() {
+		if err := durables.Save(); err != nil {
+			log.Printf("ERROR: couldn't save durable subscriptions: %s", err)
+		}
+		if err := topicLog.Close(); err != nil {
+			log.Printf("ERROR: couldn't close topic log: %s", err)
+		}
+	}()
 
 	// Create a new webwire server implementation instance
-	serverImpl := NewPubSubServer()
+	serverImpl := NewPubSubServer(
+		acl,
+		retained,
+		topicLog,
+		durables,
+		*ackTimeout,
+		*outboxSize,
+		*deadLetterTopic,
+	)
+
+	// Map the publishers to their topics
+	if len(publishers) < 1 {
+		publishers = publisherList{"time=ticker:1s"}
+	}
+	for _, definition := range publishers {
+		topic, publisher, err := parsePublisher(definition)
+		if err != nil {
+			panic(err)
+		}
+		if err := serverImpl.AddPublisher(topic, publisher); err != nil {
+			panic(fmt.Errorf("Failed adding publisher of %s: %s", topic, err))
+		}
+	}
 
 	// Setup a new webwire server instance
 	server, err := wwr.NewServer(
 		serverImpl,
-		wwr.ServerOptions{},
+		wwr.ServerOptions{
+			// Session info parser function must override the default one
+			// for the session info object to be typed as shared.SessionInfo
+			// after a session restoration
+			SessionInfoParser: shared.SessionInfoParser,
+		},
 		&wwrgorilla.Transport{
 			Host: *serverAddr,
 		},
@@ -130,8 +718,17 @@ func main() {
 		panic(fmt.Errorf("Failed setting up WebWire server: %s", err))
 	}
 
-	// Start broadcast
-	go serverImpl.Broadcast()
+	// Start the publishers
+	serverImpl.StartPublishers()
+
+	// Start retransmitting unacknowledged reliable deliveries
+	go serverImpl.Retransmit()
+
+	// Start publishing undeliverable messages to the dead-letter topic
+	go serverImpl.ForwardDeadLetters()
+
+	// Start saving the committed offsets of the durable subscriptions
+	go durables.Persist(1 * time.Second)
 
 	// Listen for OS signals and shutdown server in case of demanded termination
 	osSignals := make(chan os.Signal, 1)
@@ -142,6 +739,9 @@ func main() {
 
 		log.Printf("Termination demanded by the OS (%s), shutting down...", sig)
 
+		// Stop the publishers
+		serverImpl.StopPublishers()
+
 		// Shutdown the webwire server
 		if err := server.Shutdown(); err != nil {
 			log.Printf("Error during server shutdown: %s", err)
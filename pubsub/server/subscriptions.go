@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// handleSubscribe subscribes the client to the requested pattern.
+// Subscriptions resuming from an offset replay the matching logged
+// messages in the background, other subscriptions receive the retained
+// messages of all matching topics instead. Both are delivered before
+// any live message
+func (srv *PubSubServer) handleSubscribe(
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	var req shared.SubscribeRequest
+	if err := json.Unmarshal(message.Payload(), &req); err != nil {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "DECODING_FAILURE",
+			Message: fmt.Sprintf("Failed decoding subscribe request: %s", err),
+		}
+	}
+	if err := req.Validate(); err != nil {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "INVALID_SUBSCRIPTION",
+			Message: err.Error(),
+		}
+	}
+	var flt *filter
+	if req.Filter != "" {
+		var err error
+		if flt, err = compileFilter(req.Filter); err != nil {
+			return wwr.Payload{}, wwr.ErrRequest{
+				Code:    "INVALID_FILTER",
+				Message: err.Error(),
+			}
+		}
+	}
+
+	if !srv.acl.MaySubscribe(sessionInfo(client), req.Pattern) {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code: "FORBIDDEN",
+			Message: fmt.Sprintf(
+				"Not allowed to subscribe to %s",
+				req.Pattern,
+			),
+		}
+	}
+
+	srv.mapLock.Lock()
+	defer srv.mapLock.Unlock()
+
+	state, connected := srv.connectedClients[client]
+	if !connected || state.patterns[req.Pattern] {
+		return wwr.Payload{}, nil
+	}
+	if len(state.patterns) >= maxSubscriptions {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code: "TOO_MANY_SUBSCRIPTIONS",
+			Message: fmt.Sprintf(
+				"A connection can subscribe to at most %d patterns",
+				maxSubscriptions,
+			),
+		}
+	}
+
+	// Determine the offset to resume from, 0 if not resuming.
+	// Durable subscriptions are owned by the authenticated user
+	var from uint64
+	key := durableKey{Name: req.Durable, Pattern: req.Pattern}
+	if info := sessionInfo(client); info != nil {
+		key.Owner = info.Username
+	}
+	switch {
+	case req.Offset != nil:
+		from = *req.Offset
+		if from < 1 {
+			from = 1
+		}
+		if end := srv.log.End(); from > end+1 {
+			from = end + 1
+		}
+	case req.Since != nil:
+		from = srv.log.OffsetAt(*req.Since)
+	case req.Durable != "":
+		if committed, exists := srv.durables.Committed(key); exists {
+			from = committed + 1
+		}
+	}
+
+	if req.Durable != "" {
+		if owner, inUse := srv.durableClients[key]; inUse && owner != client {
+			return wwr.Payload{}, wwr.ErrRequest{
+				Code: "DURABLE_IN_USE",
+				Message: fmt.Sprintf(
+					"The durable subscription %s is in use",
+					req.Durable,
+				),
+			}
+		}
+		if from > 0 {
+			srv.durables.Create(key, from-1)
+		} else {
+			srv.durables.Create(key, srv.log.End())
+		}
+		srv.durableClients[key] = client
+		state.durables[req.Pattern] = key
+	}
+
+	state.patterns[req.Pattern] = true
+	if flt != nil {
+		state.filters[req.Pattern] = flt
+	}
+	srv.subscriptions.Subscribe(req.Pattern, client)
+
+	if flt != nil {
+		log.Printf(
+			"Client %s subscribed to %s filtering %s",
+			client.RemoteAddr(),
+			req.Pattern,
+			flt.source,
+		)
+	} else {
+		log.Printf(
+			"Client %s subscribed to %s",
+			client.RemoteAddr(),
+			req.Pattern,
+		)
+	}
+
+	// Publishers are blocked by the lock until the retained messages
+	// are delivered, which keeps them ahead of the live messages
+	if from < 1 {
+		for _, retained := range srv.retained.Matching(req.Pattern) {
+			if flt != nil && !flt.MatchMessage(retained.Message) {
+				continue
+			}
+			srv.deliver(client, state, logEntry{
+				Topic:   retained.Topic,
+				Message: retained.Message,
+				Expires: retained.Expires,
+			})
+		}
+		return wwr.Payload{}, nil
+	}
+
+	// Live messages are delivered by the replay until it caught up
+	rp := newReplay(client, state, req.Pattern, flt, from)
+	state.replays[req.Pattern] = rp
+	go srv.replay(rp)
+	return wwr.Payload{}, nil
+}
+
+// parsePattern decodes and validates the pattern payload of a request
+func parsePattern(message wwr.Message) (string, error) {
+	pattern, err := message.PayloadUtf8()
+	if err != nil {
+		return "", wwr.ErrRequest{
+			Code:    "DECODING_FAILURE",
+			Message: fmt.Sprintf("Failed decoding pattern: %s", err),
+		}
+	}
+	if err := shared.ValidatePattern(string(pattern)); err != nil {
+		return "", wwr.ErrRequest{
+			Code:    "INVALID_PATTERN",
+			Message: err.Error(),
+		}
+	}
+	return string(pattern), nil
+}
+
+// handleUnsubscribe cancels the subscription of the client
+// to the requested pattern. Durable subscriptions are deleted
+func (srv *PubSubServer) handleUnsubscribe(
+	client wwr.Connection,
+	message wwr.Message,
+) (wwr.Payload, error) {
+	pattern, err := parsePattern(message)
+	if err != nil {
+		return wwr.Payload{}, err
+	}
+
+	srv.mapLock.Lock()
+	defer srv.mapLock.Unlock()
+
+	state, connected := srv.connectedClients[client]
+	if !connected || !state.patterns[pattern] {
+		return wwr.Payload{}, wwr.ErrRequest{
+			Code:    "NOT_SUBSCRIBED",
+			Message: fmt.Sprintf("Not subscribed to %s", pattern),
+		}
+	}
+	if key, isDurable := state.durables[pattern]; isDurable {
+		srv.durables.Delete(key)
+	}
+	srv.unsubscribe(client, state, pattern)
+
+	log.Printf("Client %s unsubscribed from %s", client.RemoteAddr(), pattern)
+	return wwr.Payload{}, nil
+}
+
+// unsubscribe removes the subscription of the client to the given pattern
+// cancelling its replay and releasing durable subscriptions
+// without deleting them.
+// The caller is expected to hold the lock
+func (srv *PubSubServer) unsubscribe(
+	client wwr.Connection,
+	state *clientState,
+	pattern string,
+) {
+	if key, isDurable := state.durables[pattern]; isDurable {
+		delete(srv.durableClients, key)
+		delete(state.durables, pattern)
+	}
+	if rp, isReplaying := state.replays[pattern]; isReplaying {
+		rp.Cancel()
+		delete(state.replays, pattern)
+	}
+	delete(state.patterns, pattern)
+	delete(state.filters, pattern)
+	srv.subscriptions.Unsubscribe(pattern, client)
+}
+
+// matchingDurables returns the durable subscriptions of the client
+// matching the given topic
+func matchingDurables(state *clientState, topic string) []durableKey {
+	var keys []durableKey
+	for pattern, key := range state.durables {
+		if shared.MatchTopic(pattern, topic) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// accepts returns true if any subscription of the client matching
+// the topic is either unfiltered or its filter matches the message
+func accepts(state *clientState, topic string, doc *filterDocument) bool {
+	if len(state.filters) < 1 {
+		return true
+	}
+	for pattern := range state.patterns {
+		if !shared.MatchTopic(pattern, topic) {
+			continue
+		}
+		flt, isFiltered := state.filters[pattern]
+		if !isFiltered || flt.Match(doc.Value()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// logEntry represents a published message in the topic log
+type logEntry struct {
+	// Offset is the position of the message in the log starting at 1
+	Offset    uint64          `json:"offset"`
+	Topic     string          `json:"topic"`
+	Message   json.RawMessage `json:"message"`
+	Published time.Time       `json:"published"`
+
+	// Expires is the time the message expires, nil if it never expires
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Expired returns true if the message expired at the given time
+func (entry logEntry) Expired(now time.Time) bool {
+	return entry.Expires != nil && !now.Before(*entry.Expires)
+}
+
+// logIndexEntry locates an entry in the log file
+type logIndexEntry struct {
+	position  int64
+	published time.Time
+}
+
+// topicLog persists all published messages to an append-only JSON Lines
+// file. The offset of an entry is its line number, an in-memory index
+// maps offsets to file positions
+type topicLog struct {
+	file  *os.File
+	size  int64
+	index []logIndexEntry
+	lock  sync.RWMutex
+}
+
+// openTopicLog opens the log file at the given path
+// creating it if it doesn't exist yet and builds the index
+func openTopicLog(filePath string) (*topicLog, error) {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't open topic log: %s", err)
+	}
+
+	tlog := &topicLog{file: file}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Drop a partially written last entry
+			if len(line) > 0 {
+				if err := file.Truncate(tlog.size); err != nil {
+					file.Close()
+					return nil, fmt.Errorf(
+						"Couldn't truncate topic log: %s",
+						err,
+					)
+				}
+			}
+			break
+		} else if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("Couldn't read topic log: %s", err)
+		}
+
+		var entry logEntry
+		if err := json.Unmarshal(line, &entry); err != nil ||
+			entry.Offset != uint64(len(tlog.index)+1) {
+			file.Close()
+			return nil, fmt.Errorf(
+				"Malformed topic log entry %d",
+				len(tlog.index)+1,
+			)
+		}
+		tlog.index = append(tlog.index, logIndexEntry{
+			position:  tlog.size,
+			published: entry.Published,
+		})
+		tlog.size += int64(len(line))
+	}
+	return tlog, nil
+}
+
+// Append appends a message to the log and returns the new entry.
+// The message expires after the given time to live unless it's 0
+func (tlog *topicLog) Append(
+	topic string,
+	message []byte,
+	ttl time.Duration,
+) (logEntry, error) {
+	tlog.lock.Lock()
+	defer tlog.lock.Unlock()
+
+	entry := logEntry{
+		Offset:    uint64(len(tlog.index) + 1),
+		Topic:     topic,
+		Message:   message,
+		Published: time.Now().UTC(),
+	}
+	if ttl > 0 {
+		expires := entry.Published.Add(ttl)
+		entry.Expires = &expires
+	}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return logEntry{}, fmt.Errorf("Couldn't marshal log entry: %s", err)
+	}
+	if _, err := tlog.file.WriteAt(
+		append(encoded, '\n'),
+		tlog.size,
+	); err != nil {
+		return logEntry{}, fmt.Errorf("Couldn't write topic log: %s", err)
+	}
+	tlog.index = append(tlog.index, logIndexEntry{
+		position:  tlog.size,
+		published: entry.Published,
+	})
+	tlog.size += int64(len(encoded) + 1)
+	return entry, nil
+}
+
+// End returns the offset of the last entry, 0 if the log is empty
+func (tlog *topicLog) End() uint64 {
+	tlog.lock.RLock()
+	defer tlog.lock.RUnlock()
+	return uint64(len(tlog.index))
+}
+
+// OffsetAt returns the offset of the first entry
+// published at or after the given time
+func (tlog *topicLog) OffsetAt(since time.Time) uint64 {
+	tlog.lock.RLock()
+	defer tlog.lock.RUnlock()
+	return uint64(sort.Search(len(tlog.index), func(i int) bool {
+		return !tlog.index[i].published.Before(since)
+	}) + 1)
+}
+
+// Read calls the given function for each entry starting at the given
+// offset until the end of the log is reached or the function returns false
+func (tlog *topicLog) Read(from uint64, fn func(logEntry) bool) error {
+	tlog.lock.RLock()
+	defer tlog.lock.RUnlock()
+
+	if from < 1 {
+		from = 1
+	}
+	if from > uint64(len(tlog.index)) {
+		return nil
+	}
+
+	section := io.NewSectionReader(
+		tlog.file,
+		tlog.index[from-1].position,
+		tlog.size-tlog.index[from-1].position,
+	)
+	scanner := bufio.NewScanner(section)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry logEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("Malformed topic log entry: %s", err)
+		}
+		if !fn(entry) {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Couldn't read topic log: %s", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the log file
+func (tlog *topicLog) Close() error {
+	tlog.lock.Lock()
+	defer tlog.lock.Unlock()
+
+	if err := tlog.file.Sync(); err != nil {
+		tlog.file.Close()
+		return err
+	}
+	return tlog.file.Close()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestLog opens a topic log in a new temporary directory
+func openTestLog(t *testing.T) (*topicLog, string) {
+	dir, err := ioutil.TempDir("", "pubsub")
+	if err != nil {
+		t.Fatalf("couldn't create directory: %s", err)
+	}
+	filePath := filepath.Join(dir, "topics.log")
+	tlog, err := openTopicLog(filePath)
+	if err != nil {
+		t.Fatalf("couldn't open topic log: %s", err)
+	}
+	return tlog, filePath
+}
+
+// appendEntries appends the given number of messages to the log
+// and returns the times they were published at
+func appendEntries(t *testing.T, tlog *topicLog, count int) []time.Time {
+	published := make([]time.Time, 0, count)
+	for i := 0; i < count; i++ {
+		entry, err := tlog.Append("time", []byte(`"12:00"`), 0)
+		if err != nil {
+			t.Fatalf("appending failed: %s", err)
+		}
+		published = append(published, entry.Published)
+
+		// Entries are told apart by their publication time
+		time.Sleep(2 * time.Millisecond)
+	}
+	return published
+}
+
+// TestTopicLogTruncation tests dropping a partially written
+// last entry when reopening the log
+func TestTopicLogTruncation(t *testing.T) {
+	tlog, filePath := openTestLog(t)
+	defer os.RemoveAll(filepath.Dir(filePath))
+	appendEntries(t, tlog, 3)
+	size := tlog.size
+	if err := tlog.Close(); err != nil {
+		t.Fatalf("closing failed: %s", err)
+	}
+
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("couldn't open log file: %s", err)
+	}
+	file.WriteString(`{"offset":4,"topic":"ti`)
+	file.Close()
+
+	tlog, err = openTopicLog(filePath)
+	if err != nil {
+		t.Fatalf("reopening failed: %s", err)
+	}
+	defer tlog.Close()
+	if end := tlog.End(); end != 3 {
+		t.Fatalf("unexpected end: %d", end)
+	}
+	if info, err := os.Stat(filePath); err != nil || info.Size() != size {
+		t.Fatalf("log file not truncated: %v", err)
+	}
+
+	entry, err := tlog.Append("time", []byte(`"12:01"`), 0)
+	if err != nil || entry.Offset != 4 {
+		t.Fatalf("unexpected appended entry: %+v (%v)", entry, err)
+	}
+	var offsets []uint64
+	if err := tlog.Read(1, func(entry logEntry) bool {
+		offsets = append(offsets, entry.Offset)
+		return true
+	}); err != nil {
+		t.Fatalf("reading failed: %s", err)
+	}
+	if len(offsets) != 4 || offsets[3] != 4 {
+		t.Fatalf("unexpected offsets: %v", offsets)
+	}
+}
+
+// TestTopicLogMalformed tests refusing to open a log
+// with a malformed complete entry
+func TestTopicLogMalformed(t *testing.T) {
+	tlog, filePath := openTestLog(t)
+	defer os.RemoveAll(filepath.Dir(filePath))
+	appendEntries(t, tlog, 1)
+	tlog.Close()
+
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("couldn't open log file: %s", err)
+	}
+	file.WriteString("{\"offset\":3}\n")
+	file.Close()
+
+	if _, err := openTopicLog(filePath); err == nil {
+		t.Fatal("malformed log opened")
+	}
+}
+
+// TestTopicLogRead tests reading from an offset
+// until the function stops or the end is reached
+func TestTopicLogRead(t *testing.T) {
+	tlog, filePath := openTestLog(t)
+	defer os.RemoveAll(filepath.Dir(filePath))
+	defer tlog.Close()
+	appendEntries(t, tlog, 5)
+
+	for _, test := range []struct {
+		from     uint64
+		limit    int
+		expected []uint64
+	}{
+		{0, 2, []uint64{1, 2}},
+		{3, 10, []uint64{3, 4, 5}},
+		{4, 1, []uint64{4}},
+		{6, 10, nil},
+	} {
+		var offsets []uint64
+		if err := tlog.Read(test.from, func(entry logEntry) bool {
+			offsets = append(offsets, entry.Offset)
+			return len(offsets) < test.limit
+		}); err != nil {
+			t.Fatalf("reading failed: %s", err)
+		}
+		if len(offsets) != len(test.expected) {
+			t.Fatalf(
+				"read %v from %d, expected %v",
+				offsets,
+				test.from,
+				test.expected,
+			)
+		}
+		for i := range offsets {
+			if offsets[i] != test.expected[i] {
+				t.Fatalf(
+					"read %v from %d, expected %v",
+					offsets,
+					test.from,
+					test.expected,
+				)
+			}
+		}
+	}
+}
+
+// TestTopicLogOffsetAt tests looking up the first entry
+// published at or after a time
+func TestTopicLogOffsetAt(t *testing.T) {
+	tlog, filePath := openTestLog(t)
+	defer os.RemoveAll(filepath.Dir(filePath))
+	defer tlog.Close()
+	published := appendEntries(t, tlog, 3)
+
+	for _, test := range []struct {
+		since    time.Time
+		expected uint64
+	}{
+		{published[0].Add(-time.Hour), 1},
+		{published[0], 1},
+		{published[1], 2},
+		{published[1].Add(time.Millisecond), 3},
+		{published[2].Add(time.Nanosecond), 4},
+	} {
+		if offset := tlog.OffsetAt(test.since); offset != test.expected {
+			t.Fatalf(
+				"offset at %s is %d, expected %d",
+				test.since,
+				offset,
+				test.expected,
+			)
+		}
+	}
+}
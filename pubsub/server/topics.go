@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// trieNode represents a single pattern segment in the subscription trie.
+// Wildcard segments are stored as regular children since they can't
+// occur in topic names
+type trieNode struct {
+	children    map[string]*trieNode
+	subscribers map[wwr.Connection]bool
+}
+
+// topicTrie indexes subscriptions by the segments of their patterns.
+// Matching a topic only visits the branches that can match it, which makes
+// the cost of publishing scale with the number of matching subscriptions
+// rather than the total number of subscriptions. Not thread-safe
+type topicTrie struct {
+	root *trieNode
+}
+
+// newTopicTrie creates a new empty subscription trie
+func newTopicTrie() *topicTrie {
+	return &topicTrie{root: &trieNode{}}
+}
+
+// Subscribe adds a subscription of the given client to the pattern
+func (trie *topicTrie) Subscribe(pattern string, client wwr.Connection) {
+	node := trie.root
+	for _, segment := range strings.Split(pattern, shared.TopicSeparator) {
+		if node.children == nil {
+			node.children = make(map[string]*trieNode)
+		}
+		child, exists := node.children[segment]
+		if !exists {
+			child = &trieNode{}
+			node.children[segment] = child
+		}
+		node = child
+	}
+	if node.subscribers == nil {
+		node.subscribers = make(map[wwr.Connection]bool)
+	}
+	node.subscribers[client] = true
+}
+
+// Unsubscribe removes the subscription of the given client to the pattern
+// and prunes the branches left empty
+func (trie *topicTrie) Unsubscribe(pattern string, client wwr.Connection) {
+	unsubscribe(
+		trie.root,
+		strings.Split(pattern, shared.TopicSeparator),
+		client,
+	)
+}
+
+// unsubscribe recursively removes a subscription from the given node.
+// Returns true if the node is left empty
+func unsubscribe(
+	node *trieNode,
+	segments []string,
+	client wwr.Connection,
+) bool {
+	if len(segments) < 1 {
+		delete(node.subscribers, client)
+	} else if child, exists := node.children[segments[0]]; exists {
+		if unsubscribe(child, segments[1:], client) {
+			delete(node.children, segments[0])
+		}
+	}
+	return len(node.subscribers) < 1 && len(node.children) < 1
+}
+
+// Match returns the set of clients subscribed to patterns matching the
+// given topic. Clients subscribed to several matching patterns are
+// contained only once
+func (trie *topicTrie) Match(topic string) map[wwr.Connection]bool {
+	matches := make(map[wwr.Connection]bool)
+	match(
+		trie.root,
+		strings.Split(topic, shared.TopicSeparator),
+		matches,
+	)
+	return matches
+}
+
+// match recursively collects the subscribers of all patterns
+// below the given node matching the remaining topic segments
+func match(
+	node *trieNode,
+	segments []string,
+	matches map[wwr.Connection]bool,
+) {
+	// The multi-level wildcard matches any remaining segments
+	if multi, exists := node.children[shared.WildcardMulti]; exists {
+		for client := range multi.subscribers {
+			matches[client] = true
+		}
+	}
+	if len(segments) < 1 {
+		for client := range node.subscribers {
+			matches[client] = true
+		}
+		return
+	}
+	if child, exists := node.children[segments[0]]; exists {
+		match(child, segments[1:], matches)
+	}
+	if single, exists := node.children[shared.WildcardSingle]; exists {
+		match(single, segments[1:], matches)
+	}
+}
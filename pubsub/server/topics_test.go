@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	wwr "github.com/qbeon/webwire-go"
+	"github.com/qbeon/webwire-go-examples/pubsub/shared"
+)
+
+// fakeConnection identifies a subscriber without being connected
+type fakeConnection struct {
+	wwr.Connection
+	id int
+}
+
+// subscription represents a subscription of the linear matching baseline
+type subscription struct {
+	pattern string
+	client  wwr.Connection
+}
+
+// matchLinear matches the topic against every single subscription
+// like the broadcast loop iterating over all connected clients did
+func matchLinear(
+	subscriptions []subscription,
+	topic string,
+) map[wwr.Connection]bool {
+	matches := make(map[wwr.Connection]bool)
+	for _, sub := range subscriptions {
+		if shared.MatchTopic(sub.pattern, topic) {
+			matches[sub.client] = true
+		}
+	}
+	return matches
+}
+
+// testSubscriptions generates subscriptions to the metrics of the given
+// number of hosts, every fifth subscription using a wildcard
+func testSubscriptions(hosts int) []subscription {
+	subscriptions := make([]subscription, 0, hosts*3)
+	for host := 0; host < hosts; host++ {
+		for i, pattern := range []string{
+			fmt.Sprintf("metrics.host%d.cpu", host),
+			fmt.Sprintf("metrics.host%d.mem", host),
+			fmt.Sprintf("alerts.host%d.#", host),
+		} {
+			id := host*3 + i
+			if id%5 == 0 {
+				pattern = fmt.Sprintf("metrics.*.cpu%d", id)
+			}
+			subscriptions = append(subscriptions, subscription{
+				pattern: pattern,
+				client:  fakeConnection{id: id},
+			})
+		}
+	}
+	return subscriptions
+}
+
+// TestPatterns tests pattern validation and matching
+func TestPatterns(t *testing.T) {
+	for _, pattern := range []string{"a", "a.b", "*", "#", "a.*.c", "a.#"} {
+		if err := shared.ValidatePattern(pattern); err != nil {
+			t.Errorf("expected %q to be valid: %s", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"", "a..b", "a.#.c", "a*", "a.b#", "a b"} {
+		if err := shared.ValidatePattern(pattern); err == nil {
+			t.Errorf("expected %q to be invalid", pattern)
+		}
+	}
+
+	for _, c := range []struct {
+		pattern string
+		topic   string
+		matches bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.b", "a.b.c", false},
+		{"a.*", "a.b", true},
+		{"a.*", "a", false},
+		{"a.*", "a.b.c", false},
+		{"*.b.*", "a.b.c", true},
+		{"a.#", "a", true},
+		{"a.#", "a.b.c", true},
+		{"a.#", "b.c", false},
+		{"#", "a.b", true},
+	} {
+		if shared.MatchTopic(c.pattern, c.topic) != c.matches {
+			t.Errorf(
+				"expected %q matching %q to be %t",
+				c.pattern,
+				c.topic,
+				c.matches,
+			)
+		}
+
+		trie := newTopicTrie()
+		trie.Subscribe(c.pattern, fakeConnection{})
+		if len(trie.Match(c.topic)) > 0 != c.matches {
+			t.Errorf(
+				"expected the trie matching %q against %q to be %t",
+				c.topic,
+				c.pattern,
+				c.matches,
+			)
+		}
+	}
+}
+
+// TestTopicTrie tests the trie matches the same subscribers
+// as the linear baseline and prunes unsubscribed branches
+func TestTopicTrie(t *testing.T) {
+	subscriptions := testSubscriptions(20)
+	trie := newTopicTrie()
+	for _, sub := range subscriptions {
+		trie.Subscribe(sub.pattern, sub.client)
+	}
+
+	for _, topic := range []string{
+		"metrics.host3.cpu",
+		"metrics.host4.mem",
+		"metrics.host4.cpu10",
+		"alerts.host7",
+		"alerts.host7.disk.full",
+		"unknown",
+	} {
+		expected := matchLinear(subscriptions, topic)
+		actual := trie.Match(topic)
+		if len(actual) != len(expected) {
+			t.Fatalf(
+				"%s: expected %d matches, got %d",
+				topic,
+				len(expected),
+				len(actual),
+			)
+		}
+		for client := range expected {
+			if !actual[client] {
+				t.Fatalf("%s: missing subscriber %v", topic, client)
+			}
+		}
+	}
+
+	for _, sub := range subscriptions {
+		trie.Unsubscribe(sub.pattern, sub.client)
+	}
+	if len(trie.root.children) != 0 {
+		t.Fatalf(
+			"expected an empty trie, got %d branches",
+			len(trie.root.children),
+		)
+	}
+}
+
+// benchmarkMatching benchmarks publishing a topic matched by a single
+// subscription out of the subscriptions to the given number of hosts
+func benchmarkMatching(b *testing.B, hosts int, useTrie bool) {
+	subscriptions := testSubscriptions(hosts)
+	trie := newTopicTrie()
+	for _, sub := range subscriptions {
+		trie.Subscribe(sub.pattern, sub.client)
+	}
+	topic := fmt.Sprintf("metrics.host%d.mem", hosts/2)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if useTrie {
+			trie.Match(topic)
+		} else {
+			matchLinear(subscriptions, topic)
+		}
+	}
+}
+
+// BenchmarkMatching compares the trie against the linear baseline
+func BenchmarkMatching(b *testing.B) {
+	for _, hosts := range []int{10, 100, 1000, 10000} {
+		b.Run(fmt.Sprintf("linear/%d", hosts*3), func(b *testing.B) {
+			benchmarkMatching(b, hosts, false)
+		})
+		b.Run(fmt.Sprintf("trie/%d", hosts*3), func(b *testing.B) {
+			benchmarkMatching(b, hosts, true)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package shared
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Reasons of dead letters
+const (
+	// DeadLetterClientGone is the reason of messages that couldn't be sent
+	// because the client disconnected
+	DeadLetterClientGone = "client_gone"
+
+	// DeadLetterQueueFull is the reason of messages dropped because the queue
+	// of the client was full or too many reliable deliveries were pending
+	// acknowledgement
+	DeadLetterQueueFull = "queue_full"
+
+	// DeadLetterRetriesExceeded is the reason of reliable deliveries
+	// that weren't acknowledged after the maximum number of attempts
+	DeadLetterRetriesExceeded = "retries_exceeded"
+
+	// DeadLetterExpired is the reason of reliable deliveries
+	// that weren't acknowledged before the message expired
+	DeadLetterExpired = "expired"
+)
+
+// DeadLetter represents a message that couldn't be delivered to a client.
+// Dead letters are published to the dead-letter topic of the server
+type DeadLetter struct {
+	// Topic is the topic the message was published to
+	Topic string `json:"topic"`
+
+	// Offset is the position of the message in the topic log,
+	// 0 for retained messages
+	Offset uint64 `json:"offset,omitempty"`
+
+	// Message is the JSON encoded message
+	Message json.RawMessage `json:"message"`
+
+	// Reason is the reason the delivery failed
+	Reason string `json:"reason"`
+
+	// Client is the remote address of the client
+	Client string `json:"client"`
+
+	// Failed is the time the delivery failed
+	Failed time.Time `json:"failed"`
+}
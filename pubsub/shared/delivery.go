@@ -0,0 +1,78 @@
+package shared
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// SignalAck acknowledges the receipt of a reliable delivery.
+// Payload: the decimal sequence number of the delivery
+const SignalAck = "ack"
+
+// Delivery represents the payload of the signals sent to connections
+// in reliable mode. Deliveries that aren't acknowledged in time
+// are retransmitted with the same sequence number
+type Delivery struct {
+	// Seq is the sequence number of the delivery,
+	// it's unique per connection and starts at 1
+	Seq uint64 `json:"seq"`
+
+	// Offset is the position of the message in the topic log,
+	// 0 for retained messages
+	Offset uint64 `json:"offset,omitempty"`
+
+	// Message is the JSON encoded message
+	Message json.RawMessage `json:"message"`
+}
+
+// SequenceWindowSize defines the maximum number of sequence numbers
+// a SequenceWindow keeps track of above its lower bound
+const SequenceWindowSize = 4096
+
+// SequenceWindow detects duplicate deliveries by their sequence number
+type SequenceWindow struct {
+	// lowest is the highest sequence number all lower or equal
+	// sequence numbers of which were received
+	lowest uint64
+
+	// received is the set of received sequence numbers above lowest
+	received map[uint64]bool
+
+	lock sync.Mutex
+}
+
+// NewSequenceWindow creates a new empty sequence window
+func NewSequenceWindow() *SequenceWindow {
+	return &SequenceWindow{
+		received: make(map[uint64]bool),
+	}
+}
+
+// Accept returns true if the given sequence number wasn't received before
+// and marks it received
+func (win *SequenceWindow) Accept(seq uint64) bool {
+	win.lock.Lock()
+	defer win.lock.Unlock()
+
+	if seq <= win.lowest || win.received[seq] {
+		return false
+	}
+	win.received[seq] = true
+
+	// Deliveries the server gave up on leave gaps that are never filled,
+	// skip them once the window is full
+	for len(win.received) > SequenceWindowSize || win.received[win.lowest+1] {
+		win.lowest++
+		delete(win.received, win.lowest)
+	}
+	return true
+}
+
+// Reset forgets all received sequence numbers.
+// Sequence numbers start over for every new connection
+func (win *SequenceWindow) Reset() {
+	win.lock.Lock()
+	win.lowest = 0
+	win.received = make(map[uint64]bool)
+	win.lock.Unlock()
+}
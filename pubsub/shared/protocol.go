@@ -0,0 +1,99 @@
+package shared
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Request names
+const (
+	// RequestSubscribe subscribes the connection to all topics
+	// matching a pattern.
+	// Payload: SubscribeRequest
+	RequestSubscribe = "subscribe"
+
+	// RequestUnsubscribe cancels the subscription to a pattern.
+	// Payload: the topic pattern
+	RequestUnsubscribe = "unsubscribe"
+
+	// RequestAuth authenticates the connection and creates a session.
+	// The rights of the connection are determined by the access control
+	// rules applying to the user and the roles of the session.
+	// Payload: Credentials
+	RequestAuth = "auth"
+
+	// RequestReliable enables reliable delivery for the connection.
+	// Messages are then delivered as Delivery and retransmitted
+	// until acknowledged by a SignalAck signal.
+	// Payload: none
+	RequestReliable = "reliable"
+
+	// RequestPublish publishes a message to all subscribers of a topic.
+	// Payload: PublishRequest
+	RequestPublish = "publish"
+)
+
+// Messages published to a topic are delivered to its subscribers as
+// signals named after the topic carrying the JSON encoded message
+
+// Credentials represents the payload of an auth request
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// SubscribeRequest represents the payload of a subscribe request
+type SubscribeRequest struct {
+	Pattern string `json:"pattern"`
+
+	// Durable names a durable subscription. The server commits the offset
+	// of each message delivered to a durable subscription and resumes
+	// from the committed offset when it's subscribed to again
+	Durable string `json:"durable,omitempty"`
+
+	// Offset replays the logged messages starting at the given offset
+	Offset *uint64 `json:"offset,omitempty"`
+
+	// Since replays the logged messages published at or after the given time
+	Since *time.Time `json:"since,omitempty"`
+
+	// Filter is an expression over the fields of the JSON encoded messages,
+	// only matching messages are delivered
+	Filter string `json:"filter,omitempty"`
+}
+
+// Validate returns an error if the request isn't valid
+func (req SubscribeRequest) Validate() error {
+	if err := ValidatePattern(req.Pattern); err != nil {
+		return err
+	}
+	if req.Durable != "" {
+		if err := ValidateDurableName(req.Durable); err != nil {
+			return err
+		}
+	}
+	if req.Offset != nil && req.Since != nil {
+		return errors.New("offset and since are mutually exclusive")
+	}
+	return nil
+}
+
+// PublishRequest represents the payload of a publish request
+type PublishRequest struct {
+	Topic string `json:"topic"`
+
+	// Message is the JSON encoded message
+	Message json.RawMessage `json:"message"`
+
+	// Retain makes the server keep the message as the last value of the
+	// topic delivered to every new subscriber, a retained null message
+	// removes the retained value
+	Retain bool `json:"retain,omitempty"`
+
+	// TTL is the time to live of the message in milliseconds.
+	// Messages that expire before they're delivered are dead-lettered
+	// and expired retained messages are no longer delivered.
+	// Messages without a TTL never expire
+	TTL uint64 `json:"ttl,omitempty"`
+}
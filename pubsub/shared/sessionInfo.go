@@ -0,0 +1,86 @@
+package shared
+
+import webwire "github.com/qbeon/webwire-go"
+
+var sessionInfoFieldNames = []string{"username", "roles"}
+
+// SessionInfo implements the webwire.SessionInfo interface
+// for this particular example
+type SessionInfo struct {
+	Username string
+	Roles    []string
+}
+
+// Copy implements the webwire.SessionInfo interface.
+// It deep-copies the object and returns it's exact clone
+func (sinf *SessionInfo) Copy() webwire.SessionInfo {
+	return &SessionInfo{
+		Username: sinf.Username,
+		Roles:    copyStrings(sinf.Roles),
+	}
+}
+
+// Fields implements the webwire.SessionInfo interface.
+// It returns a constant list of the names of all fields of the object
+func (sinf *SessionInfo) Fields() []string {
+	return sessionInfoFieldNames
+}
+
+// Value implements the webwire.SessionInfo interface.
+// It returns an exact deep copy of a session info field value
+func (sinf *SessionInfo) Value(fieldName string) interface{} {
+	switch fieldName {
+	case "username":
+		return sinf.Username
+	case "roles":
+		return copyStrings(sinf.Roles)
+	}
+	return nil
+}
+
+// HasRole returns true if any of the given roles
+// is assigned to the session owner
+func (sinf *SessionInfo) HasRole(roles ...string) bool {
+	for _, assigned := range sinf.Roles {
+		for _, role := range roles {
+			if assigned == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SessionInfoParser parses the given session info data into a
+// webwire.SessionInfo compliant object specific to this application
+func SessionInfoParser(data map[string]interface{}) webwire.SessionInfo {
+	info := &SessionInfo{
+		Username: data["username"].(string),
+	}
+
+	// Roles are either a string slice when the session was just created
+	// or a slice of variants when parsed from a serialized session
+	switch roles := data["roles"].(type) {
+	case []string:
+		info.Roles = copyStrings(roles)
+	case []interface{}:
+		info.Roles = make([]string, 0, len(roles))
+		for _, role := range roles {
+			if name, isString := role.(string); isString {
+				info.Roles = append(info.Roles, name)
+			}
+		}
+	}
+
+	return info
+}
+
+// copyStrings returns a copy of the given string slice
+func copyStrings(original []string) []string {
+	if original == nil {
+		return nil
+	}
+	cpy := make([]string, len(original))
+	copy(cpy, original)
+	return cpy
+}
@@ -0,0 +1,126 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxTopicLength defines the maximum length of a topic name in bytes
+const MaxTopicLength = 200
+
+// TopicSeparator separates the segments of a topic name
+const TopicSeparator = "."
+
+// ValidateTopic returns an error if the given string isn't a valid topic name.
+// Topic names consist of non-empty segments separated by dots and may
+// only contain printable ASCII characters except for spaces, '*' and '#'
+func ValidateTopic(topic string) error {
+	if topic == "" {
+		return errors.New("topic name is empty")
+	}
+	if len(topic) > MaxTopicLength {
+		return fmt.Errorf(
+			"topic name exceeds %d bytes",
+			MaxTopicLength,
+		)
+	}
+	for i := 0; i < len(topic); i++ {
+		char := topic[i]
+		if char <= ' ' || char > '~' || char == '*' || char == '#' {
+			return fmt.Errorf("invalid character in topic name: %q", char)
+		}
+	}
+	for _, segment := range strings.Split(topic, TopicSeparator) {
+		if segment == "" {
+			return errors.New("topic name contains an empty segment")
+		}
+	}
+	return nil
+}
+
+// Wildcards usable in subscription patterns
+const (
+	// WildcardSingle matches exactly one topic segment
+	WildcardSingle = "*"
+
+	// WildcardMulti matches any number of trailing topic segments
+	// including none, it must be the last segment of a pattern
+	WildcardMulti = "#"
+)
+
+// ValidatePattern returns an error if the given string isn't a valid
+// subscription pattern. Patterns are topic names where entire segments
+// may be replaced by wildcards
+func ValidatePattern(pattern string) error {
+	if pattern == "" {
+		return errors.New("pattern is empty")
+	}
+	if len(pattern) > MaxTopicLength {
+		return fmt.Errorf("pattern exceeds %d bytes", MaxTopicLength)
+	}
+	segments := strings.Split(pattern, TopicSeparator)
+	for i, segment := range segments {
+		switch segment {
+		case WildcardSingle:
+			continue
+		case WildcardMulti:
+			if i != len(segments)-1 {
+				return fmt.Errorf(
+					"wildcard %s must be the last segment",
+					WildcardMulti,
+				)
+			}
+			continue
+		}
+		if err := ValidateTopic(segment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MatchTopic returns true if the given topic name matches the pattern
+func MatchTopic(pattern, topic string) bool {
+	patternSegments := strings.Split(pattern, TopicSeparator)
+	topicSegments := strings.Split(topic, TopicSeparator)
+	for i, segment := range patternSegments {
+		if segment == WildcardMulti {
+			return true
+		}
+		if i >= len(topicSegments) {
+			return false
+		}
+		if segment != WildcardSingle && segment != topicSegments[i] {
+			return false
+		}
+	}
+	return len(patternSegments) == len(topicSegments)
+}
+
+// MaxDurableNameLength defines the maximum length
+// of a durable subscription name in bytes
+const MaxDurableNameLength = 64
+
+// ValidateDurableName returns an error if the given string
+// isn't a valid durable subscription name
+func ValidateDurableName(name string) error {
+	if name == "" {
+		return errors.New("durable subscription name is empty")
+	}
+	if len(name) > MaxDurableNameLength {
+		return fmt.Errorf(
+			"durable subscription name exceeds %d bytes",
+			MaxDurableNameLength,
+		)
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] > '~' {
+			return fmt.Errorf(
+				"invalid character in durable subscription name: %q",
+				name[i],
+			)
+		}
+	}
+	return nil
+}